@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+	"github.com/mitchellh/go-homedir"
+	"github.com/nsf/termbox-go"
+)
+
+// The auto-save file for fn, which lives next to it, like in GNU Emacs.
+func autoSaveName(fn string) string {
+	dir, base := filepath.Split(fn)
+	return filepath.Join(dir, "#"+base+"#")
+}
+
+// Buffers without a file can only be dumped when we crash; they go in the
+// home directory, tagged with the process ID so that two crashes don't clash.
+func unnamedAutoSaveName(i int) string {
+	dir, err := homedir.Dir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("#unnamed-buffer-%d-%d#", i, os.Getpid()))
+}
+
+// Write the buffer's contents to its auto-save file, unless they haven't
+// changed since the last time we did so.
+func (buf *EditorBuffer) autoSaveTo(fn string) (bool, error) {
+	data, err := buf.encodeContents()
+	if err != nil {
+		// Can't encode in the file's coding system - UTF-8 is better than nothing
+		data, _ = encodeText(buf.contentsString(), CodingUTF8)
+	}
+	sum := crc32.ChecksumIEEE(data)
+	if buf.autoSaved && buf.autoSaveSum == sum {
+		return false, nil
+	}
+	err = ioutil.WriteFile(fn, data, 0600)
+	if err != nil {
+		return false, err
+	}
+	buf.autoSaved = true
+	buf.autoSaveSum = sum
+	return true, nil
+}
+
+// Get the buffer's text, with line separators but without encoding it.
+func (buf *EditorBuffer) contentsString() string {
+	lines := make([]string, buf.NumRows())
+	buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+		lines[i] = row.Data
+		return true
+	})
+	return strings.Join(lines, buf.Eol.Separator())
+}
+
+// Remove a buffer's auto-save file, e.g. because its contents have been saved
+// for real.
+func (buf *EditorBuffer) deleteAutoSave() {
+	if buf.Filename == "" || !buf.autoSaved {
+		return
+	}
+	os.Remove(autoSaveName(buf.Filename))
+	buf.autoSaved = false
+}
+
+// Auto-save every dirty buffer visiting a file with auto-save-mode on.
+func doAutoSave() {
+	Global.autoSaveKeys = 0
+	saved := false
+	for _, buf := range Global.Buffers {
+		if !buf.Dirty || buf.Filename == "" || !buf.hasMode("auto-save-mode") {
+			continue
+		}
+		wrote, err := buf.autoSaveTo(autoSaveName(buf.Filename))
+		if err != nil {
+			Global.Input = fmt.Sprintf("Error auto-saving %s: %s", buf.getRenderName(), err.Error())
+			AddErrorMessage(Global.Input)
+			return
+		}
+		saved = saved || wrote
+	}
+	if saved && Global.Input == "" {
+		Global.Input = "Auto-saving...done"
+	}
+}
+
+// Count a keystroke towards the next auto-save.
+func autoSaveKeystroke() {
+	Global.autoSaveKeys++
+	if 0 < Global.AutoSaveInterval && Global.AutoSaveInterval <= Global.autoSaveKeys {
+		doAutoSave()
+	}
+}
+
+// doAutoSave as a periodic job, which redraws if there's something to say.
+func doTimedAutoSave() bool {
+	oldinput := Global.Input
+	doAutoSave()
+	return Global.Input != oldinput
+}
+
+// Dump all dirty buffers, whether they have auto-save-mode on or not, because
+// we're about to die. Returns the names of the files written.
+func dumpDirtyBuffers() []string {
+	ret := []string{}
+	for i, buf := range Global.Buffers {
+		if !buf.Dirty {
+			continue
+		}
+		fn := unnamedAutoSaveName(i)
+		if buf.Filename != "" {
+			fn = autoSaveName(buf.Filename)
+		}
+		// The buffer could be what broke, so don't let it stop us saving the others
+		func() {
+			defer func() { recover() }()
+			buf.autoSaved = false
+			_, err := buf.autoSaveTo(fn)
+			if err == nil {
+				ret = append(ret, fn)
+			}
+		}()
+	}
+	return ret
+}
+
+// Deferred in main: on a panic, put the terminal back, save what we can, and
+// tell the user where to find it.
+func recoverFromCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	termbox.Close()
+	dumped := dumpDirtyBuffers()
+	dumpCrashLog(fmt.Sprint(r))
+	fmt.Fprintln(os.Stderr, "gomacs crashed:", r)
+	for _, fn := range dumped {
+		fmt.Fprintln(os.Stderr, "Unsaved changes were written to", fn)
+	}
+	if len(dumped) > 0 {
+		fmt.Fprintln(os.Stderr, "Use M-x recover-file to get them back.")
+	}
+	os.Exit(2)
+}
+
+// Whether fn has an auto-save file that's newer than it.
+func hasNewerAutoSave(fn string) bool {
+	asinfo, err := os.Stat(autoSaveName(fn))
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(fn)
+	return err != nil || info.ModTime().Before(asinfo.ModTime())
+}
+
+// Visit fn, replacing its contents with those of its auto-save file.
+func recoverAutoSave(fn string, env *glisp.Zlisp) error {
+	fpath, err := AbsPath(fn)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(autoSaveName(fpath))
+	if err != nil {
+		return err
+	}
+	openFile(fpath, env)
+	buf := Global.CurrentB
+	buf.loadContents(data)
+	buf.cx, buf.cy, buf.rowoff, buf.prefcx = 0, 0, 0, 0
+	buf.clearUndo()
+	buf.Dirty = true
+	// The auto-save file already has these contents; delete it when we save
+	buf.autoSaved = true
+	buf.autoSaveSum = crc32.ChecksumIEEE(data)
+	return nil
+}
+
+func recoverFile(env *glisp.Zlisp) {
+	fn := tabCompletedEditorPrompt("Recover file", tabCompleteFilename)
+	if fn == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	fpath, err := AbsPath(fn)
+	if err == nil && !hasNewerAutoSave(fpath) {
+		err = errors.New("Auto-save file " + autoSaveName(fpath) + " is not current")
+	}
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+		return
+	}
+	ok, cancel := editorYesNoPrompt("Recover auto save file "+autoSaveName(fpath)+"?", false)
+	if !ok || cancel != nil {
+		Global.Input = "Cancelled."
+		return
+	}
+	err = recoverAutoSave(fpath, env)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+		return
+	}
+	Global.Input = "Auto-save file recovered; save the buffer to keep it."
+}
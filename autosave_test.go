@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAutoSave(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	InitEditor()
+	EditorOpen(fn, nil)
+	doAutoSave()
+	if _, err := os.Stat(autoSaveName(fn)); !os.IsNotExist(err) {
+		t.Error("Clean buffer was auto-saved")
+	}
+	editorInsertStr("new ")
+	doAutoSave()
+	failIfFileNe(autoSaveName(fn), "new old\n", t)
+	failIfFileNe(fn, "old\n", t)
+	editorBufSave(Global.CurrentB, nil)
+	if _, err := os.Stat(autoSaveName(fn)); !os.IsNotExist(err) {
+		t.Error("Auto-save file was not deleted after saving")
+	}
+}
+
+func TestAutoSaveKeystrokes(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	InitEditor()
+	Global.AutoSaveInterval = 3
+	EditorOpen(fn, nil)
+	for _, s := range []string{"a", "b", "c"} {
+		if _, err := os.Stat(autoSaveName(fn)); !os.IsNotExist(err) {
+			t.Error("Auto-saved after too few keystrokes")
+		}
+		editorInsertStr(s)
+		autoSaveKeystroke()
+	}
+	failIfFileNe(autoSaveName(fn), "abcold\n", t)
+}
+
+func TestDumpDirtyBuffers(t *testing.T) {
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean.txt")
+	dirty := filepath.Join(dir, "dirty.txt")
+	ioutil.WriteFile(clean, []byte("clean\n"), 0644)
+	ioutil.WriteFile(dirty, []byte("dirty\n"), 0644)
+	InitEditor()
+	EditorOpen(clean, nil)
+	openFile(dirty, nil)
+	// Crash dumps ignore auto-save-mode
+	Global.CurrentB.setMode("auto-save-mode", false)
+	editorInsertStr("very ")
+	dumped := dumpDirtyBuffers()
+	if len(dumped) != 1 || dumped[0] != autoSaveName(dirty) {
+		t.Error("Expected only the dirty buffer to be dumped, got", dumped)
+	}
+	failIfFileNe(autoSaveName(dirty), "very dirty\n", t)
+}
+
+func TestRecoverAutoSave(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	ioutil.WriteFile(autoSaveName(fn), []byte("recovered\n"), 0600)
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(fn, past, past)
+	if !hasNewerAutoSave(fn) {
+		t.Fatal("Expected auto-save file to be newer")
+	}
+	InitEditor()
+	err := recoverAutoSave(fn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Global.CurrentB.FailIfBufferNe([]string{"recovered"}, t)
+	if !Global.CurrentB.Dirty {
+		t.Error("Recovered buffer should be dirty")
+	}
+	editorBufSave(Global.CurrentB, nil)
+	failIfFileNe(fn, "recovered\n", t)
+	if hasNewerAutoSave(fn) {
+		t.Error("Auto-save file should be gone after saving")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The desktop clipboard. Kills are copied to it, and yanking first checks
+// whether something else has put text on it since.
+type clipboardBackend interface {
+	Name() string
+	Copy(text string) error
+	Paste() (string, error)
+}
+
+var errNoPaste = errors.New("can't read the clipboard")
+
+// How long to wait for a clipboard program, which can hang when the display
+// isn't answering; killing and yanking wait on it.
+var clipboardTimeout = time.Second
+
+// A clipboard reached through a pair of external programs.
+type commandClipboard struct {
+	name  string
+	copy  []string
+	paste []string
+}
+
+func (c *commandClipboard) Name() string {
+	return c.name
+}
+
+func (c *commandClipboard) Copy(text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.copy[0], c.copy[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return clipboardError(ctx, cmd.Run())
+}
+
+func (c *commandClipboard) Paste() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, c.paste[0], c.paste[1:]...).Output()
+	return string(out), clipboardError(ctx, err)
+}
+
+func clipboardError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", clipboardTimeout)
+	}
+	return err
+}
+
+// Copying with the OSC 52 escape sequence, which asks the terminal to put the
+// text on the clipboard - this works over SSH. Few terminals let programs read
+// the clipboard that way, so we don't try.
+type osc52Clipboard struct {
+	out io.Writer
+}
+
+func (c *osc52Clipboard) Name() string {
+	return "osc52"
+}
+
+func (c *osc52Clipboard) Copy(text string) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		// Ask tmux to pass it on to the real terminal
+		seq = "\x1bPtmux;" + strings.Replace(seq, "\x1b", "\x1b\x1b", -1) + "\x1b\\"
+	}
+	out := c.out
+	if out == nil {
+		tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer tty.Close()
+		out = tty
+	}
+	_, err := io.WriteString(out, seq)
+	return err
+}
+
+func (c *osc52Clipboard) Paste() (string, error) {
+	return "", errNoPaste
+}
+
+// Get a clipboard backend by name; "auto" picks one that suits the session,
+// and "none" (or nothing suitable) gives nil.
+func getClipboardBackend(name string) (clipboardBackend, error) {
+	switch name {
+	case "none":
+		return nil, nil
+	case "xsel":
+		return &commandClipboard{name, []string{"xsel", "--clipboard", "--input"},
+			[]string{"xsel", "--clipboard", "--output"}}, nil
+	case "xclip":
+		return &commandClipboard{name, []string{"xclip", "-selection", "clipboard", "-in"},
+			[]string{"xclip", "-selection", "clipboard", "-out"}}, nil
+	case "wayland":
+		return &commandClipboard{name, []string{"wl-copy"},
+			[]string{"wl-paste", "--no-newline"}}, nil
+	case "osc52":
+		return &osc52Clipboard{}, nil
+	case "auto":
+		return detectClipboardBackend(), nil
+	}
+	return nil, errors.New("Unknown clipboard " + name +
+		"; try auto, xsel, xclip, wayland, osc52 or none")
+}
+
+func detectClipboardBackend() clipboardBackend {
+	has := func(prog string) bool {
+		_, err := exec.LookPath(prog)
+		return err == nil
+	}
+	name := "none"
+	if os.Getenv("WAYLAND_DISPLAY") != "" && has("wl-copy") && has("wl-paste") {
+		name = "wayland"
+	} else if os.Getenv("DISPLAY") != "" && has("xsel") {
+		name = "xsel"
+	} else if os.Getenv("DISPLAY") != "" && has("xclip") {
+		name = "xclip"
+	} else if os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CONNECTION") != "" {
+		name = "osc52"
+	}
+	ret, _ := getClipboardBackend(name)
+	return ret
+}
+
+// Copy a kill to the clipboard.
+func syncKillToClipboard(text string) {
+	if Global.Clipboard == nil {
+		return
+	}
+	Global.lastClipboard = text
+	err := Global.Clipboard.Copy(text)
+	if err != nil {
+		AddErrorMessage("Couldn't copy to the " + Global.Clipboard.Name() +
+			" clipboard: " + err.Error())
+	}
+}
+
+// If another program has put something on the clipboard since we last looked,
+// make it the newest kill, so that it gets yanked. If the clipboard can't be
+// read, the kill ring is yanked as it is.
+func syncKillFromClipboard() {
+	if Global.Clipboard == nil {
+		return
+	}
+	text, err := Global.Clipboard.Paste()
+	if err != nil || text == "" || text == Global.lastClipboard {
+		return
+	}
+	Global.lastClipboard = text
+	if len(Global.KillRing) == 0 || Global.KillRing[0] != text {
+		Global.KillRing = append([]string{text}, Global.KillRing...)
+		trimKillRing()
+	}
+	Global.killRingYank = 0
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeClipboard struct {
+	text   string
+	copies int
+}
+
+func (c *fakeClipboard) Name() string {
+	return "fake"
+}
+
+func (c *fakeClipboard) Copy(text string) error {
+	c.text = text
+	c.copies++
+	return nil
+}
+
+func (c *fakeClipboard) Paste() (string, error) {
+	return c.text, nil
+}
+
+func TestKillsGoToClipboard(t *testing.T) {
+	InitEditor()
+	clip := &fakeClipboard{}
+	Global.Clipboard = clip
+	editorInsertStr("one two")
+	Global.CurrentB.cx = 0
+	runAsCommand("kill-word", delForwardWord)
+	if clip.text != "one" {
+		t.Errorf("Expected %q on the clipboard but got %q", "one", clip.text)
+	}
+	runAsCommand("kill-word", delForwardWord)
+	if clip.text != "one two" {
+		t.Errorf("Expected the appended kill %q on the clipboard but got %q", "one two", clip.text)
+	}
+}
+
+func TestYankReadsChangedClipboard(t *testing.T) {
+	InitEditor()
+	clip := &fakeClipboard{}
+	Global.Clipboard = clip
+	pushKill("ours")
+	clip.text = "theirs"
+	runAsCommand("yank-region", doYankRegion)
+	Global.CurrentB.FailIfBufferNe([]string{"theirs"}, t)
+	if len(Global.KillRing) != 2 {
+		t.Error("Expected the clipboard to be added to the kill ring but it was", Global.KillRing)
+	}
+	// Yank-pop gets back to our kill
+	runAsCommand("yank-pop", doYankPop)
+	Global.CurrentB.FailIfBufferNe([]string{"ours"}, t)
+}
+
+func TestYankIgnoresUnchangedClipboard(t *testing.T) {
+	InitEditor()
+	clip := &fakeClipboard{}
+	Global.Clipboard = clip
+	pushKill("one")
+	pushKill("two")
+	Global.killRingYank = 1
+	runAsCommand("yank-region", doYankRegion)
+	Global.CurrentB.FailIfBufferNe([]string{"one"}, t)
+	if len(Global.KillRing) != 2 {
+		t.Error("Expected the kill ring to be left alone but it was", Global.KillRing)
+	}
+}
+
+func TestOsc52Copy(t *testing.T) {
+	t.Setenv("TMUX", "")
+	out := &bytes.Buffer{}
+	clip := &osc52Clipboard{out}
+	clip.Copy("hello")
+	if out.String() != "\x1b]52;c;aGVsbG8=\a" {
+		t.Errorf("Wrong escape sequence %q", out.String())
+	}
+	if _, err := clip.Paste(); err == nil {
+		t.Error("Expected pasting with OSC 52 to fail")
+	}
+}
+
+func TestCommandClipboard(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "clipboard")
+	clip := &commandClipboard{"test", []string{"sh", "-c", "cat > " + fn},
+		[]string{"cat", fn}}
+	err := clip.Copy("some\ntext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := clip.Paste()
+	if err != nil || text != "some\ntext" {
+		t.Errorf("Expected to paste %q but got %q (%v)", "some\ntext", text, err)
+	}
+}
+
+func TestHungClipboardYanksKillRing(t *testing.T) {
+	InitEditor()
+	defer func(d time.Duration) { clipboardTimeout = d }(clipboardTimeout)
+	clipboardTimeout = 50 * time.Millisecond
+	Global.Clipboard = &commandClipboard{"test", []string{"sleep", "10"},
+		[]string{"sleep", "10"}}
+	start := time.Now()
+	pushKill("ours")
+	runAsCommand("yank-region", doYankRegion)
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected the clipboard programs to be killed but they ran for", time.Since(start))
+	}
+	Global.CurrentB.FailIfBufferNe([]string{"ours"}, t)
+}
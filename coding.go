@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// EolStyle is the line ending convention used by a buffer's file. The zero
+// value is the Unix convention, which is what new buffers get.
+type EolStyle uint8
+
+const (
+	EolUnix EolStyle = iota
+	EolDos
+	EolMac
+)
+
+// Coding is the character encoding used by a buffer's file. The zero value is
+// plain UTF-8, which is what new buffers get.
+type Coding uint8
+
+const (
+	CodingUTF8 Coding = iota
+	CodingUTF8BOM
+	CodingLatin1
+	CodingUTF16LE
+	CodingUTF16BE
+)
+
+var codingNames = []string{"utf-8", "utf-8-with-signature", "latin-1", "utf-16le", "utf-16be"}
+var eolNames = []string{"unix", "dos", "mac"}
+
+var bomUTF8 = []byte{0xef, 0xbb, 0xbf}
+var bomUTF16LE = []byte{0xff, 0xfe}
+var bomUTF16BE = []byte{0xfe, 0xff}
+
+func (e EolStyle) String() string {
+	return eolNames[e]
+}
+
+// The string that ends a line in this style.
+func (e EolStyle) Separator() string {
+	switch e {
+	case EolDos:
+		return "\r\n"
+	case EolMac:
+		return "\r"
+	default:
+		return "\n"
+	}
+}
+
+// Same characters GNU Emacs uses in its mode line.
+func (e EolStyle) Mnemonic() rune {
+	switch e {
+	case EolDos:
+		return '\\'
+	case EolMac:
+		return '/'
+	default:
+		return ':'
+	}
+}
+
+func (c Coding) String() string {
+	return codingNames[c]
+}
+
+func (c Coding) Mnemonic() rune {
+	switch c {
+	case CodingUTF8BOM:
+		return 'B'
+	case CodingLatin1:
+		return '1'
+	case CodingUTF16LE:
+		return 'L'
+	case CodingUTF16BE:
+		return 'E'
+	default:
+		return 'U'
+	}
+}
+
+// Guess the encoding of some file contents. Byte order marks win; anything
+// else that isn't valid UTF-8 is assumed to be Latin-1, which can represent
+// any sequence of bytes and so always round-trips.
+func detectCoding(data []byte) Coding {
+	if bytes.HasPrefix(data, bomUTF8) {
+		return CodingUTF8BOM
+	} else if len(data)%2 == 0 && bytes.HasPrefix(data, bomUTF16LE) {
+		return CodingUTF16LE
+	} else if len(data)%2 == 0 && bytes.HasPrefix(data, bomUTF16BE) {
+		return CodingUTF16BE
+	} else if utf8.Valid(data) {
+		return CodingUTF8
+	}
+	return CodingLatin1
+}
+
+func decodeText(data []byte, c Coding) string {
+	switch c {
+	case CodingUTF8BOM:
+		return string(data[len(bomUTF8):])
+	case CodingLatin1:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	case CodingUTF16LE, CodingUTF16BE:
+		data = data[2:]
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if c == CodingUTF16LE {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		return string(utf16.Decode(units))
+	default:
+		return string(data)
+	}
+}
+
+func encodeText(s string, c Coding) ([]byte, error) {
+	switch c {
+	case CodingUTF8BOM:
+		return append(append([]byte{}, bomUTF8...), s...), nil
+	case CodingLatin1:
+		ret := make([]byte, 0, len(s))
+		for _, ru := range s {
+			if ru > 0xff {
+				return nil, fmt.Errorf("can't encode %s in %s", describeRune(ru), c)
+			}
+			ret = append(ret, byte(ru))
+		}
+		return ret, nil
+	case CodingUTF16LE, CodingUTF16BE:
+		units := utf16.Encode([]rune(s))
+		ret := make([]byte, 0, 2+2*len(units))
+		if c == CodingUTF16LE {
+			ret = append(ret, bomUTF16LE...)
+		} else {
+			ret = append(ret, bomUTF16BE...)
+		}
+		for _, u := range units {
+			if c == CodingUTF16LE {
+				ret = append(ret, byte(u), byte(u>>8))
+			} else {
+				ret = append(ret, byte(u>>8), byte(u))
+			}
+		}
+		return ret, nil
+	default:
+		return []byte(s), nil
+	}
+}
+
+// A file only counts as DOS if every line ends in CRLF; mixed files are
+// treated as Unix files with stray carriage returns so that saving them
+// doesn't change a single byte.
+func detectEol(s string) EolStyle {
+	lfs := strings.Count(s, "\n")
+	if lfs == 0 {
+		if strings.Contains(s, "\r") {
+			return EolMac
+		}
+		return EolUnix
+	} else if strings.Count(s, "\r\n") == lfs {
+		return EolDos
+	}
+	return EolUnix
+}
+
+// Split text into lines, reporting whether the text ended with a line
+// separator.
+func splitLines(s string, eol EolStyle) ([]string, bool) {
+	if s == "" {
+		return []string{}, true
+	}
+	sep := eol.Separator()
+	lines := strings.Split(s, sep)
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1], true
+	}
+	return lines, false
+}
+
+// Set the buffer's text, coding, and line ending style from file contents.
+func (buf *EditorBuffer) loadContents(data []byte) {
+	buf.Coding = detectCoding(data)
+	text := decodeText(data, buf.Coding)
+	buf.Eol = detectEol(text)
+	lines, final := splitLines(text, buf.Eol)
+	buf.NoFinalNewline = !final
+	rows := make([]*EditorRow, len(lines))
+	for i, line := range lines {
+		rows[i] = &EditorRow{Size: len(line), Data: line}
+		rowUpdateRender(rows[i])
+	}
+	buf.closeLargeFile()
+	buf.SetRows(rows)
+	buf.LargeFile = false
+}
+
+// Get the buffer's text as it should be written to disk.
+func (buf *EditorBuffer) encodeContents() ([]byte, error) {
+	var bb bytes.Buffer
+	sep := buf.Eol.Separator()
+	buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+		bb.WriteString(row.Data)
+		if i < buf.NumRows()-1 || !buf.NoFinalNewline {
+			bb.WriteString(sep)
+		}
+		return true
+	})
+	return encodeText(bb.String(), buf.Coding)
+}
+
+// Codings are named as in GNU Emacs: "latin-1-dos" sets both the encoding and
+// the line endings, "latin-1" only the encoding, and "dos" only the line
+// endings.
+func parseCodingName(name string) (Coding, bool, EolStyle, bool, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, eolname := range eolNames {
+		if name == eolname {
+			return 0, false, EolStyle(i), true, nil
+		}
+	}
+	for i, codingname := range codingNames {
+		if name == codingname {
+			return Coding(i), true, 0, false, nil
+		}
+		for j, eolname := range eolNames {
+			if name == codingname+"-"+eolname {
+				return Coding(i), true, EolStyle(j), true, nil
+			}
+		}
+	}
+	return 0, false, 0, false, errors.New("Unknown coding system " + name)
+}
+
+func codingNameCompletions(prefix string) []string {
+	ret := []string{}
+	for _, eolname := range eolNames {
+		if strings.HasPrefix(eolname, prefix) {
+			ret = append(ret, eolname)
+		}
+	}
+	for _, codingname := range codingNames {
+		if strings.HasPrefix(codingname, prefix) {
+			ret = append(ret, codingname)
+		}
+		for _, eolname := range eolNames {
+			if strings.HasPrefix(codingname+"-"+eolname, prefix) {
+				ret = append(ret, codingname+"-"+eolname)
+			}
+		}
+	}
+	return ret
+}
+
+func (buf *EditorBuffer) codingSystemName() string {
+	return buf.Coding.String() + "-" + buf.Eol.String()
+}
+
+func (buf *EditorBuffer) setCodingSystem(name string) error {
+	coding, setcoding, eol, seteol, err := parseCodingName(name)
+	if err != nil {
+		return err
+	}
+	if setcoding {
+		buf.Coding = coding
+	}
+	if seteol {
+		buf.Eol = eol
+	}
+	buf.Dirty = true
+	return nil
+}
+
+func setBufferFileCodingSystem() {
+	buf := Global.CurrentB
+	name := tabCompletedEditorPrompt("Coding system for saving file (currently "+
+		buf.codingSystemName()+")", codingNameCompletions)
+	if name == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	err := buf.setCodingSystem(name)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	Global.Input = "Coding system set to " + buf.codingSystemName()
+}
+
+func toggleFinalNewline() {
+	buf := Global.CurrentB
+	buf.NoFinalNewline = !buf.NoFinalNewline
+	buf.Dirty = true
+	if buf.NoFinalNewline {
+		Global.Input = "File will be saved without a final newline"
+	} else {
+		Global.Input = "File will be saved with a final newline"
+	}
+}
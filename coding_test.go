@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func roundTrip(data []byte, t *testing.T) *EditorBuffer {
+	InitEditor()
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	err := ioutil.WriteFile(fn, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = EditorOpen(fn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editorBufSave(Global.CurrentB, nil)
+	saved, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, saved) {
+		t.Errorf("Expected file %q but saved %q", data, saved)
+	}
+	return Global.CurrentB
+}
+
+func TestRoundTripUnix(t *testing.T) {
+	buf := roundTrip([]byte("foo\nbar\n"), t)
+	buf.FailIfBufferNe([]string{"foo", "bar"}, t)
+	if buf.Eol != EolUnix || buf.Coding != CodingUTF8 || buf.NoFinalNewline {
+		t.Error("Wrong coding system detected:", buf.codingSystemName())
+	}
+}
+
+func TestRoundTripDos(t *testing.T) {
+	buf := roundTrip([]byte("foo\r\nbar\r\n"), t)
+	buf.FailIfBufferNe([]string{"foo", "bar"}, t)
+	if buf.Eol != EolDos {
+		t.Error("Expected dos line endings, got", buf.Eol)
+	}
+}
+
+func TestRoundTripMac(t *testing.T) {
+	buf := roundTrip([]byte("foo\rbar\r"), t)
+	buf.FailIfBufferNe([]string{"foo", "bar"}, t)
+	if buf.Eol != EolMac {
+		t.Error("Expected mac line endings, got", buf.Eol)
+	}
+}
+
+func TestRoundTripMixedEol(t *testing.T) {
+	buf := roundTrip([]byte("foo\r\nbar\n"), t)
+	buf.FailIfBufferNe([]string{"foo\r", "bar"}, t)
+	if buf.Eol != EolUnix {
+		t.Error("Expected unix line endings, got", buf.Eol)
+	}
+}
+
+func TestRoundTripNoFinalNewline(t *testing.T) {
+	buf := roundTrip([]byte("foo\nbar"), t)
+	buf.FailIfBufferNe([]string{"foo", "bar"}, t)
+	if !buf.NoFinalNewline {
+		t.Error("Missing final newline was not detected")
+	}
+}
+
+func TestRoundTripLatin1(t *testing.T) {
+	buf := roundTrip([]byte("caf\xe9\n"), t)
+	buf.FailIfBufferNe([]string{"café"}, t)
+	if buf.Coding != CodingLatin1 {
+		t.Error("Expected latin-1, got", buf.Coding)
+	}
+}
+
+func TestRoundTripUTF8BOM(t *testing.T) {
+	buf := roundTrip([]byte("\xef\xbb\xbfcafé\n"), t)
+	buf.FailIfBufferNe([]string{"café"}, t)
+	if buf.Coding != CodingUTF8BOM {
+		t.Error("Expected utf-8-with-signature, got", buf.Coding)
+	}
+}
+
+func TestRoundTripUTF16LE(t *testing.T) {
+	buf := roundTrip([]byte("\xff\xfeh\x00i\x00\r\x00\n\x00"), t)
+	buf.FailIfBufferNe([]string{"hi"}, t)
+	if buf.Coding != CodingUTF16LE || buf.Eol != EolDos {
+		t.Error("Expected utf-16le-dos, got", buf.codingSystemName())
+	}
+}
+
+func TestRoundTripEmpty(t *testing.T) {
+	buf := roundTrip([]byte{}, t)
+	if buf.NumRows() != 0 {
+		t.Error("Expected empty buffer but had", buf.NumRows(), "rows")
+	}
+}
+
+func TestConvertCodingSystem(t *testing.T) {
+	InitEditor()
+	editorInsertStr("café")
+	buf := Global.CurrentB
+	err := buf.setCodingSystem("latin-1-dos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.NoFinalNewline = true
+	data, err := buf.encodeContents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("caf\xe9")) {
+		t.Errorf("Encoded as %q", data)
+	}
+	editorInsertNewline(false)
+	editorInsertStr("日本")
+	_, err = buf.encodeContents()
+	if err == nil {
+		t.Error("Expected an error encoding CJK in latin-1")
+	}
+	err = buf.setCodingSystem("nonsense")
+	if err == nil {
+		t.Error("Expected an error for an unknown coding system")
+	}
+}
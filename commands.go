@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	glisp "github.com/glycerine/zygomys/zygo"
 )
@@ -202,9 +203,36 @@ func RunNamedCommand(env *glisp.Zlisp, cmdname string) error {
 	}
 }
 
+// Every command gets a number, so that all the undo records it makes (see
+// editorPushUndo) can be undone in one go. Commands run by other commands
+// share the number of the outermost one.
+var commandSeq, commandDepth int
+
+// Whether the running command types or deletes a character, so that a run of
+// them can be undone together (see canMergeUndo).
+var commandTypes bool
+
+func typesCharacter(cmd *CommandFunc) bool {
+	return cmd.Name == "delete-char" || cmd.Name == "delete-backward-char" ||
+		utf8.RuneCountInString(cmd.Name) == 1 // Self-inserting
+}
+
 func (cmd *CommandFunc) Run(env *glisp.Zlisp) error {
 	if cmd.Com != nil {
-		cmd.Com(env)
+		if commandDepth == 0 {
+			commandSeq++
+			commandTypes = typesCharacter(cmd)
+		}
+		commandDepth++
+		func() {
+			defer func() { commandDepth-- }()
+			buf := Global.CurrentB
+			if commandDepth == 1 && buf != nil && len(buf.cursors) > 0 && runsAtCursors(cmd) {
+				buf.runAtCursors(func() { cmd.Com(env) })
+			} else {
+				cmd.Com(env)
+			}
+		}()
 		if !cmd.NoRepeat {
 			Global.LastCommand = cmd
 			Global.LastCommandSetUniversal = Global.SetUniversal
@@ -249,6 +277,10 @@ func LoadDefaultCommands() {
 		func(env *glisp.Zlisp) { DescribeKeyBriefly() }, false})
 	DefineCommand(&CommandFunc{"run-command", RunCommand, true})
 	DefineCommand(&CommandFunc{"redo", editorRedoAction, false})
+	DefineCommand(&CommandFunc{"undo-in-region", undoInRegion, false})
+	DefineCommand(&CommandFunc{"undo-tree-visualize", undoTreeVisualize, false})
+	DefineCommand(&CommandFunc{"undo-tree-switch-branch", undoTreeSwitchBranch, false})
+	DefineCommand(&CommandFunc{"undo-tree-jump-to-time", undoTreeJumpToTime, false})
 	DefineCommand(&CommandFunc{"suspend-emacs",
 		func(env *glisp.Zlisp) { suspend() }, false})
 	DefineCommand(&CommandFunc{"move-end-of-line",
@@ -276,7 +308,113 @@ func LoadDefaultCommands() {
 	DefineCommand(&CommandFunc{"insert-newline",
 		func(env *glisp.Zlisp) { editorInsertNewline(false) }, false})
 	DefineCommand(&CommandFunc{"isearch",
-		func(env *glisp.Zlisp) { editorFind() }, false})
+		func(env *glisp.Zlisp) { editorFind(false, false) }, false})
+	DefineCommand(&CommandFunc{"isearch-backward",
+		func(env *glisp.Zlisp) { editorFind(false, true) }, false})
+	DefineCommand(&CommandFunc{"isearch-forward-regexp",
+		func(env *glisp.Zlisp) { editorFind(true, false) }, false})
+	DefineCommand(&CommandFunc{"isearch-backward-regexp",
+		func(env *glisp.Zlisp) { editorFind(true, true) }, false})
+	DefineCommand(&CommandFunc{"occur",
+		func(env *glisp.Zlisp) { occur() }, false})
+	DefineCommand(&CommandFunc{"occur-mode-goto-occurrence",
+		func(env *glisp.Zlisp) { occurGotoOccurrence() }, false})
+	DefineCommand(&CommandFunc{"occur-next",
+		func(env *glisp.Zlisp) { occurNext(1) }, false})
+	DefineCommand(&CommandFunc{"occur-prev",
+		func(env *glisp.Zlisp) { occurNext(-1) }, false})
+	DefineCommand(&CommandFunc{"occur-revert",
+		func(env *glisp.Zlisp) { occurRevert() }, false})
+	DefineCommand(&CommandFunc{"occur-edit-mode",
+		func(env *glisp.Zlisp) { occurEditMode() }, false})
+	DefineCommand(&CommandFunc{"occur-cease-edit",
+		func(env *glisp.Zlisp) { occurCeaseEdit() }, false})
+	DefineCommand(&CommandFunc{"quit-window",
+		func(env *glisp.Zlisp) { quitWindow() }, false})
+	DefineCommand(&CommandFunc{"grep",
+		func(env *glisp.Zlisp) { grepCommand(false) }, false})
+	DefineCommand(&CommandFunc{"rgrep",
+		func(env *glisp.Zlisp) { grepCommand(true) }, false})
+	DefineCommand(&CommandFunc{"next-error",
+		func(env *glisp.Zlisp) { nextError(env, 1, false) }, false})
+	DefineCommand(&CommandFunc{"previous-error",
+		func(env *glisp.Zlisp) { nextError(env, -1, false) }, false})
+	DefineCommand(&CommandFunc{"next-error-no-select",
+		func(env *glisp.Zlisp) { nextError(env, 1, true) }, false})
+	DefineCommand(&CommandFunc{"previous-error-no-select",
+		func(env *glisp.Zlisp) { nextError(env, -1, true) }, false})
+	DefineCommand(&CommandFunc{"compile-goto-error",
+		func(env *glisp.Zlisp) { gotoError(env) }, false})
+	DefineCommand(&CommandFunc{"compile",
+		func(env *glisp.Zlisp) { compileCommand() }, false})
+	DefineCommand(&CommandFunc{"recompile",
+		func(env *glisp.Zlisp) { recompile() }, false})
+	DefineCommand(&CommandFunc{"kill-compilation",
+		func(env *glisp.Zlisp) { killCompilation() }, false})
+	DefineCommand(&CommandFunc{"shell",
+		func(env *glisp.Zlisp) { shellCommand() }, false})
+	DefineCommand(&CommandFunc{"comint-send-input",
+		func(env *glisp.Zlisp) {
+			if s := currentShell(); s != nil {
+				s.sendInput()
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"comint-previous-input",
+		func(env *glisp.Zlisp) {
+			if s := currentShell(); s != nil {
+				s.historyMove(1)
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"comint-next-input",
+		func(env *glisp.Zlisp) {
+			if s := currentShell(); s != nil {
+				s.historyMove(-1)
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"comint-interrupt-subjob",
+		func(env *glisp.Zlisp) {
+			if s := currentShell(); s != nil {
+				s.interrupt()
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"comint-send-eof",
+		func(env *glisp.Zlisp) {
+			if s := currentShell(); s != nil {
+				s.sendEOF()
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"comint-bol",
+		func(env *glisp.Zlisp) {
+			if s := currentShell(); s != nil {
+				s.bol()
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"comint-dynamic-complete-filename",
+		func(env *glisp.Zlisp) {
+			if s := currentShell(); s != nil {
+				s.complete()
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"term",
+		func(env *glisp.Zlisp) { termCommand() }, false})
+	DefineCommand(&CommandFunc{"term-char-mode",
+		func(env *glisp.Zlisp) {
+			if t := currentTerm(); t != nil {
+				t.setCharMode(true)
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"term-line-mode",
+		func(env *glisp.Zlisp) {
+			if t := currentTerm(); t != nil {
+				t.setCharMode(false)
+			}
+		}, false})
+	DefineCommand(&CommandFunc{"term-interrupt-subjob",
+		func(env *glisp.Zlisp) {
+			if t := currentTerm(); t != nil {
+				t.send("\x03")
+			}
+		}, false})
 	DefineCommand(&CommandFunc{"buffers-list",
 		func(env *glisp.Zlisp) { editorSwitchBuffer() }, false})
 	DefineCommand(&CommandFunc{"end-of-buffer",
@@ -324,6 +462,31 @@ func LoadDefaultCommands() {
 		func(env *glisp.Zlisp) {
 			doYankRegion()
 		}, false})
+	DefineCommand(&CommandFunc{"yank-pop",
+		func(env *glisp.Zlisp) {
+			doYankPop()
+		}, false})
+	DefineCommand(&CommandFunc{"browse-kill-ring",
+		func(env *glisp.Zlisp) {
+			browseKillRing()
+		}, false})
+	DefineCommand(&CommandFunc{"mc-add-cursor-next-line",
+		func(env *glisp.Zlisp) {
+			mcAddCursorNextLine()
+		}, false})
+	DefineCommand(&CommandFunc{"mc-mark-next-like-this",
+		func(env *glisp.Zlisp) {
+			mcMarkNextLikeThis()
+		}, false})
+	DefineCommand(&CommandFunc{"mc-mark-all-like-this",
+		func(env *glisp.Zlisp) {
+			mcMarkAllLikeThis()
+		}, false})
+	DefineCommand(&CommandFunc{"mc-remove-cursors",
+		func(env *glisp.Zlisp) {
+			Global.CurrentB.clearCursors()
+			Global.Input = "Removed the extra cursors"
+		}, false})
 	DefineCommand(&CommandFunc{"copy-region",
 		func(env *glisp.Zlisp) {
 			doCopyRegion()
@@ -420,6 +583,7 @@ and press n.
 - C-w - Kill (cut) the region (the space between the mark and cursor)
 - M-w - Copy the region
 - C-y - Yank (paste) the last thing you killed or copied.
+- M-y - Straight after yanking, replace the yanked text with an older kill.
 
 Current key bindings:
 `, WalkCommandTree(Emacs, ""))
@@ -428,6 +592,100 @@ Current key bindings:
 		func(env *glisp.Zlisp) {
 			DiredMode(env)
 		}, false})
+	DefineCommand(&CommandFunc{"dired-find-file",
+		func(env *glisp.Zlisp) { diredFindFile(env, false) }, false})
+	DefineCommand(&CommandFunc{"dired-find-file-other-window",
+		func(env *glisp.Zlisp) { diredFindFile(env, true) }, false})
+	DefineCommand(&CommandFunc{"dired-up-directory",
+		func(env *glisp.Zlisp) { diredUpDirectory() }, false})
+	DefineCommand(&CommandFunc{"dired-next-line",
+		func(env *glisp.Zlisp) { diredNextLine(1) }, false})
+	DefineCommand(&CommandFunc{"dired-previous-line",
+		func(env *glisp.Zlisp) { diredNextLine(-1) }, false})
+	DefineCommand(&CommandFunc{"dired-mark",
+		func(env *glisp.Zlisp) { diredMark('*', 1) }, false})
+	DefineCommand(&CommandFunc{"dired-unmark",
+		func(env *glisp.Zlisp) { diredMark(0, 1) }, false})
+	DefineCommand(&CommandFunc{"dired-unmark-backward",
+		func(env *glisp.Zlisp) { diredMark(0, -1) }, false})
+	DefineCommand(&CommandFunc{"dired-flag-file-deletion",
+		func(env *glisp.Zlisp) { diredMark('D', 1) }, false})
+	DefineCommand(&CommandFunc{"dired-unmark-all-marks",
+		func(env *glisp.Zlisp) { diredUnmarkAll() }, false})
+	DefineCommand(&CommandFunc{"dired-do-flagged-delete",
+		func(env *glisp.Zlisp) { diredDoDelete('D') }, false})
+	DefineCommand(&CommandFunc{"dired-do-delete",
+		func(env *glisp.Zlisp) { diredDoDelete('*') }, false})
+	DefineCommand(&CommandFunc{"dired-do-copy",
+		func(env *glisp.Zlisp) { diredDoTransfer(false) }, false})
+	DefineCommand(&CommandFunc{"dired-do-rename",
+		func(env *glisp.Zlisp) { diredDoTransfer(true) }, false})
+	DefineCommand(&CommandFunc{"dired-do-chmod",
+		func(env *glisp.Zlisp) { diredDoChmod() }, false})
+	DefineCommand(&CommandFunc{"dired-create-directory",
+		func(env *glisp.Zlisp) { diredCreateDirectory() }, false})
+	DefineCommand(&CommandFunc{"dired-sort-toggle",
+		func(env *glisp.Zlisp) { diredSortToggle() }, false})
+	DefineCommand(&CommandFunc{"dired-toggle-hidden",
+		func(env *glisp.Zlisp) { diredToggleHidden() }, false})
+	DefineCommand(&CommandFunc{"dired-revert",
+		func(env *glisp.Zlisp) { diredRevert() }, false})
+	DefineCommand(&CommandFunc{"wdired",
+		func(env *glisp.Zlisp) { wdiredCommand() }, false})
+	DefineCommand(&CommandFunc{"wdired-finish-edit",
+		func(env *glisp.Zlisp) { wdiredFinishEdit() }, false})
+	DefineCommand(&CommandFunc{"wdired-abort-changes",
+		func(env *glisp.Zlisp) { wdiredAbortChanges() }, false})
+	DefineCommand(&CommandFunc{"lsp",
+		func(env *glisp.Zlisp) { lspCommand() }, false})
+	DefineCommand(&CommandFunc{"lsp-shutdown",
+		func(env *glisp.Zlisp) { lspShutdownCommand() }, false})
+	DefineCommand(&CommandFunc{"lsp-describe-thing-at-point",
+		func(env *glisp.Zlisp) { lspDescribeThingAtPoint() }, false})
+	DefineCommand(&CommandFunc{"lsp-rename",
+		func(env *glisp.Zlisp) { lspRename(env) }, false})
+	DefineCommand(&CommandFunc{"lsp-diagnostics",
+		func(env *glisp.Zlisp) { lspListDiagnostics() }, false})
+	DefineCommand(&CommandFunc{"format-buffer", formatBufferCmd, false})
+	DefineCommand(&CommandFunc{"vc-diff",
+		func(env *glisp.Zlisp) { vcDiff() }, false})
+	DefineCommand(&CommandFunc{"diff-goto-source", diffGotoSource, false})
+	DefineCommand(&CommandFunc{"diff-hunk-next",
+		func(env *glisp.Zlisp) { diffHunkMove(1) }, false})
+	DefineCommand(&CommandFunc{"diff-hunk-prev",
+		func(env *glisp.Zlisp) { diffHunkMove(-1) }, false})
+	DefineCommand(&CommandFunc{"vc-annotate",
+		func(env *glisp.Zlisp) { vcAnnotate() }, false})
+	DefineCommand(&CommandFunc{"vc-annotate-goto-line", vcAnnotateGotoLine, false})
+	DefineCommand(&CommandFunc{"vc-annotate-show-commit",
+		func(env *glisp.Zlisp) { vcAnnotateShowCommit() }, false})
+	DefineCommand(&CommandFunc{"vc-stage", vcStage, false})
+	DefineCommand(&CommandFunc{"vc-next-hunk",
+		func(env *glisp.Zlisp) { vcHunkMove(1) }, false})
+	DefineCommand(&CommandFunc{"vc-previous-hunk",
+		func(env *glisp.Zlisp) { vcHunkMove(-1) }, false})
+	DefineCommand(&CommandFunc{"vc-revert-hunk",
+		func(env *glisp.Zlisp) { vcRevertHunk() }, false})
+	DefineCommand(&CommandFunc{"vc-commit",
+		func(env *glisp.Zlisp) { vcCommit() }, false})
+	DefineCommand(&CommandFunc{"vc-log-finish",
+		func(env *glisp.Zlisp) { vcLogFinish() }, false})
+	DefineCommand(&CommandFunc{"vc-log-cancel",
+		func(env *glisp.Zlisp) { vcLogCancel() }, false})
+	DefineCommand(&CommandFunc{"completion-at-point",
+		func(env *glisp.Zlisp) { completionAtPoint() }, false})
+	DefineCommand(&CommandFunc{"xref-find-definitions",
+		func(env *glisp.Zlisp) { xrefFindDefinitions(env) }, false})
+	DefineCommand(&CommandFunc{"xref-find-references",
+		func(env *glisp.Zlisp) { xrefFindReferences(env) }, false})
+	DefineCommand(&CommandFunc{"xref-go-back",
+		func(env *glisp.Zlisp) { xrefGoBack() }, false})
+	DefineCommand(&CommandFunc{"find-tag",
+		func(env *glisp.Zlisp) { findTag(env) }, false})
+	DefineCommand(&CommandFunc{"pop-tag-mark",
+		func(env *glisp.Zlisp) { xrefGoBack() }, false})
+	DefineCommand(&CommandFunc{"visit-tags-table",
+		func(env *glisp.Zlisp) { visitTagsTable() }, false})
 	DefineCommand(&CommandFunc{"goto-line",
 		func(*glisp.Zlisp) {
 			gotoLine()
@@ -532,9 +790,10 @@ Current key bindings:
 		DefineCommand(&CommandFunc{"debug-undo", func(*glisp.Zlisp) { showMessages(fmt.Sprint(Global.CurrentB.Undo, "\n", Global.CurrentB.Undo.prev)) }, false})
 		DefineCommand(&CommandFunc{"debug-universal", func(*glisp.Zlisp) { showMessages(fmt.Sprint(Global.Universal), fmt.Sprint(Global.SetUniversal)) }, false})
 		DefineCommand(&CommandFunc{"debug-buffer", func(*glisp.Zlisp) {
-			linedata := make([]string, Global.CurrentB.NumRows+2)
+			linedata := make([]string, Global.CurrentB.NumRows()+2)
 			linedata[0] = fmt.Sprintf("cx: %d, cy: %d", Global.CurrentB.cx, Global.CurrentB.cy)
-			for i, row := range Global.CurrentB.Rows {
+			for i := 0; i < Global.CurrentB.NumRows(); i++ {
+				row := Global.CurrentB.Row(i)
 				linedata[i+1] = fmt.Sprintf("Size: %d, data: \"%s\"", row.Size, row.Data)
 			}
 			showMessages(linedata...)
@@ -690,4 +949,28 @@ Current key bindings:
 		func(env *glisp.Zlisp) {
 			editorDeleteIndentation()
 		}, false})
+	DefineCommand(&CommandFunc{"set-buffer-file-coding-system",
+		func(env *glisp.Zlisp) {
+			setBufferFileCodingSystem()
+		}, false})
+	DefineCommand(&CommandFunc{"toggle-final-newline",
+		func(env *glisp.Zlisp) {
+			toggleFinalNewline()
+		}, false})
+	DefineCommand(&CommandFunc{"recover-file",
+		func(env *glisp.Zlisp) {
+			recoverFile(env)
+		}, false})
+	DefineCommand(&CommandFunc{"revert-buffer",
+		func(env *glisp.Zlisp) {
+			revertBuffer(env)
+		}, false})
+	DefineCommand(&CommandFunc{"read-only-mode",
+		func(env *glisp.Zlisp) {
+			doToggleMode("read-only-mode")
+		}, false})
+	DefineCommand(&CommandFunc{"do-auto-save",
+		func(env *glisp.Zlisp) {
+			doAutoSave()
+		}, false})
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Compilation runs a shell command in the background, adding its output to the
+// *compilation* buffer as it comes. The buffer is an error list (see grep.go),
+// so next-error goes through the messages in it.
+
+type compilation struct {
+	command string
+	dir     string
+	cmd     *exec.Cmd
+	buf     *EditorBuffer
+	killed  bool
+	done    bool
+	gen     int
+}
+
+var currentCompilation *compilation
+
+// Each compilation gets a number, and the buffer remembers the number of the
+// one it was last filled for, so that one that's been replaced by another
+// (which reuses the buffer) can't add to it.
+var compileGeneration int
+var lastCompileCommand = "make -k"
+var lastCompileDir string
+
+// Add a line of output to a read-only list, keeping the cursor at the end if
+// it was there.
+func appendOutput(buf *EditorBuffer, line string) {
+	follow := buf.cy == buf.NumRows()-1
+	row := &EditorRow{Size: len(line), Data: line}
+	rowUpdateRender(row)
+	buf.InsertRows(buf.NumRows(), row)
+	if follow {
+		buf.cy = buf.NumRows() - 1
+		buf.cx, buf.prefcx = 0, 0
+		if buf.cy >= buf.rowoff+Global.CurrentBHeight-1 {
+			buf.rowoff = buf.cy - Global.CurrentBHeight + 2
+		}
+	}
+}
+
+// Start running command in dir, putting its output in the *compilation*
+// buffer.
+func startCompilation(command, dir string) (*compilation, error) {
+	buf := namedBuffer("*compilation*")
+	fillErrorList(buf, &errorList{dir, nil, -1}, "compilation", []string{
+		"-*- mode: compilation; default-directory: " + fmt.Sprintf("%q", dir) + " -*-",
+		"Compilation started at " + time.Now().Format(time.ANSIC),
+		"",
+		command,
+	})
+	buf.cy = buf.NumRows() - 1
+	buf.lazyHighlight = errorLineRe
+	buf.lazyHighlightSkip = 0
+	compileGeneration++
+	buf.compileGen = compileGeneration
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	setProcessGroup(cmd)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	err := cmd.Start()
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+	c := &compilation{command, dir, cmd, buf, false, false, compileGeneration}
+	waited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waited <- err
+	}()
+	go func() {
+		rd := bufio.NewReader(pr)
+		for {
+			line, err := rd.ReadString('\n')
+			if line != "" {
+				line = strings.TrimRight(line, "\r\n")
+				runOnMain(func() {
+					if c.current() {
+						appendOutput(buf, line)
+					}
+				})
+			}
+			if err != nil {
+				break
+			}
+		}
+		err := <-waited
+		runOnMain(func() { c.finish(err) })
+	}()
+	return c, nil
+}
+
+// Whether the compilation's output still goes in its buffer.
+func (c *compilation) current() bool {
+	return c.buf.compileGen == c.gen
+}
+
+// Say how the compilation ended.
+func (c *compilation) finish(err error) {
+	c.done = true
+	if currentCompilation == c {
+		currentCompilation = nil
+	}
+	if !c.current() {
+		return
+	}
+	msg := "finished"
+	if c.killed {
+		msg = "killed"
+	} else if exit, ok := err.(*exec.ExitError); ok {
+		msg = fmt.Sprintf("exited abnormally with code %d", exit.ExitCode())
+	} else if err != nil {
+		msg = "failed: " + err.Error()
+	}
+	appendOutput(c.buf, "")
+	appendOutput(c.buf, "Compilation "+msg+" at "+time.Now().Format(time.ANSIC))
+	Global.Input = "Compilation " + msg
+}
+
+// Stop the running compilation.
+func killCompilation() {
+	c := currentCompilation
+	if c == nil || c.done {
+		Global.Input = "No compilation running"
+		return
+	}
+	c.killed = true
+	err := killProcessGroup(c.cmd)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+	}
+}
+
+// Run command in dir, after asking to kill the compilation that's running if
+// there is one.
+func runCompile(command, dir string) {
+	if c := currentCompilation; c != nil && !c.done {
+		kill, _ := editorYesNoPrompt("A compilation process is running; kill it?", false)
+		if !kill {
+			Global.Input = "Cancelled."
+			return
+		}
+		c.killed = true
+		killProcessGroup(c.cmd)
+		currentCompilation = nil
+	}
+	lastCompileCommand, lastCompileDir = command, dir
+	c, err := startCompilation(command, dir)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+		return
+	}
+	currentCompilation = c
+	shown := getWindowWithProps(func(t *winTree) bool { return t.buf == c.buf }, Global.WindowTree)
+	if shown == nil {
+		callFunOtherWindowAndGoBack(func() {
+			getFocusWindow().buf = c.buf
+			Global.CurrentB = c.buf
+		})
+	}
+	Global.Input = "Compiling: " + command
+}
+
+func compileCommand() {
+	command := editorPrompt("Compile command (default "+lastCompileCommand+")", nil)
+	if command == "" {
+		command = lastCompileCommand
+	}
+	runCompile(command, defaultDirectory())
+}
+
+// Run the last compile command again, where it was run before.
+func recompile() {
+	dir := lastCompileDir
+	if dir == "" {
+		dir = defaultDirectory()
+	}
+	runCompile(lastCompileCommand, dir)
+}
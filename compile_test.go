@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run what the compilation sends to the main loop until it's done.
+func waitForCompilation(t *testing.T, c *compilation) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !c.done {
+		if time.Now().After(deadline) {
+			t.Fatal("Compilation didn't finish")
+		}
+		if !runQueuedJobs() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	InitEditor()
+	dir, err := ioutil.TempDir("", "gomacs-compile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a.go": "package a\n\nfunc f() { x }\n"})
+	c, err := startCompilation("echo building; echo '  a.go:3:12: undefined: x' >&2; exit 2", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCompilation(t, c)
+	buf := c.buf
+	if buf.MajorMode != "compilation" || buf.Rendername != "*compilation*" {
+		t.Errorf("Expected the *compilation* buffer in compilation mode")
+	}
+	if buf.Row(4).Data != "building" || buf.Row(5).Data != "  a.go:3:12: undefined: x" {
+		t.Errorf("Expected the command's output, got %q and %q", buf.Row(4).Data, buf.Row(5).Data)
+	}
+	last := buf.Row(buf.NumRows() - 1).Data
+	if !strings.HasPrefix(last, "Compilation exited abnormally with code 2 at ") {
+		t.Errorf("Expected the exit code, got %q", last)
+	}
+
+	nextError(nil, 1, false)
+	if Global.CurrentB.Filename != filepath.Join(dir, "a.go") {
+		t.Fatalf("Expected next-error to visit a.go, got %q", Global.CurrentB.Filename)
+	}
+	if Global.CurrentB.cy != 2 || Global.CurrentB.cx != 11 {
+		t.Errorf("Expected to be at 3:12, got %d:%d", Global.CurrentB.cy+1, Global.CurrentB.cx+1)
+	}
+}
+
+func TestKillCompilation(t *testing.T) {
+	InitEditor()
+	c, err := startCompilation("echo started; sleep 10; echo never", os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentCompilation = c
+	start := time.Now()
+	killCompilation()
+	waitForCompilation(t, c)
+	if 5*time.Second < time.Since(start) {
+		t.Error("Expected kill-compilation to stop the command straight away")
+	}
+	if currentCompilation != nil {
+		t.Error("Expected no compilation to be running")
+	}
+	last := c.buf.Row(c.buf.NumRows() - 1).Data
+	if !strings.HasPrefix(last, "Compilation killed at ") {
+		t.Errorf("Expected the compilation to say it was killed, got %q", last)
+	}
+	c.buf.EachRow(0, c.buf.NumRows(), func(i int, row *EditorRow) bool {
+		if row.Data == "never" {
+			t.Error("Expected the command not to finish")
+		}
+		return true
+	})
+}
+
+func TestRecompileWhileRunning(t *testing.T) {
+	InitEditor()
+	old, err := startCompilation("echo old; sleep 10; echo never", os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for old.buf.NumRows() < 5 {
+		if !runQueuedJobs() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	old.killed = true
+	killProcessGroup(old.cmd)
+	c, err := startCompilation("echo new", os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCompilation(t, old)
+	waitForCompilation(t, c)
+	if c.buf.NumRows() != 7 || c.buf.Row(4).Data != "new" {
+		t.Errorf("Expected only the new compilation's output, got %q", lspText(c.buf))
+	}
+	if Global.Input != "Compilation finished" {
+		t.Errorf("Expected the new compilation to finish, got %q", Global.Input)
+	}
+}
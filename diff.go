@@ -0,0 +1,173 @@
+package main
+
+import "fmt"
+
+// Line diffs, by Myers' algorithm, for changing a buffer into new text with as
+// few edits as possible, and for showing people what has changed.
+
+// Rows [oldStart, oldEnd) of the old lines are replaced by rows [newStart,
+// newEnd) of the new ones.
+type diffHunk struct {
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// Past this many differing lines, the remaining middle is replaced as a whole
+// rather than spend quadratic time and memory finding the best diff.
+var diffMaxEdits = 1000
+
+// The hunks that change a into b, in order, with at least one unchanged line
+// between each.
+func diffLines(a, b []string) []diffHunk {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	hunks := diffMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	for i := range hunks {
+		hunks[i].oldStart += pre
+		hunks[i].oldEnd += pre
+		hunks[i].newStart += pre
+		hunks[i].newEnd += pre
+	}
+	return hunks
+}
+
+func diffMiddle(a, b []string) []diffHunk {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+	whole := []diffHunk{{0, n, 0, m}}
+	if n == 0 || m == 0 {
+		return whole
+	}
+	// v[max+k] is how far along a the furthest path on diagonal k has got;
+	// trace[d] is a copy of v[max-d:max+d+1] after d edits.
+	max := n + m
+	v := make([]int, 2*max+1)
+	trace := [][]int{}
+	for d := 0; ; d++ {
+		if diffMaxEdits < d {
+			return whole
+		}
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if n <= x && m <= y {
+				done = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+		if done {
+			break
+		}
+	}
+
+	// Walk back from the end, noting each line deleted from a or inserted
+	// from b, then gather neighbouring ones into hunks.
+	type edit struct {
+		x, y int
+		del  bool
+	}
+	edits := []edit{}
+	x, y := n, m
+	for d := len(trace) - 1; 0 < d; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+		edits = append(edits, edit{px, py, pk == k-1})
+		x, y = px, py
+	}
+	hunks := []diffHunk{}
+	for i := len(edits) - 1; 0 <= i; i-- {
+		e := edits[i]
+		l := len(hunks) - 1
+		if l < 0 || hunks[l].oldEnd != e.x || hunks[l].newEnd != e.y {
+			hunks = append(hunks, diffHunk{e.x, e.x, e.y, e.y})
+			l++
+		}
+		if e.del {
+			hunks[l].oldEnd++
+		} else {
+			hunks[l].newEnd++
+		}
+	}
+	return hunks
+}
+
+// The hunks of a unified diff from a to b, each with its "@@" line and up to
+// context unchanged lines around the changes.
+func unifiedDiff(a, b []string, context int) []string {
+	hunks := diffLines(a, b)
+	ret := []string{}
+	for i := 0; i < len(hunks); {
+		// Hunks close enough for their context to meet go together
+		j := i + 1
+		for j < len(hunks) && hunks[j].oldStart-hunks[j-1].oldEnd <= 2*context {
+			j++
+		}
+		first, last := hunks[i], hunks[j-1]
+		oldFrom := first.oldStart - context
+		if oldFrom < 0 {
+			oldFrom = 0
+		}
+		oldTo := last.oldEnd + context
+		if len(a) < oldTo {
+			oldTo = len(a)
+		}
+		newFrom := first.newStart - (first.oldStart - oldFrom)
+		newTo := last.newEnd + (oldTo - last.oldEnd)
+		ret = append(ret, fmt.Sprintf("@@ -%s +%s @@", hunkRange(oldFrom, oldTo), hunkRange(newFrom, newTo)))
+		pos := oldFrom
+		for _, h := range hunks[i:j] {
+			for _, l := range a[pos:h.oldStart] {
+				ret = append(ret, " "+l)
+			}
+			for _, l := range a[h.oldStart:h.oldEnd] {
+				ret = append(ret, "-"+l)
+			}
+			for _, l := range b[h.newStart:h.newEnd] {
+				ret = append(ret, "+"+l)
+			}
+			pos = h.oldEnd
+		}
+		for _, l := range a[pos:oldTo] {
+			ret = append(ret, " "+l)
+		}
+		i = j
+	}
+	return ret
+}
+
+// Lines [from, to) as a hunk header has them: the first line, counting from
+// 1, or the one before if there are none, and how many.
+func hunkRange(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("%d,0", from)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
@@ -1,53 +1,808 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	glisp "github.com/glycerine/zygomys/zygo"
+	"github.com/mitchellh/go-homedir"
 )
 
+// Dired: a directory listing in a buffer, ls -l style, whose files can be
+// marked and acted on.
+
+const (
+	diredSortName = iota
+	diredSortTime
+	diredSortSize
+)
+
+var diredSortNames = []string{"name", "time", "size"}
+
+type diredEntry struct {
+	name    string
+	info    os.FileInfo
+	nameCol int // Where the name starts in its row
+}
+
+type diredInfo struct {
+	dir        string
+	sortBy     int
+	hideHidden bool
+	marks      map[string]byte // '*' for marked, 'D' for flagged for deletion
+	entries    []diredEntry    // One per row, after the header
+}
+
+// Rows before the first entry
+const diredHeaderRows = 1
+
+// A mode like ls prints it.
+func lsMode(mode os.FileMode) string {
+	var sb strings.Builder
+	switch {
+	case mode&os.ModeDir != 0:
+		sb.WriteByte('d')
+	case mode&os.ModeSymlink != 0:
+		sb.WriteByte('l')
+	case mode&os.ModeNamedPipe != 0:
+		sb.WriteByte('p')
+	case mode&os.ModeSocket != 0:
+		sb.WriteByte('s')
+	case mode&os.ModeCharDevice != 0:
+		sb.WriteByte('c')
+	case mode&os.ModeDevice != 0:
+		sb.WriteByte('b')
+	default:
+		sb.WriteByte('-')
+	}
+	// setuid, setgid and sticky show where x would, as themselves with x and
+	// in upper case without it
+	special := []struct {
+		set    bool
+		x, noX byte
+	}{
+		{mode&os.ModeSetuid != 0, 's', 'S'},
+		{mode&os.ModeSetgid != 0, 's', 'S'},
+		{mode&os.ModeSticky != 0, 't', 'T'},
+	}
+	for i := 0; i < 3; i++ {
+		bits := mode.Perm() >> uint(6-3*i)
+		for j, c := range "rw" {
+			if bits&(4>>uint(j)) != 0 {
+				sb.WriteRune(c)
+			} else {
+				sb.WriteByte('-')
+			}
+		}
+		x := bits&1 != 0
+		switch {
+		case special[i].set && x:
+			sb.WriteByte(special[i].x)
+		case special[i].set:
+			sb.WriteByte(special[i].noX)
+		case x:
+			sb.WriteByte('x')
+		default:
+			sb.WriteByte('-')
+		}
+	}
+	return sb.String()
+}
+
+// A modification time like ls prints it: the year for old files, otherwise
+// the time of day.
+func lsTime(t time.Time) string {
+	if time.Since(t) > 182*24*time.Hour || time.Until(t) > time.Hour {
+		return t.Format("Jan _2  2006")
+	}
+	return t.Format("Jan _2 15:04")
+}
+
+func isHiddenFile(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// Read the directory, sorted as asked.
+func (d *diredInfo) read() ([]diredEntry, error) {
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := []diredEntry{}
+	for _, name := range []string{".", ".."} {
+		if info, err := os.Stat(filepath.Join(d.dir, name)); err == nil {
+			entries = append(entries, diredEntry{name: name, info: info})
+		}
+	}
+	files := []diredEntry{}
+	for _, info := range infos {
+		if d.hideHidden && isHiddenFile(info.Name()) {
+			continue
+		}
+		files = append(files, diredEntry{name: info.Name(), info: info})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i].info, files[j].info
+		switch d.sortBy {
+		case diredSortTime:
+			if !a.ModTime().Equal(b.ModTime()) {
+				return a.ModTime().After(b.ModTime())
+			}
+		case diredSortSize:
+			if a.Size() != b.Size() {
+				return a.Size() > b.Size()
+			}
+		}
+		return a.Name() < b.Name()
+	})
+	return append(entries, files...), nil
+}
+
+// The row for an entry, and where its name starts.
+func (d *diredInfo) formatEntry(e diredEntry, sizeWidth int) (string, int) {
+	mark := d.marks[e.name]
+	if mark == 0 {
+		mark = ' '
+	}
+	prefix := fmt.Sprintf("%c %s %*d %s ", mark, lsMode(e.info.Mode()), sizeWidth,
+		e.info.Size(), lsTime(e.info.ModTime()))
+	row := prefix + e.name
+	if e.info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(filepath.Join(d.dir, e.name)); err == nil {
+			row += " -> " + target
+		}
+	}
+	return row, len(prefix)
+}
+
+// Fill buf with the listing of its directory, keeping the cursor on the same
+// file if it's still there.
+func (d *diredInfo) revert(buf *EditorBuffer) error {
+	current, oldcy := "", buf.cy
+	if e := d.entryAt(buf.cy); e != nil {
+		current = e.name
+	}
+	entries, err := d.read()
+	if err != nil {
+		return err
+	}
+	sizeWidth := 1
+	for _, e := range entries {
+		if w := len(strconv.FormatInt(e.info.Size(), 10)); w > sizeWidth {
+			sizeWidth = w
+		}
+	}
+	present := map[string]bool{}
+	lines := []string{"  " + d.dir + ":"}
+	for i := range entries {
+		present[entries[i].name] = true
+		var line string
+		line, entries[i].nameCol = d.formatEntry(entries[i], sizeWidth)
+		lines = append(lines, line)
+	}
+	for name := range d.marks {
+		if !present[name] {
+			delete(d.marks, name)
+		}
+	}
+	d.entries = entries
+	rows := make([]*EditorRow, len(lines))
+	for i, line := range lines {
+		rows[i] = &EditorRow{Size: len(line), Data: line}
+		rowUpdateRender(rows[i])
+	}
+	buf.SetRows(rows)
+	buf.clearUndo()
+	buf.Dirty = false
+	buf.MajorMode = "dired-mode"
+	buf.Highlighter = nil
+	buf.setMode("read-only-mode", true)
+	buf.dired = d
+	buf.cy = -1
+	for i, e := range entries {
+		if e.name == current || (current == "" && buf.cy < 0 && e.name != "." && e.name != "..") {
+			buf.cy = i + diredHeaderRows
+		}
+	}
+	if buf.cy < 0 && current != "" {
+		// It's gone, so stay on the same row
+		buf.cy = oldcy
+	}
+	if buf.cy < diredHeaderRows || len(lines) <= buf.cy {
+		buf.cy = len(lines) - 1
+	}
+	d.toName(buf)
+	return nil
+}
+
+// The entry on row y, if there is one.
+func (d *diredInfo) entryAt(y int) *diredEntry {
+	i := y - diredHeaderRows
+	if i < 0 || len(d.entries) <= i {
+		return nil
+	}
+	return &d.entries[i]
+}
+
+// Put the cursor at the start of the file name on its row.
+func (d *diredInfo) toName(buf *EditorBuffer) {
+	buf.cx = 0
+	if e := d.entryAt(buf.cy); e != nil {
+		buf.cx = e.nameCol
+	}
+	buf.prefcx = buf.cx
+}
+
+// Show the listing of dir, in the buffer already listing it if there is one.
+func diredDirectory(dir string) *EditorBuffer {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		Global.Input = err.Error()
+		return nil
+	}
+	var buf *EditorBuffer
+	for _, b := range Global.Buffers {
+		if b.dired != nil && b.dired.dir == dir {
+			buf = b
+		}
+	}
+	if buf == nil {
+		d := &diredInfo{dir: dir, marks: map[string]byte{}}
+		buf = &EditorBuffer{Rendername: dir}
+		if err := d.revert(buf); err != nil {
+			Global.Input = err.Error()
+			AddErrorMessage(err.Error())
+			return nil
+		}
+		Global.Buffers = append(Global.Buffers, buf)
+	} else {
+		buf.dired.revert(buf)
+	}
+	getFocusWindow().buf = buf
+	Global.CurrentB = buf
+	return buf
+}
+
+// Ask for a directory and list it.
 func DiredMode(env *glisp.Zlisp) {
-	dir, perr := filepath.Abs("./")
-	if perr != nil {
-		Global.Input = perr.Error()
-		AddErrorMessage(perr.Error())
+	def := defaultDirectory()
+	dir := editorPrompt("Dired (directory) (default "+def+")", nil)
+	if dir == "" {
+		dir = def
+	}
+	dir, err := AbsPath(dir)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	diredDirectory(dir)
+}
+
+// The dired listing of the current buffer, if it is one.
+func currentDired() *diredInfo {
+	d := Global.CurrentB.dired
+	if d == nil {
+		Global.Input = "Not in a dired buffer"
+	}
+	return d
+}
+
+func (d *diredInfo) path(name string) string {
+	return filepath.Join(d.dir, name)
+}
+
+// Visit the file or directory on the cursor's row.
+func diredFindFile(env *glisp.Zlisp, otherWindow bool) {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	e := d.entryAt(Global.CurrentB.cy)
+	if e == nil {
+		Global.Input = "No file on this line"
+		return
+	}
+	fn := d.path(e.name)
+	visit := func() {
+		if info, err := os.Stat(fn); err == nil && info.IsDir() {
+			diredDirectory(fn)
+		} else {
+			visitFile(fn, env)
+		}
+	}
+	if otherWindow {
+		callFunOtherWindow(visit)
+	} else {
+		visit()
+	}
+}
+
+// List the directory above, with the cursor on this one.
+func diredUpDirectory() {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	here := filepath.Base(d.dir)
+	buf := diredDirectory(filepath.Dir(d.dir))
+	if buf == nil {
+		return
+	}
+	for i, e := range buf.dired.entries {
+		if e.name == here {
+			buf.cy = i + diredHeaderRows
+			buf.dired.toName(buf)
+		}
+	}
+}
+
+// Move down (or, if delta is negative, up) a line, to the file name.
+func diredNextLine(delta int) {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	buf := Global.CurrentB
+	y := buf.cy + delta
+	if y < diredHeaderRows {
+		y = diredHeaderRows
+	} else if diredHeaderRows+len(d.entries) <= y {
+		y = diredHeaderRows + len(d.entries) - 1
+	}
+	buf.cy = y
+	d.toName(buf)
+}
+
+// Redraw the row of an entry, after its mark has changed.
+func (d *diredInfo) redrawMark(buf *EditorBuffer, y int) {
+	e := d.entryAt(y)
+	if e == nil {
+		return
+	}
+	mark := d.marks[e.name]
+	if mark == 0 {
+		mark = ' '
+	}
+	row := buf.Row(y)
+	row.Data = string(mark) + row.Data[1:]
+	rowUpdateRender(row)
+}
+
+// Mark the file on the cursor's row with mark (or, if mark is 0, unmark it)
+// and move to the next line.
+func diredMark(mark byte, delta int) {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	buf := Global.CurrentB
+	if delta < 0 {
+		diredNextLine(delta)
+	}
+	e := d.entryAt(buf.cy)
+	if e == nil || (mark != 0 && (e.name == "." || e.name == "..")) {
+		if e == nil {
+			Global.Input = "No file on this line"
+		} else {
+			Global.Input = "Can't mark " + e.name
+		}
 		return
 	}
-	done := false
-	for !done {
-		files, err := ioutil.ReadDir(dir)
+	if mark == 0 {
+		delete(d.marks, e.name)
+	} else {
+		d.marks[e.name] = mark
+	}
+	d.redrawMark(buf, buf.cy)
+	if 0 < delta {
+		diredNextLine(delta)
+	}
+}
+
+func diredUnmarkAll() {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	n := len(d.marks)
+	d.marks = map[string]byte{}
+	for y := diredHeaderRows; y < diredHeaderRows+len(d.entries); y++ {
+		d.redrawMark(Global.CurrentB, y)
+	}
+	Global.Input = fmt.Sprintf("%d marks removed", n)
+}
+
+// The names of the files marked with mark, in the order they're listed.
+func (d *diredInfo) marked(mark byte) []string {
+	ret := []string{}
+	for _, e := range d.entries {
+		if d.marks[e.name] == mark {
+			ret = append(ret, e.name)
+		}
+	}
+	return ret
+}
+
+// The marked files, or the file on the cursor's row if none are.
+func (d *diredInfo) targets(buf *EditorBuffer) []string {
+	names := d.marked('*')
+	if len(names) == 0 {
+		if e := d.entryAt(buf.cy); e != nil && e.name != "." && e.name != ".." {
+			names = []string{e.name}
+		}
+	}
+	return names
+}
+
+func describeFiles(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return fmt.Sprintf("%d files", len(names))
+}
+
+// Copy the file or directory src to dst.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			Global.Input = err.Error()
-			AddErrorMessage(err.Error())
-			return
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		if cerr := out.Close(); err == nil {
+			err = cerr
 		}
-		choices := []string{"<Cancel>", "../"}
-		for _, f := range files {
-			suffix := ""
-			if f.IsDir() {
-				suffix += "/"
+		return err
+	})
+}
+
+// Copy or rename the named files in dir to dest, which must be a directory
+// if there's more than one of them. When there's already a file where one is
+// going, ask is called with its name: "y" replaces it, "!" replaces it and
+// every other one without asking again, "n" skips this file and anything else
+// stops. Returns the names of the files that were done.
+func diredTransfer(dir string, names []string, dest string, rename bool, ask func(string) string) ([]string, error) {
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(dir, dest)
+	}
+	info, err := os.Stat(dest)
+	intoDir := err == nil && info.IsDir()
+	if 1 < len(names) && !intoDir {
+		return nil, fmt.Errorf("%s is not a directory", dest)
+	}
+	done := []string{}
+	all := false
+	for _, name := range names {
+		src, target := filepath.Join(dir, name), dest
+		if intoDir {
+			target = filepath.Join(dest, name)
+		}
+		if err := checkTransfer(src, target); err != nil {
+			return done, err
+		}
+		if _, err := os.Lstat(target); err == nil && !all {
+			switch ask(target) {
+			case "y":
+			case "!":
+				all = true
+			case "n":
+				continue
+			default:
+				return done, nil
 			}
-			suffix += " " + f.Mode().String()
-			choices = append(choices, f.Name()+suffix)
-		}
-		choice := editorChoiceIndex("Dired: "+dir, choices, 0)
-		filechosen := choice - 2
-		if filechosen == -2 {
-			return
-		} else if filechosen == -1 {
-			dir += "/.."
-		} else if files[filechosen].IsDir() {
-			dir += "/" + files[filechosen].Name()
+		}
+		if rename {
+			err = os.Rename(src, target)
 		} else {
-			openFile(dir+"/"+files[filechosen].Name(), env)
-			done = true
+			err = copyTree(src, target)
 		}
-		dir, err = filepath.Abs(dir)
 		if err != nil {
-			Global.Input = err.Error()
-			AddErrorMessage(err.Error())
-			return
+			return done, err
+		}
+		done = append(done, name)
+	}
+	return done, nil
+}
+
+// Refuse to copy or move a file onto itself, which would truncate it, or a
+// directory into itself, which would never finish.
+func checkTransfer(src, target string) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(target); err == nil && os.SameFile(srcInfo, info) {
+		return fmt.Errorf("%s and %s are the same file", src, target)
+	}
+	if !srcInfo.IsDir() {
+		return nil
+	}
+	// The target needn't exist yet, but the directory it goes in does
+	s, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	t, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(s, filepath.Join(t, filepath.Base(target)))
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("can't copy %s into itself", src)
+	}
+	return nil
+}
+
+// Ask whether to replace a file, for diredTransfer.
+func askOverwrite(target string) string {
+	return editorPressKey("Overwrite "+target+"?", "y", "n", "!", "q", "C-g")
+}
+
+// Delete the named files in dir, and directories with everything in them.
+func diredDelete(dir string, names []string) (int, error) {
+	for i, name := range names {
+		err := os.RemoveAll(filepath.Join(dir, name))
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(names), nil
+}
+
+// Work out a file's new mode from a chmod-style spec: octal, like 644, or
+// symbolic, like u+x,go-w.
+func parseChmod(spec string, mode os.FileMode) (os.FileMode, error) {
+	if n, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if 0777 < n {
+			return mode, fmt.Errorf("Mode %s is out of range", spec)
+		}
+		return os.FileMode(n), nil
+	}
+	perm := mode.Perm()
+	for _, clause := range strings.Split(spec, ",") {
+		i := strings.IndexAny(clause, "+-=")
+		if i < 0 {
+			return mode, fmt.Errorf("Bad mode %q", spec)
+		}
+		var who, bits os.FileMode
+		for _, c := range clause[:i] {
+			switch c {
+			case 'u':
+				who |= 0700
+			case 'g':
+				who |= 0070
+			case 'o':
+				who |= 0007
+			case 'a':
+				who |= 0777
+			default:
+				return mode, fmt.Errorf("Bad mode %q", spec)
+			}
+		}
+		if who == 0 {
+			who = 0777
+		}
+		for _, c := range clause[i+1:] {
+			switch c {
+			case 'r':
+				bits |= 0444
+			case 'w':
+				bits |= 0222
+			case 'x':
+				bits |= 0111
+			default:
+				return mode, fmt.Errorf("Bad mode %q", spec)
+			}
+		}
+		switch clause[i] {
+		case '+':
+			perm |= bits & who
+		case '-':
+			perm &^= bits & who
+		case '=':
+			perm = perm&^who | bits&who
+		}
+	}
+	return perm, nil
+}
+
+// Change the modes of the named files in dir as spec says.
+func diredChmod(dir string, names []string, spec string) error {
+	for _, name := range names {
+		fn := filepath.Join(dir, name)
+		info, err := os.Stat(fn)
+		if err != nil {
+			return err
 		}
+		mode, err := parseChmod(spec, info.Mode())
+		if err != nil {
+			return err
+		}
+		if err = os.Chmod(fn, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Re-read the listing, and report err if there is one.
+func (d *diredInfo) done(buf *EditorBuffer, err error, msg string) {
+	if rerr := d.revert(buf); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+	} else {
+		Global.Input = msg
+	}
+}
+
+// Copy or rename the marked files, asking where to.
+func diredDoTransfer(rename bool) {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	buf := Global.CurrentB
+	names := d.targets(buf)
+	if len(names) == 0 {
+		Global.Input = "No files"
+		return
+	}
+	verb := "Copy"
+	if rename {
+		verb = "Rename"
+	}
+	dest := editorPrompt(verb+" "+describeFiles(names)+" to", nil)
+	if dest == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	dest, err := homedir.Expand(dest)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	done, err := diredTransfer(d.dir, names, dest, rename, askOverwrite)
+	for _, name := range done {
+		delete(d.marks, name)
+	}
+	d.done(buf, err, fmt.Sprintf("%s: %d of %d files", verb, len(done), len(names)))
+}
+
+// Delete the files marked with mark (or the file on the cursor's row), after
+// asking.
+func diredDoDelete(mark byte) {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	buf := Global.CurrentB
+	var names []string
+	if mark == 'D' {
+		names = d.marked('D')
+	} else {
+		names = d.targets(buf)
+	}
+	if len(names) == 0 {
+		Global.Input = "No files to delete"
+		return
+	}
+	ok, _ := editorYesNoPrompt("Delete "+describeFiles(names)+"?", false)
+	if !ok {
+		Global.Input = "Cancelled."
+		return
+	}
+	n, err := diredDelete(d.dir, names)
+	d.done(buf, err, fmt.Sprintf("Deleted %d of %d files", n, len(names)))
+}
+
+func diredDoChmod() {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	buf := Global.CurrentB
+	names := d.targets(buf)
+	if len(names) == 0 {
+		Global.Input = "No files"
+		return
+	}
+	spec := editorPrompt("Change mode of "+describeFiles(names)+" to", nil)
+	if spec == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	d.done(buf, diredChmod(d.dir, names, spec), "Changed the mode of "+describeFiles(names))
+}
+
+func diredCreateDirectory() {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	buf := Global.CurrentB
+	name := editorPrompt("Create directory", nil)
+	if name == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	fn, err := homedir.Expand(name)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	if !filepath.IsAbs(fn) {
+		fn = filepath.Join(d.dir, fn)
+	}
+	err = os.MkdirAll(fn, 0777)
+	d.done(buf, err, "Created "+name)
+	for i, e := range d.entries {
+		if d.path(e.name) == fn {
+			buf.cy = i + diredHeaderRows
+			d.toName(buf)
+		}
+	}
+}
+
+// Sort by name, then time, then size.
+func diredSortToggle() {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	d.sortBy = (d.sortBy + 1) % len(diredSortNames)
+	d.done(Global.CurrentB, nil, "Sorted by "+diredSortNames[d.sortBy])
+}
+
+func diredToggleHidden() {
+	d := currentDired()
+	if d == nil {
+		return
+	}
+	d.hideHidden = !d.hideHidden
+	if d.hideHidden {
+		d.done(Global.CurrentB, nil, "Hiding hidden files")
+	} else {
+		d.done(Global.CurrentB, nil, "Showing hidden files")
+	}
+}
+
+func diredRevert() {
+	d := currentDired()
+	if d == nil {
+		return
 	}
+	d.done(Global.CurrentB, nil, "Reading "+d.dir)
 }
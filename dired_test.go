@@ -0,0 +1,241 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The names dired lists, in order, skipping . and ..
+func diredNames(d *diredInfo) []string {
+	ret := []string{}
+	for _, e := range d.entries[2:] {
+		ret = append(ret, e.name)
+	}
+	return ret
+}
+
+func TestDiredListing(t *testing.T) {
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	dir, err := ioutil.TempDir("", "gomacs-dired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"b.txt": "bb", "a.txt": "aaaa", ".hidden": "", "sub/c": "c"})
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.txt"), old, old)
+	os.Chmod(filepath.Join(dir, "b.txt"), 0640)
+
+	buf := diredDirectory(dir)
+	d := buf.dired
+	if Global.CurrentB != buf || buf.MajorMode != "dired-mode" || !buf.hasMode("read-only-mode") {
+		t.Fatal("Expected a read-only dired buffer")
+	}
+	if names := diredNames(d); !reflect.DeepEqual(names, []string{".hidden", "a.txt", "b.txt", "sub"}) {
+		t.Errorf("Expected the files sorted by name, got %q", names)
+	}
+	if buf.Row(0).Data != "  "+dir+":" {
+		t.Errorf("Expected a header naming the directory, got %q", buf.Row(0).Data)
+	}
+	row := buf.Row(5).Data
+	if !strings.HasPrefix(row, "  -rw-r----- ") || !strings.Contains(row, " 2 ") || !strings.HasSuffix(row, " b.txt") {
+		t.Errorf("Expected an ls-style row for b.txt, got %q", row)
+	}
+	if buf.cy != 3 || buf.cx != d.entries[2].nameCol || row[d.entries[4].nameCol:] != "b.txt" {
+		t.Errorf("Expected the cursor on the first file's name, got %d:%d", buf.cy, buf.cx)
+	}
+	if defaultDirectory() != dir {
+		t.Error("Expected dired's directory to be the default directory")
+	}
+
+	diredSortToggle()
+	if names := diredNames(d); names[0] == "a.txt" || names[3] != "a.txt" {
+		t.Errorf("Expected the oldest file last when sorted by time, got %q", names)
+	}
+	diredSortToggle()
+	if names := diredNames(d); names[0] != "sub" || names[1] != "a.txt" {
+		t.Errorf("Expected the biggest file first when sorted by size, got %q", names)
+	}
+	diredSortToggle()
+	diredToggleHidden()
+	if names := diredNames(d); !reflect.DeepEqual(names, []string{"a.txt", "b.txt", "sub"}) {
+		t.Errorf("Expected hidden files to be hidden, got %q", names)
+	}
+
+	diredUpDirectory()
+	if Global.CurrentB.dired == nil || Global.CurrentB.dired.dir != filepath.Dir(dir) {
+		t.Fatal("Expected ^ to list the directory above")
+	}
+	if e := Global.CurrentB.dired.entryAt(Global.CurrentB.cy); e == nil || e.name != filepath.Base(dir) {
+		t.Error("Expected the cursor on the directory we came from")
+	}
+	if diredDirectory(dir) != buf {
+		t.Error("Expected to go back to the same dired buffer")
+	}
+}
+
+func TestDiredMarksAndOperations(t *testing.T) {
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	dir, err := ioutil.TempDir("", "gomacs-dired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a": "a", "b": "b", "c": "c", "dest/.keep": ""})
+	buf := diredDirectory(dir)
+	d := buf.dired
+	diredMark('*', 1)
+	diredMark('D', 1)
+	diredMark('*', 1)
+	if !reflect.DeepEqual(d.marked('*'), []string{"a", "c"}) || !reflect.DeepEqual(d.marked('D'), []string{"b"}) {
+		t.Errorf("Expected a and c marked and b flagged, got %v", d.marks)
+	}
+	if buf.Row(3).Data[0] != '*' || buf.Row(4).Data[0] != 'D' {
+		t.Error("Expected the marks to be shown")
+	}
+	diredMark(0, -1)
+	if !reflect.DeepEqual(d.targets(buf), []string{"a"}) || buf.Row(5).Data[0] != ' ' {
+		t.Errorf("Expected DEL to unmark c, got %v", d.marks)
+	}
+
+	if done, err := diredTransfer(dir, d.targets(buf), "dest", false, nil); len(done) != 1 || err != nil {
+		t.Errorf("Expected to copy a, got %v, %v", done, err)
+	}
+	if _, err := diredTransfer(dir, []string{"a", "c"}, "nowhere", true, nil); err == nil {
+		t.Error("Expected renaming two files to something that isn't a directory to fail")
+	}
+	if _, err := diredTransfer(dir, []string{"c"}, "renamed", true, nil); err != nil {
+		t.Error(err)
+	}
+	if err := diredChmod(dir, []string{"a"}, "u=rw,go="); err != nil {
+		t.Error(err)
+	}
+	if n, err := diredDelete(dir, d.marked('D')); n != 1 || err != nil {
+		t.Errorf("Expected to delete b, got %d, %v", n, err)
+	}
+	diredRevert()
+	if names := diredNames(d); !reflect.DeepEqual(names, []string{"a", "dest", "renamed"}) {
+		t.Errorf("Expected a, dest and renamed, got %q", names)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "dest", "a")); string(data) != "a" {
+		t.Error("Expected a copy of a in dest")
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "a")); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a's mode to be 600, got %o", info.Mode().Perm())
+	}
+	if len(d.marks) != 1 || d.marks["a"] != '*' {
+		t.Errorf("Expected only the marks of files that are left to be kept, got %v", d.marks)
+	}
+}
+
+func TestDiredTransferAsksBeforeOverwriting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomacs-dired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a": "new a", "b": "new b", "c": "new c",
+		"sub/a": "old a", "sub/b": "old b", "sub/c": "old c"})
+	asked := []string{}
+	answer := func(answers ...string) func(string) string {
+		return func(target string) string {
+			asked = append(asked, filepath.Base(target))
+			ret := answers[0]
+			answers = answers[1:]
+			return ret
+		}
+	}
+	contents := func(name string) string {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "sub", name))
+		return string(data)
+	}
+
+	done, err := diredTransfer(dir, []string{"a", "b", "c"}, "sub", true, answer("n", "q"))
+	if len(done) != 0 || err != nil || contents("a") != "old a" || contents("b") != "old b" {
+		t.Errorf("Expected nothing to be replaced, got %v, %v", done, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); err != nil {
+		t.Error("Expected a not to be renamed")
+	}
+	done, err = diredTransfer(dir, []string{"a", "b", "c"}, "sub", false, answer("y", "!"))
+	if !reflect.DeepEqual(done, []string{"a", "b", "c"}) || err != nil || contents("c") != "new c" {
+		t.Errorf("Expected everything to be replaced, got %v, %v", done, err)
+	}
+	if !reflect.DeepEqual(asked, []string{"a", "b", "a", "b"}) {
+		t.Errorf("Expected to be asked about a and b each time, got %v", asked)
+	}
+}
+
+func TestDiredTransferRefusesSameFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomacs-dired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a": "contents"})
+	asked := false
+	done, err := diredTransfer(dir, []string{"a"}, ".", false, func(string) string {
+		asked = true
+		return "y"
+	})
+	if len(done) != 0 || err == nil || asked {
+		t.Errorf("Expected copying a onto itself to be refused without asking, got %v, %v", done, err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "a")); string(data) != "contents" {
+		t.Errorf("Expected a to be left alone but it has %q", data)
+	}
+}
+
+func TestDiredTransferRefusesCopyIntoItself(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomacs-dired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"sub/a": "a", "sub/deeper/b": "b"})
+	for _, dest := range []string{"sub", "sub/deeper", "sub/new"} {
+		done, err := diredTransfer(dir, []string{"sub"}, dest, false, func(string) string { return "y" })
+		if len(done) != 0 || err == nil {
+			t.Errorf("Expected copying sub to %s to be refused, got %v, %v", dest, done, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "new")); err == nil {
+		t.Error("Expected nothing to be copied")
+	}
+	// A sibling whose name starts the same way is fine
+	done, err := diredTransfer(dir, []string{"sub"}, "sub2", false, func(string) string { return "y" })
+	if len(done) != 1 || err != nil {
+		t.Errorf("Expected sub to be copied to sub2, got %v, %v", done, err)
+	}
+}
+
+func TestParseChmod(t *testing.T) {
+	for _, c := range []struct {
+		spec   string
+		old    os.FileMode
+		expect os.FileMode
+	}{
+		{"755", 0600, 0755},
+		{"+x", 0644, 0755},
+		{"go-rwx", 0755, 0700},
+		{"u=r,a+w", 0777, 0677},
+	} {
+		got, err := parseChmod(c.spec, c.old)
+		if err != nil || got != c.expect {
+			t.Errorf("Expected %s on %o to give %o, got %o (%v)", c.spec, c.old, c.expect, got, err)
+		}
+	}
+	if _, err := parseChmod("q+z", 0644); err == nil {
+		t.Error("Expected a bad mode to be an error")
+	}
+	if lsMode(os.ModeDir|os.ModeSticky|0777) != "drwxrwxrwt" || lsMode(os.ModeSetuid|0644) != "-rwSr--r--" {
+		t.Error("Expected modes to look like ls's")
+	}
+}
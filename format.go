@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+)
+
+// Formatters, such as gofmt, prettier and black, which read a file on stdin
+// and write it formatted on stdout. Their output is applied to the buffer as
+// the smallest set of changes that will do, so that point, the mark and the
+// scroll position stay where they were relative to the text around them and a
+// single undo puts everything back.
+
+var formatters = map[string][]string{} // The command line to run, by major mode
+
+// How long to let a formatter run.
+var formatterTimeout = 10 * time.Second
+
+const formatErrorsBuffer = "*format errors*"
+
+// Run buf's text through a formatter. "%f" in its arguments is replaced by
+// the buffer's file name, for formatters like prettier that go by the
+// extension. On failure, the output is what it wrote to stderr.
+func runFormatter(buf *EditorBuffer, argv []string) (string, error) {
+	fn := buf.Filename
+	if fn == "" {
+		fn = buf.getRenderName()
+	}
+	args := make([]string, len(argv))
+	for i, arg := range argv {
+		args[i] = strings.Replace(arg, "%f", fn, -1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), formatterTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if buf.Filename != "" {
+		cmd.Dir = filepath.Dir(buf.Filename)
+	}
+	cmd.Stdin = strings.NewReader(lspText(buf))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", formatterTimeout)
+	}
+	if err != nil {
+		return stderr.String(), err
+	}
+	return stdout.String(), nil
+}
+
+// Fill the *format errors* buffer with what went wrong, so that next-error can
+// visit the places the formatter complained about.
+func showFormatErrors(buf *EditorBuffer, argv []string, output string, err error) {
+	dir := defaultDirectory()
+	name := buf.getRenderName()
+	if buf.Filename != "" {
+		dir, name = filepath.Dir(buf.Filename), filepath.Base(buf.Filename)
+	}
+	lines := []string{strings.Join(argv, " ") + ": " + err.Error()}
+	output = strings.TrimRight(output, "\n")
+	if output != "" {
+		output = strings.Replace(output, "<standard input>", name, -1)
+		lines = append(lines, strings.Split(output, "\n")...)
+	}
+	fillErrorList(namedBuffer(formatErrorsBuffer), &errorList{dir, nil, -1}, "format-errors", lines)
+}
+
+// Format buf with the formatter for its major mode. Returns whether anything
+// changed.
+func formatBuffer(buf *EditorBuffer) (bool, error) {
+	argv := formatters[buf.MajorMode]
+	if len(argv) == 0 {
+		return false, fmt.Errorf("No formatter for %s mode; set one with setformatter", buf.MajorMode)
+	}
+	if buf.hasMode("read-only-mode") {
+		return false, errors.New("Buffer is read-only")
+	}
+	if buf.NumRows() == 0 {
+		return false, nil
+	}
+	output, err := runFormatter(buf, argv)
+	if err != nil {
+		showFormatErrors(buf, argv, output, err)
+		return false, fmt.Errorf("%s failed; see %s", filepath.Base(argv[0]), formatErrorsBuffer)
+	}
+	old := make([]string, buf.NumRows())
+	buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+		old[i] = row.Data
+		return true
+	})
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	hunks := diffLines(old, lines)
+	if len(hunks) == 0 {
+		return false, nil
+	}
+	applyHunks(buf, old, lines, hunks)
+	return true, nil
+}
+
+// Change buf, whose rows are old, into lines, keeping the cursors, the mark
+// and the scroll position with the text they were on.
+func applyHunks(buf *EditorBuffer, old, lines []string, hunks []diffHunk) {
+	mapPos := func(cx, cy int) (int, int) {
+		delta := 0
+		for _, h := range hunks {
+			if cy < h.oldStart {
+				break
+			}
+			if h.oldEnd <= cy {
+				delta += (h.newEnd - h.newStart) - (h.oldEnd - h.oldStart)
+				continue
+			}
+			if h.newStart == h.newEnd {
+				return 0, h.newStart
+			}
+			row := h.newStart + cy - h.oldStart
+			if h.newEnd <= row {
+				row = h.newEnd - 1
+			}
+			return formatColumn(old[cy], cx, lines[row]), row
+		}
+		return cx, cy + delta
+	}
+	clamp := func(cx, cy int) (int, int) {
+		if len(lines) <= cy {
+			cy = len(lines) - 1
+		}
+		if cy < 0 {
+			return 0, 0
+		}
+		if len(lines[cy]) < cx {
+			cx = len(lines[cy])
+		}
+		return cx, cy
+	}
+	cx, cy := clamp(mapPos(buf.cx, buf.cy))
+	markx, marky := clamp(mapPos(buf.MarkX, buf.MarkY))
+	_, rowoff := clamp(mapPos(0, buf.rowoff))
+	for _, c := range buf.cursors {
+		c.cx, c.cy = clamp(mapPos(c.cx, c.cy))
+		c.prefcx = c.cx
+	}
+
+	// Each hunk, as a replacement of bytes in the old text with bytes of the
+	// new, both with a newline on the end of every line. Common bytes at
+	// either end are left alone, so changing the indentation of a line only
+	// touches its indentation.
+	starts := func(ls []string) []int {
+		ret := make([]int, len(ls)+1)
+		for i, l := range ls {
+			ret[i+1] = ret[i] + len(l) + 1
+		}
+		return ret
+	}
+	oldStarts, newStarts := starts(old), starts(lines)
+	oldText := strings.Join(old, "\n") + "\n"
+	newText := strings.Join(lines, "\n") + "\n"
+	pos := func(off int) (int, int) {
+		row := sort.Search(len(old), func(i int) bool { return off < oldStarts[i+1] })
+		return off - oldStarts[row], row
+	}
+
+	saved := Global.CurrentB
+	Global.CurrentB = buf
+	defer func() { Global.CurrentB = saved }()
+	for i := len(hunks) - 1; 0 <= i; i-- {
+		h := hunks[i]
+		start, end := oldStarts[h.oldStart], oldStarts[h.oldEnd]
+		from, to := oldText[start:end], newText[newStarts[h.newStart]:newStarts[h.newEnd]]
+		pre := 0
+		for pre < len(from) && pre < len(to) && from[pre] == to[pre] {
+			pre++
+		}
+		for 0 < pre && pre < len(from) && !utf8.RuneStart(from[pre]) {
+			pre--
+		}
+		suf := 0
+		for suf < len(from)-pre && suf < len(to)-pre && from[len(from)-1-suf] == to[len(to)-1-suf] {
+			suf++
+		}
+		for 0 < suf && !utf8.RuneStart(from[len(from)-suf]) {
+			suf--
+		}
+		start, end = start+pre, end-suf
+		text := to[pre : len(to)-suf]
+		if end == len(oldText) {
+			// The last newline isn't in the rows, so move the change back
+			// over the one before it
+			if start == end {
+				text = "\n" + strings.TrimSuffix(text, "\n")
+			}
+			if 0 < start && start == oldStarts[h.oldStart] {
+				start--
+			}
+			end--
+		}
+		startc, startl := pos(start)
+		endc, endl := pos(end)
+		replaceText(buf, startc, endc, startl, endl, text)
+	}
+	buf.cx, buf.cy, buf.prefcx = cx, cy, cx
+	buf.MarkX, buf.MarkY = markx, marky
+	buf.rowoff = rowoff
+}
+
+// Where cx in old ends up in new, which is the same line with its whitespace
+// changed: before or after the same non-blank character.
+func formatColumn(old string, cx int, new string) int {
+	blank := func(c byte) bool { return c == ' ' || c == '\t' }
+	k := 0
+	for i := 0; i < cx && i < len(old); i++ {
+		if !blank(old[i]) {
+			k++
+		}
+	}
+	before := cx < len(old) && !blank(old[cx])
+	if k == 0 && !before {
+		indent := len(new) - len(strings.TrimLeft(new, " \t"))
+		if cx < indent {
+			return cx
+		}
+		return indent
+	}
+	n := 0
+	for i := 0; i < len(new); i++ {
+		if blank(new[i]) {
+			continue
+		}
+		if n == k && before {
+			return i
+		}
+		n++
+		if n == k && !before {
+			return i + 1
+		}
+	}
+	return len(new)
+}
+
+func formatBufferCmd(env *glisp.Zlisp) {
+	buf := Global.CurrentB
+	changed, err := formatBuffer(buf)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+	} else if changed {
+		Global.Input = "Formatted with " + filepath.Base(formatters[buf.MajorMode][0])
+	} else {
+		Global.Input = "Already formatted"
+	}
+}
+
+// Format buf before it's saved, if it's in format-on-save-mode and there's a
+// formatter for its mode. A formatter that fails doesn't stop the save.
+func formatOnSave(buf *EditorBuffer) error {
+	if !buf.hasMode("format-on-save-mode") || len(formatters[buf.MajorMode]) == 0 {
+		return nil
+	}
+	_, err := formatBuffer(buf)
+	return err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A formatter that indents by braces with tabs, leaves no more than one blank
+// line in a row and none at the start, and objects to BAD the way gofmt
+// objects to syntax errors.
+func stubFormatter(in io.Reader, out, errs io.Writer) int {
+	lines := []string{}
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	depth, status := 0, 0
+	var sb strings.Builder
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "BAD") {
+			fmt.Fprintf(errs, "<standard input>:%d:1: BAD here\n", i+1)
+			status = 2
+		}
+		if strings.HasPrefix(line, "}") {
+			depth--
+		}
+		if line == "" && (i == 0 || strings.HasSuffix(sb.String(), "\n\n") || sb.Len() == 0) {
+			continue
+		}
+		if line != "" {
+			sb.WriteString(strings.Repeat("\t", depth) + line)
+		}
+		sb.WriteByte('\n')
+		if strings.HasSuffix(line, "{") {
+			depth++
+		}
+	}
+	if status == 0 {
+		io.WriteString(out, sb.String())
+	}
+	return status
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, c := range []struct {
+		a, b   string
+		expect []diffHunk
+	}{
+		{"a b c", "a b c", []diffHunk{}},
+		{"a b c", "a x c", []diffHunk{{1, 2, 1, 2}}},
+		{"a b c d e", "a c d x e y", []diffHunk{{1, 2, 1, 1}, {4, 4, 3, 4}, {5, 5, 5, 6}}},
+		{"a", "b c", []diffHunk{{0, 1, 0, 2}}},
+		{"x a b y", "a b", []diffHunk{{0, 1, 0, 0}, {3, 4, 2, 2}}},
+	} {
+		got := diffLines(strings.Fields(c.a), strings.Fields(c.b))
+		if len(got) == 0 && len(c.expect) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("Expected %q to %q to be %v, got %v", c.a, c.b, c.expect, got)
+		}
+	}
+}
+
+// A new buffer in a mode formatted by stubFormatter.
+func stubFormatted(lines ...string) *EditorBuffer {
+	InitEditor()
+	formatters["stub"] = []string{os.Args[0], "-gomacs-format-stub"}
+	buf := setLines(lines...)
+	buf.MajorMode = "stub"
+	return buf
+}
+
+func TestFormatBuffer(t *testing.T) {
+	defer delete(formatters, "stub")
+	buf := stubFormatted("func main() {", "  x := 1", "", "    y := 2", "}", "", "", "func f() {", "return", "}")
+	buf.cy, buf.cx = 3, 6
+	buf.MarkY, buf.MarkX = 8, 3
+	buf.rowoff = 7
+	runAsCommand("format-buffer", func() { formatBufferCmd(nil) })
+	buf.FailIfBufferNe([]string{"func main() {", "\tx := 1", "", "\ty := 2", "}", "", "func f() {", "\treturn", "}"}, t)
+	if Global.Input != "Formatted with "+filepath.Base(os.Args[0]) {
+		t.Errorf("Expected a message, got %q", Global.Input)
+	}
+	if buf.cy != 3 || buf.cx != 3 || buf.MarkY != 7 || buf.MarkX != 4 || buf.rowoff != 6 {
+		t.Errorf("Expected point, mark and scroll to stay put, got %d:%d, %d:%d, %d",
+			buf.cy, buf.cx, buf.MarkY, buf.MarkX, buf.rowoff)
+	}
+	editorUndoAction()
+	buf.FailIfBufferNe([]string{"func main() {", "  x := 1", "", "    y := 2", "}", "", "", "func f() {", "return", "}"}, t)
+
+	formatBufferCmd(nil)
+	formatBufferCmd(nil)
+	if Global.Input != "Already formatted" {
+		t.Errorf("Expected nothing to do, got %q", Global.Input)
+	}
+
+	// Lines coming and going at the ends
+	buf = stubFormatted("", "", "a {", "b", "}", "", "", "")
+	buf.cy = 7
+	formatBufferCmd(nil)
+	buf.FailIfBufferNe([]string{"a {", "\tb", "}", ""}, t)
+	if buf.cy != 3 {
+		t.Errorf("Expected point to stay at the end, got %d", buf.cy)
+	}
+	old := []string{"b", "c"}
+	for _, lines := range [][]string{{"a", "b", "c", "d"}, {"x"}, {"c", "", "e"}} {
+		buf = stubFormatted(old...)
+		applyHunks(buf, old, lines, diffLines(old, lines))
+		buf.FailIfBufferNe(lines, t)
+	}
+}
+
+func TestFormatErrorsAndSave(t *testing.T) {
+	defer delete(formatters, "stub")
+	dir, err := ioutil.TempDir("", "gomacs-format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	buf := stubFormatted("a {", "BAD", "}")
+	buf.Filename = filepath.Join(dir, "a.stub")
+	formatBufferCmd(nil)
+	if Global.Input != filepath.Base(os.Args[0])+" failed; see *format errors*" {
+		t.Errorf("Expected the formatter to fail, got %q", Global.Input)
+	}
+	buf.FailIfBufferNe([]string{"a {", "BAD", "}"}, t)
+	errs := namedBuffer(formatErrorsBuffer)
+	if errs.NumRows() != 2 || errs.Row(1).Data != "a.stub:2:1: BAD here" {
+		t.Errorf("Expected the error to be listed, got %q", lspText(errs))
+	}
+
+	// Saving sets the major mode from the file name
+	if defs == nil {
+		LoadSyntaxDefs()
+	}
+	buf = stubFormatted("a {", "b", "}")
+	defer delete(formatters, "go")
+	formatters["go"] = formatters["stub"]
+	buf.Filename = filepath.Join(dir, "b.go")
+	editorBufSave(buf, nil)
+	if data, _ := ioutil.ReadFile(buf.Filename); string(data) != "a {\nb\n}\n" {
+		t.Errorf("Expected no formatting outside format-on-save-mode, got %q", data)
+	}
+	Global.MinorModes["format-on-save-mode"] = true
+	buf.setMode("format-on-save-mode", true)
+	editorBufSave(buf, nil)
+	if data, _ := ioutil.ReadFile(buf.Filename); string(data) != "a {\n\tb\n}\n" || buf.Dirty {
+		t.Errorf("Expected the file to be formatted as it was saved, got %q", data)
+	}
+}
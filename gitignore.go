@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Reading .gitignore files, so that searching a tree can skip what git does.
+
+type gitignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// Whether the pattern has a slash in it, and so is matched against the
+	// whole path from the .gitignore's directory rather than just the name
+	anchored bool
+}
+
+// Turn a gitignore glob into a regexp that matches the whole of a path.
+func gitignoreGlob(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// Understand one line of a .gitignore; ok is false for blanks and comments.
+func parseGitignoreLine(line string) (rule gitignoreRule, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	rule.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	re, err := regexp.Compile(gitignoreGlob(line))
+	if err != nil {
+		return rule, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+func readGitignore(fn string) []gitignoreRule {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	rules := []gitignoreRule{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if rule, ok := parseGitignoreLine(sc.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Whether rules say to ignore rel, a slash-separated path relative to the
+// directory they came from; matched is false if none of them mention it.
+func matchGitignore(rules []gitignoreRule, rel string, isDir bool) (ignored, matched bool) {
+	name := rel[strings.LastIndexByte(rel, '/')+1:]
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		subject := name
+		if rule.anchored {
+			subject = rel
+		}
+		if rule.re.MatchString(subject) {
+			ignored, matched = !rule.negate, true
+		}
+	}
+	return ignored, matched
+}
+
+// The .gitignore files of a tree, read as they're needed.
+type gitignoreTree struct {
+	root  string
+	rules map[string][]gitignoreRule
+}
+
+func newGitignoreTree(root string) *gitignoreTree {
+	return &gitignoreTree{filepath.Clean(root), map[string][]gitignoreRule{}}
+}
+
+func (t *gitignoreTree) rulesFor(dir string) []gitignoreRule {
+	rules, ok := t.rules[dir]
+	if !ok {
+		rules = readGitignore(filepath.Join(dir, ".gitignore"))
+		t.rules[dir] = rules
+	}
+	return rules
+}
+
+// Whether path, somewhere under the tree's root, is ignored. The .gitignore
+// nearest to it has the last word. Directories called .git always are.
+func (t *gitignoreTree) ignored(path string, isDir bool) bool {
+	if isDir && filepath.Base(path) == ".git" {
+		return true
+	}
+	path = filepath.Clean(path)
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			break
+		}
+		if ignored, matched := matchGitignore(t.rulesFor(dir), filepath.ToSlash(rel), isDir); matched {
+			return ignored
+		}
+		if dir == t.root || dir == filepath.Dir(dir) || !strings.HasPrefix(dir, t.root) {
+			break
+		}
+	}
+	return false
+}
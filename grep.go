@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+)
+
+// Buffers that list places in files, one per line, as file:line: or
+// file:line:column: - grep's results, say. next-error and previous-error go
+// through the most recent of them.
+type errorList struct {
+	dir string // File names are relative to this
+	// If set, visiting a line puts the cursor on the first match of it, and
+	// lines aren't expected to have columns
+	re      *regexp.Regexp
+	current int // The row last visited, or -1
+}
+
+var nextErrorBuffer *EditorBuffer
+
+// Compilers' messages, which may be indented (as go test's are), and grep's
+// matches, whose file names may have spaces in them.
+var errorLineRe = regexp.MustCompile(`^\s*([^:\s]+):([0-9]+):(?:([0-9]+):)?`)
+var grepLineRe = regexp.MustCompile(`^(.+?):([0-9]+):`)
+
+// The place a row of an error list refers to.
+func (l *errorList) parse(data string) (fn string, line, col int, ok bool) {
+	re := errorLineRe
+	if l.re != nil {
+		re = grepLineRe
+	}
+	m := re.FindStringSubmatch(data)
+	if m == nil {
+		return "", 0, 0, false
+	}
+	line, _ = strconv.Atoi(m[2])
+	if l.re == nil && m[3] != "" {
+		col, _ = strconv.Atoi(m[3])
+	}
+	fn = m[1]
+	if !filepath.IsAbs(fn) {
+		fn = filepath.Join(l.dir, fn)
+	}
+	return fn, line, col, 0 < line
+}
+
+// Make buf a read-only list of places with the given lines.
+func fillErrorList(buf *EditorBuffer, list *errorList, mode string, lines []string) {
+	fillReadOnlyBuffer(buf, mode, lines)
+	buf.errors = list
+	nextErrorBuffer = buf
+}
+
+// Replace buf's contents with lines, in major mode mode, read-only and with
+// the cursor at the top.
+func fillReadOnlyBuffer(buf *EditorBuffer, mode string, lines []string) {
+	rows := make([]*EditorRow, len(lines))
+	for i, line := range lines {
+		rows[i] = &EditorRow{Size: len(line), Data: line}
+		rowUpdateRender(rows[i])
+	}
+	buf.SetRows(rows)
+	buf.clearUndo()
+	buf.Dirty = false
+	buf.MajorMode = mode
+	buf.Highlighter = nil
+	buf.setMode("read-only-mode", true)
+	buf.cx, buf.cy, buf.prefcx, buf.rowoff = 0, 0, 0, 0
+}
+
+// Switch to the buffer visiting fn, opening it if need be.
+func visitFile(fn string, env *glisp.Zlisp) *EditorBuffer {
+	abs, err := filepath.Abs(fn)
+	if err == nil {
+		for _, buf := range Global.Buffers {
+			if buf.Filename == abs {
+				getFocusWindow().buf = buf
+				Global.CurrentB = buf
+				return buf
+			}
+		}
+	}
+	openFile(fn, env)
+	return Global.CurrentB
+}
+
+// The buffer visiting fn, which is opened in the background if there isn't
+// one.
+func findFileNoSelect(fn string, env *glisp.Zlisp) *EditorBuffer {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return nil
+	}
+	for _, buf := range Global.Buffers {
+		if buf.Filename == abs {
+			return buf
+		}
+	}
+	buf := &EditorBuffer{}
+	old := Global.CurrentB
+	Global.CurrentB = buf
+	err = EditorOpen(abs, env)
+	Global.CurrentB = old
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+		return nil
+	}
+	Global.Buffers = append(Global.Buffers, buf)
+	return buf
+}
+
+// Go to the place row of list's buffer refers to. The file is shown in
+// another window if we're in the list; if stay, the list stays selected.
+func visitError(env *glisp.Zlisp, listBuf *EditorBuffer, row int, stay bool) {
+	list := listBuf.errors
+	fn, line, col, ok := list.parse(listBuf.Row(row).Data)
+	if !ok {
+		Global.Input = "No file and line here"
+		return
+	}
+	if _, err := os.Stat(fn); err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	list.current = row
+	listBuf.cy, listBuf.cx = row, 0
+	visit := func() {
+		buf := visitFile(fn, env)
+		if buf.NumRows() < line {
+			line = buf.NumRows()
+		}
+		buf.cy = line - 1
+		if buf.cy < 0 {
+			buf.cy = 0
+		}
+		buf.cx = 0
+		if buf.cy < buf.NumRows() {
+			data := buf.Row(buf.cy).Data
+			if list.re != nil {
+				if m := list.re.FindStringIndex(data); m != nil {
+					buf.cx = m[0]
+				}
+			} else if 0 < col && col <= len(data)+1 {
+				buf.cx = col - 1
+			}
+		}
+		buf.prefcx = buf.cx
+		editorCentreView()
+	}
+	if Global.CurrentB != listBuf {
+		visit()
+	} else if stay {
+		callFunOtherWindowAndGoBack(visit)
+	} else {
+		callFunOtherWindow(visit)
+	}
+	Global.Input = listBuf.Row(row).Data
+}
+
+// Visit the next (or, if delta is negative, previous) place in the most recent
+// error list.
+func nextError(env *glisp.Zlisp, delta int, stay bool) {
+	listBuf := nextErrorBuffer
+	if Global.CurrentB.errors != nil {
+		listBuf = Global.CurrentB
+		nextErrorBuffer = listBuf
+	}
+	if listBuf == nil || !bufferAlive(listBuf) {
+		Global.Input = "No error list to go through"
+		return
+	}
+	list := listBuf.errors
+	from := list.current
+	if listBuf == Global.CurrentB && from != listBuf.cy {
+		// Start from wherever the cursor has been moved to
+		from = listBuf.cy - delta
+	}
+	for row := from + delta; 0 <= row && row < listBuf.NumRows(); row += delta {
+		if _, _, _, ok := list.parse(listBuf.Row(row).Data); ok {
+			visitError(env, listBuf, row, stay)
+			return
+		}
+	}
+	if 0 < delta {
+		Global.Input = "No more places after this one"
+	} else {
+		Global.Input = "No more places before this one"
+	}
+}
+
+// Visit the place on the row the cursor is on.
+func gotoError(env *glisp.Zlisp) {
+	buf := Global.CurrentB
+	if buf.errors == nil || buf.NumRows() <= buf.cy {
+		return
+	}
+	nextErrorBuffer = buf
+	visitError(env, buf, buf.cy, false)
+}
+
+// Whether a file looks like text rather than binary.
+func looksLikeText(data []byte) bool {
+	if 8000 < len(data) {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) < 0
+}
+
+// Whether name matches one of the space-separated globs; "" matches anything.
+func matchesGlobs(globs, name string) bool {
+	if strings.TrimSpace(globs) == "" {
+		return true
+	}
+	for _, glob := range strings.Fields(globs) {
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// The top of the git repository dir is in, or dir if it isn't in one.
+func gitTopLevel(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if d == filepath.Dir(d) {
+			return dir
+		}
+	}
+}
+
+// Search the files under dir whose names match globs for re, skipping those
+// git ignores. Returns file:line:text lines, with names relative to dir.
+func grepTree(re *regexp.Regexp, globs, dir string) ([]string, error) {
+	ignores := newGitignoreTree(gitTopLevel(dir))
+	ret := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Skip what we can't read rather than give up
+			return nil
+		}
+		if path != dir && ignores.ignored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !matchesGlobs(globs, info.Name()) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || !looksLikeText(data) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			rel = path
+		}
+		rel = filepath.ToSlash(rel)
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if re.MatchString(line) {
+				ret = append(ret, fmt.Sprintf("%s:%d:%s", rel, i+1, line))
+			}
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// The directory the current buffer's file is in (or that it lists), or the
+// working directory.
+func defaultDirectory() string {
+	if d := Global.CurrentB.dired; d != nil {
+		return d.dir
+	}
+	if fn := Global.CurrentB.Filename; fn != "" {
+		return filepath.Dir(fn)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
+}
+
+// Search a tree and list what's found in the *grep* buffer.
+func doGrep(query, globs, dir string) {
+	re, err := compileSearch(query, true)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	matches, err := grepTree(re, globs, dir)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+		return
+	}
+	header := fmt.Sprintf("grep for %q in %s", query, dir)
+	if strings.TrimSpace(globs) != "" {
+		header += " (" + globs + ")"
+	}
+	lines := append([]string{header}, matches...)
+	lines = append(lines, fmt.Sprintf("Grep finished with %d matches", len(matches)))
+	buf := namedBuffer("*grep*")
+	fillErrorList(buf, &errorList{dir, re, -1}, "grep", lines)
+	showBufferOtherWindow(buf)
+	Global.Input = fmt.Sprintf("%d matches", len(matches))
+}
+
+// grep asks for a regexp and searches every file under a directory; rgrep asks
+// which files to search as well.
+func grepCommand(askGlobs bool) {
+	query := editorPrompt("Search for regexp", nil)
+	if query == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	globs := ""
+	if askGlobs {
+		globs = editorPrompt("In files (e.g. *.go *.c; empty for all)", nil)
+	}
+	def := defaultDirectory()
+	dir := editorPrompt("In directory (default "+def+")", nil)
+	if dir == "" {
+		dir = def
+	}
+	doGrep(query, globs, dir)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGitignoreRules(t *testing.T) {
+	rules := []gitignoreRule{}
+	for _, line := range []string{"# comment", "", "*.log", "!keep.log", "build/",
+		"/top.txt", "docs/**/*.md", `\#hash`} {
+		if rule, ok := parseGitignoreLine(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	cases := []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"sub/a.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build", true, true},
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"docs/a.md", false, true},
+		{"docs/x/y/a.md", false, true},
+		{"a.md", false, false},
+		{"#hash", false, true},
+	}
+	for _, c := range cases {
+		if ignored, _ := matchGitignore(rules, c.rel, c.isDir); ignored != c.ignored {
+			t.Errorf("Expected %s (dir %v) ignored to be %v", c.rel, c.isDir, c.ignored)
+		}
+	}
+}
+
+// Make files under dir with the given contents.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGrepTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomacs-grep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		".gitignore":        "*.tmp\nvendor/\n",
+		"a.go":              "package a\n// TODO: one\n",
+		"b.txt":             "todo two\r\nnothing\n",
+		"x.tmp":             "TODO ignored\n",
+		"vendor/v.go":       "TODO ignored\n",
+		"sub/.gitignore":    "*.txt\n!keep.txt\n",
+		"sub/c.go":          "x := 1 // TODO three\n",
+		"sub/d.txt":         "TODO ignored\n",
+		"sub/keep.txt":      "TODO four\n",
+		"bin/binary":        "TODO\x00ignored\n",
+		".git/COMMIT_MSG":   "TODO ignored\n",
+		"sub/deeper/e.go":   "no match\n",
+		"sub/deeper/f.json": "{\"todo\": 5}\n",
+	})
+	re, _ := compileSearch("todo", true)
+	got, err := grepTree(re, "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"a.go:2:// TODO: one",
+		"b.txt:1:todo two",
+		"sub/c.go:1:x := 1 // TODO three",
+		`sub/deeper/f.json:1:{"todo": 5}`,
+		"sub/keep.txt:1:TODO four",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %q, got %q", expect, got)
+	}
+	got, _ = grepTree(re, "*.go *.json", dir)
+	if len(got) != 3 {
+		t.Errorf("Expected 3 matches in .go and .json files, got %q", got)
+	}
+}
+
+func TestNextError(t *testing.T) {
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	dir, err := ioutil.TempDir("", "gomacs-grep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		"a.txt": "one\ntwo foo\n",
+		"b.txt": "  foo\n",
+	})
+	re, _ := compileSearch("foo", true)
+	list := namedBuffer("*grep*")
+	fillErrorList(list, &errorList{dir, re, -1}, "grep", []string{
+		"grep for foo", "a.txt:2:two foo", "b.txt:1:  foo", "Grep finished with 2 matches"})
+	src := Global.CurrentB
+	nextError(nil, 1, false)
+	if Global.CurrentB == src || Global.CurrentB.Filename != filepath.Join(dir, "a.txt") {
+		t.Fatalf("Expected next-error to visit a.txt, got %q", Global.CurrentB.Filename)
+	}
+	if Global.CurrentB.cy != 1 || Global.CurrentB.cx != 4 {
+		t.Errorf("Expected to be at 2:4, got %d:%d", Global.CurrentB.cy+1, Global.CurrentB.cx)
+	}
+	nextError(nil, 1, false)
+	if Global.CurrentB.Filename != filepath.Join(dir, "b.txt") || Global.CurrentB.cx != 2 {
+		t.Errorf("Expected to be in b.txt at 1:2, got %q %d:%d", Global.CurrentB.Filename,
+			Global.CurrentB.cy+1, Global.CurrentB.cx)
+	}
+	nextError(nil, 1, false)
+	if Global.Input != "No more places after this one" {
+		t.Errorf("Expected to run out of places, got %q", Global.Input)
+	}
+	a := Global.Buffers
+	nextError(nil, -1, false)
+	if Global.CurrentB.Filename != filepath.Join(dir, "a.txt") || len(Global.Buffers) != len(a) {
+		t.Errorf("Expected previous-error to go back to the open a.txt")
+	}
+}
+
+func TestErrorListColumns(t *testing.T) {
+	list := &errorList{"/src", nil, -1}
+	fn, line, col, ok := list.parse("main.go:12:5: undefined: x")
+	if !ok || fn != "/src/main.go" || line != 12 || col != 5 {
+		t.Errorf("Expected /src/main.go:12:5, got %s:%d:%d", fn, line, col)
+	}
+	if _, _, _, ok = list.parse("ok   github.com/x 0.1s"); ok {
+		t.Error("Expected a line without a place not to parse")
+	}
+}
@@ -26,6 +26,11 @@ func editorGetKey() string {
 			return ParseTermboxEvent(ev)
 		} else if ev.Type == termbox.EventMouse {
 			return ParseMouseEvent(ev)
+		} else if ev.Type == termbox.EventInterrupt {
+			redraw := runQueuedJobs()
+			if runPeriodicJobs() || redraw {
+				editorRefreshScreen()
+			}
 		}
 	}
 }
@@ -87,6 +92,12 @@ func DynamicPromptWithCallback(prompt string, refresh func(int, int), callback f
 // function, and callback. It allows the user to edit the default
 // value. It returns what the user entered.
 func EditDynamicWithCallback(defval, prompt string, refresh func(int, int), callback func(string, string) string) string {
+	return editDynamic(defval, func() string { return prompt }, refresh, callback)
+}
+
+// As EditDynamicWithCallback, but asks prompt for the prompt before every
+// keystroke, so that it can change as the user types.
+func editDynamic(defval string, prompt func() string, refresh func(int, int), callback func(string, string) string) string {
 	var buffer string
 	var bufpos, cursor, offset int
 	if defval == "" {
@@ -106,8 +117,8 @@ func EditDynamicWithCallback(defval, prompt string, refresh func(int, int), call
 			cursor = termutil.RunewidthStr(buffer)
 		}
 	}
-	iw := termutil.RunewidthStr(prompt + ": ")
 	for {
+		iw := termutil.RunewidthStr(prompt() + ": ")
 		buflen := len(buffer)
 		x, y := termbox.Size()
 		if refresh != nil {
@@ -123,7 +134,7 @@ func EditDynamicWithCallback(defval, prompt string, refresh func(int, int), call
 			cursor++
 		}
 		t, _ := trimString(buffer, offset)
-		termutil.Printstring(prompt+": "+t, 0, y-1)
+		termutil.Printstring(prompt()+": "+t, 0, y-1)
 		termbox.SetCursor(iw+cursor, y-1)
 		termbox.Flush()
 		ev := termbox.PollEvent()
@@ -163,9 +174,10 @@ func EditDynamicWithCallback(defval, prompt string, refresh func(int, int), call
 			}
 		case "C-y":
 			bp := bufpos
+			syncKillFromClipboard()
 			buffer, buflen, bufpos, cursor = recalcBuffer(
-				buffer[:bufpos] + Global.Clipboard + buffer[bufpos:])
-			bufpos = bp + len(Global.Clipboard)
+				buffer[:bufpos] + currentKill() + buffer[bufpos:])
+			bufpos = bp + len(currentKill())
 			cursor = termutil.RunewidthStr(buffer[:bufpos])
 		case "C-c":
 			fallthrough
@@ -391,7 +403,7 @@ func InsertRaw() {
 }
 
 func zapToChar() {
-	if Global.CurrentB.cy == Global.CurrentB.NumRows {
+	if Global.CurrentB.cy == Global.CurrentB.NumRows() {
 		Global.Input = "End of buffer"
 		return
 	}
@@ -400,11 +412,12 @@ func zapToChar() {
 	chars := GetRawChar()
 	Global.Input += chars
 	zapru, size := utf8.DecodeLastRuneInString(chars)
-	for _, row := range Global.CurrentB.Rows[Global.CurrentB.cy:] {
-		thisrow := row.idx == Global.CurrentB.cy
+	for cy := Global.CurrentB.cy; cy < Global.CurrentB.NumRows(); cy++ {
+		row := Global.CurrentB.Row(cy)
+		thisrow := cy == Global.CurrentB.cy
 		for in, ru := range row.Data {
 			if ru == zapru && !(thisrow && in < Global.CurrentB.cx) {
-				Global.Clipboard = bufKillRegion(Global.CurrentB, Global.CurrentB.cx, in+size, Global.CurrentB.cy, row.idx)
+				addKill(bufKillRegion(Global.CurrentB, Global.CurrentB.cx, in+size, Global.CurrentB.cy, cy), false)
 				return
 			}
 		}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Incremental search. The query is matched as you type it, either literally
+// or as a regular expression. Case is ignored unless the query has an
+// upper-case letter in it.
+
+// How many past queries are kept for M-p and M-n.
+const searchRingMax = 16
+
+var searchRing, regexpSearchRing []string
+
+type isearchState struct {
+	regexp   bool
+	backward bool
+	// Where the current match starts and ends; the cursor sits at its start
+	matchx, matchy, matchend int
+	found                    bool
+	failing                  bool
+	wrapped                  bool
+	// Which past query M-p and M-n are on; -1 when the query is new
+	history int
+}
+
+func (s *isearchState) ring() *[]string {
+	if s.regexp {
+		return &regexpSearchRing
+	}
+	return &searchRing
+}
+
+// Remember a query, newest first, without repeating the newest one.
+func addToSearchRing(ring *[]string, query string) {
+	if query == "" || (len(*ring) > 0 && (*ring)[0] == query) {
+		return
+	}
+	*ring = append([]string{query}, *ring...)
+	if searchRingMax < len(*ring) {
+		*ring = (*ring)[:searchRingMax]
+	}
+}
+
+// Whether the query should match regardless of case: it has no upper-case
+// letters in it, not counting ones escaped with a backslash in a regexp.
+func searchFoldsCase(query string, isRegexp bool) bool {
+	for i := 0; i < len(query); {
+		r, rs := utf8.DecodeRuneInString(query[i:])
+		if isRegexp && r == '\\' {
+			_, es := utf8.DecodeRuneInString(query[i+rs:])
+			i += rs + es
+			continue
+		}
+		if unicode.IsUpper(r) {
+			return false
+		}
+		i += rs
+	}
+	return true
+}
+
+// Turn a query into the regexp that finds it.
+func compileSearch(query string, isRegexp bool) (*regexp.Regexp, error) {
+	expr := query
+	if !isRegexp {
+		expr = regexp.QuoteMeta(query)
+	}
+	if searchFoldsCase(query, isRegexp) {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// Every non-empty match of re on the row, as start and end pairs.
+func rowMatches(row *EditorRow, re *regexp.Regexp) [][]int {
+	ret := [][]int{}
+	for _, m := range re.FindAllStringIndex(row.Data, -1) {
+		if m[0] < m[1] {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// Find the nearest match of re starting after (cx, cy), or before it if
+// backward; a match starting right at (cx, cy) counts if inclusive. The
+// search wraps around the ends of the buffer. Returns the start and end of the
+// match on line y, whether the search wrapped, and whether it found anything.
+func (buf *EditorBuffer) findMatch(re *regexp.Regexp, cx, cy int, backward, inclusive bool) (int, int, int, bool, bool) {
+	n := buf.NumRows()
+	if n == 0 {
+		return 0, 0, 0, false, false
+	}
+	if n <= cy {
+		cy, cx = n-1, buf.Row(n-1).Size
+	}
+	for i := 0; i <= n; i++ {
+		y := cy + i
+		if backward {
+			y = cy - i
+		}
+		y = (y%n + n) % n
+		matches := rowMatches(buf.Row(y), re)
+		if backward {
+			for j := len(matches) - 1; 0 <= j; j-- {
+				m := matches[j]
+				if i == 0 && !(m[0] < cx || (inclusive && m[0] == cx)) {
+					continue
+				} else if i == n && m[0] < cx {
+					break
+				}
+				return m[0], m[1], y, 0 < i, true
+			}
+		} else {
+			for _, m := range matches {
+				if i == 0 && !(cx < m[0] || (inclusive && m[0] == cx)) {
+					continue
+				} else if i == n && cx < m[0] {
+					break
+				}
+				return m[0], m[1], y, 0 < i, true
+			}
+		}
+	}
+	return 0, 0, 0, false, false
+}
+
+func (s *isearchState) prompt() string {
+	ret := "I-search"
+	if s.regexp {
+		ret = "Regexp I-search"
+	}
+	if s.backward {
+		ret += " backward"
+	}
+	if s.failing {
+		ret = "Failing " + ret
+	} else if s.wrapped {
+		ret = "Wrapped " + ret
+	}
+	return ret
+}
+
+// Look for the query from the current match (or the cursor, if there isn't
+// one). If next, skip the current match.
+func (s *isearchState) search(buf *EditorBuffer, query string, next bool) {
+	if query == "" {
+		s.failing, s.found = false, false
+		buf.regionActive = false
+		buf.lazyHighlight = nil
+		return
+	}
+	re, err := compileSearch(query, s.regexp)
+	if err != nil {
+		// Probably a regexp that hasn't been finished yet
+		s.failing = true
+		return
+	}
+	buf.lazyHighlight = re
+	cx, cy := buf.cx, buf.cy
+	if s.found {
+		cx, cy = s.matchx, s.matchy
+	}
+	start, end, y, wrapped, ok := buf.findMatch(re, cx, cy, s.backward, !next)
+	s.failing = !ok
+	if !ok {
+		return
+	}
+	s.wrapped = s.wrapped || wrapped
+	s.found = true
+	s.matchx, s.matchy, s.matchend = start, y, end
+	buf.cy, buf.cx = y, start
+	buf.prefcx = buf.cx
+	buf.MarkX, buf.MarkY = end, y
+	buf.regionActive = true
+	buf.recalcRegion()
+	// Make the refresh scroll the match into view
+	buf.rowoff = buf.NumRows()
+}
+
+// Add the rest of the word after the current match (or the cursor) to the
+// query.
+func (s *isearchState) yankWord(buf *EditorBuffer, query string) string {
+	if buf.NumRows() <= buf.cy {
+		return query
+	}
+	row := buf.Row(buf.cy)
+	from := buf.cx
+	if s.found && query != "" {
+		from = s.matchend
+	}
+	if len(row.Data) <= from {
+		return query
+	}
+	word := row.Data[from:forwardWordIndex(row.Data, from)]
+	if s.regexp {
+		word = regexp.QuoteMeta(word)
+	}
+	return query + word
+}
+
+// Handle a key typed at the isearch prompt; returns the new query.
+func (s *isearchState) key(buf *EditorBuffer, query, key string) string {
+	ring := s.ring()
+	switch key {
+	case "C-s", "C-M-s", "C-r", "C-M-r":
+		backward := key == "C-r" || key == "C-M-r"
+		if query == "" && len(*ring) > 0 {
+			// Search for the last thing we searched for
+			s.backward = backward
+			query = (*ring)[0]
+			s.search(buf, query, false)
+			return query
+		}
+		if s.backward != backward {
+			s.backward = backward
+			s.wrapped = false
+		}
+		s.search(buf, query, true)
+	case "C-w":
+		query = s.yankWord(buf, query)
+		s.search(buf, query, false)
+	case "M-p", "M-n":
+		if key == "M-p" && s.history+1 < len(*ring) {
+			s.history++
+		} else if key == "M-n" && 0 <= s.history {
+			s.history--
+		}
+		query = ""
+		if 0 <= s.history {
+			query = (*ring)[s.history]
+		}
+		s.found = false
+		s.search(buf, query, false)
+	case "RET":
+		addToSearchRing(ring, query)
+		s.done(buf)
+	case "C-g", "C-c":
+		s.done(buf)
+	default:
+		s.search(buf, query, false)
+	}
+	return query
+}
+
+func (s *isearchState) done(buf *EditorBuffer) {
+	buf.regionActive = false
+	buf.lazyHighlight = nil
+}
+
+// Search the current buffer as you type.
+func editorFind(isRegexp, backward bool) {
+	buf := Global.CurrentB
+	savedCx, savedCy, savedRo := buf.cx, buf.cy, buf.rowoff
+	s := &isearchState{regexp: isRegexp, backward: backward, history: -1}
+	query := editDynamic("", s.prompt, func(int, int) { editorRefreshScreen() },
+		func(query, key string) string {
+			return s.key(buf, query, key)
+		})
+	if query == "" || !s.found {
+		// Search cancelled, go back to where we were
+		buf.cx = savedCx
+		buf.prefcx = buf.cx
+		buf.cy = savedCy
+		buf.rowoff = savedRo
+		if query == "" {
+			Global.Input = "Cancelled search."
+		} else {
+			Global.Input = "Not found: " + query
+		}
+		return
+	}
+	buf.MarkX, buf.MarkY = savedCx, savedCy
+	Global.Input = "Mark saved where search started"
+}
+
+// Show every match of the buffer's lazy highlight regexp that's on screen.
+func drawLazyHighlight(startx, starty, sx, sy int, buf *EditorBuffer, gutsize int) {
+	re := buf.lazyHighlight
+	if re == nil {
+		return
+	}
+	w, h := termbox.Size()
+	cells := termbox.CellBuffer()
+	for y := starty; y < sy && y < h; y++ {
+		filerow := y - starty + buf.rowoff
+		if buf.NumRows() <= filerow {
+			break
+		}
+		row := buf.Row(filerow)
+		skip := buf.lazyHighlightSkip
+		if row.Size < skip {
+			continue
+		}
+		for _, m := range re.FindAllStringIndex(row.Data[skip:], -1) {
+			from := startx + gutsize + row.cxToRx(skip+m[0]) - row.coloff
+			to := startx + gutsize + row.cxToRx(skip+m[1]) - row.coloff
+			for x := from; x < to; x++ {
+				if x < startx+gutsize || sx <= x || w <= x {
+					continue
+				}
+				cell := cells[y*w+x]
+				if cell.Fg&termbox.AttrReverse == 0 {
+					// Leave the current match (the region) as it is
+					termbox.SetCell(x, y, cell.Ch, cell.Fg, termbox.ColorCyan)
+				}
+			}
+		}
+	}
+}
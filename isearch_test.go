@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchFoldsCase(t *testing.T) {
+	cases := []struct {
+		query  string
+		regexp bool
+		folds  bool
+	}{
+		{"foo", false, true},
+		{"Foo", false, false},
+		{`\W+`, true, true},
+		{`\WX`, true, false},
+		{`\W`, false, false},
+	}
+	for _, c := range cases {
+		if searchFoldsCase(c.query, c.regexp) != c.folds {
+			t.Errorf("Expected searchFoldsCase(%q, %v) to be %v", c.query, c.regexp, c.folds)
+		}
+	}
+}
+
+func TestFindMatch(t *testing.T) {
+	InitEditor()
+	buf := setLines("a foo foo", "FOO", "bar foo")
+	re, _ := compileSearch("foo", false)
+	expect := func(start, end, y int, wrapped bool, gs, ge, gy int, gw, ok bool) {
+		t.Helper()
+		if !ok || start != gs || end != ge || y != gy || wrapped != gw {
+			t.Errorf("Expected a match at %d:%d-%d (wrapped %v), got %d:%d-%d (wrapped %v, found %v)",
+				y+1, start, end, wrapped, gy+1, gs, ge, gw, ok)
+		}
+	}
+	gs, ge, gy, gw, ok := buf.findMatch(re, 2, 0, false, true)
+	expect(2, 5, 0, false, gs, ge, gy, gw, ok)
+	gs, ge, gy, gw, ok = buf.findMatch(re, 2, 0, false, false)
+	expect(6, 9, 0, false, gs, ge, gy, gw, ok)
+	gs, ge, gy, gw, ok = buf.findMatch(re, 6, 0, false, false)
+	expect(0, 3, 1, true, gs, ge, gy, gw, ok)
+	gs, ge, gy, gw, ok = buf.findMatch(re, 4, 2, false, false)
+	expect(2, 5, 0, true, gs, ge, gy, gw, ok)
+	gs, ge, gy, gw, ok = buf.findMatch(re, 6, 0, true, false)
+	expect(2, 5, 0, false, gs, ge, gy, gw, ok)
+	gs, ge, gy, gw, ok = buf.findMatch(re, 2, 0, true, false)
+	expect(4, 7, 2, true, gs, ge, gy, gw, ok)
+	re, _ = compileSearch("FOO", false)
+	gs, ge, gy, gw, ok = buf.findMatch(re, 0, 0, false, true)
+	expect(0, 3, 1, true, gs, ge, gy, gw, ok)
+	re, _ = compileSearch("xyzzy", false)
+	if _, _, _, _, ok = buf.findMatch(re, 0, 0, false, true); ok {
+		t.Error("Expected no match for xyzzy")
+	}
+}
+
+// Type a query into isearch one key at a time.
+func isearchType(s *isearchState, buf *EditorBuffer, query string, keys ...string) string {
+	for _, key := range keys {
+		if len(key) == 1 {
+			query += key
+		}
+		query = s.key(buf, query, key)
+	}
+	return query
+}
+
+func TestIsearch(t *testing.T) {
+	InitEditor()
+	buf := setLines("one two", "Two three two")
+	s := &isearchState{history: -1}
+	query := isearchType(s, buf, "", "t", "w")
+	if buf.cy != 0 || buf.cx != 4 || !buf.regionActive {
+		t.Errorf("Expected the first match at 1:4, got %d:%d", buf.cy+1, buf.cx)
+	}
+	query = isearchType(s, buf, query, "C-s", "C-s")
+	if buf.cy != 1 || buf.cx != 10 {
+		t.Errorf("Expected the third match at 2:10, got %d:%d", buf.cy+1, buf.cx)
+	}
+	query = isearchType(s, buf, query, "C-r")
+	if buf.cy != 1 || buf.cx != 0 || !s.backward {
+		t.Errorf("Expected to search back to 2:0, got %d:%d", buf.cy+1, buf.cx)
+	}
+	query = isearchType(s, buf, query, "C-w")
+	if query != "two" {
+		t.Errorf("Expected C-w to add the rest of the word, got %q", query)
+	}
+	query = isearchType(s, buf, query, "x")
+	if !s.failing || buf.cy != 1 || buf.cx != 0 {
+		t.Errorf("Expected a failing search that stays at 2:0, got %d:%d", buf.cy+1, buf.cx)
+	}
+	isearchType(s, buf, "two", "RET")
+	if len(searchRing) == 0 || searchRing[0] != "two" {
+		t.Errorf("Expected the query in the search ring, got %q", searchRing)
+	}
+
+	s = &isearchState{history: -1}
+	query = isearchType(s, buf, "", "C-s")
+	if query != "two" {
+		t.Errorf("Expected C-s to search for the last query again, got %q", query)
+	}
+	query = isearchType(s, buf, "", "M-p")
+	if query != "two" {
+		t.Errorf("Expected M-p to bring back the last query, got %q", query)
+	}
+}
+
+func TestRegexpIsearch(t *testing.T) {
+	InitEditor()
+	buf := setLines("x = 10", "y = 200")
+	s := &isearchState{regexp: true, history: -1}
+	isearchType(s, buf, `[0-9]+`, "C-s", "C-s")
+	if !s.found || buf.cy != 1 || buf.cx != 4 || buf.MarkX != 7 {
+		t.Errorf("Expected to match 200 at 2:4, got %d:%d-%d", buf.cy+1, buf.cx, buf.MarkX)
+	}
+	query := isearchType(s, buf, `[0-9`, "DEL")
+	if !s.failing || query != `[0-9` {
+		t.Errorf("Expected an unfinished regexp to fail quietly")
+	}
+}
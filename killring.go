@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+)
+
+// The kill ring holds the most recent kills, newest first. Global.killRingYank
+// is the one that yank inserts; yank-pop moves it on to older kills.
+
+// Put text on the kill ring. If the last command killed something too, text
+// is added to that kill instead - at the front if we're killing backwards - so
+// that a run of kills can be yanked back in one go.
+func addKill(text string, backward bool) {
+	if 0 < len(Global.KillRing) && 0 < Global.lastKill && commandSeq-Global.lastKill <= 1 {
+		if backward {
+			Global.KillRing[0] = text + Global.KillRing[0]
+		} else {
+			Global.KillRing[0] += text
+		}
+		Global.killRingYank = 0
+		syncKillToClipboard(Global.KillRing[0])
+	} else {
+		pushKill(text)
+	}
+	Global.lastKill = commandSeq
+}
+
+// Put text on the kill ring as a kill of its own.
+func pushKill(text string) {
+	Global.KillRing = append([]string{text}, Global.KillRing...)
+	trimKillRing()
+	Global.killRingYank = 0
+	syncKillToClipboard(text)
+}
+
+// Throw away the oldest kills if there are more than KillRingMax.
+func trimKillRing() {
+	if 0 < Global.KillRingMax && Global.KillRingMax < len(Global.KillRing) {
+		Global.KillRing = Global.KillRing[:Global.KillRingMax]
+	}
+	if len(Global.KillRing) <= Global.killRingYank {
+		Global.killRingYank = 0
+	}
+}
+
+// The kill n places on from the one yank would insert; "" if there are none.
+func nthKill(n int) string {
+	l := len(Global.KillRing)
+	if l == 0 {
+		return ""
+	}
+	return Global.KillRing[((Global.killRingYank+n)%l+l)%l]
+}
+
+// The kill that yank will insert.
+func currentKill() string {
+	return nthKill(0)
+}
+
+// Move on to the kill n places on, and return it.
+func rotateKill(n int) string {
+	l := len(Global.KillRing)
+	if l == 0 {
+		return ""
+	}
+	Global.killRingYank = ((Global.killRingYank+n)%l + l) % l
+	return currentKill()
+}
+
+func lastCommandWasYank() bool {
+	if Global.LastCommand == nil {
+		return false
+	}
+	switch Global.LastCommand.Name {
+	case "yank-region", "yank-pop", "mouse-yank-primary", "browse-kill-ring":
+		return true
+	}
+	return false
+}
+
+// Replace the text that was just yanked with the kill before it.
+func doYankPop() {
+	if !lastCommandWasYank() {
+		Global.Input = "Previous command was not a yank"
+		return
+	}
+	if len(Global.KillRing) == 0 {
+		Global.Input = "Kill ring is empty"
+		return
+	}
+	buf := Global.CurrentB
+	startc, startl := Global.yankX, Global.yankY
+	killed := bufKillRegion(buf, startc, buf.cx, startl, buf.cy)
+	editorAddRegionUndo(false, startc, buf.cx, startl, buf.cy, killed)
+	n := 1
+	if Global.SetUniversal {
+		n = Global.Universal
+	}
+	text := rotateKill(n)
+	cx, cy := spitRegion(startc, startl, text)
+	editorAddRegionUndo(true, cx, buf.cx, cy, buf.cy, text)
+}
+
+// Summarise a kill on one line, for choosing between them.
+func killSummary(text string) string {
+	text = strings.Replace(text, "\n", "^J", -1)
+	text = strings.Replace(text, "\t", "^I", -1)
+	if 60 < len(text) {
+		text = text[:57] + "..."
+	}
+	return text
+}
+
+func browseKillRing() {
+	syncKillFromClipboard()
+	if len(Global.KillRing) == 0 {
+		Global.Input = "Kill ring is empty"
+		return
+	}
+	choices := make([]string, len(Global.KillRing))
+	for i, text := range Global.KillRing {
+		choices[i] = killSummary(text)
+	}
+	choice := editorChoiceIndex("Yank from kill ring", choices, Global.killRingYank)
+	if choice < 0 || len(Global.KillRing) <= choice {
+		Global.Input = "Cancelled."
+		return
+	}
+	Global.killRingYank = choice
+	doYankRegion()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+)
+
+// Run f as a command of its own, as if from the keyboard.
+func runAsCommand(name string, f func()) {
+	cmd := &CommandFunc{name, func(*glisp.Zlisp) { f() }, false}
+	cmd.Run(nil)
+}
+
+func TestConsecutiveKillsAppend(t *testing.T) {
+	InitEditor()
+	editorInsertStr("one two three")
+	Global.CurrentB.cx = 0
+	runAsCommand("kill-word", delForwardWord)
+	runAsCommand("kill-word", delForwardWord)
+	if len(Global.KillRing) != 1 || currentKill() != "one two" {
+		t.Errorf("Expected one kill of %q but got %q", "one two", Global.KillRing)
+	}
+	runAsCommand("forward-char", Global.CurrentB.MoveCursorRight)
+	runAsCommand("kill-word", delForwardWord)
+	if len(Global.KillRing) != 2 || currentKill() != "three" {
+		t.Errorf("Expected a new kill of %q but got %q", "three", Global.KillRing)
+	}
+}
+
+func TestBackwardKillsPrepend(t *testing.T) {
+	InitEditor()
+	editorInsertStr("one two")
+	runAsCommand("backward-kill-word", delBackWord)
+	runAsCommand("backward-kill-word", delBackWord)
+	if len(Global.KillRing) != 1 || currentKill() != "one two" {
+		t.Errorf("Expected one kill of %q but got %q", "one two", Global.KillRing)
+	}
+}
+
+func TestYankPop(t *testing.T) {
+	InitEditor()
+	editorInsertStr("<>")
+	Global.CurrentB.cx = 1
+	pushKill("one")
+	pushKill("two")
+	pushKill("three")
+	runAsCommand("yank-region", doYankRegion)
+	Global.CurrentB.FailIfBufferNe([]string{"<three>"}, t)
+	runAsCommand("yank-pop", doYankPop)
+	Global.CurrentB.FailIfBufferNe([]string{"<two>"}, t)
+	runAsCommand("yank-pop", doYankPop)
+	Global.CurrentB.FailIfBufferNe([]string{"<one>"}, t)
+	runAsCommand("yank-pop", doYankPop)
+	Global.CurrentB.FailIfBufferNe([]string{"<three>"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"<one>"}, t)
+}
+
+func TestYankPopNeedsYank(t *testing.T) {
+	InitEditor()
+	pushKill("one")
+	runAsCommand("yank-pop", doYankPop)
+	if Global.Input != "Previous command was not a yank" {
+		t.Error("Expected a complaint but got", Global.Input)
+	}
+	Global.CurrentB.FailIfBufferNe([]string{}, t)
+}
+
+func TestKillRingMax(t *testing.T) {
+	InitEditor()
+	Global.KillRingMax = 2
+	pushKill("one")
+	pushKill("two")
+	pushKill("three")
+	if len(Global.KillRing) != 2 || nthKill(1) != "two" {
+		t.Error("Expected the kill ring to be trimmed but it was", Global.KillRing)
+	}
+}
+
+func TestKillRingLisp(t *testing.T) {
+	InitEditor()
+	env := NewLispInterp(false)
+	res, err := env.EvalString(`(killringpush "one") (killringpush "two") (killringget 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := res.(*glisp.SexpStr); !ok || s.S != "one" {
+		t.Error("Expected \"one\" but got", res.SexpString(nil))
+	}
+}
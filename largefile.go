@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	termutil "github.com/japanoise/termbox-util"
+	"github.com/nsf/termbox-go"
+	"github.com/zyedidia/highlight"
+)
+
+// Large files aren't read into memory when they're opened. Instead we scan
+// them once to find where every chunk of lines starts, and build a rope whose
+// leaves are placeholders for those chunks. A chunk is only read from disk,
+// decoded and split into rows when something asks for one of its rows, and
+// only the rows on screen are ever highlighted.
+
+// Lines longer than this (in bytes) are never highlighted in a large file;
+// minified code would take forever.
+const largeFileMaxHighlight = 10000
+
+// Where a large file's chunks are read from. The file is kept open so that it
+// can't change under us if it's replaced by a rename (which is how we save).
+type lazySource struct {
+	f      *os.File
+	coding Coding
+	eol    EolStyle
+}
+
+// A run of lines in a large file that hasn't been read yet.
+type lazyChunk struct {
+	src        *lazySource
+	start, end int64
+}
+
+// Read a placeholder leaf's lines from disk and turn them into rows.
+func (n *ropeNode) materialize() {
+	chunk := n.lazy
+	n.lazy = nil
+	data := make([]byte, chunk.end-chunk.start)
+	_, err := chunk.src.f.ReadAt(data, chunk.start)
+	if err != nil && err != io.EOF {
+		AddErrorMessage("Error reading " + chunk.src.f.Name() + ": " + err.Error())
+	}
+	lines := splitChunk(decodeText(data, chunk.src.coding), chunk.src.eol)
+	n.rows = make([]*EditorRow, n.count)
+	for i := range n.rows {
+		line := ""
+		if i < len(lines) {
+			line = lines[i]
+		}
+		row := &EditorRow{Size: len(line), Data: line, leaf: n}
+		rowUpdateRender(row)
+		n.rows[i] = row
+	}
+}
+
+// Split a chunk of text into lines. Lines are split at the character the
+// indexer counted, so that we always get the number of rows it promised.
+func splitChunk(s string, eol EolStyle) []string {
+	sep := "\n"
+	if eol == EolMac {
+		sep = "\r"
+	}
+	lines := strings.Split(s, sep)
+	if eol == EolDos {
+		for i, line := range lines {
+			lines[i] = strings.TrimSuffix(line, "\r")
+		}
+	}
+	return lines
+}
+
+// Show a message on the prompt line straight away, if the terminal is up.
+func showProgress(msg string) {
+	if !termbox.IsInit {
+		return
+	}
+	x, y := termbox.Size()
+	termutil.ClearLine(x, y-1)
+	termutil.Printstring(msg, 0, y-1)
+	termbox.Flush()
+}
+
+// Open a file in large-file mode. Returns false (having done nothing) if the
+// file is in an encoding that can't be read a piece at a time.
+func (buf *EditorBuffer) loadLarge(fn string) (bool, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return true, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return true, err
+	}
+	size := info.Size()
+
+	// Guess the coding and line endings from the start of the file
+	sample := make([]byte, 64*1024)
+	n, _ := io.ReadFull(f, sample)
+	sample = sample[:n]
+	for i := 0; i < utf8.UTFMax && !utf8.Valid(sample) && len(sample) > 0; i++ {
+		// Don't let a character cut in half at the end spoil the guess
+		sample = sample[:len(sample)-1]
+	}
+	src := &lazySource{f: f, coding: detectCoding(sample)}
+	if src.coding == CodingUTF16LE || src.coding == CodingUTF16BE {
+		f.Close()
+		return false, nil
+	}
+	src.eol = detectEol(decodeText(sample, src.coding))
+	sep := byte('\n')
+	if src.eol == EolMac {
+		sep = '\r'
+	}
+	var offset int64
+	if src.coding == CodingUTF8BOM {
+		offset = int64(len(bomUTF8))
+	}
+
+	// Find where every chunk of lines starts
+	leaves := []*ropeNode{}
+	chunkLines := ropeNodeMax / 2
+	chunkStart, lines := offset, 0
+	block := make([]byte, 1024*1024)
+	name := filepath.Base(fn)
+	lastpct := -1
+	for offset < size {
+		n, err := f.ReadAt(block, offset)
+		if err != nil && err != io.EOF {
+			f.Close()
+			return true, err
+		}
+		for i := 0; i < n; {
+			j := bytes.IndexByte(block[i:n], sep)
+			if j < 0 {
+				break
+			}
+			i += j + 1
+			lines++
+			if lines == chunkLines {
+				end := offset + int64(i)
+				leaves = append(leaves, &ropeNode{count: lines,
+					lazy: &lazyChunk{src, chunkStart, end}})
+				chunkStart, lines = end, 0
+			}
+		}
+		offset += int64(n)
+		if n == 0 {
+			break
+		}
+		if pct := int(100 * offset / size); pct != lastpct {
+			showProgress(fmt.Sprintf("Indexing %s... %d%%", name, pct))
+			lastpct = pct
+		}
+	}
+	buf.NoFinalNewline = false
+	if chunkStart < size {
+		var last [1]byte
+		f.ReadAt(last[:], size-1)
+		if last[0] != sep {
+			// The last line has no separator after it
+			buf.NoFinalNewline = true
+			lines++
+		}
+		leaves = append(leaves, &ropeNode{count: lines,
+			lazy: &lazyChunk{src, chunkStart, size}})
+	}
+
+	buf.closeLargeFile()
+	buf.largeSource = src
+	buf.rows = &rowRope{}
+	buf.rows.buildFromLeaves(leaves)
+	buf.Coding = src.coding
+	buf.Eol = src.eol
+	buf.LargeFile = true
+	buf.setMode("read-only-mode", true)
+	return true, nil
+}
+
+// Close the file a large file's rows are read from, when they're being
+// replaced or the buffer is going away.
+func (buf *EditorBuffer) closeLargeFile() {
+	if buf.largeSource != nil {
+		buf.largeSource.f.Close()
+		buf.largeSource = nil
+	}
+}
+
+// Whether fn should be opened in large-file mode.
+func isLargeFile(fn string) bool {
+	info, err := os.Stat(fn)
+	return err == nil && 0 < Global.LargeFileThreshold && Global.LargeFileThreshold < info.Size()
+}
+
+// Forget the highlighting of every row that has been loaded, so that it's
+// redone when the rows are next drawn.
+func (n *ropeNode) forgetHighlighting() {
+	if n.lazy != nil {
+		return
+	}
+	for _, row := range n.rows {
+		row.HlState = nil
+		row.HlMatches = nil
+	}
+	for _, child := range n.children {
+		child.forgetHighlighting()
+	}
+}
+
+// Highlight the rows from line from up to line to that haven't been
+// highlighted yet. Only large files are highlighted like this; everything
+// else is highlighted in one go when it's loaded.
+func (buf *EditorBuffer) highlightRange(from, to int) {
+	if !buf.LargeFile || buf.Highlighter == nil {
+		return
+	}
+	if to > buf.NumRows() {
+		to = buf.NumRows()
+	}
+	buf.EachRow(from, to, func(i int, row *EditorRow) bool {
+		if row.HlMatches != nil {
+			return true
+		}
+		if len(row.Data) > largeFileMaxHighlight {
+			row.HlMatches = highlight.LineMatch{}
+		} else {
+			buf.Highlighter.ReHighlightLine(buf, i)
+		}
+		return true
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func largeFileTestLines() []string {
+	lines := make([]string, 5000)
+	for i := range lines {
+		lines[i] = "line " + strconv.Itoa(i)
+	}
+	// Longer than the sample used to guess the coding
+	lines[2500] = strings.Repeat("x", 100000)
+	return lines
+}
+
+func openLargeTestFile(contents string, t *testing.T) *EditorBuffer {
+	fn := filepath.Join(t.TempDir(), "large.txt")
+	ioutil.WriteFile(fn, []byte(contents), 0644)
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	Global.LargeFileThreshold = 1024
+	EditorOpen(fn, nil)
+	buf := Global.CurrentB
+	if !buf.LargeFile {
+		t.Fatal("File wasn't opened as a large file")
+	}
+	return buf
+}
+
+func checkLargeFile(buf *EditorBuffer, lines []string, t *testing.T) {
+	if buf.NumRows() != len(lines) {
+		t.Fatal("Expected", len(lines), "rows but got", buf.NumRows())
+	}
+	for _, i := range []int{4999, 0, 2500, 1234} {
+		if buf.Row(i).Data != lines[i] {
+			t.Errorf("Expected line %d to be %.20q but was %.20q", i, lines[i], buf.Row(i).Data)
+		}
+	}
+}
+
+func TestLargeFileLoadsLazily(t *testing.T) {
+	lines := largeFileTestLines()
+	buf := openLargeTestFile(strings.Join(lines, "\n")+"\n", t)
+	if buf.NoFinalNewline {
+		t.Error("Final newline not detected")
+	}
+	checkLargeFile(buf, lines, t)
+	loaded, lazy := 0, 0
+	var count func(n *ropeNode)
+	count = func(n *ropeNode) {
+		if n.lazy != nil {
+			lazy++
+		} else if n.isLeaf() {
+			loaded++
+		}
+		for _, child := range n.children {
+			count(child)
+		}
+	}
+	count(buf.rows.root)
+	if loaded > 4 || lazy == 0 {
+		t.Error("Expected only a few leaves to be loaded but", loaded, "were, with", lazy, "left")
+	}
+	data, err := buf.encodeContents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != strings.Join(lines, "\n")+"\n" {
+		t.Error("Large file didn't round-trip")
+	}
+}
+
+func TestLargeFileDosNoFinalNewline(t *testing.T) {
+	lines := largeFileTestLines()
+	buf := openLargeTestFile(strings.Join(lines, "\r\n"), t)
+	if buf.Eol != EolDos {
+		t.Error("Expected DOS line endings")
+	}
+	if !buf.NoFinalNewline {
+		t.Error("Missing final newline not detected")
+	}
+	checkLargeFile(buf, lines, t)
+}
+
+// Run f, returning the readOnlyError it panics with, if any.
+func catchReadOnly(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(readOnlyError)
+		}
+	}()
+	f()
+	return nil
+}
+
+func TestLargeFileIsReadOnly(t *testing.T) {
+	buf := openLargeTestFile(strings.Join(largeFileTestLines(), "\n"), t)
+	err := catchReadOnly(func() { editorInsertStr("a") })
+	if err == nil {
+		t.Error("Editing a large file didn't complain")
+	}
+	if buf.Dirty || buf.Row(0).Data != "line 0" || buf.Undo != nil {
+		t.Error("Large file was edited")
+	}
+	doToggleMode("read-only-mode")
+	err = catchReadOnly(func() { editorInsertStr("a") })
+	if err != nil || buf.Row(0).Data != "aline 0" {
+		t.Error("Couldn't edit after turning off read-only-mode:", err, buf.Row(0).Data)
+	}
+	editorUndoAction()
+	if buf.Row(0).Data != "line 0" {
+		t.Errorf("Expected undo to take back only the insert, got %q", buf.Row(0).Data)
+	}
+}
+
+func TestLargeFileIsClosed(t *testing.T) {
+	buf := openLargeTestFile(strings.Join(largeFileTestLines(), "\n"), t)
+	closed := func(src *lazySource) bool {
+		_, err := src.f.Stat()
+		return err != nil
+	}
+	first := buf.largeSource
+	ioutil.WriteFile(buf.Filename, []byte(strings.Join(largeFileTestLines(), "\n")+"\n"), 0644)
+	if err := buf.revert(); err != nil {
+		t.Fatal(err)
+	}
+	if !closed(first) || buf.largeSource == nil || closed(buf.largeSource) {
+		t.Error("Expected reverting to close the old file and keep the new one open")
+	}
+	second := buf.largeSource
+	ioutil.WriteFile(buf.Filename, []byte("small\n"), 0644)
+	buf.revert()
+	if !closed(second) || buf.largeSource != nil || buf.LargeFile {
+		t.Error("Expected the file to be closed once it's no longer large")
+	}
+
+	buf = openLargeTestFile(strings.Join(largeFileTestLines(), "\n"), t)
+	src := buf.largeSource
+	namedBuffer("*other*")
+	for i, b := range Global.Buffers {
+		if b == buf {
+			killGivenBuffer(i)
+			break
+		}
+	}
+	if !closed(src) {
+		t.Error("Expected killing the buffer to close its file")
+	}
+}
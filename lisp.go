@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	glisp "github.com/glycerine/zygomys/zygo"
 	"github.com/uinta-labs/configdir"
@@ -402,6 +403,225 @@ func lispSetSyntaxOff(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.S
 	return glisp.SexpNull, nil
 }
 
+func lispSetBackupStyle(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpStr:
+		style, err := parseBackupStyle(string(t.S))
+		if err != nil {
+			return glisp.SexpNull, err
+		}
+		Global.BackupStyle = style
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be a string")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispSetUndoDir(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpStr:
+		dir := string(t.S)
+		if dir != "" {
+			var err error
+			dir, err = AbsPath(dir)
+			if err != nil {
+				return glisp.SexpNull, err
+			}
+		}
+		Global.UndoDir = dir
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be a string")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispKillRingPush(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpStr:
+		pushKill(string(t.S))
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be a string")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispKillRingGet(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	n := 0
+	if len(args) == 1 {
+		switch t := args[0].(type) {
+		case *glisp.SexpInt:
+			n = int(t.Val)
+		default:
+			return glisp.SexpNull, errors.New("Arg needs to be an int")
+		}
+	} else if len(args) != 0 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	if len(Global.KillRing) == 0 {
+		return glisp.SexpNull, errors.New("Kill ring is empty")
+	}
+	return &glisp.SexpStr{S: nthKill(n)}, nil
+}
+
+func lispKillRing(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	kills := []glisp.Sexp{}
+	for _, text := range Global.KillRing {
+		kills = append(kills, &glisp.SexpStr{S: text})
+	}
+	return glisp.MakeList(kills), nil
+}
+
+func lispSetKillRingMax(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpInt:
+		Global.KillRingMax = int(t.Val)
+		trimKillRing()
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be an int")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispSetClipboard(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpStr:
+		backend, err := getClipboardBackend(string(t.S))
+		if err != nil {
+			return glisp.SexpNull, err
+		}
+		Global.Clipboard = backend
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be a string")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispSetKeptBackups(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpInt:
+		Global.KeptBackups = int(t.Val)
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be an int")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispSetAutoSaveInterval(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpInt:
+		Global.AutoSaveInterval = int(t.Val)
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be an int")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispSetAutoSaveTimeout(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpInt:
+		Global.AutoSaveTimeout = int(t.Val)
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be an int")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispSetAutoRevertInterval(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpInt:
+		Global.AutoRevertInterval = int(t.Val)
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be an int")
+	}
+	return glisp.SexpNull, nil
+}
+
+func lispSetLargeFileThreshold(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpInt:
+		Global.LargeFileThreshold = t.Val
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be an int")
+	}
+	return glisp.SexpNull, nil
+}
+
+// (setformatter "python" "black -q -") formats buffers in python mode with
+// black; an empty command means they have no formatter.
+func lispSetFormatter(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 2 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	mode, ok := args[0].(*glisp.SexpStr)
+	if !ok {
+		return glisp.SexpNull, errors.New("Arg 1 needs to be a string")
+	}
+	command, ok := args[1].(*glisp.SexpStr)
+	if !ok {
+		return glisp.SexpNull, errors.New("Arg 2 needs to be a string")
+	}
+	argv := strings.Fields(string(command.S))
+	if len(argv) == 0 {
+		delete(formatters, string(mode.S))
+	} else {
+		formatters[string(mode.S)] = argv
+	}
+	return glisp.SexpNull, nil
+}
+
+// (setlspserver "go" "gopls") runs gopls for buffers in go mode; an empty
+// command turns the server for a mode off.
+func lispSetLspServer(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 2 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	mode, ok := args[0].(*glisp.SexpStr)
+	if !ok {
+		return glisp.SexpNull, errors.New("Arg 1 needs to be a string")
+	}
+	command, ok := args[1].(*glisp.SexpStr)
+	if !ok {
+		return glisp.SexpNull, errors.New("Arg 2 needs to be a string")
+	}
+	argv := strings.Fields(string(command.S))
+	if len(argv) == 0 {
+		delete(lspServers, string(mode.S))
+	} else {
+		lspServers[string(mode.S)] = argv
+	}
+	return glisp.SexpNull, nil
+}
+
 func lispGetTabStr(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
 	return &glisp.SexpStr{S: getTabString()}, nil
 }
@@ -589,6 +809,22 @@ func lispFilterBuffer(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.S
 	return glisp.SexpNull, nil
 }
 
+func lispSetCodingSystem(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	if len(args) != 1 {
+		return glisp.SexpNull, glisp.WrongNargs
+	}
+	switch t := args[0].(type) {
+	case *glisp.SexpStr:
+		return glisp.SexpNull, Global.CurrentB.setCodingSystem(string(t.S))
+	default:
+		return glisp.SexpNull, errors.New("Arg needs to be a string")
+	}
+}
+
+func lispGetCodingSystem(env *glisp.Zlisp, name string, args []glisp.Sexp) (glisp.Sexp, error) {
+	return &glisp.SexpStr{S: Global.CurrentB.codingSystemName()}, nil
+}
+
 func loadLispFunctions(env *glisp.Zlisp) {
 	env.AddFunction("emacsprint", lispPrint)
 	cmdAndLispFunc(env, "save-buffers-kill-emacs", "emacsquit", func() { saveBuffersKillEmacs(env) })
@@ -622,6 +858,22 @@ func loadLispFunctions(env *glisp.Zlisp) {
 	env.AddFunction("filterbuffer", lispFilterBuffer)
 	env.AddFunction("filterregion", lispFilterRegion)
 	env.AddFunction("shellcmd", lispRunExtCmd)
+	env.AddFunction("setcodingsystem", lispSetCodingSystem)
+	env.AddFunction("getcodingsystem", lispGetCodingSystem)
+	env.AddFunction("setbackupstyle", lispSetBackupStyle)
+	env.AddFunction("setkeptbackups", lispSetKeptBackups)
+	env.AddFunction("setautosaveinterval", lispSetAutoSaveInterval)
+	env.AddFunction("setautosavetimeout", lispSetAutoSaveTimeout)
+	env.AddFunction("setautorevertinterval", lispSetAutoRevertInterval)
+	env.AddFunction("setlargefilethreshold", lispSetLargeFileThreshold)
+	env.AddFunction("setundodir", lispSetUndoDir)
+	env.AddFunction("killringpush", lispKillRingPush)
+	env.AddFunction("killringget", lispKillRingGet)
+	env.AddFunction("killring", lispKillRing)
+	env.AddFunction("setkillringmax", lispSetKillRingMax)
+	env.AddFunction("setclipboard", lispSetClipboard)
+	env.AddFunction("setlspserver", lispSetLspServer)
+	env.AddFunction("setformatter", lispSetFormatter)
 	LoadDefaultCommands()
 }
 
@@ -665,17 +917,136 @@ func LoadDefaultConfig(env *glisp.Zlisp) {
 	_, err := env.EvalString(`
 (defmode "aggressive-fill-mode")
 (defmode "auto-fill-mode")
+(defmode "auto-revert-mode")
+(defmode "auto-save-mode")
+(defmode "backup-mode")
 (defmode "column-bytes-mode")
+(defmode "diff-gutter-mode")
 (defmode "dired-mode")
+(defmode "format-on-save-mode")
 (defmode "indent-mode")
 (defmode "line-number-mode")
 (defmode "no-self-insert-mode")
+(defmode "persistent-undo-mode")
+(defmode "read-only-mode")
 (defmode "terminal-title-mode")
 (defmode "tilde-mode")
 (defmode "toggle-mode")
 (defmode "xsel-jump-to-cursor-mode")
 
 (emacsbindkey "C-s" "isearch")
+(emacsbindkey "C-r" "isearch-backward")
+(emacsbindkey "C-M-s" "isearch-forward-regexp")
+(emacsbindkey "C-M-r" "isearch-backward-regexp")
+(emacsbindkey "M-s o" "occur")
+(bindkeymode "occur" "RET" "occur-mode-goto-occurrence")
+(bindkeymode "occur" "o" "occur-mode-goto-occurrence")
+(bindkeymode "occur" "n" "occur-next")
+(bindkeymode "occur" "p" "occur-prev")
+(bindkeymode "occur" "g" "occur-revert")
+(bindkeymode "occur" "e" "occur-edit-mode")
+(bindkeymode "occur" "q" "quit-window")
+(bindkeymode "occur-edit" "C-c C-c" "occur-cease-edit")
+(emacsbindkey "M-g n" "next-error")
+(emacsbindkey "M-g M-n" "next-error")
+(emacsbindkey "M-g p" "previous-error")
+(emacsbindkey "M-g M-p" "previous-error")
+(bindkeymode "grep" "RET" "compile-goto-error")
+(bindkeymode "grep" "n" "next-error-no-select")
+(bindkeymode "grep" "p" "previous-error-no-select")
+(bindkeymode "grep" "q" "quit-window")
+(bindkeymode "compilation" "RET" "compile-goto-error")
+(bindkeymode "compilation" "n" "next-error-no-select")
+(bindkeymode "compilation" "p" "previous-error-no-select")
+(bindkeymode "compilation" "g" "recompile")
+(bindkeymode "compilation" "q" "quit-window")
+(bindkeymode "compilation" "C-c C-k" "kill-compilation")
+(bindkeymode "shell" "RET" "comint-send-input")
+(bindkeymode "shell" "M-p" "comint-previous-input")
+(bindkeymode "shell" "M-n" "comint-next-input")
+(bindkeymode "shell" "C-c C-c" "comint-interrupt-subjob")
+(bindkeymode "shell" "C-c C-d" "comint-send-eof")
+(bindkeymode "shell" "C-a" "comint-bol")
+(bindkeymode "shell" "TAB" "comint-dynamic-complete-filename")
+(bindkeymode "term" "C-c C-k" "term-char-mode")
+(bindkeymode "term" "C-c C-j" "term-line-mode")
+(bindkeymode "term" "C-c C-c" "term-interrupt-subjob")
+(bindkeymode "dired-mode" "RET" "dired-find-file")
+(bindkeymode "dired-mode" "f" "dired-find-file")
+(bindkeymode "dired-mode" "e" "dired-find-file")
+(bindkeymode "dired-mode" "o" "dired-find-file-other-window")
+(bindkeymode "dired-mode" "^" "dired-up-directory")
+(bindkeymode "dired-mode" "n" "dired-next-line")
+(bindkeymode "dired-mode" "p" "dired-previous-line")
+(bindkeymode "dired-mode" "DOWN" "dired-next-line")
+(bindkeymode "dired-mode" "UP" "dired-previous-line")
+(bindkeymode "dired-mode" "m" "dired-mark")
+(bindkeymode "dired-mode" "u" "dired-unmark")
+(bindkeymode "dired-mode" "DEL" "dired-unmark-backward")
+(bindkeymode "dired-mode" "d" "dired-flag-file-deletion")
+(bindkeymode "dired-mode" "U" "dired-unmark-all-marks")
+(bindkeymode "dired-mode" "x" "dired-do-flagged-delete")
+(bindkeymode "dired-mode" "D" "dired-do-delete")
+(bindkeymode "dired-mode" "C" "dired-do-copy")
+(bindkeymode "dired-mode" "R" "dired-do-rename")
+(bindkeymode "dired-mode" "M" "dired-do-chmod")
+(bindkeymode "dired-mode" "+" "dired-create-directory")
+(bindkeymode "dired-mode" "s" "dired-sort-toggle")
+(bindkeymode "dired-mode" "." "dired-toggle-hidden")
+(bindkeymode "dired-mode" "g" "dired-revert")
+(bindkeymode "dired-mode" "q" "quit-window")
+(bindkeymode "dired-mode" "C-x C-q" "wdired")
+(bindkeymode "wdired" "C-c C-c" "wdired-finish-edit")
+(bindkeymode "wdired" "C-x C-s" "wdired-finish-edit")
+(bindkeymode "wdired" "C-c C-k" "wdired-abort-changes")
+(emacsbindkey "M-." "xref-find-definitions")
+(emacsbindkey "M-?" "xref-find-references")
+(emacsbindkey "M-," "pop-tag-mark")
+(emacsbindkey "M-TAB" "completion-at-point")
+(emacsbindkey "C-c l h" "lsp-describe-thing-at-point")
+(emacsbindkey "C-c l r" "lsp-rename")
+(emacsbindkey "C-c l d" "lsp-diagnostics")
+(bindkeymode "xref" "RET" "compile-goto-error")
+(bindkeymode "xref" "n" "next-error-no-select")
+(bindkeymode "xref" "p" "previous-error-no-select")
+(bindkeymode "xref" "q" "quit-window")
+(bindkeymode "diagnostics" "RET" "compile-goto-error")
+(bindkeymode "diagnostics" "n" "next-error-no-select")
+(bindkeymode "diagnostics" "p" "previous-error-no-select")
+(bindkeymode "diagnostics" "q" "quit-window")
+(emacsbindkey "C-c f" "format-buffer")
+(bindkeymode "format-errors" "RET" "compile-goto-error")
+(bindkeymode "format-errors" "n" "next-error-no-select")
+(bindkeymode "format-errors" "p" "previous-error-no-select")
+(bindkeymode "format-errors" "q" "quit-window")
+(emacsbindkey "C-x v =" "vc-diff")
+(emacsbindkey "C-x v g" "vc-annotate")
+(emacsbindkey "C-x v i" "vc-stage")
+(emacsbindkey "C-x v v" "vc-commit")
+(emacsbindkey "C-x v ]" "vc-next-hunk")
+(emacsbindkey "C-x v [" "vc-previous-hunk")
+(emacsbindkey "C-x v n" "vc-revert-hunk")
+(bindkeymode "diff" "RET" "diff-goto-source")
+(bindkeymode "diff" "n" "diff-hunk-next")
+(bindkeymode "diff" "p" "diff-hunk-prev")
+(bindkeymode "diff" "q" "quit-window")
+(bindkeymode "vc-annotate" "RET" "vc-annotate-goto-line")
+(bindkeymode "vc-annotate" "d" "vc-annotate-show-commit")
+(bindkeymode "vc-annotate" "q" "quit-window")
+(bindkeymode "vc-log" "C-c C-c" "vc-log-finish")
+(bindkeymode "vc-log" "C-c C-k" "vc-log-cancel")
+(setformatter "go" "gofmt")
+(setformatter "python" "black -q -")
+(setformatter "rust" "rustfmt")
+(setformatter "c" "clang-format --assume-filename=%f")
+(setformatter "c++" "clang-format --assume-filename=%f")
+(setformatter "javascript" "prettier --stdin-filepath %f")
+(setformatter "typescript" "prettier --stdin-filepath %f")
+(setformatter "css" "prettier --stdin-filepath %f")
+(setformatter "html" "prettier --stdin-filepath %f")
+(setformatter "json" "prettier --stdin-filepath %f")
+(setformatter "markdown" "prettier --stdin-filepath %f")
+(setformatter "yaml" "prettier --stdin-filepath %f")
 (emacsbindkey "C-x C-c" "save-buffers-kill-emacs")
 (emacsbindkey "C-x C-s" "save-buffer")
 (emacsbindkey "LEFT" "backward-char")
@@ -717,6 +1088,11 @@ func LoadDefaultConfig(env *glisp.Zlisp) {
 (emacsbindkey "C-w" "kill-region")
 (emacsbindkey "M-w" "copy-region")
 (emacsbindkey "C-y" "yank-region")
+(emacsbindkey "M-y" "yank-pop")
+(emacsbindkey "C-x m l" "mc-add-cursor-next-line")
+(emacsbindkey "C-x m n" "mc-mark-next-like-this")
+(emacsbindkey "C-x m a" "mc-mark-all-like-this")
+(emacsbindkey "C-x m q" "mc-remove-cursors")
 (emacsbindkey "M-f" "forward-word")
 (emacsbindkey "M-d" "kill-word")
 (emacsbindkey "M-b" "backward-word")
@@ -726,6 +1102,7 @@ func LoadDefaultConfig(env *glisp.Zlisp) {
 (emacsbindkey "C-x k" "kill-buffer")
 (emacsbindkey "C-k" "kill-line")
 (emacsbindkey "C-x C-_" "redo")
+(emacsbindkey "C-x u" "undo-tree-visualize")
 (emacsbindkey "C-z" "suspend-emacs")
 (emacsbindkey "C-h c" "describe-key-briefly")
 (emacsbindkey "M-x" "run-command")
@@ -796,6 +1173,8 @@ func LoadDefaultConfig(env *glisp.Zlisp) {
 (emacsbindkey "C-x 4 r" "rotate-windows")
 (emacsbindkey "C-x 4 s" "swap-windows")
 (emacsbindkey "M-^" "delete-indentation")
+(emacsbindkey "C-x RET f" "set-buffer-file-coding-system")
+(emacsbindkey "C-x C-q" "read-only-mode")
 `)
 	if err != nil {
 		fmt.Println(err.Error())
@@ -0,0 +1,1090 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+	termutil "github.com/japanoise/termbox-util"
+)
+
+// A client for language servers, which speak JSON-RPC over their standard
+// input and output. setlspserver says which server to run for a major mode;
+// one runs for each mode and project, and the buffers visiting the project's
+// files in that mode are kept in sync with it.
+
+var lspServers = map[string][]string{} // The command line to run, by major mode
+var lspClients = map[string]*lspClient{}
+
+// How long to wait for an answer from a server.
+var lspTimeout = 10 * time.Second
+
+// Files that mark the top of a project, looked for from a file's directory up.
+var lspRootMarkers = []string{"go.mod", "package.json", "tsconfig.json", "Cargo.toml", ".git"}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // In UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"` // 1 for errors, 2 for warnings, 3 and 4 for the rest
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspCompletionItem struct {
+	Label      string       `json:"label"`
+	Detail     string       `json:"detail"`
+	InsertText string       `json:"insertText"`
+	FilterText string       `json:"filterText"`
+	TextEdit   *lspTextEdit `json:"textEdit"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// A message either way; requests have an ID and a method, responses an ID and
+// a result or error, and notifications just a method.
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspClient struct {
+	mode, root string
+	cmd        *exec.Cmd
+	mu         sync.Mutex // Guards stdin, nextID and pending
+	stdin      io.WriteCloser
+	nextID     int
+	pending    map[int]chan *lspMessage // Nil once the server has gone
+	readDone   chan bool                // Closed when read returns
+	// The rest belong to the main goroutine
+	docs         map[string]*EditorBuffer   // The buffers open on the server, by URI
+	diagnostics  map[string][]lspDiagnostic // By URI
+	shuttingDown bool
+}
+
+// A buffer visiting a file the server knows about.
+type lspDocument struct {
+	client  *lspClient
+	uri     string
+	version int
+	text    string // What the server was last told is in the buffer
+}
+
+// Read a message, which comes after a Content-Length header.
+func readLspMessage(rd *bufio.Reader) (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if i := strings.IndexByte(line, ':'); 0 < i && strings.EqualFold(line[:i], "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("Message without a Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(rd, body); err != nil {
+		return nil, err
+	}
+	msg := &lspMessage{}
+	return msg, json.Unmarshal(body, msg)
+}
+
+func writeLspMessage(w io.Writer, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func fileURI(fn string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(fn)}).String()
+}
+
+// The file a URI names, or "" if it isn't a file: URI.
+func uriFile(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// The nearest directory from dir up with one of lspRootMarkers in it, or dir.
+func lspProjectRoot(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, marker := range lspRootMarkers {
+			if _, err := os.Stat(filepath.Join(d, marker)); err == nil {
+				return d
+			}
+		}
+		if d == filepath.Dir(d) {
+			return dir
+		}
+	}
+}
+
+// Start the server and go through the initialize handshake with it.
+func startLspClient(mode, root string, argv []string) (*lspClient, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = root
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Couldn't start the %s language server: %s", mode, err.Error())
+	}
+	c := &lspClient{mode: mode, root: root, cmd: cmd, stdin: stdin,
+		pending: map[int]chan *lspMessage{}, readDone: make(chan bool),
+		docs: map[string]*EditorBuffer{}, diagnostics: map[string][]lspDiagnostic{}}
+	go c.read(bufio.NewReader(stdout))
+	_, err = c.request("initialize", map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI(root),
+		"rootPath":  root,
+		"workspaceFolders": []interface{}{
+			map[string]string{"uri": fileURI(root), "name": filepath.Base(root)},
+		},
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization":    map[string]interface{}{"didSave": true},
+				"hover":              map[string]interface{}{"contentFormat": []string{"plaintext", "markdown"}},
+				"completion":         map[string]interface{}{"completionItem": map[string]bool{"snippetSupport": false}},
+				"definition":         map[string]bool{"linkSupport": true},
+				"references":         map[string]interface{}{},
+				"rename":             map[string]interface{}{},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+			"workspace": map[string]interface{}{
+				"workspaceEdit":    map[string]bool{"documentChanges": true},
+				"configuration":    true,
+				"workspaceFolders": true,
+			},
+		},
+	})
+	if err == nil {
+		err = c.notify("initialized", map[string]interface{}{})
+	}
+	if err != nil {
+		c.shuttingDown = true
+		stdin.Close()
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("Couldn't start the %s language server: %s", mode, err.Error())
+	}
+	return c, nil
+}
+
+// Handle what the server sends until it goes away.
+func (c *lspClient) read(rd *bufio.Reader) {
+	defer close(c.readDone)
+	for {
+		msg, err := readLspMessage(rd)
+		if err != nil {
+			break
+		}
+		c.dispatch(msg)
+	}
+	c.mu.Lock()
+	for _, ch := range c.pending {
+		close(ch)
+	}
+	c.pending = nil
+	c.mu.Unlock()
+	c.cmd.Wait()
+	runOnMain(c.finish)
+}
+
+func (c *lspClient) dispatch(msg *lspMessage) {
+	switch {
+	case msg.Method == "":
+		var id int
+		if json.Unmarshal(msg.ID, &id) != nil {
+			return
+		}
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- msg
+		}
+	case len(msg.ID) != 0:
+		// We don't do anything the server asks, but have to answer
+		var result interface{}
+		if msg.Method == "workspace/configuration" {
+			var params struct {
+				Items []json.RawMessage `json:"items"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			result = make([]interface{}, len(params.Items))
+		}
+		c.mu.Lock()
+		writeLspMessage(c.stdin, map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+		c.mu.Unlock()
+	case msg.Method == "textDocument/publishDiagnostics":
+		var params struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			runOnMain(func() { c.setDiagnostics(params.URI, params.Diagnostics) })
+		}
+	case msg.Method == "window/showMessage":
+		var params struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			runOnMain(func() {
+				Global.Input = c.mode + " language server: " + params.Message
+				if params.Type == 1 {
+					AddErrorMessage(Global.Input)
+				}
+			})
+		}
+	}
+}
+
+// Send a request and wait for the answer.
+func (c *lspClient) request(method string, params interface{}) (json.RawMessage, error) {
+	return c.requestWithin(method, params, lspTimeout)
+}
+
+func (c *lspClient) requestWithin(method string, params interface{}, timeout time.Duration) (json.RawMessage, error) {
+	c.mu.Lock()
+	if c.pending == nil {
+		c.mu.Unlock()
+		return nil, errors.New("The " + c.mode + " language server isn't running")
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *lspMessage, 1)
+	c.pending[id] = ch
+	err := writeLspMessage(c.stdin, map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return nil, errors.New("The " + c.mode + " language server exited")
+		} else if msg.Error != nil {
+			return nil, errors.New(msg.Error.Message)
+		}
+		return msg.Result, nil
+	case <-time.After(timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("The %s language server didn't answer %s in time", c.mode, method)
+	}
+}
+
+func (c *lspClient) notify(method string, params interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeLspMessage(c.stdin, map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// Forget the server once it has gone.
+func (c *lspClient) finish() {
+	for key, other := range lspClients {
+		if other == c {
+			delete(lspClients, key)
+		}
+	}
+	for _, buf := range c.docs {
+		if buf.lsp != nil && buf.lsp.client == c {
+			buf.lsp = nil
+		}
+	}
+	c.docs = map[string]*EditorBuffer{}
+	c.diagnostics = map[string][]lspDiagnostic{}
+	if !c.shuttingDown {
+		Global.Input = "The " + c.mode + " language server exited"
+		AddErrorMessage(Global.Input)
+	}
+}
+
+// Ask the server to exit, killing it if it won't.
+func (c *lspClient) shutdown() {
+	c.shuttingDown = true
+	if _, err := c.requestWithin("shutdown", nil, time.Second); err == nil {
+		c.notify("exit", nil)
+	}
+	c.mu.Lock()
+	c.stdin.Close()
+	c.mu.Unlock()
+	select {
+	case <-c.readDone:
+	case <-time.After(time.Second):
+		c.cmd.Process.Kill()
+		<-c.readDone
+	}
+}
+
+func lspShutdownAll() {
+	for _, c := range lspClients {
+		c.shutdown()
+	}
+}
+
+func (c *lspClient) setDiagnostics(uri string, diags []lspDiagnostic) {
+	if len(diags) == 0 {
+		delete(c.diagnostics, uri)
+		return
+	}
+	for i := range diags {
+		if diags[i].Severity == 0 {
+			diags[i].Severity = 1
+		}
+	}
+	c.diagnostics[uri] = diags
+}
+
+// The buffer's text as the server sees it: lines end with newlines, whatever
+// the file has in it.
+func lspText(buf *EditorBuffer) string {
+	var sb strings.Builder
+	buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+		sb.WriteString(row.Data)
+		if i < buf.NumRows()-1 || !buf.NoFinalNewline {
+			sb.WriteByte('\n')
+		}
+		return true
+	})
+	return sb.String()
+}
+
+// Open buf on the server for its major mode, starting the server if need be.
+// Buffers in modes that have no server, and large files, are left alone.
+func lspAttach(buf *EditorBuffer) error {
+	if buf.Filename == "" || buf.LargeFile {
+		return nil
+	}
+	uri := fileURI(buf.Filename)
+	if d := buf.lsp; d != nil {
+		if d.uri == uri && d.client.mode == buf.MajorMode {
+			return nil
+		}
+		lspDetach(buf)
+	}
+	argv := lspServers[buf.MajorMode]
+	if len(argv) == 0 {
+		return nil
+	}
+	root := lspProjectRoot(filepath.Dir(buf.Filename))
+	key := buf.MajorMode + "\x00" + root
+	c := lspClients[key]
+	if c == nil {
+		var err error
+		c, err = startLspClient(buf.MajorMode, root, argv)
+		if err != nil {
+			return err
+		}
+		lspClients[key] = c
+	}
+	d := &lspDocument{client: c, uri: uri, version: 1, text: lspText(buf)}
+	buf.lsp = d
+	c.docs[uri] = buf
+	return c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": uri, "languageId": buf.MajorMode, "version": d.version, "text": d.text,
+		},
+	})
+}
+
+// Attach a buffer that has just been visited, saying so if the server fails.
+func lspVisited(buf *EditorBuffer) {
+	if err := lspAttach(buf); err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+	}
+}
+
+// Tell the server the buffer has been saved; it may be visiting a new file.
+func lspSaved(buf *EditorBuffer) {
+	lspVisited(buf)
+	if d := buf.lsp; d != nil {
+		d.sync(buf)
+		d.client.notify("textDocument/didSave", map[string]interface{}{
+			"textDocument": map[string]string{"uri": d.uri},
+		})
+	}
+}
+
+// Close buf on its server, e.g. because it's being killed.
+func lspDetach(buf *EditorBuffer) {
+	d := buf.lsp
+	if d == nil {
+		return
+	}
+	buf.lsp = nil
+	delete(d.client.docs, d.uri)
+	delete(d.client.diagnostics, d.uri)
+	d.client.notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]string{"uri": d.uri},
+	})
+}
+
+// Tell the server what's in the buffer, if it has changed since it was last
+// told.
+func (d *lspDocument) sync(buf *EditorBuffer) {
+	text := lspText(buf)
+	if text == d.text {
+		return
+	}
+	d.version++
+	d.text = text
+	d.client.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": d.uri, "version": d.version},
+		"contentChanges": []interface{}{map[string]string{"text": text}},
+	})
+}
+
+// A periodic job, so that the servers can keep their diagnostics up to date.
+func lspSyncBuffers() bool {
+	for _, buf := range Global.Buffers {
+		if buf.lsp != nil {
+			buf.lsp.sync(buf)
+		}
+	}
+	return false
+}
+
+func (buf *EditorBuffer) lspDiagnostics() []lspDiagnostic {
+	if buf.lsp == nil {
+		return nil
+	}
+	return buf.lsp.client.diagnostics[buf.lsp.uri]
+}
+
+// What the status line says about the buffer's server, e.g. " [LSP E:2 W:1]"
+// for two errors and a warning.
+func (d *lspDocument) status(buf *EditorBuffer) string {
+	errs, warnings := 0, 0
+	for _, diag := range buf.lspDiagnostics() {
+		switch diag.Severity {
+		case 1:
+			errs++
+		case 2:
+			warnings++
+		}
+	}
+	ret := " [LSP"
+	if 0 < errs {
+		ret += fmt.Sprintf(" E:%d", errs)
+	}
+	if 0 < warnings {
+		ret += fmt.Sprintf(" W:%d", warnings)
+	}
+	return ret + "]"
+}
+
+// The number of UTF-16 code units s takes up, which is how servers count
+// columns.
+func utf16Len(s string) int {
+	n := 0
+	for _, ru := range s {
+		if 0x10000 <= ru {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// The byte index in s of UTF-16 column col.
+func utf16ByteIndex(s string, col int) int {
+	n := 0
+	for i, ru := range s {
+		if col <= n {
+			return i
+		}
+		if 0x10000 <= ru {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return len(s)
+}
+
+func (buf *EditorBuffer) lspPosition(cx, cy int) lspPosition {
+	if buf.NumRows() <= cy {
+		return lspPosition{cy, 0}
+	}
+	data := buf.Row(cy).Data
+	if len(data) < cx {
+		cx = len(data)
+	}
+	return lspPosition{cy, utf16Len(data[:cx])}
+}
+
+// Where a position is in the buffer. Positions past the last row, which are
+// after the final newline, are at the end of it.
+func (buf *EditorBuffer) fromLspPosition(p lspPosition) (cx, cy int) {
+	if buf.NumRows() == 0 {
+		return 0, 0
+	}
+	if buf.NumRows() <= p.Line {
+		cy = buf.NumRows() - 1
+		return buf.Row(cy).Size, cy
+	}
+	if p.Line < 0 {
+		return 0, 0
+	}
+	return utf16ByteIndex(buf.Row(p.Line).Data, p.Character), p.Line
+}
+
+// The document and position parameters most requests take, for the cursor in
+// the current buffer, which is synced first. Nil if it has no server.
+func lspPointParams() map[string]interface{} {
+	buf := Global.CurrentB
+	d := buf.lsp
+	if d == nil {
+		if len(lspServers[buf.MajorMode]) == 0 {
+			Global.Input = "No language server for " + buf.MajorMode + " mode; see setlspserver"
+		} else {
+			Global.Input = "No language server for this buffer; try M-x lsp"
+		}
+		return nil
+	}
+	d.sync(buf)
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": d.uri},
+		"position":     buf.lspPosition(buf.cx, buf.cy),
+	}
+}
+
+// Replace the text between two places in buf, with undo. buf has to be the
+// current buffer.
+func replaceText(buf *EditorBuffer, startc, endc, startl, endl int, text string) {
+	if startc != endc || startl != endl {
+		killed := bufKillRegion(buf, startc, endc, startl, endl)
+		editorAddRegionUndo(false, startc, endc, startl, endl, killed)
+	}
+	if text != "" {
+		cx, cy := spitRegion(startc, startl, text)
+		editorAddRegionUndo(true, cx, buf.cx, cy, buf.cy, text)
+	}
+	buf.Dirty = true
+}
+
+// Make edits, which don't overlap and are all against the text as it is now,
+// to buf. They're made back to front so that each leaves the places the rest
+// refer to alone. Edits that start in the same place go in in the order
+// they're given, so the last of them is made first.
+func applyTextEdits(buf *EditorBuffer, given []lspTextEdit) {
+	edits := make([]lspTextEdit, len(given))
+	for i, e := range given {
+		edits[len(given)-1-i] = e
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		a, b := edits[i].Range.Start, edits[j].Range.Start
+		return a.Line > b.Line || (a.Line == b.Line && a.Character > b.Character)
+	})
+	old := Global.CurrentB
+	Global.CurrentB = buf
+	defer func() { Global.CurrentB = old }()
+	cx, cy := buf.cx, buf.cy
+	for _, e := range edits {
+		startc, startl := buf.fromLspPosition(e.Range.Start)
+		endc, endl := buf.fromLspPosition(e.Range.End)
+		text := e.NewText
+		if buf.NumRows() <= e.Range.End.Line {
+			// The edit takes in the final newline, which the rows leave out
+			text = strings.TrimSuffix(text, "\n")
+		}
+		if buf.NumRows() == 0 {
+			buf.SetRows([]*EditorRow{&EditorRow{}})
+		}
+		replaceText(buf, startc, endc, startl, endl, text)
+	}
+	if buf.NumRows() <= cy {
+		cy = buf.NumRows() - 1
+	}
+	if cy < 0 {
+		cy = 0
+	} else if buf.Row(cy).Size < cx {
+		cx = buf.Row(cy).Size
+	}
+	buf.cx, buf.cy, buf.prefcx = cx, cy, cx
+}
+
+// Make the edits in a WorkspaceEdit, visiting the files they're in. Returns
+// the number of files changed.
+func applyWorkspaceEdit(raw json.RawMessage, env *glisp.Zlisp) (int, error) {
+	var edit struct {
+		Changes         map[string][]lspTextEdit `json:"changes"`
+		DocumentChanges []struct {
+			Kind         string `json:"kind"`
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Edits []lspTextEdit `json:"edits"`
+		} `json:"documentChanges"`
+	}
+	if err := json.Unmarshal(raw, &edit); err != nil {
+		return 0, err
+	}
+	changes := edit.Changes
+	if edit.DocumentChanges != nil {
+		changes = map[string][]lspTextEdit{}
+		for _, dc := range edit.DocumentChanges {
+			if dc.Kind != "" {
+				return 0, errors.New("The edit creates, renames or deletes files, which isn't supported")
+			}
+			changes[dc.TextDocument.URI] = append(changes[dc.TextDocument.URI], dc.Edits...)
+		}
+	}
+	uris := []string{}
+	for uri := range changes {
+		if uriFile(uri) == "" {
+			return 0, errors.New("Can't edit " + uri)
+		}
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		buf := findFileNoSelect(uriFile(uri), env)
+		if buf == nil {
+			return 0, errors.New("Couldn't visit " + uriFile(uri))
+		}
+		applyTextEdits(buf, changes[uri])
+	}
+	return len(uris), nil
+}
+
+// The word the cursor is in or just after.
+func symbolAtPoint() string {
+	buf := Global.CurrentB
+	if buf.NumRows() <= buf.cy {
+		return ""
+	}
+	data := buf.Row(buf.cy).Data
+	start, end := buf.cx, buf.cx
+	for 0 < start {
+		ru, size := utf8.DecodeLastRuneInString(data[:start])
+		if !termutil.WordCharacter(ru) {
+			break
+		}
+		start -= size
+	}
+	for _, ru := range data[end:] {
+		if !termutil.WordCharacter(ru) {
+			break
+		}
+		end += len(string(ru))
+	}
+	return data[start:end]
+}
+
+// Hover text as one line for the prompt, leaving out markdown code fences.
+func hoverText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var markup struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(raw, &markup) == nil && markup.Value != "" {
+		lines := []string{}
+		for _, line := range strings.Split(markup.Value, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "```") {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, " ")
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		parts := []string{}
+		for _, part := range list {
+			if text := hoverText(part); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+// Show what the server says about the thing at the cursor.
+func lspDescribeThingAtPoint() {
+	params := lspPointParams()
+	if params == nil {
+		return
+	}
+	res, err := Global.CurrentB.lsp.client.request("textDocument/hover", params)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	var hover struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if json.Unmarshal(res, &hover) != nil || hoverText(hover.Contents) == "" {
+		Global.Input = "No information here"
+		return
+	}
+	Global.Input = hoverText(hover.Contents)
+}
+
+// Locations, in any of the shapes definition and references answers come in.
+func parseLocations(raw json.RawMessage) []lspLocation {
+	var one lspLocation
+	if json.Unmarshal(raw, &one) == nil && one.URI != "" {
+		return []lspLocation{one}
+	}
+	var many []struct {
+		lspLocation
+		TargetURI            string   `json:"targetUri"`
+		TargetSelectionRange lspRange `json:"targetSelectionRange"`
+	}
+	json.Unmarshal(raw, &many)
+	ret := []lspLocation{}
+	for _, l := range many {
+		if l.TargetURI != "" {
+			ret = append(ret, lspLocation{l.TargetURI, l.TargetSelectionRange})
+		} else if l.URI != "" {
+			ret = append(ret, l.lspLocation)
+		}
+	}
+	return ret
+}
+
+// Ask the current buffer's server for a list of places about the thing at the
+// cursor.
+func lspLocations(method string, extra map[string]interface{}) ([]lspLocation, error) {
+	params := lspPointParams()
+	if params == nil {
+		return nil, errors.New(Global.Input)
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	res, err := Global.CurrentB.lsp.client.request(method, params)
+	if err != nil {
+		return nil, err
+	}
+	return parseLocations(res), nil
+}
+
+// The line a location is on, from the buffer visiting its file if there is
+// one, and the byte index of its start in it.
+func locationLine(loc lspLocation) (string, int) {
+	fn := uriFile(loc.URI)
+	line := ""
+	found := false
+	for _, buf := range Global.Buffers {
+		if buf.Filename == fn {
+			if loc.Range.Start.Line < buf.NumRows() {
+				line = buf.Row(loc.Range.Start.Line).Data
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		if data, err := ioutil.ReadFile(fn); err == nil {
+			lines := strings.Split(string(data), "\n")
+			if loc.Range.Start.Line < len(lines) {
+				line = strings.TrimSuffix(lines[loc.Range.Start.Line], "\r")
+			}
+		}
+	}
+	return line, utf16ByteIndex(line, loc.Range.Start.Character)
+}
+
+// Lines for an error list of the locations, with file names relative to dir.
+func locationLines(locs []lspLocation, dir string) []string {
+	ret := []string{}
+	for _, loc := range locs {
+		fn := uriFile(loc.URI)
+		if rel, err := filepath.Rel(dir, fn); err == nil && !strings.HasPrefix(rel, "..") {
+			fn = rel
+		}
+		line, col := locationLine(loc)
+		ret = append(ret, fmt.Sprintf("%s:%d:%d: %s", filepath.ToSlash(fn), loc.Range.Start.Line+1, col+1,
+			strings.TrimSpace(line)))
+	}
+	return ret
+}
+
+// Ask for a new name for the thing at the cursor, and have the server rename
+// it everywhere.
+func lspRename(env *glisp.Zlisp) {
+	if lspPointParams() == nil {
+		return
+	}
+	old := symbolAtPoint()
+	name := editorPrompt("Rename "+old+" to", nil)
+	if name == "" {
+		Global.Input = "Cancelled"
+		return
+	}
+	lspRenameTo(name, env)
+}
+
+func lspRenameTo(name string, env *glisp.Zlisp) {
+	params := lspPointParams()
+	if params == nil {
+		return
+	}
+	params["newName"] = name
+	res, err := Global.CurrentB.lsp.client.request("textDocument/rename", params)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	if string(res) == "null" || len(res) == 0 {
+		Global.Input = "Nothing to rename here"
+		return
+	}
+	n, err := applyWorkspaceEdit(res, env)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+		return
+	}
+	if n == 1 {
+		Global.Input = "Renamed in 1 file"
+	} else {
+		Global.Input = fmt.Sprintf("Renamed in %d files", n)
+	}
+}
+
+// The completions the server offers at the cursor, and where the word being
+// completed starts.
+func lspCompletions() (int, []lspCompletionItem, error) {
+	params := lspPointParams()
+	if params == nil {
+		return 0, nil, errors.New(Global.Input)
+	}
+	res, err := Global.CurrentB.lsp.client.request("textDocument/completion", params)
+	if err != nil {
+		return 0, nil, err
+	}
+	var items []lspCompletionItem
+	var list struct {
+		Items []lspCompletionItem `json:"items"`
+	}
+	if json.Unmarshal(res, &list) == nil && list.Items != nil {
+		items = list.Items
+	} else {
+		json.Unmarshal(res, &items)
+	}
+	buf := Global.CurrentB
+	data := buf.Row(buf.cy).Data
+	start := buf.cx
+	for 0 < start {
+		ru, size := utf8.DecodeLastRuneInString(data[:start])
+		if !termutil.WordCharacter(ru) {
+			break
+		}
+		start -= size
+	}
+	typed := data[start:buf.cx]
+	prefix := strings.ToLower(typed)
+	ret := []lspCompletionItem{}
+	for _, item := range items {
+		filter := item.FilterText
+		if filter == "" {
+			filter = item.Label
+		}
+		// Leave out what would just put back what's been typed
+		if item.TextEdit == nil && item.InsertText == "" && item.Label == typed {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(filter), prefix) {
+			ret = append(ret, item)
+		}
+	}
+	return start, ret, nil
+}
+
+// Put a completion in place of the word being completed, which starts at
+// start.
+func insertCompletion(item lspCompletionItem, start int) {
+	buf := Global.CurrentB
+	if e := item.TextEdit; e != nil {
+		startc, startl := buf.fromLspPosition(e.Range.Start)
+		endc, endl := buf.fromLspPosition(e.Range.End)
+		if endl == buf.cy && endc < buf.cx {
+			endc = buf.cx
+		}
+		replaceText(buf, startc, endc, startl, endl, e.NewText)
+		return
+	}
+	text := item.InsertText
+	if text == "" {
+		text = item.Label
+	}
+	replaceText(buf, start, buf.cx, buf.cy, buf.cy, text)
+}
+
+// Complete the word at the cursor with what the server suggests, letting the
+// user choose if there's more than one.
+func completionAtPoint() {
+	buf := Global.CurrentB
+	if buf.NumRows() <= buf.cy {
+		return
+	}
+	start, items, err := lspCompletions()
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	switch len(items) {
+	case 0:
+		Global.Input = "No completions"
+	case 1:
+		insertCompletion(items[0], start)
+	default:
+		choices := make([]string, len(items))
+		for i, item := range items {
+			choices[i] = item.Label
+			if item.Detail != "" {
+				choices[i] += "  " + item.Detail
+			}
+		}
+		i := editorChoiceIndex("Complete", choices, -1)
+		if 0 <= i && i < len(items) {
+			insertCompletion(items[i], start)
+		}
+	}
+}
+
+// List every diagnostic the current buffer's server has sent, for files in
+// its project.
+func lspListDiagnostics() {
+	d := Global.CurrentB.lsp
+	if d == nil {
+		lspPointParams()
+		return
+	}
+	c := d.client
+	d.sync(Global.CurrentB)
+	uris := []string{}
+	for uri := range c.diagnostics {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	lines := []string{}
+	severities := []string{"error", "error", "warning", "info", "hint"}
+	for _, uri := range uris {
+		for _, diag := range c.diagnostics[uri] {
+			loc := lspLocation{uri, diag.Range}
+			line := locationLines([]lspLocation{loc}, c.root)[0]
+			line = line[:strings.Index(line, ": ")+2]
+			sev := "error"
+			if 0 <= diag.Severity && diag.Severity < len(severities) {
+				sev = severities[diag.Severity]
+			}
+			msg := strings.SplitN(diag.Message, "\n", 2)[0]
+			lines = append(lines, line+sev+": "+msg)
+		}
+	}
+	if len(lines) == 0 {
+		Global.Input = "No diagnostics"
+		return
+	}
+	buf := namedBuffer("*diagnostics*")
+	fillErrorList(buf, &errorList{c.root, nil, -1}, "diagnostics", lines)
+	showBufferOtherWindow(buf)
+	Global.Input = fmt.Sprintf("%d diagnostics", len(lines))
+}
+
+// Start the language server for the current buffer by hand, e.g. after
+// setting one up.
+func lspCommand() {
+	buf := Global.CurrentB
+	if buf.Filename == "" {
+		Global.Input = "The buffer isn't visiting a file"
+		return
+	}
+	if len(lspServers[buf.MajorMode]) == 0 {
+		Global.Input = "No language server for " + buf.MajorMode + " mode; see setlspserver"
+		return
+	}
+	if err := lspAttach(buf); err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+		return
+	}
+	Global.Input = "Connected to the " + buf.MajorMode + " language server in " + buf.lsp.client.root
+}
+
+// Shut down the current buffer's language server.
+func lspShutdownCommand() {
+	d := Global.CurrentB.lsp
+	if d == nil {
+		Global.Input = "No language server for this buffer"
+		return
+	}
+	for _, buf := range d.client.docs {
+		buf.lsp = nil
+	}
+	d.client.shutdown()
+	Global.Input = "Shut down the " + d.client.mode + " language server"
+}
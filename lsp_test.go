@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run as a stub language server or formatter when the tests start one.
+func TestMain(m *testing.M) {
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "-gomacs-lsp-stub":
+			stubLspServer(os.Stdin, os.Stdout)
+			os.Exit(0)
+		case "-gomacs-format-stub":
+			os.Exit(stubFormatter(os.Stdin, os.Stdout, os.Stderr))
+		}
+	}
+	// There's no terminal to wake up when other goroutines hand over work
+	wakeMain = func() {}
+	os.Exit(m.Run())
+}
+
+var stubWordRe = regexp.MustCompile(`\w+`)
+
+// A language server that knows just enough: BAD is an error and TODO a
+// warning, functions are defined by "func name", and every word completes.
+func stubLspServer(in io.Reader, out io.Writer) {
+	rd := bufio.NewReader(in)
+	docs := map[string]string{}
+	lines := func(uri string) []string { return strings.Split(docs[uri], "\n") }
+	// The word at a position, and the range of every whole occurrence of it
+	wordAt := func(uri string, p lspPosition) (string, []lspRange) {
+		ls := lines(uri)
+		if len(ls) <= p.Line {
+			return "", nil
+		}
+		col := utf16ByteIndex(ls[p.Line], p.Character)
+		word := ""
+		for _, m := range stubWordRe.FindAllStringIndex(ls[p.Line], -1) {
+			if m[0] <= col && col <= m[1] {
+				word = ls[p.Line][m[0]:m[1]]
+			}
+		}
+		ranges := []lspRange{}
+		for i, line := range ls {
+			for _, m := range stubWordRe.FindAllStringIndex(line, -1) {
+				if word != "" && line[m[0]:m[1]] == word {
+					ranges = append(ranges, lspRange{lspPosition{i, utf16Len(line[:m[0]])},
+						lspPosition{i, utf16Len(line[:m[1]])}})
+				}
+			}
+		}
+		return word, ranges
+	}
+	publish := func(uri string) {
+		diags := []lspDiagnostic{}
+		for i, line := range lines(uri) {
+			for word, sev := range map[string]int{"BAD": 1, "TODO": 2} {
+				if j := strings.Index(line, word); 0 <= j {
+					p := lspPosition{i, utf16Len(line[:j])}
+					diags = append(diags, lspDiagnostic{lspRange{p, p}, sev, word + " here"})
+				}
+			}
+		}
+		writeLspMessage(out, map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/publishDiagnostics",
+			"params": map[string]interface{}{"uri": uri, "diagnostics": diags}})
+	}
+	for {
+		msg, err := readLspMessage(rd)
+		if err != nil {
+			return
+		}
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+			Position lspPosition `json:"position"`
+			NewName  string      `json:"newName"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		uri := params.TextDocument.URI
+		var result interface{}
+		switch msg.Method {
+		case "initialize":
+			result = map[string]interface{}{"capabilities": map[string]interface{}{"textDocumentSync": 1}}
+		case "textDocument/didOpen":
+			docs[uri] = params.TextDocument.Text
+			publish(uri)
+		case "textDocument/didChange":
+			docs[uri] = params.ContentChanges[len(params.ContentChanges)-1].Text
+			publish(uri)
+		case "textDocument/definition":
+			word, ranges := wordAt(uri, params.Position)
+			locs := []lspLocation{}
+			for _, r := range ranges {
+				if strings.HasSuffix(lines(uri)[r.Start.Line][:utf16ByteIndex(lines(uri)[r.Start.Line], r.Start.Character)], "func ") {
+					locs = append(locs, lspLocation{uri, r})
+				}
+			}
+			if word != "" {
+				result = locs
+			}
+		case "textDocument/references":
+			locs := []lspLocation{}
+			_, ranges := wordAt(uri, params.Position)
+			for _, r := range ranges {
+				locs = append(locs, lspLocation{uri, r})
+			}
+			result = locs
+		case "textDocument/hover":
+			if word, _ := wordAt(uri, params.Position); word != "" {
+				result = map[string]interface{}{"contents": map[string]string{
+					"kind": "markdown", "value": "```go\nfunc " + word + "()\n```"}}
+			}
+		case "textDocument/rename":
+			_, ranges := wordAt(uri, params.Position)
+			edits := []lspTextEdit{}
+			for _, r := range ranges {
+				edits = append(edits, lspTextEdit{r, params.NewName})
+			}
+			result = map[string]interface{}{"changes": map[string][]lspTextEdit{uri: edits}}
+		case "textDocument/completion":
+			items := []lspCompletionItem{}
+			seen := map[string]bool{}
+			for _, word := range stubWordRe.FindAllString(docs[uri], -1) {
+				if !seen[word] {
+					seen[word] = true
+					items = append(items, lspCompletionItem{Label: word, Detail: "word"})
+				}
+			}
+			result = map[string]interface{}{"isIncomplete": false, "items": items}
+		case "exit":
+			return
+		}
+		if len(msg.ID) != 0 {
+			writeLspMessage(out, map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+		}
+	}
+}
+
+// Visit a Go file in a new project, with the stub server running for it.
+func visitWithStubServer(t *testing.T, text string) (*EditorBuffer, string) {
+	InitEditor()
+	if defs == nil {
+		LoadSyntaxDefs()
+	}
+	lspServers["go"] = []string{os.Args[0], "-gomacs-lsp-stub"}
+	dir, err := ioutil.TempDir("", "gomacs-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ = filepath.EvalSymlinks(dir)
+	writeTree(t, dir, map[string]string{"go.mod": "module stub\n", "sub/main.go": text})
+	openFile(filepath.Join(dir, "sub", "main.go"), nil)
+	buf := Global.CurrentB
+	if buf.MajorMode != "go" || buf.lsp == nil {
+		t.Fatalf("Expected the stub server to be running, got %q", Global.Input)
+	}
+	if buf.lsp.client.root != dir {
+		t.Errorf("Expected the project to be %s, got %s", dir, buf.lsp.client.root)
+	}
+	return buf, dir
+}
+
+func stopStubServers(dir string) {
+	lspShutdownAll()
+	runQueuedJobs()
+	delete(lspServers, "go")
+	os.RemoveAll(dir)
+}
+
+// Wait until the server has sent the buffer n diagnostics.
+func waitForDiagnostics(t *testing.T, buf *EditorBuffer, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(buf.lspDiagnostics()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d diagnostics, got %v", n, buf.lspDiagnostics())
+		}
+		time.Sleep(10 * time.Millisecond)
+		runQueuedJobs()
+	}
+}
+
+func TestLspDiagnostics(t *testing.T) {
+	buf, dir := visitWithStubServer(t, "package main\n\nfunc main() {\n\tBAD()\n\t// TODO\n}\n")
+	defer stopStubServers(dir)
+	waitForDiagnostics(t, buf, 2)
+	if ru, _ := buf.gutterMark(3); ru != 'E' {
+		t.Errorf("Expected an error mark, got %q", ru)
+	}
+	if ru, _ := buf.gutterMark(4); ru != 'W' {
+		t.Errorf("Expected a warning mark, got %q", ru)
+	}
+	if gutterWidth(buf) != 2 {
+		t.Error("Expected a gutter for the marks")
+	}
+	if status := editorUpdateStatus(buf); !strings.Contains(status, " [LSP E:1 W:1]") {
+		t.Errorf("Expected the counts in the status line, got %q", status)
+	}
+
+	Global.CurrentB = buf
+	replaceText(buf, 1, 4, 3, 3, "good")
+	lspSyncBuffers()
+	waitForDiagnostics(t, buf, 1)
+	if buf.lsp.version != 2 || !strings.Contains(editorUpdateStatus(buf), " [LSP W:1]") {
+		t.Error("Expected the change to be sent and the error to go away")
+	}
+
+	lspListDiagnostics()
+	list := Global.CurrentB
+	list.FailIfBufferNe([]string{"sub/main.go:5:5: warning: TODO here"}, t)
+	nextError(nil, 1, false)
+	if Global.CurrentB != buf || buf.cy != 4 || buf.cx != 4 {
+		t.Errorf("Expected next-error to go to the warning, got %d:%d", buf.cy, buf.cx)
+	}
+}
+
+func TestLspNavigation(t *testing.T) {
+	buf, dir := visitWithStubServer(t, "package main\n\nfunc helper() {}\n\nfunc main() {\n\thelper()\n\thelper()\n}\n")
+	defer stopStubServers(dir)
+	buf.cy, buf.cx = 5, 3
+	xrefFindDefinitions(nil)
+	if Global.CurrentB != buf || buf.cy != 2 || buf.cx != 5 {
+		t.Errorf("Expected to be at helper's definition, got %d:%d", buf.cy, buf.cx)
+	}
+	xrefGoBack()
+	if buf.cy != 5 || buf.cx != 3 {
+		t.Errorf("Expected M-, to go back, got %d:%d", buf.cy, buf.cx)
+	}
+
+	lspDescribeThingAtPoint()
+	if Global.Input != "func helper()" {
+		t.Errorf("Expected the hover text, got %q", Global.Input)
+	}
+
+	xrefFindReferences(nil)
+	Global.CurrentB.FailIfBufferNe([]string{
+		"sub/main.go:3:6: func helper() {}",
+		"sub/main.go:6:2: helper()",
+		"sub/main.go:7:2: helper()",
+	}, t)
+	Global.CurrentB = buf
+
+	lspRenameTo("assist", nil)
+	if Global.Input != "Renamed in 1 file" {
+		t.Errorf("Expected a rename, got %q", Global.Input)
+	}
+	if buf.Row(2).Data != "func assist() {}" || buf.Row(6).Data != "\tassist()" || !buf.Dirty {
+		t.Errorf("Expected every helper to be renamed, got %q", lspText(buf))
+	}
+
+	replaceText(buf, 0, 0, 7, 7, "\tass\n")
+	buf.cy, buf.cx = 7, 4
+	start, items, err := lspCompletions()
+	if err != nil || start != 1 || len(items) != 1 || items[0].Label != "assist" {
+		t.Errorf("Expected only assist to complete ass, from column 1, got %d, %v, %v", start, items, err)
+	}
+	completionAtPoint()
+	if buf.Row(7).Data != "\tassist" || buf.cx != 7 {
+		t.Errorf("Expected assist to be completed, got %q", buf.Row(7).Data)
+	}
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	at := func(sl, sc, el, ec int, text string) lspTextEdit {
+		return lspTextEdit{lspRange{lspPosition{sl, sc}, lspPosition{el, ec}}, text}
+	}
+	for _, c := range []struct {
+		edits  []lspTextEdit
+		expect []string
+	}{
+		{[]lspTextEdit{at(0, 0, 0, 0, "A"), at(0, 0, 0, 0, "B")}, []string{"ABhello world", "two"}},
+		{[]lspTextEdit{at(0, 0, 0, 0, "X"), at(0, 0, 0, 5, "bye")}, []string{"Xbye world", "two"}},
+		{[]lspTextEdit{at(1, 0, 1, 3, "2"), at(0, 6, 0, 11, "there"), at(1, 3, 1, 3, "!")},
+			[]string{"hello there", "2!"}},
+	} {
+		InitEditor()
+		buf := setLines("hello world", "two")
+		applyTextEdits(buf, c.edits)
+		buf.FailIfBufferNe(c.expect, t)
+	}
+}
+
+func TestLspPositions(t *testing.T) {
+	if utf16Len("a𝄞b") != 4 || utf16ByteIndex("a𝄞b", 3) != 5 || utf16ByteIndex("é", 5) != 2 {
+		t.Error("Expected columns in UTF-16 code units")
+	}
+	if uriFile(fileURI("/tmp/a b.go")) != "/tmp/a b.go" {
+		t.Error("Expected file names to survive being URIs")
+	}
+	raw := json.RawMessage(`[{"language": "go", "value": "x int"}, "the x"]`)
+	if text := hoverText(raw); text != "x int the x" {
+		t.Errorf("Expected the hover text joined up, got %q", text)
+	}
+	locs := parseLocations(json.RawMessage(`[{"targetUri": "file:///a", "targetSelectionRange":
+		{"start": {"line": 1, "character": 2}, "end": {"line": 1, "character": 3}}}]`))
+	if len(locs) != 1 || locs[0].URI != "file:///a" || locs[0].Range.Start.Character != 2 {
+		t.Errorf("Expected a location link to be understood, got %v", locs)
+	}
+}
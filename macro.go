@@ -39,6 +39,9 @@ func micromode(repeatkey string, msg string, env *glisp.Zlisp, f func(*glisp.Zli
 		key = editorGetKey()
 	}
 	Global.SetUniversal = false
+	// The key that ended the micromode is a command of its own as far as undo
+	// is concerned
+	commandSeq++
 	RunCommandForKey(key, env)
 	editorRefreshScreen()
 }
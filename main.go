@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
@@ -9,9 +8,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	glisp "github.com/glycerine/zygomys/zygo"
@@ -22,7 +23,6 @@ import (
 )
 
 type EditorRow struct {
-	idx        int
 	Size       int
 	Data       string
 	RenderSize int
@@ -30,6 +30,7 @@ type EditorRow struct {
 	HlState    highlight.State
 	HlMatches  highlight.LineMatch
 	coloff     int
+	leaf       *ropeNode // Where the row lives in its buffer's rope
 }
 
 type EditorBuffer struct {
@@ -40,10 +41,8 @@ type EditorBuffer struct {
 	cy           int
 	rx           int
 	rowoff       int
-	NumRows      int
-	Rows         []*EditorRow
+	rows         *rowRope
 	Undo         *EditorUndo
-	Redo         *EditorUndo
 	SaveUndo     *EditorUndo // The undo at which we can undirty the buffer
 	MarkX        int
 	MarkY        int
@@ -53,6 +52,32 @@ type EditorBuffer struct {
 	prefcx       int
 	regionActive bool
 	region       *Region
+	Eol          EolStyle
+	Coding       Coding
+	// Zero value so that new buffers get a final newline
+	NoFinalNewline bool
+	backedUp       bool
+	autoSaved      bool
+	autoSaveSum    uint32
+	modTime        time.Time
+	fileSize       int64
+	LargeFile      bool
+	largeSource    *lazySource // Where a large file's unread rows come from
+	undoStart      EditorUndo
+	fileSum        string
+	cursors        []*editorCursor
+	lazyHighlight  *regexp.Regexp // Matches to show, e.g. while searching
+	// How many bytes at the start of each row lazyHighlight ignores
+	lazyHighlightSkip int
+	occur             *occurInfo
+	errors            *errorList
+	compileGen        int // Which compilation's output goes in the buffer
+	shell             *shellProcess
+	term              *terminal
+	dired             *diredInfo
+	wdired            *wdiredInfo
+	lsp               *lspDocument
+	vc                *vcInfo
 }
 
 type EditorState struct {
@@ -65,7 +90,7 @@ type EditorState struct {
 	NoSyntax                bool
 	WindowTree              *winTree
 	CurrentBHeight          int
-	Clipboard               string
+	KillRing                []string
 	SoftTab                 bool
 	DefaultModes            map[string]bool
 	messages                []string
@@ -82,6 +107,21 @@ type EditorState struct {
 	MouseX                  int
 	MouseY                  int
 	MinorModes              map[string]bool
+	BackupStyle             BackupStyle
+	KeptBackups             int
+	AutoSaveInterval        int
+	AutoSaveTimeout         int
+	autoSaveKeys            int
+	AutoRevertInterval      int
+	LargeFileThreshold      int64
+	UndoDir                 string
+	KillRingMax             int
+	killRingYank            int
+	lastKill                int // The command that last killed something
+	yankX                   int // Where the last yank started
+	yankY                   int
+	Clipboard               clipboardBackend
+	lastClipboard           string // What we last put on or saw on the clipboard
 }
 
 var Global EditorState
@@ -91,10 +131,16 @@ func editorSetPrompt(prompt string) {
 	Global.Prompt = prompt
 }
 
+// Whether buf has changes to save, as opposed to being a shell, terminal or
+// directory listing, which can't be saved.
+func (buf *EditorBuffer) needsSaving() bool {
+	return buf.Dirty && buf.shell == nil && buf.term == nil && buf.dired == nil
+}
+
 func saveSomeBuffers(env *glisp.Zlisp) bool {
 	nodirty := true
 	for _, buf := range Global.Buffers {
-		if buf.Dirty {
+		if buf.needsSaving() {
 			ds, cancel := editorYesNoPrompt(fmt.Sprintf("%s has unsaved changes; save them?", buf.getRenderName()), true)
 			if ds && cancel == nil {
 				editorBufSave(buf, env)
@@ -102,7 +148,7 @@ func saveSomeBuffers(env *glisp.Zlisp) bool {
 				return false
 			}
 		}
-		nodirty = nodirty && !buf.Dirty
+		nodirty = nodirty && !buf.needsSaving()
 	}
 	return nodirty
 }
@@ -150,16 +196,20 @@ func rowUpdateRender(row *EditorRow) {
 }
 
 func editorReHighlightRow(row *EditorRow, buf *EditorBuffer) {
-	if buf.Highlighter != nil {
-		curstate := buf.State(row.idx)
-		buf.Highlighter.ReHighlightStates(buf, row.idx)
-		if curstate != buf.State(row.idx) {
+	if buf.Highlighter != nil && buf.LargeFile {
+		// Don't go looking at the rest of the file; it may not be loaded
+		buf.Highlighter.ReHighlightLine(buf, row.Line())
+	} else if buf.Highlighter != nil {
+		line := row.Line()
+		curstate := buf.State(line)
+		buf.Highlighter.ReHighlightStates(buf, line)
+		if curstate != buf.State(line) {
 			// If the EOL state changed, the buffer needs rehighlighting
 			// as this was probably multiline comment or string.
-			buf.Highlighter.HighlightMatches(buf, row.idx, buf.NumRows)
+			buf.Highlighter.HighlightMatches(buf, line, buf.NumRows())
 		} else {
 			// Probably only this line changed.
-			buf.Highlighter.ReHighlightLine(buf, row.idx)
+			buf.Highlighter.ReHighlightLine(buf, line)
 		}
 	}
 }
@@ -169,43 +219,44 @@ func editorUpdateRow(row *EditorRow, buf *EditorBuffer) {
 	editorReHighlightRow(row, buf)
 }
 
-func updateLineIndexes() {
-	for i, row := range Global.CurrentB.Rows {
-		row.idx = i
+// Signalled by the editing primitives when the buffer is read-only, and
+// caught in RunCommandForKey, so that commands don't each have to check.
+type readOnlyError struct {
+	buf *EditorBuffer
+}
+
+func (e readOnlyError) Error() string {
+	return "Buffer is read-only: " + e.buf.getRenderName()
+}
+
+func barfIfReadOnly(buf *EditorBuffer) {
+	if buf.hasMode("read-only-mode") {
+		panic(readOnlyError{buf})
 	}
 }
 
 func editorAppendRow(line string) {
-	Global.CurrentB.Rows = append(Global.CurrentB.Rows, &EditorRow{Global.CurrentB.NumRows,
-		len(line), line, 0, "", nil, nil, 0})
-	editorUpdateRow(Global.CurrentB.Rows[Global.CurrentB.NumRows], Global.CurrentB)
-	Global.CurrentB.NumRows++
-	Global.CurrentB.Dirty = true
+	editorInsertRow(Global.CurrentB.NumRows(), line)
 }
 
 func editorDelRow(at int) {
-	if at < 0 || at > Global.CurrentB.NumRows {
+	if at < 0 || at >= Global.CurrentB.NumRows() {
 		return
 	}
-	copy(Global.CurrentB.Rows[at:], Global.CurrentB.Rows[at+1:])
-	Global.CurrentB.Rows[len(Global.CurrentB.Rows)-1] = nil
-	Global.CurrentB.Rows = Global.CurrentB.Rows[:len(Global.CurrentB.Rows)-1]
-	Global.CurrentB.NumRows--
+	barfIfReadOnly(Global.CurrentB)
+	Global.CurrentB.DeleteRows(at, at+1)
 	Global.CurrentB.Dirty = true
-	updateLineIndexes()
 }
 
 func editorInsertRow(at int, line string) {
-	if at < 0 || at > Global.CurrentB.NumRows {
+	if at < 0 || at > Global.CurrentB.NumRows() {
 		return
 	}
-	Global.CurrentB.Rows = append(Global.CurrentB.Rows, nil)
-	copy(Global.CurrentB.Rows[at+1:], Global.CurrentB.Rows[at:])
-	Global.CurrentB.Rows[at] = &EditorRow{at, len(line), line, 0, "", nil, nil, 0}
-	editorUpdateRow(Global.CurrentB.Rows[at], Global.CurrentB)
-	Global.CurrentB.NumRows++
+	barfIfReadOnly(Global.CurrentB)
+	row := &EditorRow{Size: len(line), Data: line}
+	Global.CurrentB.InsertRows(at, row)
+	editorUpdateRow(row, Global.CurrentB)
 	Global.CurrentB.Dirty = true
-	updateLineIndexes()
 }
 
 func editorRowAppendStr(row *EditorRow, buf *EditorBuffer, s string) {
@@ -227,6 +278,7 @@ func editorRowInsertStr(row *EditorRow, buf *EditorBuffer, at int, s string) {
 }
 
 func editorMutateRow(row *EditorRow, buf *EditorBuffer, s string) {
+	barfIfReadOnly(buf)
 	row.Data = s
 	row.Size = len(row.Data)
 	editorUpdateRow(row, buf)
@@ -237,6 +289,7 @@ func editorRowDelChar(row *EditorRow, buf *EditorBuffer, at int, rw int) {
 	if at < 0 || row.Size < 0 || at >= row.Size {
 		return
 	}
+	barfIfReadOnly(buf)
 	var buffer bytes.Buffer
 	buffer.WriteString(row.Data[0:at])
 	buffer.WriteString(row.Data[at+rw:])
@@ -255,14 +308,14 @@ func editorInsertStr(s string) {
 		}
 	}
 	Global.Input = ""
-	if Global.CurrentB.cy == Global.CurrentB.NumRows {
+	if Global.CurrentB.cy == Global.CurrentB.NumRows() {
 		editorAddInsertUndo(Global.CurrentB.cx, Global.CurrentB.cy, s)
 		editorInsertRow(Global.CurrentB.cy, s)
 		Global.CurrentB.cx += len(s)
 		return
 	}
 	editorAddInsertUndo(Global.CurrentB.cx, Global.CurrentB.cy, s)
-	editorRowInsertStr(Global.CurrentB.Rows[Global.CurrentB.cy], Global.CurrentB, Global.CurrentB.cx, s)
+	editorRowInsertStr(Global.CurrentB.Row(Global.CurrentB.cy), Global.CurrentB, Global.CurrentB.cx, s)
 	Global.CurrentB.cx += len(s)
 }
 
@@ -270,7 +323,7 @@ func editorIndent() {
 	tab := getTabString()
 	if Global.SoftTab {
 		buf := Global.CurrentB
-		rx := editorRowCxToRx(buf.Rows[buf.cy])
+		rx := editorRowCxToRx(buf.Row(buf.cy))
 		tab = tab[:nextTabStop(rx)]
 	}
 	editorInsertStr(tab)
@@ -278,12 +331,12 @@ func editorIndent() {
 
 func editorDeleteIndentation() {
 	buf := Global.CurrentB
-	if buf.cy == 0 || buf.NumRows <= 1 {
+	if buf.cy == 0 || buf.NumRows() <= 1 {
 		return
 	}
 
 	cx := 0
-	for _, ru := range buf.Rows[buf.cy].Data {
+	for _, ru := range buf.Row(buf.cy).Data {
 		if ru != ' ' && ru != '\t' {
 			break
 		}
@@ -291,7 +344,7 @@ func editorDeleteIndentation() {
 		cx++
 	}
 
-	startc, startl, endc, endl := buf.Rows[buf.cy-1].Size, buf.cy-1, cx, buf.cy
+	startc, startl, endc, endl := buf.Row(buf.cy-1).Size, buf.cy-1, cx, buf.cy
 	buf.cx, buf.cy = startc, startl
 	buf.MarkX, buf.MarkY = endc, endl
 	_, err := regionCmd(func(buf *EditorBuffer, startc, endc, startl, endl int) string {
@@ -330,10 +383,10 @@ func editorDelChar() {
 			Global.Input = "Beginning of buffer"
 			return
 		}
-		if buf.cy == buf.NumRows {
+		if buf.cy == buf.NumRows() {
 			return
 		}
-		row := buf.Rows[buf.cy]
+		row := buf.Row(buf.cy)
 		if buf.cx > 0 {
 			rv, rs := utf8.DecodeLastRuneInString(row.Data[:buf.cx])
 			if Global.SoftTab && rv == ' ' {
@@ -349,9 +402,9 @@ func editorDelChar() {
 			editorRowDelChar(row, buf, buf.cx-rs, rs)
 			buf.cx -= rs
 		} else {
-			editorAddDeleteUndo(buf.cx, buf.Rows[buf.cy-1].Size, buf.cy-1, buf.cy, row.Data)
-			buf.cx = buf.Rows[buf.cy-1].Size
-			editorRowAppendStr(buf.Rows[buf.cy-1], buf, row.Data)
+			editorAddDeleteUndo(buf.cx, buf.Row(buf.cy-1).Size, buf.cy-1, buf.cy, row.Data)
+			buf.cx = buf.Row(buf.cy - 1).Size
+			editorRowAppendStr(buf.Row(buf.cy-1), buf, row.Data)
 			editorDelRow(buf.cy)
 			buf.cy--
 		}
@@ -360,7 +413,7 @@ func editorDelChar() {
 
 func editorDelForwardChar() {
 	cx, cy := Global.CurrentB.cx, Global.CurrentB.cy
-	if cy == Global.CurrentB.NumRows-1 && cx == Global.CurrentB.Rows[cy].Size {
+	if cy == Global.CurrentB.NumRows()-1 && cx == Global.CurrentB.Row(cy).Size {
 		Global.Input = "End of buffer"
 		return
 	}
@@ -395,17 +448,17 @@ func getIndentation(s string) string {
 }
 
 func editorInsertNewline(indent bool) {
-	if Global.CurrentB.cy == Global.CurrentB.NumRows {
+	if Global.CurrentB.cy == Global.CurrentB.NumRows() {
 		defer func() { Global.CurrentB.cy++; Global.CurrentB.cx = 0 }()
-		if Global.CurrentB.NumRows == 0 {
+		if Global.CurrentB.NumRows() == 0 {
 			editorAppendRow("")
 			return
 		} else {
 			Global.CurrentB.cy--
-			Global.CurrentB.cx = Global.CurrentB.Rows[Global.CurrentB.cy].Size
+			Global.CurrentB.cx = Global.CurrentB.Row(Global.CurrentB.cy).Size
 		}
 	}
-	row := Global.CurrentB.Rows[Global.CurrentB.cy]
+	row := Global.CurrentB.Row(Global.CurrentB.cy)
 	if Global.CurrentB.cx == 0 {
 		editorAddInsertUndo(Global.CurrentB.cx, Global.CurrentB.cy, "\n")
 		editorInsertRow(Global.CurrentB.cy, "")
@@ -418,7 +471,7 @@ func editorInsertNewline(indent bool) {
 		data := pre + row.Data[Global.CurrentB.cx:]
 		editorAddInsertUndo(Global.CurrentB.cx, Global.CurrentB.cy, "\n"+pre)
 		editorInsertRow(Global.CurrentB.cy+1, data)
-		row = Global.CurrentB.Rows[Global.CurrentB.cy]
+		row = Global.CurrentB.Row(Global.CurrentB.cy)
 		row.Size = Global.CurrentB.cx
 		row.Data = row.Data[0:Global.CurrentB.cx]
 		editorUpdateRow(row, Global.CurrentB)
@@ -449,17 +502,41 @@ func EditorOpen(filename string, env *glisp.Zlisp) error {
 	}
 	Global.CurrentB.Filename = fpath
 	Global.CurrentB.UpdateRenderName()
-	f, err := os.Open(fpath)
+	err := Global.CurrentB.loadFile(fpath)
+	Global.CurrentB.recordFileStat()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		editorAppendRow(scanner.Text())
-	}
 	Global.CurrentB.Dirty = false
+	err = Global.CurrentB.loadUndoHistory()
+	if err != nil {
+		AddErrorMessage("Couldn't load undo history: " + err.Error())
+	}
 	editorSelectSyntaxHighlight(Global.CurrentB, env)
+	lspVisited(Global.CurrentB)
+	vcRefresh(Global.CurrentB)
+	if hasNewerAutoSave(fpath) {
+		Global.Input = Global.CurrentB.Rendername + " has auto save data; consider M-x recover-file"
+		AddErrorMessage(Global.Input)
+	}
+	return nil
+}
+
+// Read a file into the buffer, in large-file mode if it's big enough.
+func (buf *EditorBuffer) loadFile(fn string) error {
+	buf.fileSum = ""
+	if isLargeFile(fn) {
+		handled, err := buf.loadLarge(fn)
+		if handled {
+			return err
+		}
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	buf.loadContents(data)
+	buf.fileSum = hashContents(data)
 	return nil
 }
 
@@ -517,25 +594,55 @@ func editorBufSave(buf *EditorBuffer, env *glisp.Zlisp) {
 			buf.Rendername = filepath.Base(fpath)
 		}
 	}
+	if buf.changedOnDisk() {
+		ok, _ := editorYesNoPrompt(buf.getRenderName()+" has changed since visited or saved; save anyway?", false)
+		if !ok {
+			Global.Input = "Save aborted"
+			return
+		}
+	}
 	editorSelectSyntaxHighlight(buf, env)
-	f, err := os.Create(fn)
+	formatErr := formatOnSave(buf)
+	data, err := buf.encodeContents()
+	if err != nil {
+		Global.Input = "Save aborted: " + err.Error()
+		AddErrorMessage(Global.Input)
+		return
+	}
+	if buf.hasMode("backup-mode") && !buf.backedUp {
+		_, err = backupFile(fn)
+		if err != nil {
+			AddErrorMessage("Couldn't write backup file: " + err.Error())
+			anyway, _ := editorYesNoPrompt("Couldn't write backup file; save anyway?", false)
+			if !anyway {
+				Global.Input = "Save aborted"
+				return
+			}
+		}
+		buf.backedUp = true
+	}
+	err = writeFileAtomic(fn, data)
 	if err != nil {
 		Global.Input = err.Error()
 		AddErrorMessage(err.Error())
 		return
 	}
-	defer f.Close()
-	l, b := 0, 0
-	for _, row := range buf.Rows {
-		f.WriteString(row.Data)
-		f.WriteString("\n")
-		b += row.Size + 1
-		l++
-	}
-	Global.Input = fmt.Sprintf("Wrote %d lines (%d bytes) to %s", l, b, fn)
+	buf.recordFileStat()
+	buf.fileSum = hashContents(data)
+	Global.Input = fmt.Sprintf("Wrote %d lines (%d bytes) to %s", buf.NumRows(), len(data), fn)
 	AddErrorMessage(Global.Input)
+	if formatErr != nil {
+		Global.Input += " (" + formatErr.Error() + ")"
+	}
 	buf.Dirty = false
 	buf.SaveUndo = buf.Undo
+	buf.deleteAutoSave()
+	err = buf.saveUndoHistory()
+	if err != nil {
+		AddErrorMessage("Couldn't save undo history: " + err.Error())
+	}
+	lspSaved(buf)
+	vcRefresh(buf)
 }
 
 func getTabString() string {
@@ -551,10 +658,14 @@ func InitEditor() {
 	buffer.MajorMode = "Unknown"
 	Global = EditorState{false, "", buffer, []*EditorBuffer{buffer}, 4, "",
 		false, &winTree{false, false, true, buffer, nil, nil, nil}, 0,
-		"", false, make(map[string]bool), []string{}, false, 0, false,
+		nil, false, make(map[string]bool), []string{}, false, 0, false,
 		loadDefaultHooks(), nil, false, 0, NewRegisterList(), 80,
-		make(map[string]*CommandList), 0, 0, make(map[string]bool)}
+		make(map[string]*CommandList), 0, 0, make(map[string]bool),
+		BackupSimple, 0, 300, 30, 0, 5, 64 * 1024 * 1024, "", 120, 0, 0, 0, 0, nil, ""}
 	Global.DefaultModes["terminal-title-mode"] = true
+	Global.DefaultModes["auto-save-mode"] = true
+	Global.DefaultModes["persistent-undo-mode"] = true
+	Global.DefaultModes["diff-gutter-mode"] = true
 	Emacs = new(CommandList)
 	Emacs.Parent = true
 	funcnames = make(map[string]*CommandFunc)
@@ -592,6 +703,18 @@ func RunCommandForKey(key string, env *glisp.Zlisp) {
 	}
 
 	Global.Input = ""
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(readOnlyError); ok {
+				Global.Input = err.Error()
+				return
+			}
+			panic(r)
+		}
+	}()
+	if t := Global.CurrentB.term; t != nil && t.charMode && t.handleKey(key, env) {
+		return
+	}
 	com, comerr := GetCommand(key, Emacs, Global.MajorBindings[Global.CurrentB.MajorMode])
 	if comerr != nil {
 		if selfins != nil {
@@ -695,12 +818,15 @@ func setFillColumn() {
 func keyboardQuit() {
 	Global.Input = "Quit"
 	Global.CurrentB.regionActive = false
+	Global.CurrentB.clearCursors()
 }
 
 func main() {
 	var dumptreequit bool
 	cpuprofile := ""
 	InitEditor()
+	Global.UndoDir = defaultUndoDir()
+	Global.Clipboard = detectClipboardBackend()
 	fs := flag.NewFlagSet("", flag.ExitOnError)
 	fs.BoolVar(&Global.NoSyntax, "s", false, "disable syntax highlighting")
 	fs.BoolVar(&Global.debug, "d", false, "enable dumps of crash logs")
@@ -723,6 +849,9 @@ func main() {
 		fmt.Println(WalkCommandTree(Emacs, ""))
 		return
 	}
+	InitTerm()
+	defer termbox.Close()
+	defer recoverFromCrash()
 	if Global.Input == "" {
 		Global.Input = "Welcome to Emacs!"
 	}
@@ -743,14 +872,11 @@ func main() {
 			if ferr != nil {
 				Global.Input = ferr.Error()
 				AddErrorMessage(ferr.Error())
-				Global.CurrentB.Rows = make([]*EditorRow, 1)
-				Global.CurrentB.Rows[0] = &EditorRow{
-					Global.CurrentB.NumRows,
-					0, "", 0, "", nil, nil, 0}
+				Global.CurrentB.SetRows([]*EditorRow{&EditorRow{}})
 			}
 
 			if err == nil {
-				if linum >= Global.CurrentB.NumRows-1 {
+				if linum >= Global.CurrentB.NumRows()-1 {
 					Global.CurrentB.MoveCursorToEndOfBuffer()
 				} else if linum > 0 {
 					Global.CurrentB.cy = linum
@@ -762,9 +888,7 @@ func main() {
 			if ferr != nil {
 				Global.Input = ferr.Error()
 				AddErrorMessage(ferr.Error())
-				Global.CurrentB.Rows = make([]*EditorRow, 1)
-				Global.CurrentB.Rows[0] = &EditorRow{Global.CurrentB.NumRows,
-					0, "", 0, "", nil, nil, 0}
+				Global.CurrentB.SetRows([]*EditorRow{&EditorRow{}})
 			}
 		}
 		if len(args) > 1 {
@@ -777,29 +901,33 @@ func main() {
 				if ferr != nil {
 					Global.Input = ferr.Error()
 					AddErrorMessage(ferr.Error())
-					Global.CurrentB.Rows = make([]*EditorRow, 1)
-					Global.CurrentB.Rows[0] = &EditorRow{Global.CurrentB.NumRows,
-						0, "", 0, "", nil, nil, 0}
+					Global.CurrentB.SetRows([]*EditorRow{&EditorRow{}})
 				}
 			}
 			Global.CurrentB = Global.Buffers[0]
 		}
 	} else {
-		Global.CurrentB.Rows = make([]*EditorRow, 1)
-		Global.CurrentB.Rows[0] = &EditorRow{Global.CurrentB.NumRows,
-			0, "", 0, "", nil, nil, 0}
+		Global.CurrentB.SetRows([]*EditorRow{&EditorRow{}})
 	}
 
-	InitTerm()
-	defer termbox.Close()
+	startTimers()
 
 	for {
 		editorRefreshScreen()
 		if Global.quit {
+			if currentCompilation != nil {
+				killProcessGroup(currentCompilation.cmd)
+			}
+			for _, buf := range Global.Buffers {
+				killBufferProcess(buf)
+			}
+			lspShutdownAll()
+			saveUndoHistories()
 			return
 		} else {
 			key := editorGetKey()
 			RunCommandForKey(key, env)
+			autoSaveKeystroke()
 		}
 	}
 }
@@ -147,7 +147,6 @@ func ExecSaveHooksForMode(env *glisp.Zlisp, mode string) {
 }
 
 func BindKeyMajorMode(mode, key string, cmd *CommandFunc) {
-	AddErrorMessage(mode + key + cmd.Name)
 	if Global.MajorBindings[mode] == nil {
 		Global.MajorBindings[mode] = new(CommandList)
 		Global.MajorBindings[mode].Parent = true
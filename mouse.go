@@ -37,15 +37,12 @@ func (t *winTree) mouseInBuffer(x, y, wx, wy, mx, my int) (int, int, *EditorBuff
 
 	cy := t.buf.rowoff + my%wy
 
-	if cy >= Global.CurrentB.NumRows {
+	if cy >= Global.CurrentB.NumRows() {
 		return 0, cy, t.buf
 	}
 
-	row := Global.CurrentB.Rows[cy]
-	gut := 0
-	if Global.CurrentB.hasMode("line-number-mode") {
-		gut = GetGutterWidth(Global.CurrentB.NumRows)
-	}
+	row := Global.CurrentB.Row(cy)
+	gut := gutterWidth(Global.CurrentB)
 	rx := mx - x - gut + row.coloff
 
 	Global.WindowTree.mapTree(func(wt *winTree) { wt.focused = false })
@@ -60,14 +57,14 @@ func getMousePoint(mx, my int) (int, int, *EditorBuffer) {
 }
 
 func JumpToMousePoint() {
-	if Global.CurrentB.NumRows <= 0 {
+	if Global.CurrentB.NumRows() <= 0 {
 		return
 	}
 	var cx, cy int
 	cx, cy, Global.CurrentB = getMousePoint(Global.MouseX, Global.MouseY)
-	if cy >= Global.CurrentB.NumRows {
-		Global.CurrentB.cy = Global.CurrentB.NumRows - 1
-		Global.CurrentB.cx = Global.CurrentB.Rows[Global.CurrentB.cy].Size
+	if cy >= Global.CurrentB.NumRows() {
+		Global.CurrentB.cy = Global.CurrentB.NumRows() - 1
+		Global.CurrentB.cx = Global.CurrentB.Row(Global.CurrentB.cy).Size
 	} else {
 		Global.CurrentB.cy = cy
 		Global.CurrentB.cx = cx
@@ -79,7 +76,7 @@ var mousestate byte = GomacsMouseNone
 
 func MouseDragRegion() {
 	buf := Global.CurrentB
-	if buf.NumRows <= 0 {
+	if buf.NumRows() <= 0 {
 		return
 	}
 	cachedcx, cachedcy := buf.cx, buf.cy
@@ -109,7 +106,7 @@ func MouseScrollUp() {
 
 func MouseScrollDown() {
 	_, _, Global.CurrentB = getMousePoint(Global.MouseX, Global.MouseY)
-	if Global.CurrentB.rowoff < Global.CurrentB.NumRows {
+	if Global.CurrentB.rowoff < Global.CurrentB.NumRows() {
 		Global.CurrentB.rowoff++
 	} else {
 		Global.Input = "End of buffer"
@@ -138,7 +135,7 @@ func MouseYankXsel() {
 		AddErrorMessage(Global.Input)
 		return
 	}
-	Global.Clipboard = out
+	pushKill(out)
 	if Global.CurrentB.hasMode("xsel-jump-to-cursor-mode") {
 		JumpToMousePoint()
 	}
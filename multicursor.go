@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Extra cursors. While a buffer has any, editing commands (see
+// multiCursorCommands) are run once at each of them as well as at the real
+// cursor. Every cursor has its own kill ring, so that killing and then
+// yanking moves each cursor's text about on its own.
+type editorCursor struct {
+	cx, cy, prefcx int
+	killRing       []string
+	killRingYank   int
+	lastKill       int
+	yankX, yankY   int
+}
+
+// Commands that run at every cursor. Self-inserting keys do too.
+var multiCursorCommands = map[string]bool{
+	"forward-char":                true,
+	"backward-char":               true,
+	"next-line":                   true,
+	"previous-line":               true,
+	"move-beginning-of-line":      true,
+	"move-end-of-line":            true,
+	"forward-word":                true,
+	"backward-word":               true,
+	"delete-char":                 true,
+	"delete-backward-char":        true,
+	"kill-word":                   true,
+	"backward-kill-word":          true,
+	"kill-line":                   true,
+	"yank-region":                 true,
+	"yank-pop":                    true,
+	"insert-newline-and-indent":   true,
+	"insert-newline-maybe-indent": true,
+	"indent":                      true,
+	"upcase-word":                 true,
+	"downcase-word":               true,
+	"capitalize-word":             true,
+	"transpose-chars":             true,
+}
+
+func runsAtCursors(cmd *CommandFunc) bool {
+	return multiCursorCommands[cmd.Name] || utf8.RuneCountInString(cmd.Name) == 1
+}
+
+// A new cursor at (cx, cy) with a copy of the kill ring as it is now.
+func newCursor(cx, cy int) *editorCursor {
+	return &editorCursor{cx, cy, cx, append([]string{}, Global.KillRing...),
+		Global.killRingYank, 0, 0, 0}
+}
+
+// Swap the cursor with the buffer's real cursor and the global kill ring.
+func (c *editorCursor) swap(buf *EditorBuffer) {
+	buf.cx, c.cx = c.cx, buf.cx
+	buf.cy, c.cy = c.cy, buf.cy
+	buf.prefcx, c.prefcx = c.prefcx, buf.prefcx
+	Global.KillRing, c.killRing = c.killRing, Global.KillRing
+	Global.killRingYank, c.killRingYank = c.killRingYank, Global.killRingYank
+	Global.lastKill, c.lastKill = c.lastKill, Global.lastKill
+	Global.yankX, c.yankX = c.yankX, Global.yankX
+	Global.yankY, c.yankY = c.yankY, Global.yankY
+}
+
+// Measure the cursor's position from the end of the buffer, or back again.
+// Changes before a cursor don't move it relative to the end.
+func (c *editorCursor) fromEnd(buf *EditorBuffer) {
+	if 0 <= c.cy && c.cy < buf.NumRows() {
+		c.cx = buf.Row(c.cy).Size - c.cx
+	}
+	c.cy = buf.NumRows() - c.cy
+}
+
+func (c *editorCursor) fromStart(buf *EditorBuffer) {
+	c.cy = buf.NumRows() - c.cy
+	if c.cy < 0 {
+		c.cy, c.cx = 0, 0
+	} else if buf.NumRows() <= c.cy {
+		c.cy = buf.NumRows()
+		c.cx = 0
+	} else {
+		size := buf.Row(c.cy).Size
+		c.cx = size - c.cx
+		if c.cx < 0 || size < c.cx {
+			c.cx = size
+		}
+	}
+}
+
+// Keep the cursor inside the buffer, e.g. after an undo.
+func (c *editorCursor) clamp(buf *EditorBuffer) {
+	if buf.NumRows() <= c.cy {
+		c.cy, c.cx = buf.NumRows(), 0
+	} else if size := buf.Row(c.cy).Size; size < c.cx {
+		c.cx = size
+	}
+}
+
+// Call f at each of the buffer's cursors, starting from the one nearest the
+// end of the buffer so that each change leaves the cursors yet to come where
+// they were.
+func (buf *EditorBuffer) runAtCursors(f func()) {
+	point := &editorCursor{cx: buf.cx, cy: buf.cy, prefcx: buf.prefcx}
+	all := append([]*editorCursor{point}, buf.cursors...)
+	sort.SliceStable(all, func(i, j int) bool {
+		return posBefore(all[j].cy, all[j].cx, all[i].cy, all[i].cx)
+	})
+	done := 0
+	defer func() {
+		for _, c := range all[:done] {
+			c.fromStart(buf)
+		}
+		buf.cx, buf.cy, buf.prefcx = point.cx, point.cy, point.prefcx
+		// Cursors that have run into each other become one
+		buf.cursors = nil
+		for _, c := range all {
+			if c != point && !(c.cx == buf.cx && c.cy == buf.cy) && !buf.hasCursorAt(c.cx, c.cy) {
+				buf.cursors = append(buf.cursors, c)
+			}
+		}
+	}()
+	for _, c := range all {
+		c.clamp(buf)
+	}
+	for _, c := range all {
+		buf.runAtCursor(c, c == point, f)
+		c.fromEnd(buf)
+		done++
+	}
+}
+
+func (buf *EditorBuffer) runAtCursor(c *editorCursor, isPoint bool, f func()) {
+	if isPoint {
+		// The real cursor uses the global kill ring as it stands
+		buf.cx, buf.cy, buf.prefcx = c.cx, c.cy, c.prefcx
+		defer func() { c.cx, c.cy, c.prefcx = buf.cx, buf.cy, buf.prefcx }()
+		f()
+		return
+	}
+	// Only the real cursor's kills go to the desktop clipboard
+	clipboard := Global.Clipboard
+	Global.Clipboard = nil
+	c.swap(buf)
+	defer func() {
+		c.swap(buf)
+		Global.Clipboard = clipboard
+	}()
+	f()
+}
+
+func (buf *EditorBuffer) hasCursorAt(cx, cy int) bool {
+	for _, c := range buf.cursors {
+		if c.cx == cx && c.cy == cy {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a cursor at (cx, cy), unless there's one there already.
+func (buf *EditorBuffer) addCursor(cx, cy int) {
+	if (cx == buf.cx && cy == buf.cy) || buf.hasCursorAt(cx, cy) {
+		return
+	}
+	buf.cursors = append(buf.cursors, newCursor(cx, cy))
+}
+
+func (buf *EditorBuffer) clearCursors() {
+	buf.cursors = nil
+}
+
+// Leave a cursor where the real one is and move the real one down a line.
+func mcAddCursorNextLine() {
+	buf := Global.CurrentB
+	if buf.NumRows()-1 <= buf.cy {
+		Global.Input = "End of buffer"
+		return
+	}
+	cx, cy := buf.cx, buf.cy
+	buf.cy++
+	buf.UpdateRowToPrefCX()
+	buf.addCursor(cx, cy)
+	mcShowCount(buf)
+}
+
+// The region's text, which has to be on one line to be looked for.
+func mcRegionText(buf *EditorBuffer) (string, bool) {
+	if !buf.regionActive || !validMark(buf) {
+		Global.Input = "No region to look for"
+		return "", false
+	}
+	if buf.MarkY != buf.cy {
+		Global.Input = "The region has to be on one line"
+		return "", false
+	}
+	text, _ := regionCmd(bufCopyRegion)
+	if text == "" {
+		Global.Input = "The region is empty"
+		return "", false
+	}
+	return text, true
+}
+
+// Find text at or after (cx, cy); returns where it starts, or -1, -1.
+func (buf *EditorBuffer) findForward(text string, cx, cy int) (int, int) {
+	for ; cy < buf.NumRows(); cy++ {
+		data := buf.Row(cy).Data
+		if cx <= len(data) {
+			if i := strings.Index(data[cx:], text); 0 <= i {
+				return cx + i, cy
+			}
+		}
+		cx = 0
+	}
+	return -1, -1
+}
+
+// Leave a cursor at the region and move the region (and the real cursor) on
+// to the next place its text appears.
+func mcMarkNextLikeThis() {
+	buf := Global.CurrentB
+	text, ok := mcRegionText(buf)
+	if !ok {
+		return
+	}
+	ahead := markAhead(buf)
+	start := buf.cx
+	if !ahead {
+		start = buf.MarkX
+	}
+	mx, my := buf.findForward(text, start+len(text), buf.cy)
+	if my < 0 {
+		Global.Input = "No more matches for " + text
+		return
+	}
+	cx, cy := buf.cx, buf.cy
+	buf.cy, buf.MarkY = my, my
+	if ahead {
+		buf.cx, buf.MarkX = mx, mx+len(text)
+	} else {
+		buf.cx, buf.MarkX = mx+len(text), mx
+	}
+	buf.prefcx = buf.cx
+	buf.recalcRegion()
+	buf.addCursor(cx, cy)
+	mcShowCount(buf)
+}
+
+// Put a cursor at every place the region's text appears.
+func mcMarkAllLikeThis() {
+	buf := Global.CurrentB
+	text, ok := mcRegionText(buf)
+	if !ok {
+		return
+	}
+	// Put the cursors at the same end of each match as the real one
+	off := len(text)
+	if markAhead(buf) {
+		off = 0
+	}
+	for cx, cy := buf.findForward(text, 0, 0); 0 <= cy; cx, cy = buf.findForward(text, cx+len(text), cy) {
+		buf.addCursor(cx+off, cy)
+	}
+	mcShowCount(buf)
+}
+
+func mcShowCount(buf *EditorBuffer) {
+	Global.Input = fmt.Sprintf("%d cursors", len(buf.cursors)+1)
+}
+
+// Show the extra cursors as reversed cells.
+func drawCursors(startx, starty, sx, sy int, buf *EditorBuffer, gutsize int) {
+	if len(buf.cursors) == 0 {
+		return
+	}
+	w, h := termbox.Size()
+	cells := termbox.CellBuffer()
+	for _, c := range buf.cursors {
+		y := c.cy - buf.rowoff + starty
+		if y < starty || sy <= y || h <= y {
+			continue
+		}
+		x := startx + gutsize
+		if c.cy < buf.NumRows() {
+			row := buf.Row(c.cy)
+			if row.Size < c.cx {
+				continue
+			}
+			x += row.cxToRx(c.cx) - row.coloff
+		}
+		if x < startx+gutsize || sx <= x || w <= x {
+			continue
+		}
+		cell := cells[y*w+x]
+		if cell.Ch == 0 {
+			cell.Ch = ' '
+		}
+		termbox.SetCell(x, y, cell.Ch, cell.Fg|termbox.AttrReverse, cell.Bg)
+	}
+}
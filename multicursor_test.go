@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+)
+
+// Fill the buffer with lines, leaving nothing to undo.
+func setLines(lines ...string) *EditorBuffer {
+	for i, line := range lines {
+		if i > 0 {
+			editorInsertNewline(false)
+		}
+		editorInsertStr(line)
+	}
+	buf := Global.CurrentB
+	buf.clearUndo()
+	buf.cx, buf.cy = 0, 0
+	return buf
+}
+
+func TestCursorsOnNextLines(t *testing.T) {
+	InitEditor()
+	buf := setLines("one", "two", "three")
+	runAsCommand("mc-add-cursor-next-line", mcAddCursorNextLine)
+	runAsCommand("mc-add-cursor-next-line", mcAddCursorNextLine)
+	if len(buf.cursors) != 2 || buf.cy != 2 {
+		t.Fatalf("Expected 2 extra cursors and the real one on line 3, got %d on line %d",
+			len(buf.cursors), buf.cy+1)
+	}
+	runAsCommand("-", func() { editorInsertStr("-") })
+	runAsCommand(" ", func() { editorInsertStr(" ") })
+	buf.FailIfBufferNe([]string{"- one", "- two", "- three"}, t)
+	runAsCommand("move-end-of-line", MoveCursorToEol)
+	runAsCommand("delete-backward-char", editorDelChar)
+	buf.FailIfBufferNe([]string{"- on", "- tw", "- thre"}, t)
+	editorUndoAction()
+	buf.FailIfBufferNe([]string{"- one", "- two", "- three"}, t)
+	editorUndoAction()
+	buf.FailIfBufferNe([]string{"-one", "-two", "-three"}, t)
+	keyboardQuit()
+	if len(buf.cursors) != 0 {
+		t.Errorf("Expected C-g to remove the cursors, but %d are left", len(buf.cursors))
+	}
+}
+
+func TestCursorsOnOneLine(t *testing.T) {
+	InitEditor()
+	buf := setLines("a, b, c")
+	buf.MarkX, buf.MarkY = 0, 0
+	buf.cx = 1
+	buf.regionActive = true
+	runAsCommand("mc-mark-next-like-this", mcMarkNextLikeThis)
+	if len(buf.cursors) != 0 {
+		t.Errorf("Expected no match for \"a\", but got %d cursors", len(buf.cursors))
+	}
+	buf.MarkX, buf.cx = 1, 2
+	runAsCommand("mc-mark-all-like-this", mcMarkAllLikeThis)
+	if len(buf.cursors) != 1 {
+		t.Fatalf("Expected a cursor at the other comma, but got %d", len(buf.cursors))
+	}
+	buf.regionActive = false
+	runAsCommand("delete-backward-char", editorDelChar)
+	runAsCommand(";", func() { editorInsertStr(";") })
+	buf.FailIfBufferNe([]string{"a; b; c"}, t)
+	if buf.cx != 2 || buf.cursors[0].cx != 5 {
+		t.Errorf("Expected cursors at 2 and 5, got %d and %d", buf.cx, buf.cursors[0].cx)
+	}
+}
+
+func TestMarkNextLikeThis(t *testing.T) {
+	InitEditor()
+	buf := setLines("foo bar", "foo baz foo")
+	buf.MarkX, buf.cx = 0, 3
+	buf.regionActive = true
+	runAsCommand("mc-mark-next-like-this", mcMarkNextLikeThis)
+	runAsCommand("mc-mark-next-like-this", mcMarkNextLikeThis)
+	if buf.cy != 1 || buf.cx != 11 || len(buf.cursors) != 2 {
+		t.Fatalf("Expected the real cursor at 2:11 and 2 more, got %d:%d and %d",
+			buf.cy+1, buf.cx, len(buf.cursors))
+	}
+	runAsCommand("mc-mark-next-like-this", mcMarkNextLikeThis)
+	if len(buf.cursors) != 2 {
+		t.Errorf("Expected no more cursors, got %d", len(buf.cursors))
+	}
+	buf.regionActive = false
+	runAsCommand("!", func() { editorInsertStr("!") })
+	buf.FailIfBufferNe([]string{"foo! bar", "foo! baz foo!"}, t)
+}
+
+func TestKillAndYankAtCursors(t *testing.T) {
+	InitEditor()
+	buf := setLines("one two", "three four")
+	pushKill("old")
+	runAsCommand("mc-add-cursor-next-line", mcAddCursorNextLine)
+	runAsCommand("kill-word", delForwardWord)
+	buf.FailIfBufferNe([]string{" two", " four"}, t)
+	runAsCommand("move-end-of-line", MoveCursorToEol)
+	runAsCommand(" ", func() { editorInsertStr(" ") })
+	runAsCommand("yank-region", doYankRegion)
+	buf.FailIfBufferNe([]string{" two one", " four three"}, t)
+	if currentKill() != "three" {
+		t.Errorf("Expected the real cursor's kill on the kill ring, got %q", currentKill())
+	}
+	runAsCommand("yank-pop", doYankPop)
+	buf.FailIfBufferNe([]string{" two old", " four old"}, t)
+	editorUndoAction()
+	buf.FailIfBufferNe([]string{" two one", " four three"}, t)
+}
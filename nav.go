@@ -10,8 +10,8 @@ import (
 
 func editorScroll(sx, sy int) {
 	Global.CurrentB.rx = 0
-	if Global.CurrentB.cy < Global.CurrentB.NumRows {
-		Global.CurrentB.rx = editorRowCxToRx(Global.CurrentB.Rows[Global.CurrentB.cy])
+	if Global.CurrentB.cy < Global.CurrentB.NumRows() {
+		Global.CurrentB.rx = editorRowCxToRx(Global.CurrentB.Row(Global.CurrentB.cy))
 	}
 
 	if Global.CurrentB.cy < Global.CurrentB.rowoff {
@@ -20,10 +20,10 @@ func editorScroll(sx, sy int) {
 	if Global.CurrentB.cy >= Global.CurrentB.rowoff+sy {
 		Global.CurrentB.rowoff = Global.CurrentB.cy - sy + 1
 	}
-	if Global.CurrentB.NumRows == 0 {
+	if Global.CurrentB.NumRows() == 0 {
 		return
 	}
-	row := Global.CurrentB.Rows[Global.CurrentB.cy]
+	row := Global.CurrentB.Row(Global.CurrentB.cy)
 	if Global.CurrentB.rx < row.coloff+3 {
 		row.coloff = Global.CurrentB.rx - 5
 		if row.coloff < 0 {
@@ -43,7 +43,7 @@ func editorCentreView() {
 }
 
 func (buf *EditorBuffer) UpdateRowToPrefCX() {
-	row := buf.Rows[buf.cy]
+	row := buf.Row(buf.cy)
 	if buf.prefcx == -1 || buf.prefcx > row.Size {
 		buf.cx = row.Size
 	} else {
@@ -52,15 +52,15 @@ func (buf *EditorBuffer) UpdateRowToPrefCX() {
 }
 
 func (buf *EditorBuffer) MoveCursorToEndOfBuffer() {
-	buf.cy = len(buf.Rows) - 1
-	buf.cx = buf.Rows[buf.cy].Size
+	buf.cy = buf.NumRows() - 1
+	buf.cx = buf.Row(buf.cy).Size
 	buf.prefcx = -1
 }
 
 func (buf *EditorBuffer) MoveCursorDown() {
 	times := getRepeatTimes()
 	for i := 0; i < times; i++ {
-		if buf.cy >= buf.NumRows-1 {
+		if buf.cy >= buf.NumRows()-1 {
 			Global.Input = "End of buffer"
 		} else {
 			buf.cy++
@@ -89,10 +89,10 @@ func (buf *EditorBuffer) MoveCursorLeft() {
 		} else if buf.cx == 0 {
 			buf.cy--
 			buf.prefcx = -1
-			buf.cx = buf.Rows[buf.cy].Size
+			buf.cx = buf.Row(buf.cy).Size
 		} else {
 			_, rs :=
-				utf8.DecodeLastRuneInString(buf.Rows[buf.cy].Data[:buf.cx])
+				utf8.DecodeLastRuneInString(buf.Row(buf.cy).Data[:buf.cx])
 			buf.cx -= rs
 			buf.prefcx = buf.cx
 		}
@@ -102,10 +102,10 @@ func (buf *EditorBuffer) MoveCursorLeft() {
 func (buf *EditorBuffer) MoveCursorRight() {
 	times := getRepeatTimes()
 	for i := 0; i < times; i++ {
-		if buf.cy >= buf.NumRows {
+		if buf.cy >= buf.NumRows() {
 			Global.Input = "End of buffer"
-		} else if buf.cx == buf.Rows[buf.cy].Size {
-			if buf.cy == buf.NumRows-1 {
+		} else if buf.cx == buf.Row(buf.cy).Size {
+			if buf.cy == buf.NumRows()-1 {
 				Global.Input = "End of buffer"
 			} else {
 				buf.cy++
@@ -113,7 +113,7 @@ func (buf *EditorBuffer) MoveCursorRight() {
 				buf.cx = 0
 			}
 		} else {
-			_, rs := utf8.DecodeRuneInString(buf.Rows[buf.cy].Data[buf.cx:])
+			_, rs := utf8.DecodeRuneInString(buf.Row(buf.cy).Data[buf.cx:])
 			buf.cx += rs
 			buf.prefcx = buf.cx
 		}
@@ -122,8 +122,8 @@ func (buf *EditorBuffer) MoveCursorRight() {
 
 func MoveCursorToEol() {
 	Global.CurrentB.prefcx = -1
-	if Global.CurrentB.cy < Global.CurrentB.NumRows {
-		Global.CurrentB.cx = Global.CurrentB.Rows[Global.CurrentB.cy].Size
+	if Global.CurrentB.cy < Global.CurrentB.NumRows() {
+		Global.CurrentB.cx = Global.CurrentB.Row(Global.CurrentB.cy).Size
 	}
 }
 
@@ -143,7 +143,7 @@ func MovePage(back bool, sy int) {
 }
 
 func MoveCursorBackPage() {
-	if Global.CurrentB.NumRows == 0 {
+	if Global.CurrentB.NumRows() == 0 {
 		return
 	}
 	if Global.SetUniversal {
@@ -170,7 +170,7 @@ func MoveCursorBackPage() {
 }
 
 func MoveCursorForthPage() {
-	if Global.CurrentB.NumRows == 0 {
+	if Global.CurrentB.NumRows() == 0 {
 		return
 	}
 	if Global.SetUniversal {
@@ -178,7 +178,7 @@ func MoveCursorForthPage() {
 		if sy < 0 {
 			Global.Universal *= -1
 			MoveCursorBackPage()
-		} else if Global.CurrentB.rowoff+sy < Global.CurrentB.NumRows {
+		} else if Global.CurrentB.rowoff+sy < Global.CurrentB.NumRows() {
 			Global.CurrentB.rowoff += sy
 			for Global.CurrentB.cy < Global.CurrentB.rowoff {
 				Global.CurrentB.MoveCursorDown()
@@ -189,85 +189,15 @@ func MoveCursorForthPage() {
 	} else {
 		_, sy := GetScreenSize()
 		Global.CurrentB.cy = Global.CurrentB.rowoff + sy - 1
-		if Global.CurrentB.cy > Global.CurrentB.NumRows {
-			Global.CurrentB.cy = Global.CurrentB.NumRows - 1
+		if Global.CurrentB.cy > Global.CurrentB.NumRows() {
+			Global.CurrentB.cy = Global.CurrentB.NumRows() - 1
 		}
 		MovePage(false, sy)
 	}
 }
 
-// HACK: Go does not have static variables, so these have to go in global state.
-var last_match int = -1
-var direction int = 1
-
-func editorFindCallback(query string, key string) {
-	Global.Input = query
-	if key == "C-s" {
-		direction = 1
-	} else if key == "C-r" {
-		direction = -1
-		//If we cancelled or finished...
-	} else if key == "C-c" || key == "C-g" || key == "RET" {
-		if key == "C-c" || key == "C-g" {
-			Global.Input = "Cancelled search."
-		}
-		//...outta here!
-		last_match = -1
-		direction = 1
-		Global.CurrentB.regionActive = false
-		return
-	} else {
-		last_match = -1
-		direction = 1
-	}
-
-	if last_match == -1 {
-		direction = 1
-	}
-	current := last_match
-	ql := len(query)
-	for _ = range Global.CurrentB.Rows {
-		current += direction
-		if current == -1 {
-			current = Global.CurrentB.NumRows - 1
-		} else if current == Global.CurrentB.NumRows {
-			current = 0
-		}
-		row := Global.CurrentB.Rows[current]
-		match := strings.Index(row.Data, query)
-		if match > -1 {
-			last_match = current
-			Global.CurrentB.cy = current
-			Global.CurrentB.cx = match
-			Global.CurrentB.prefcx = Global.CurrentB.cx
-			Global.CurrentB.rowoff = Global.CurrentB.NumRows
-			Global.CurrentB.MarkX = Global.CurrentB.cx + ql
-			Global.CurrentB.MarkY = Global.CurrentB.cy
-			Global.CurrentB.regionActive = true
-			Global.CurrentB.recalcRegion()
-			break
-		}
-	}
-}
-
-func editorFind() {
-	saved_cx := Global.CurrentB.cx
-	saved_cy := Global.CurrentB.cy
-	saved_ro := Global.CurrentB.rowoff
-
-	query := editorPrompt("Search", editorFindCallback)
-
-	if query == "" {
-		//Search cancelled, go back to where we were
-		Global.CurrentB.regionActive = false
-		Global.CurrentB.cx = saved_cx
-		Global.CurrentB.prefcx = Global.CurrentB.cx
-		Global.CurrentB.cy = saved_cy
-		Global.CurrentB.rowoff = saved_ro
-	}
-}
-
 func doQueryReplace() {
+	barfIfReadOnly(Global.CurrentB)
 	orig := editorPrompt("Find", nil)
 	if orig == "" {
 		Global.Input = "Can't query-replace with an empty query"
@@ -278,7 +208,8 @@ func doQueryReplace() {
 	all := false
 	ql := len(orig)
 	rlen := len(replace)
-	for cy, row := range Global.CurrentB.Rows {
+	for cy := 0; cy < Global.CurrentB.NumRows(); cy++ {
+		row := Global.CurrentB.Row(cy)
 		match := strings.Index(row.Data, orig)
 		prestring := ""
 		matchstring := row.Data
@@ -287,7 +218,7 @@ func doQueryReplace() {
 			Global.CurrentB.cy = cy
 			Global.CurrentB.cx = match + psl
 			Global.CurrentB.prefcx = Global.CurrentB.cx
-			Global.CurrentB.rowoff = Global.CurrentB.NumRows
+			Global.CurrentB.rowoff = Global.CurrentB.NumRows()
 			Global.CurrentB.MarkX = Global.CurrentB.cx + ql
 			Global.CurrentB.MarkY = Global.CurrentB.cy
 			Global.CurrentB.regionActive = true
@@ -325,6 +256,7 @@ func doQueryReplace() {
 }
 
 func doReplaceString() {
+	barfIfReadOnly(Global.CurrentB)
 	orig := editorPrompt("Find", nil)
 	if orig == "" {
 		Global.Input = "Can't string-replace with an empty query"
@@ -335,7 +267,8 @@ func doReplaceString() {
 	lines := 0
 	ql := len(orig)
 	nl := len(replace)
-	for cy, row := range Global.CurrentB.Rows {
+	for cy := 0; cy < Global.CurrentB.NumRows(); cy++ {
+		row := Global.CurrentB.Row(cy)
 		match := strings.LastIndex(row.Data, orig)
 		if match != -1 {
 			count := strings.Count(row.Data, orig)
@@ -344,7 +277,7 @@ func doReplaceString() {
 			Global.CurrentB.cy = cy
 			Global.CurrentB.cx = match + ql - (count * (ql - nl))
 			Global.CurrentB.prefcx = Global.CurrentB.cx
-			Global.CurrentB.rowoff = Global.CurrentB.NumRows
+			Global.CurrentB.rowoff = Global.CurrentB.NumRows()
 			Global.CurrentB.Dirty = true
 			editorAddDeleteUndo(0, row.Size, cy, cy, row.Data)
 			row.Data = strings.Replace(row.Data, orig, replace, -1)
@@ -362,6 +295,7 @@ func doReplaceString() {
 }
 
 func doQueryReplaceRegexp() {
+	barfIfReadOnly(Global.CurrentB)
 	orig := editorPrompt("Find regexp", nil)
 	if orig == "" {
 		Global.Input = "Can't query-replace-regexp with an empty query"
@@ -375,7 +309,8 @@ func doQueryReplaceRegexp() {
 	}
 	replace := editorPrompt("Replace "+orig+" with", nil)
 	all := false
-	for cy, row := range Global.CurrentB.Rows {
+	for cy := 0; cy < Global.CurrentB.NumRows(); cy++ {
+		row := Global.CurrentB.Row(cy)
 		match := pattern.FindStringIndex(row.Data)
 		prestring := ""
 		matchstring := row.Data
@@ -384,7 +319,7 @@ func doQueryReplaceRegexp() {
 			Global.CurrentB.cy = cy
 			Global.CurrentB.cx = match[0] + psl
 			Global.CurrentB.prefcx = Global.CurrentB.cx
-			Global.CurrentB.rowoff = Global.CurrentB.NumRows
+			Global.CurrentB.rowoff = Global.CurrentB.NumRows()
 			matchlen := len(matchstring[match[0]:match[1]])
 			Global.CurrentB.MarkX = Global.CurrentB.cx + matchlen
 			Global.CurrentB.MarkY = Global.CurrentB.cy
@@ -432,6 +367,7 @@ func doQueryReplaceRegexp() {
 }
 
 func doReplaceRegexp() {
+	barfIfReadOnly(Global.CurrentB)
 	orig := editorPrompt("Find regexp", nil)
 	if orig == "" {
 		Global.Input = "Can't replace-regexp with an empty query"
@@ -445,7 +381,8 @@ func doReplaceRegexp() {
 	replace := editorPrompt("Replace "+orig+" with", nil)
 	matches := 0
 	lines := 0
-	for cy, row := range Global.CurrentB.Rows {
+	for cy := 0; cy < Global.CurrentB.NumRows(); cy++ {
+		row := Global.CurrentB.Row(cy)
 		match := pattern.MatchString(row.Data)
 		if match {
 			count := len(pattern.FindAllString(row.Data, -1))
@@ -454,7 +391,7 @@ func doReplaceRegexp() {
 			Global.CurrentB.cy = cy
 			Global.CurrentB.cx = row.Size
 			Global.CurrentB.prefcx = Global.CurrentB.cx
-			Global.CurrentB.rowoff = Global.CurrentB.NumRows
+			Global.CurrentB.rowoff = Global.CurrentB.NumRows()
 			Global.CurrentB.Dirty = true
 			editorAddDeleteUndo(0, row.Size, cy, cy, row.Data)
 			row.Data = pattern.ReplaceAllString(row.Data, replace)
@@ -480,8 +417,8 @@ func gotoLine() {
 	line--
 	if line < 0 {
 		line = 0
-	} else if line >= Global.CurrentB.NumRows {
-		line = Global.CurrentB.NumRows - 1
+	} else if line >= Global.CurrentB.NumRows() {
+		line = Global.CurrentB.NumRows() - 1
 	}
 	Global.CurrentB.cy = line
 	Global.Input = "Jumping to line " + strconv.Itoa(line+1)
@@ -493,10 +430,10 @@ func gotoChar() {
 		Global.Input = "Cancelled."
 		return
 	}
-	if Global.CurrentB.cy == Global.CurrentB.NumRows {
+	if Global.CurrentB.cy == Global.CurrentB.NumRows() {
 		return
 	}
-	datalen := len(Global.CurrentB.Rows[Global.CurrentB.cy].Data)
+	datalen := len(Global.CurrentB.Row(Global.CurrentB.cy).Data)
 	if line < 0 {
 		line = 0
 	} else if line >= datalen {
@@ -509,7 +446,8 @@ func gotoChar() {
 
 func getOffsetInBuffer(buf *EditorBuffer) (int, int) {
 	offset, total := 0, 0
-	for i, row := range buf.Rows {
+	for i := 0; i < buf.NumRows(); i++ {
+		row := buf.Row(i)
 		total += row.Size + 1
 		if i == buf.cy {
 			offset += buf.cx
@@ -527,11 +465,11 @@ func describeRune(ru rune) string {
 
 func whatCursorPosition() {
 	cx, cy := Global.CurrentB.cx, Global.CurrentB.cy
-	if cy >= Global.CurrentB.NumRows {
+	if cy >= Global.CurrentB.NumRows() {
 		Global.Input = "End of buffer"
 		return
 	}
-	row := Global.CurrentB.Rows[cy]
+	row := Global.CurrentB.Row(cy)
 	var ru rune
 	if cx >= row.Size {
 		ru = '\n'
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Occur lists the lines of a buffer that match a regexp in a buffer of their
+// own, each line after its line number. In occur-edit mode the list can be
+// edited, and the edited lines are written back to the buffer they came from.
+
+type occurInfo struct {
+	source *EditorBuffer
+	query  string
+	re     *regexp.Regexp
+	// What each listed line said when it was listed, by line number, so that
+	// only the lines that have been edited are written back
+	original map[int]string
+}
+
+// List the source's lines that match in buf, and return how many there were.
+func fillOccur(buf *EditorBuffer, info *occurInfo) int {
+	src := info.source
+	lines := []int{}
+	src.EachRow(0, src.NumRows(), func(i int, row *EditorRow) bool {
+		if info.re.MatchString(row.Data) {
+			lines = append(lines, i)
+		}
+		return true
+	})
+	width := len(strconv.Itoa(src.NumRows()))
+	rows := make([]*EditorRow, 0, len(lines)+1)
+	header := fmt.Sprintf("%d matching lines for %q in %s", len(lines),
+		info.query, src.getRenderName())
+	info.original = make(map[int]string, len(lines))
+	for _, line := range append([]int{-1}, lines...) {
+		data := header
+		if 0 <= line {
+			info.original[line] = src.Row(line).Data
+			data = fmt.Sprintf("%*d:%s", width, line+1, info.original[line])
+		}
+		row := &EditorRow{Size: len(data), Data: data}
+		rowUpdateRender(row)
+		rows = append(rows, row)
+	}
+	buf.occur = info
+	buf.SetRows(rows)
+	buf.clearUndo()
+	buf.Dirty = false
+	buf.MajorMode = "occur"
+	buf.Highlighter = nil
+	buf.setMode("read-only-mode", true)
+	buf.lazyHighlight = info.re
+	buf.lazyHighlightSkip = width + 1
+	buf.cx, buf.prefcx, buf.rowoff = 0, 0, 0
+	buf.cy = 0
+	if 1 < buf.NumRows() {
+		buf.cy = 1
+	}
+	return len(lines)
+}
+
+// Which line an occur buffer's row is about, and what it says; ok is false
+// if it isn't about one.
+func splitOccurRow(data string) (line int, text string, ok bool) {
+	colon := strings.IndexByte(data, ':')
+	if colon < 0 {
+		return 0, "", false
+	}
+	n, err := strconv.Atoi(strings.TrimLeft(data[:colon], " "))
+	if err != nil || n < 1 {
+		return 0, "", false
+	}
+	return n - 1, data[colon+1:], true
+}
+
+// Like splitOccurRow, but only for lines the source still has.
+func (info *occurInfo) parseRow(data string) (line int, text string, ok bool) {
+	line, text, ok = splitOccurRow(data)
+	if !ok || info.source.NumRows() <= line {
+		return 0, "", false
+	}
+	return line, text, true
+}
+
+func occur() {
+	src := Global.CurrentB
+	query := editorPrompt("List lines matching regexp", nil)
+	if query == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	re, err := compileSearch(query, true)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	buf := namedBuffer("*Occur*")
+	n := fillOccur(buf, &occurInfo{src, query, re, nil})
+	if n == 0 {
+		Global.Input = "No matches for " + query
+		return
+	}
+	showBufferOtherWindow(buf)
+	Global.Input = fmt.Sprintf("%d matching lines", n)
+}
+
+// Go to the line the cursor is on in the source buffer, in a window showing
+// it if there is one.
+func occurGotoOccurrence() {
+	buf := Global.CurrentB
+	info := buf.occur
+	if info == nil || buf.NumRows() <= buf.cy {
+		return
+	}
+	line, _, ok := info.parseRow(buf.Row(buf.cy).Data)
+	if !ok {
+		Global.Input = "No occurrence on this line"
+		return
+	}
+	if !bufferAlive(info.source) {
+		Global.Input = "The buffer these lines came from is gone"
+		return
+	}
+	src := info.source
+	win := getWindowWithProps(func(t *winTree) bool { return t.buf == src }, Global.WindowTree)
+	if win != nil {
+		getFocusWindow().focused = false
+		win.setFocus()
+	} else {
+		getFocusWindow().buf = src
+		Global.CurrentB = src
+	}
+	src.cy = line
+	src.cx = 0
+	if m := info.re.FindStringIndex(src.Row(line).Data); m != nil {
+		src.cx = m[0]
+	}
+	src.prefcx = src.cx
+	editorCentreView()
+}
+
+// Move to the next (or, if delta is negative, previous) occurrence.
+func occurNext(delta int) {
+	buf := Global.CurrentB
+	if buf.occur == nil {
+		return
+	}
+	for cy := buf.cy + delta; 0 <= cy && cy < buf.NumRows(); cy += delta {
+		if _, _, ok := buf.occur.parseRow(buf.Row(cy).Data); ok {
+			buf.cy, buf.cx, buf.prefcx = cy, 0, 0
+			return
+		}
+	}
+	Global.Input = "No more occurrences"
+}
+
+// List the matching lines again, e.g. after editing the source.
+func occurRevert() {
+	buf := Global.CurrentB
+	if buf.occur == nil {
+		return
+	}
+	if !bufferAlive(buf.occur.source) {
+		Global.Input = "The buffer these lines came from is gone"
+		return
+	}
+	cy := buf.cy
+	fillOccur(buf, buf.occur)
+	if cy < buf.NumRows() {
+		buf.cy = cy
+	}
+}
+
+// Let the occur buffer be edited.
+func occurEditMode() {
+	buf := Global.CurrentB
+	if buf.occur == nil {
+		return
+	}
+	buf.setMode("read-only-mode", false)
+	buf.MajorMode = "occur-edit"
+	Global.Input = "Editing; C-c C-c to write the changes back"
+}
+
+// Write every line that's been changed in the occur buffer back to the
+// source buffer. Returns how many lines were changed. Nothing is written if a
+// line that was edited has changed in the source since it was listed, since
+// it may not even be the same line any more.
+func occurWriteBack(buf *EditorBuffer) (int, error) {
+	info := buf.occur
+	src := info.source
+	lines, texts := []int{}, []string{}
+	stale := false
+	buf.EachRow(1, buf.NumRows(), func(i int, row *EditorRow) bool {
+		line, text, ok := splitOccurRow(row.Data)
+		orig, listed := info.original[line]
+		if !ok || !listed || text == orig {
+			return true
+		}
+		if src.NumRows() <= line || src.Row(line).Data != orig {
+			stale = true
+			return false
+		}
+		lines = append(lines, line)
+		texts = append(texts, text)
+		return true
+	})
+	if stale {
+		return 0, errors.New("Source changed; g to refresh")
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+	barfIfReadOnly(src)
+	cur, cx, cy, prefcx := Global.CurrentB, src.cx, src.cy, src.prefcx
+	Global.CurrentB = src
+	defer func() {
+		Global.CurrentB = cur
+		src.cx, src.cy, src.prefcx = cx, cy, prefcx
+	}()
+	for i, line := range lines {
+		text := texts[i]
+		transposeRegion(src, 0, src.Row(line).Size, line, line,
+			func(string) string { return text })
+	}
+	return len(lines), nil
+}
+
+// Write the changes back and stop editing.
+func occurCeaseEdit() {
+	buf := Global.CurrentB
+	if buf.occur == nil {
+		return
+	}
+	if !bufferAlive(buf.occur.source) {
+		Global.Input = "The buffer these lines came from is gone"
+		return
+	}
+	n, err := occurWriteBack(buf)
+	if err != nil {
+		// Back to occur mode, where g lists the lines afresh
+		buf.MajorMode = "occur"
+		buf.setMode("read-only-mode", true)
+		Global.Input = err.Error()
+		return
+	}
+	cy := buf.cy
+	fillOccur(buf, buf.occur)
+	if cy < buf.NumRows() {
+		buf.cy = cy
+	}
+	Global.Input = fmt.Sprintf("Changed %d lines in %s", n, buf.occur.source.getRenderName())
+}
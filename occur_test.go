@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOccurLists(t *testing.T) {
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	src := setLines("func a() {}", "var x", "func b() {}", "", "", "", "", "", "", "Func c")
+	re, _ := compileSearch("func", true)
+	occ := namedBuffer("*Occur*")
+	n := fillOccur(occ, &occurInfo{src, "func", re, nil})
+	if n != 3 {
+		t.Errorf("Expected 3 matching lines, got %d", n)
+	}
+	occ.FailIfBufferNe([]string{
+		`3 matching lines for "func" in *unnamed buffer*`,
+		" 1:func a() {}",
+		" 3:func b() {}",
+		"10:Func c",
+	}, t)
+	if !occ.hasMode("read-only-mode") || occ.MajorMode != "occur" {
+		t.Error("Expected the occur buffer to be read-only, in occur mode")
+	}
+	if namedBuffer("*Occur*") != occ {
+		t.Error("Expected occur to reuse its buffer")
+	}
+
+	Global.CurrentB = occ
+	occurNext(1)
+	if occ.cy != 2 {
+		t.Errorf("Expected occur-next to go to row 2, got %d", occ.cy)
+	}
+	occurNext(-1)
+	occurNext(-1)
+	if occ.cy != 1 {
+		t.Errorf("Expected occur-prev to stop at the first occurrence, got %d", occ.cy)
+	}
+	occurNext(1)
+	occurGotoOccurrence()
+	if Global.CurrentB != src || src.cy != 2 || src.cx != 0 {
+		t.Errorf("Expected to go to line 3 of the source, got %d:%d", src.cy+1, src.cx)
+	}
+}
+
+func TestOccurEdit(t *testing.T) {
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	src := setLines("foo one", "bar", "foo two", "foo three")
+	re, _ := compileSearch("foo", true)
+	occ := namedBuffer("*Occur*")
+	fillOccur(occ, &occurInfo{src, "foo", re, nil})
+	Global.CurrentB = occ
+	occurEditMode()
+	if occ.hasMode("read-only-mode") || occ.MajorMode != "occur-edit" {
+		t.Fatal("Expected occur-edit-mode to make the buffer editable")
+	}
+	runAsCommand("occur-edit", func() {
+		occ.cy, occ.cx = 1, occ.Row(1).Size
+		editorInsertStr("!")
+		occ.cy, occ.cx = 3, 2
+		editorInsertStr("FOO ")
+		occ.cy, occ.cx = 2, 0
+		editorInsertStr("garbage")
+	})
+	runAsCommand("occur-cease-edit", occurCeaseEdit)
+	src.FailIfBufferNe([]string{"foo one!", "bar", "foo two", "FOO foo three"}, t)
+	if Global.CurrentB != occ || occ.MajorMode != "occur" {
+		t.Error("Expected to be back in occur mode")
+	}
+	occ.FailIfBufferNe([]string{
+		`3 matching lines for "foo" in *unnamed buffer*`,
+		"1:foo one!",
+		"3:foo two",
+		"4:FOO foo three",
+	}, t)
+	Global.CurrentB = src
+	editorUndoAction()
+	src.FailIfBufferNe([]string{"foo one", "bar", "foo two", "foo three"}, t)
+}
+
+func TestOccurEditSourceChanged(t *testing.T) {
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	src := setLines("foo one", "bar", "foo two")
+	re, _ := compileSearch("foo", true)
+	occ := namedBuffer("*Occur*")
+	fillOccur(occ, &occurInfo{src, "foo", re, nil})
+	src.cy, src.cx = 0, 0
+	Global.CurrentB = src
+	editorInsertNewline(false)
+	Global.CurrentB = occ
+	occurEditMode()
+	runAsCommand("occur-edit", func() {
+		occ.cy, occ.cx = 1, occ.Row(1).Size
+		editorInsertStr("!")
+	})
+	runAsCommand("occur-cease-edit", occurCeaseEdit)
+	src.FailIfBufferNe([]string{"", "foo one", "bar", "foo two"}, t)
+	if Global.Input != "Source changed; g to refresh" || occ.MajorMode != "occur" {
+		t.Errorf("Expected the write to be refused, got %q", Global.Input)
+	}
+
+	// Nor if the line has gone
+	occurRevert()
+	occurEditMode()
+	runAsCommand("occur-edit", func() {
+		occ.cy, occ.cx = 2, occ.Row(2).Size
+		editorInsertStr("!")
+	})
+	Global.CurrentB = src
+	src.cy, src.cx = 1, 0
+	editorDelChar()
+	Global.CurrentB = occ
+	runAsCommand("occur-cease-edit", occurCeaseEdit)
+	if Global.Input != "Source changed; g to refresh" {
+		t.Errorf("Expected the write to be refused, got %q", Global.Input)
+	}
+	src.FailIfBufferNe([]string{"foo one", "bar", "foo two"}, t)
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || solaris || openbsd || netbsd || freebsd)
+// +build !linux,!darwin,!dragonfly,!solaris,!openbsd,!netbsd,!freebsd
+
+package main
+
+import "os"
+
+// do nothing, file ownership is a posix specific feature at the moment
+func copyFileOwner(fn string, info os.FileInfo) {}
+
+func fileLinks(info os.FileInfo) uint64 { return 1 }
+
+func syncDir(dir string) {}
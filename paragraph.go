@@ -8,7 +8,7 @@ func indexPreviousBlankLine() int {
 		return 0
 	}
 	for i := Global.CurrentB.cy - 1; 0 < i; i-- {
-		if Global.CurrentB.Rows[i].Size == 0 {
+		if Global.CurrentB.Row(i).Size == 0 {
 			return i
 		}
 	}
@@ -16,18 +16,18 @@ func indexPreviousBlankLine() int {
 }
 
 func indexNextBlankLine() int {
-	if Global.CurrentB.cy == Global.CurrentB.NumRows {
+	if Global.CurrentB.cy == Global.CurrentB.NumRows() {
 		Global.Input = "End of buffer"
-		return Global.CurrentB.NumRows
-	} else if Global.CurrentB.cy == Global.CurrentB.NumRows-1 {
-		return Global.CurrentB.NumRows
+		return Global.CurrentB.NumRows()
+	} else if Global.CurrentB.cy == Global.CurrentB.NumRows()-1 {
+		return Global.CurrentB.NumRows()
 	}
-	for i := Global.CurrentB.cy + 1; i < Global.CurrentB.NumRows; i++ {
-		if Global.CurrentB.Rows[i].Size == 0 {
+	for i := Global.CurrentB.cy + 1; i < Global.CurrentB.NumRows(); i++ {
+		if Global.CurrentB.Row(i).Size == 0 {
 			return i
 		}
 	}
-	return Global.CurrentB.NumRows
+	return Global.CurrentB.NumRows()
 }
 
 func backwardParagraph() {
@@ -50,10 +50,10 @@ func forwardParagraph() {
 
 func doAutoFillParagraph() {
 	buf := Global.CurrentB
-	if buf.NumRows == 0 {
+	if buf.NumRows() == 0 {
 		return
 	}
-	row := buf.Rows[buf.cy]
+	row := buf.Row(buf.cy)
 	if buf.hasMode("aggressive-fill-mode") && row.RenderSize >= Global.Fillcolumn {
 		doFillParagraph()
 	} else if buf.hasMode("auto-fill-mode") && editorRowCxToRx(row) >= Global.Fillcolumn {
@@ -66,7 +66,7 @@ func doAutoFillParagraph() {
 }
 
 func doFillParagraph() {
-	if Global.CurrentB.NumRows == 0 {
+	if Global.CurrentB.NumRows() == 0 {
 		return
 	}
 	startl := indexPreviousBlankLine()
@@ -77,7 +77,7 @@ func doFillParagraph() {
 }
 
 func doFillLines(startl, endl int) {
-	transposeRegion(Global.CurrentB, 0, Global.CurrentB.Rows[endl].Size, startl, endl, FillString)
+	transposeRegion(Global.CurrentB, 0, Global.CurrentB.Row(endl).Size, startl, endl, FillString)
 }
 
 func savePointBeforeFill(startl, endl int) (int, bool) {
@@ -86,7 +86,7 @@ func savePointBeforeFill(startl, endl int) (int, bool) {
 	space := false
 rowloop:
 	for cy := startl; cy <= endl; cy++ {
-		for cx, rv := range buf.Rows[cy].Data {
+		for cx, rv := range buf.Row(cy).Data {
 			// Spaces excluded from the count because they can be added or
 			// removed by the fill.
 			if rv != ' ' {
@@ -114,7 +114,7 @@ func restorePointAfterFill(startl, endl, runeidx int, space bool) {
 	buf := Global.CurrentB
 	cur_runeidx := 0
 	for cy := startl; cy <= endl; cy++ {
-		for cx, rv := range buf.Rows[cy].Data {
+		for cx, rv := range buf.Row(cy).Data {
 			if rv != ' ' {
 				cur_runeidx++
 			}
@@ -0,0 +1,27 @@
+//go:build !(linux || darwin || dragonfly || solaris || openbsd || netbsd || freebsd)
+// +build !linux,!darwin,!dragonfly,!solaris,!openbsd,!netbsd,!freebsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Process groups are a POSIX thing; elsewhere we only kill the process itself.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Signal(os.Interrupt)
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Ptys are only done on Linux for now.
+func setPtySize(pty *os.File, rows, cols int) error {
+	return nil
+}
+
+func startInPty(cmd *exec.Cmd, rows, cols int) (*os.File, error) {
+	return nil, errors.New("Terminals aren't supported on this platform")
+}
@@ -22,8 +22,8 @@ func doStringRectangle() {
 
 func (buf *EditorBuffer) stringRectangle(rep string, rect rectangle) {
 	addRectUndo(false, buf, rect)
-	for i := rect.TopLeftY; i <= rect.BotRightY && i < buf.NumRows; i++ {
-		rectReplace(rect.TopLeftX, rect.BotRightX, buf.Rows[i], buf, rep)
+	for i := rect.TopLeftY; i <= rect.BotRightY && i < buf.NumRows(); i++ {
+		rectReplace(rect.TopLeftX, rect.BotRightX, buf.Row(i), buf, rep)
 	}
 	if rect.TopLeftX+len(rep) != rect.BotRightX {
 		rect.BotRightX = rect.TopLeftX + len(rep)
@@ -42,8 +42,8 @@ func addRectUndo(ins bool, buf *EditorBuffer, rect rectangle) {
 // HACK: Horrid signature. I need a region struct, but I'm too lazy
 func rectToRegion(buf *EditorBuffer, rect rectangle) (int, int, int, int) {
 	startc, endc, startl, endl := rect.TopLeftX, rect.BotRightX, rect.TopLeftY, rect.BotRightY
-	if endc > buf.Rows[endl].Size {
-		endc = buf.Rows[endl].Size
+	if endc > buf.Row(endl).Size {
+		endc = buf.Row(endl).Size
 	}
 	return startc, endc, startl, endl
 }
@@ -85,7 +85,7 @@ func (buf *EditorBuffer) getRectangle() rectangle {
 
 func doCopyRectangle() {
 	if validMark(Global.CurrentB) {
-		Global.Clipboard = Global.CurrentB.copyRect()
+		pushKill(Global.CurrentB.copyRect())
 		Global.Input = "Copied rectangle to clipboard"
 		Global.CurrentB.regionActive = false
 	} else {
@@ -96,11 +96,11 @@ func doCopyRectangle() {
 func (buf *EditorBuffer) copyRect() string {
 	var buffer bytes.Buffer
 	rect := buf.getRectangle()
-	for i := rect.TopLeftY; i <= rect.BotRightY && i < buf.NumRows; i++ {
+	for i := rect.TopLeftY; i <= rect.BotRightY && i < buf.NumRows(); i++ {
 		if i != rect.TopLeftY {
 			buffer.WriteRune('\n')
 		}
-		row := buf.Rows[i]
+		row := buf.Row(i)
 		width := rect.BotRightX - rect.TopLeftX
 		if rect.TopLeftX > row.Size {
 			for i := 0; i < width; i++ {
@@ -133,7 +133,7 @@ func rectToRegister() {
 
 func doKillRectangle() {
 	if validMark(Global.CurrentB) {
-		Global.Clipboard = Global.CurrentB.copyRect()
+		pushKill(Global.CurrentB.copyRect())
 		Global.CurrentB.stringRectangle("", Global.CurrentB.getRectangle())
 		Global.Input = "Killed rectangle"
 		Global.CurrentB.regionActive = false
@@ -143,13 +143,13 @@ func doKillRectangle() {
 }
 
 func doYankRectangle() {
-	yankRectangle(Global.CurrentB, Global.Clipboard)
+	yankRectangle(Global.CurrentB, currentKill())
 	Global.Input = "Yanked rectangle from clipboard."
 	Global.CurrentB.regionActive = false
 }
 
 func yankRectangle(buf *EditorBuffer, rect string) {
-	if buf.cy >= buf.NumRows {
+	if buf.cy >= buf.NumRows() {
 		doYankText(rect)
 	} else {
 		lines := strings.Split(rect, "\n")
@@ -157,29 +157,29 @@ func yankRectangle(buf *EditorBuffer, rect string) {
 
 		startc, startl := buf.cx, buf.cy
 		var endc, endl int
-		if startl+ll >= buf.NumRows {
-			endl = buf.NumRows - 1
-			endc = buf.Rows[endl].Size
+		if startl+ll >= buf.NumRows() {
+			endl = buf.NumRows() - 1
+			endc = buf.Row(endl).Size
 		} else {
 			endl = startl + ll
-			endc = buf.Rows[endl].Size
+			endc = buf.Row(endl).Size
 		}
 		editorAddRegionUndo(false, startc, endc, startl, endl,
 			getRegionText(buf, startc, endc, startl, endl))
 
 		for i, line := range lines {
 			index := buf.cy + i
-			if index >= buf.NumRows {
+			if index >= buf.NumRows() {
 				editorAppendRow("")
 			}
-			rectReplace(buf.cx, buf.cx, buf.Rows[index], buf, line)
+			rectReplace(buf.cx, buf.cx, buf.Row(index), buf, line)
 		}
 
 		endl = startl + ll
-		if endl >= buf.NumRows {
-			endl = buf.NumRows - 1
+		if endl >= buf.NumRows() {
+			endl = buf.NumRows() - 1
 		}
-		endc = buf.Rows[endl].Size
+		endc = buf.Row(endl).Size
 		editorAddRegionUndo(true, startc, endc, startl, endl,
 			getRegionText(buf, startc, endc, startl, endl))
 		buf.Undo.paired = true
@@ -27,14 +27,14 @@ func (buf *EditorBuffer) setRegion(startc, startl, endc, endl int) {
 	}
 	region := buf.region
 	region.startl = startl
-	if region.startl < buf.NumRows {
-		region.startc = buf.Rows[region.startl].cxToRx(startc)
+	if region.startl < buf.NumRows() {
+		region.startc = buf.Row(region.startl).cxToRx(startc)
 	} else {
 		region.startc = 0
 	}
 	region.endl = endl
-	if region.endl < buf.NumRows {
-		region.endc = buf.Rows[region.endl].cxToRx(endc)
+	if region.endl < buf.NumRows() {
+		region.endc = buf.Row(region.endl).cxToRx(endc)
 	} else {
 		region.endc = 0
 	}
@@ -67,7 +67,7 @@ func (buf *EditorBuffer) recalcRegion() {
 }
 
 func validMark(buf *EditorBuffer) bool {
-	return buf.cy < buf.NumRows && buf.MarkY < buf.NumRows && buf.MarkX <= len(buf.Rows[buf.MarkY].Data)
+	return buf.cy < buf.NumRows() && buf.MarkY < buf.NumRows() && buf.MarkX <= len(buf.Row(buf.MarkY).Data)
 }
 
 func doSwapMarkAndCursor(buf *EditorBuffer) {
@@ -83,8 +83,9 @@ func doSwapMarkAndCursor(buf *EditorBuffer) {
 }
 
 func rowDelRange(row *EditorRow, startc, endc int, buf *EditorBuffer) string {
+	line := row.Line()
 	editorAddDeleteUndo(startc, endc,
-		row.idx, row.idx, row.Data[startc:endc])
+		line, line, row.Data[startc:endc])
 	ret := row.Data[startc:endc]
 	editorRowDelChar(row, buf, startc, endc-startc)
 	return ret
@@ -92,8 +93,9 @@ func rowDelRange(row *EditorRow, startc, endc int, buf *EditorBuffer) string {
 
 // Kills a region, returns the killed text.
 func bufKillRegion(buf *EditorBuffer, startc, endc, startl, endl int) string {
+	barfIfReadOnly(buf)
 	var ret string
-	row := buf.Rows[startl]
+	row := buf.Row(startl)
 	if startl == endl {
 		ret = row.Data[startc:endc]
 		editorRowDelChar(row, buf, startc, endc-startc)
@@ -107,32 +109,25 @@ func bufKillRegion(buf *EditorBuffer, startc, endc, startl, endl int) string {
 
 		// Collect data from middle rows
 		for i := startl + 1; i < endl; i++ {
-			bb.WriteString(buf.Rows[i].Data)
+			bb.WriteString(buf.Row(i).Data)
 			bb.WriteRune('\n')
 		}
 
 		// Collect data from last row
-		row = buf.Rows[endl]
+		row = buf.Row(endl)
 		bb.WriteString(row.Data[:endc])
 		row.Data = row.Data[endc:]
 
 		// Append last row's data to first row
-		buf.Rows[startl].Data += row.Data
-		buf.Rows[startl].Size = len(buf.Rows[startl].Data)
-		rowUpdateRender(buf.Rows[startl])
+		buf.Row(startl).Data += row.Data
+		buf.Row(startl).Size = len(buf.Row(startl).Data)
+		rowUpdateRender(buf.Row(startl))
 		ret = bb.String()
 
 		// Cut region out of rows
-		i, j := startl+1, endl+1
-		copy(buf.Rows[i:], buf.Rows[j:])
-		for k, n := len(buf.Rows)-j+i, len(buf.Rows); k < n; k++ {
-			buf.Rows[k] = nil // or the zero value of T
-		}
-		buf.Rows = buf.Rows[:len(buf.Rows)-j+i]
-		buf.NumRows = len(buf.Rows)
+		buf.DeleteRows(startl+1, endl+1)
 
 		// Update the buffer and return
-		updateLineIndexes()
 		buf.Highlight()
 	}
 	buf.cx = startc
@@ -144,18 +139,18 @@ func bufKillRegion(buf *EditorBuffer, startc, endc, startl, endl int) string {
 
 func getRegionText(buf *EditorBuffer, startc, endc, startl, endl int) string {
 	if startl == endl {
-		return buf.Rows[startl].Data[startc:endc]
+		return buf.Row(startl).Data[startc:endc]
 	} else {
 		var bb bytes.Buffer
-		row := buf.Rows[startl]
+		row := buf.Row(startl)
 		bb.WriteString(row.Data[startc:])
 		bb.WriteRune('\n')
 		for i := startl + 1; i < endl; i++ {
-			row = buf.Rows[i]
+			row = buf.Row(i)
 			bb.WriteString(row.Data)
 			bb.WriteRune('\n')
 		}
-		row = buf.Rows[endl]
+		row = buf.Row(endl)
 		bb.WriteString(row.Data[:endc])
 		return bb.String()
 	}
@@ -194,7 +189,7 @@ func doKillRegion() {
 		return ret
 	})
 	if err == nil {
-		Global.Clipboard = res
+		addKill(res, false)
 		Global.CurrentB.regionActive = false
 	}
 }
@@ -202,21 +197,22 @@ func doKillRegion() {
 func doCopyRegion() {
 	res, err := regionCmd(bufCopyRegion)
 	if err == nil {
-		Global.Clipboard = res
+		pushKill(res)
 		Global.CurrentB.regionActive = false
 	}
 }
 
 func spitRegion(cx, cy int, region string) (int, int) {
+	barfIfReadOnly(Global.CurrentB)
 	Global.CurrentB.Dirty = true
 	Global.CurrentB.cx = cx
 	Global.CurrentB.prefcx = cx
 	Global.CurrentB.cy = cy
 	clipLines := strings.Split(region, "\n")
-	if cy == Global.CurrentB.NumRows {
+	if cy == Global.CurrentB.NumRows() {
 		editorAppendRow("")
 	}
-	row := Global.CurrentB.Rows[cy]
+	row := Global.CurrentB.Row(cy)
 	data := row.Data
 	row.Data = data[:cx] + clipLines[0]
 	row.Size = len(row.Data)
@@ -243,21 +239,17 @@ func spitRegion(cx, cy int, region string) (int, int) {
 			rowUpdateRender(myrows[mrlen-1])
 		}
 
-		if cy < Global.CurrentB.NumRows {
-			Global.CurrentB.Rows = append(Global.CurrentB.Rows[:cy+1], append(myrows, Global.CurrentB.Rows[cy+1:]...)...)
-
-		} else {
-			Global.CurrentB.Rows = append(Global.CurrentB.Rows[:cy], myrows...)
-		}
-		Global.CurrentB.NumRows = len(Global.CurrentB.Rows)
-		updateLineIndexes()
-		if Global.CurrentB.Highlighter != nil {
+		Global.CurrentB.InsertRows(cy+1, myrows...)
+		if Global.CurrentB.LargeFile {
+			// The new rows get highlighted when they're drawn
+			editorReHighlightRow(row, Global.CurrentB)
+		} else if Global.CurrentB.Highlighter != nil {
 			Global.CurrentB.Highlighter.HighlightStates(Global.CurrentB)
 			if cy == 0 {
-				Global.CurrentB.Highlighter.HighlightMatches(Global.CurrentB, 0, Global.CurrentB.NumRows)
+				Global.CurrentB.Highlighter.HighlightMatches(Global.CurrentB, 0, Global.CurrentB.NumRows())
 
 			} else {
-				Global.CurrentB.Highlighter.HighlightMatches(Global.CurrentB, cy-1, Global.CurrentB.NumRows)
+				Global.CurrentB.Highlighter.HighlightMatches(Global.CurrentB, cy-1, Global.CurrentB.NumRows())
 
 			}
 		}
@@ -279,43 +271,52 @@ func doYankText(text string) {
 }
 
 func doYankRegion() {
-	doYankText(Global.Clipboard)
+	syncKillFromClipboard()
+	Global.yankX, Global.yankY = Global.CurrentB.cx, Global.CurrentB.cy
+	doYankText(currentKill())
 	Global.CurrentB.regionActive = false
 }
 
 func killToEol() {
 	cx := Global.CurrentB.cx
 	cy := Global.CurrentB.cy
-	if cy == Global.CurrentB.NumRows {
+	if cy == Global.CurrentB.NumRows() {
 		return
 	}
 	if Global.SetUniversal && Global.Universal != 1 {
 		if Global.Universal == 0 {
-			if 0 < Global.CurrentB.cx && cy < Global.CurrentB.NumRows {
-				Global.Clipboard = rowDelRange(Global.CurrentB.Rows[cy], 0, cx, Global.CurrentB)
+			if 0 < Global.CurrentB.cx && cy < Global.CurrentB.NumRows() {
+				addKill(rowDelRange(Global.CurrentB.Row(cy), 0, cx, Global.CurrentB), true)
 				Global.CurrentB.cx = 0
 			}
 		} else if 1 < Global.Universal {
 			endl := cy + Global.Universal
-			if Global.CurrentB.NumRows < endl {
-				endl = Global.CurrentB.NumRows - 1
+			if Global.CurrentB.NumRows() < endl {
+				endl = Global.CurrentB.NumRows() - 1
 			}
-			Global.Clipboard = bufKillRegion(Global.CurrentB, cx, 0, cy, endl)
-			editorAddRegionUndo(false, cx, 0, cy, endl, Global.Clipboard)
+			killed := bufKillRegion(Global.CurrentB, cx, 0, cy, endl)
+			editorAddRegionUndo(false, cx, 0, cy, endl, killed)
+			addKill(killed, false)
 		} else {
 			startl := cy + Global.Universal
 			if startl < 0 {
 				startl = 0
 			}
-			Global.Clipboard = bufKillRegion(Global.CurrentB, 0, cx, startl, cy)
-			editorAddRegionUndo(false, 0, cx, startl, cy, Global.Clipboard)
+			killed := bufKillRegion(Global.CurrentB, 0, cx, startl, cy)
+			editorAddRegionUndo(false, 0, cx, startl, cy, killed)
+			addKill(killed, true)
 		}
 	} else {
-		if cx >= Global.CurrentB.Rows[cy].Size {
-			Global.CurrentB.MoveCursorRight()
-			editorDelChar()
+		if cx >= Global.CurrentB.Row(cy).Size {
+			if cy < Global.CurrentB.NumRows()-1 {
+				Global.CurrentB.MoveCursorRight()
+				editorDelChar()
+				addKill("\n", false)
+			} else {
+				Global.Input = "End of buffer"
+			}
 		} else {
-			Global.Clipboard = rowDelRange(Global.CurrentB.Rows[cy], cx, Global.CurrentB.Rows[cy].Size, Global.CurrentB)
+			addKill(rowDelRange(Global.CurrentB.Row(cy), cx, Global.CurrentB.Row(cy).Size, Global.CurrentB), false)
 		}
 	}
 }
@@ -487,19 +488,19 @@ func doTransposeChars() {
 	if cy == 0 && cx == 0 {
 		Global.Input = "Beginning of buffer"
 		return
-	} else if cy >= Global.CurrentB.NumRows-1 && Global.CurrentB.Rows[cy].Size <= cx {
+	} else if cy >= Global.CurrentB.NumRows()-1 && Global.CurrentB.Row(cy).Size <= cx {
 		Global.Input = "End of buffer"
 		return
-	} else if Global.CurrentB.Rows[cy].Size == 0 {
+	} else if Global.CurrentB.Row(cy).Size == 0 {
 		Global.Input = "Nothing to transpose."
 		return
-	} else if Global.CurrentB.Rows[cy].Size <= cx {
-		if Global.CurrentB.Rows[cy+1].Size == 0 {
+	} else if Global.CurrentB.Row(cy).Size <= cx {
+		if Global.CurrentB.Row(cy+1).Size == 0 {
 			Global.Input = "Nothing to transpose."
 			return
 		}
-		first := Global.CurrentB.Rows[cy].Data[cx-1]
-		second := Global.CurrentB.Rows[cy+1].Data[0]
+		first := Global.CurrentB.Row(cy).Data[cx-1]
+		second := Global.CurrentB.Row(cy + 1).Data[0]
 		transposeRegion(Global.CurrentB, cx-1, 1,
 			cy, cy+1,
 			func(string) string {
@@ -510,12 +511,12 @@ func doTransposeChars() {
 		Global.CurrentB.MoveCursorLeft()
 		cx = Global.CurrentB.cx
 		cy = Global.CurrentB.cy
-		if Global.CurrentB.Rows[cy].Size == 0 {
+		if Global.CurrentB.Row(cy).Size == 0 {
 			Global.Input = "Nothing to transpose."
 			return
 		}
-		first := Global.CurrentB.Rows[cy].Data[cx-1]
-		second := Global.CurrentB.Rows[cy+1].Data[0]
+		first := Global.CurrentB.Row(cy).Data[cx-1]
+		second := Global.CurrentB.Row(cy + 1).Data[0]
 		transposeRegion(Global.CurrentB, cx-1, 1,
 			cy, cy+1,
 			func(string) string {
@@ -523,8 +524,8 @@ func doTransposeChars() {
 			})
 		return
 	}
-	first := Global.CurrentB.Rows[cy].Data[cx-1]
-	second := Global.CurrentB.Rows[cy].Data[cx]
+	first := Global.CurrentB.Row(cy).Data[cx-1]
+	second := Global.CurrentB.Row(cy).Data[cx]
 	transposeRegion(Global.CurrentB, cx-1, cx+1,
 		cy, cy,
 		func(string) string {
@@ -538,7 +539,7 @@ func isRegionInvalid(startc, endc, startl, endl int) bool {
 
 func doTransposeWords() {
 	buf := Global.CurrentB
-	if buf.cy == buf.NumRows-1 && buf.cx == buf.Rows[buf.cy].Size {
+	if buf.cy == buf.NumRows()-1 && buf.cx == buf.Row(buf.cy).Size {
 		moveBackWord()
 		Global.Input = "Don't have two things to transpose"
 		return
@@ -547,12 +548,12 @@ func doTransposeWords() {
 	backcx, backcy := buf.cx, buf.cy
 	moveForwardWord()
 	ebackcx := buf.cx
-	first := buf.Rows[backcy].Data[backcx:ebackcx]
+	first := buf.Row(backcy).Data[backcx:ebackcx]
 	moveForwardWord()
 	eforthcx := buf.cx
 	moveBackWord()
 	forthcx, forthcy := buf.cx, buf.cy
-	second := buf.Rows[forthcy].Data[forthcx:eforthcx]
+	second := buf.Row(forthcy).Data[forthcx:eforthcx]
 	middle := getRegionText(buf, ebackcx, forthcx, backcy, forthcy)
 	if isRegionInvalid(backcx, eforthcx, backcy, forthcy) {
 		Global.Input = "Don't have two things to transpose"
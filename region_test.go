@@ -17,23 +17,23 @@ func selectTestRegion() {
 func TestCopyRegion(t *testing.T) {
 	selectTestRegion()
 	doCopyRegion()
-	if Global.Clipboard != "Test\ntest2" {
-		t.Error("Clipboard contents was:", Global.Clipboard)
+	if currentKill() != "Test\ntest2" {
+		t.Error("Kill ring contents was:", currentKill())
 	}
 }
 
 func TestKillRegion(t *testing.T) {
 	selectTestRegion()
 	doKillRegion()
-	if Global.Clipboard != "Test\ntest2" {
-		t.Error("Clipboard contents was:", Global.Clipboard)
+	if currentKill() != "Test\ntest2" {
+		t.Error("Kill ring contents was:", currentKill())
 	}
 	Global.CurrentB.FailIfBufferNe([]string{}, t)
 }
 
 func TestYankRegion(t *testing.T) {
 	InitEditor()
-	Global.Clipboard = "Test\ntest2"
+	pushKill("Test\ntest2")
 	doYankRegion()
 	Global.CurrentB.FailIfBufferNe([]string{"Test", "test2"}, t)
 }
@@ -52,11 +52,15 @@ func (r *RegisterList) getPositionRegister(register string) (*Register, error) {
 }
 
 func (r *RegisterList) setPositionRegister(register string) {
-	ret := r.getRegisterOrCreate(register)
-	ret.Type = RegisterPos
-	ret.PosBuffer = Global.CurrentB
-	ret.Posx = Global.CurrentB.cx
-	ret.Posy = Global.CurrentB.cy
+	r.getRegisterOrCreate(register).setPosition()
+}
+
+// Make the register hold where the cursor is.
+func (reg *Register) setPosition() {
+	reg.Type = RegisterPos
+	reg.PosBuffer = Global.CurrentB
+	reg.Posx = Global.CurrentB.cx
+	reg.Posy = Global.CurrentB.cy
 }
 
 func (r *RegisterList) storeMacroToRegister(register string) {
@@ -95,25 +99,28 @@ func (r *RegisterList) saveTextToRegister(register string) {
 
 func (r *RegisterList) jumpToPositionRegister(regname string) {
 	reg := r.Registers[regname]
-	if reg == nil {
-		return
-	} else if reg.Type == RegisterPos {
-		if reg.PosBuffer != Global.CurrentB {
-			win := getFocusWindow()
-			win.buf = reg.PosBuffer
-			Global.CurrentB = reg.PosBuffer
-		}
-		if reg.Posy >= Global.CurrentB.NumRows {
-			Global.CurrentB.cy = Global.CurrentB.NumRows
-			Global.CurrentB.cx = 0
+	if reg != nil && reg.Type == RegisterPos {
+		reg.jump()
+	}
+}
+
+// Go to the position a position register holds.
+func (reg *Register) jump() {
+	if reg.PosBuffer != Global.CurrentB {
+		win := getFocusWindow()
+		win.buf = reg.PosBuffer
+		Global.CurrentB = reg.PosBuffer
+	}
+	if reg.Posy >= Global.CurrentB.NumRows() {
+		Global.CurrentB.cy = Global.CurrentB.NumRows()
+		Global.CurrentB.cx = 0
+	} else {
+		Global.CurrentB.cy = reg.Posy
+		row := Global.CurrentB.Row(reg.Posy)
+		if reg.Posx > row.Size {
+			Global.CurrentB.cx = row.Size
 		} else {
-			Global.CurrentB.cy = reg.Posy
-			row := Global.CurrentB.Rows[reg.Posy]
-			if reg.Posx > row.Size {
-				Global.CurrentB.cx = row.Size
-			} else {
-				Global.CurrentB.cx = reg.Posx
-			}
+			Global.CurrentB.cx = reg.Posx
 		}
 	}
 }
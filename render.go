@@ -88,18 +88,14 @@ func trimString(s string, coloff int) (string, int) {
 func editorDrawRows(startx, starty, sx, sy int, buf *EditorBuffer, gutsize int) {
 	for y := starty; y < sy; y++ {
 		filerow := (y - starty) + buf.rowoff
-		if filerow >= buf.NumRows {
+		if filerow >= buf.NumRows() {
 			if buf.hasMode("tilde-mode") {
 				termbox.SetCell(startx+gutsize, y, '~', termbox.ColorBlue, termbox.ColorDefault)
 			}
 		} else {
-			row := buf.Rows[filerow]
+			row := buf.Row(filerow)
 			if gutsize > 0 {
-				termutil.Printstring(runewidth.FillLeft(LineNrToString(buf.Rows[filerow].idx+1), gutsize-2), startx, y)
-				termutil.PrintRune(startx+gutsize-2, y, '│', termbox.ColorDefault)
-				if row.coloff > 0 {
-					termutil.PrintRune(startx+gutsize-1, y, '←', termbox.ColorDefault)
-				}
+				drawGutter(startx, y, filerow, row, buf, gutsize)
 			}
 			if row.coloff < row.RenderSize {
 				ts, off := trimString(row.Render, row.coloff)
@@ -110,24 +106,22 @@ func editorDrawRows(startx, starty, sx, sy int, buf *EditorBuffer, gutsize int)
 			}
 		}
 	}
+	drawLazyHighlight(startx, starty, sx, sy, buf, gutsize)
+	drawCursors(startx, starty, sx, sy, buf, gutsize)
 }
 
 func editorDrawRowsFocused(startx, starty, sx, sy int, buf *EditorBuffer, gutsize int) {
 	termbox.SetCursor(startx, starty)
 	for y := starty; y < sy; y++ {
 		filerow := (y - starty) + buf.rowoff
-		if filerow >= buf.NumRows {
+		if filerow >= buf.NumRows() {
 			if buf.hasMode("tilde-mode") {
 				termbox.SetCell(startx+gutsize, y, '~', termbox.ColorBlue, termbox.ColorDefault)
 			}
 		} else {
-			row := buf.Rows[filerow]
+			row := buf.Row(filerow)
 			if gutsize > 0 {
-				termutil.Printstring(runewidth.FillLeft(LineNrToString(buf.Rows[filerow].idx+1), gutsize-2), startx, y)
-				termutil.PrintRune(startx+gutsize-2, y, '│', termbox.ColorDefault)
-				if row.coloff > 0 {
-					termutil.PrintRune(startx+gutsize-1, y, '←', termbox.ColorDefault)
-				}
+				drawGutter(startx, y, filerow, row, buf, gutsize)
 			}
 			if filerow == buf.cy {
 				termbox.SetCursor(startx+gutsize, y)
@@ -145,20 +139,42 @@ func editorDrawRowsFocused(startx, starty, sx, sy int, buf *EditorBuffer, gutsiz
 			}
 		}
 	}
+	drawLazyHighlight(startx, starty, sx, sy, buf, gutsize)
+	drawCursors(startx, starty, sx, sy, buf, gutsize)
 }
 
 func editorUpdateStatus(buf *EditorBuffer) string {
 	fn := buf.getRenderName()
+	if buf.NoFinalNewline {
+		fn += " [noeol]"
+	}
+	if len(buf.cursors) > 0 {
+		fn += fmt.Sprintf(" [%d cursors]", len(buf.cursors)+1)
+	}
+	if buf.vc != nil {
+		fn += buf.vc.status()
+	}
+	if buf.lsp != nil {
+		fn += buf.lsp.status(buf)
+	}
+	if buf.term != nil && buf.term.charMode {
+		fn += " [char]"
+	} else if buf.term != nil {
+		fn += " [line]"
+	}
 	dc := '-'
 	if buf.Dirty {
 		dc = '*'
+	} else if buf.hasMode("read-only-mode") {
+		dc = '%'
 	}
-	if buf.hasMode("column-bytes-mode") || buf.NumRows == 0 {
-		return fmt.Sprintf("-%c %s - (%s) %d:%d", dc, fn, buf.MajorMode,
-			buf.cy+1, buf.cx)
+	if buf.hasMode("column-bytes-mode") || buf.NumRows() == 0 {
+		return fmt.Sprintf("%c%c%c %s - (%s) %d:%d", buf.Coding.Mnemonic(),
+			buf.Eol.Mnemonic(), dc, fn, buf.MajorMode, buf.cy+1, buf.cx)
 	}
-	return fmt.Sprintf("-%c %s - (%s) %d:%d", dc, fn, buf.MajorMode,
-		buf.cy+1, buf.Rows[buf.cy].cxToRx(buf.cx))
+	return fmt.Sprintf("%c%c%c %s - (%s) %d:%d", buf.Coding.Mnemonic(),
+		buf.Eol.Mnemonic(), dc, fn, buf.MajorMode, buf.cy+1,
+		buf.Row(buf.cy).cxToRx(buf.cx))
 }
 
 func GetScreenSize() (int, int) {
@@ -229,16 +245,16 @@ func editorDrawStatusLine(x, y, wx int, t *winTree) {
 }
 
 func calcEndLabel(buf *EditorBuffer) string {
-	if buf.NumRows == 0 {
+	if buf.NumRows() == 0 {
 		return " Emp "
-	} else if Global.CurrentBHeight >= buf.NumRows {
+	} else if Global.CurrentBHeight >= buf.NumRows() {
 		return " All "
-	} else if buf.rowoff+Global.CurrentBHeight >= buf.NumRows {
+	} else if buf.rowoff+Global.CurrentBHeight >= buf.NumRows() {
 		return " Bot "
 	} else if buf.rowoff == 0 {
 		return " Top "
 	} else {
-		perc := float64(buf.rowoff) / float64(buf.NumRows)
+		perc := float64(buf.rowoff) / float64(buf.NumRows())
 		return fmt.Sprintf(" %2d%% ", int(perc*100))
 	}
 }
@@ -255,6 +271,65 @@ func GetGutterWidth(NumRows int) int {
 	return NumStrWidth(NumRows) + 2
 }
 
+// The width of the gutter left of buf's text: line numbers if they're on, and
+// a column of marks if the buffer has one.
+func gutterWidth(buf *EditorBuffer) int {
+	w := 0
+	if buf.hasMode("line-number-mode") && buf.NumRows() > 0 {
+		w = GetGutterWidth(buf.NumRows())
+	}
+	if buf.hasGutterMarks() {
+		if w == 0 {
+			w = 2
+		} else {
+			w++
+		}
+	}
+	return w
+}
+
+// Whether buf has a column of marks in its gutter.
+func (buf *EditorBuffer) hasGutterMarks() bool {
+	return buf.lsp != nil || buf.hasDiffGutter()
+}
+
+// The mark next to a row: the worst of the diagnostics on it, or if there are
+// none, how it differs from HEAD.
+func (buf *EditorBuffer) gutterMark(row int) (rune, termbox.Attribute) {
+	worst := 0
+	for _, d := range buf.lspDiagnostics() {
+		if d.Range.Start.Line == row && (worst == 0 || d.Severity < worst) {
+			worst = d.Severity
+		}
+	}
+	switch worst {
+	case 0:
+		return buf.diffGutterMark(row)
+	case 1:
+		return 'E', termbox.ColorRed | termbox.AttrBold
+	case 2:
+		return 'W', termbox.ColorYellow
+	default:
+		return 'I', termbox.ColorCyan
+	}
+}
+
+func drawGutter(x, y, filerow int, row *EditorRow, buf *EditorBuffer, gutsize int) {
+	if buf.hasGutterMarks() {
+		ru, fg := buf.gutterMark(filerow)
+		termbox.SetCell(x, y, ru, fg, termbox.ColorDefault)
+		x++
+		gutsize--
+	}
+	if 2 <= gutsize {
+		termutil.Printstring(runewidth.FillLeft(LineNrToString(filerow+1), gutsize-2), x, y)
+		termutil.PrintRune(x+gutsize-2, y, '│', termbox.ColorDefault)
+	}
+	if row.coloff > 0 {
+		termutil.PrintRune(x+gutsize-1, y, '←', termbox.ColorDefault)
+	}
+}
+
 func LineNrToString(num int) string {
 	return strconv.Itoa(num)
 }
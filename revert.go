@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+)
+
+// Remember the modification time and size of the buffer's file as we last
+// loaded or saved it, so that we can tell when something else changes it.
+func (buf *EditorBuffer) recordFileStat() {
+	info, err := os.Stat(buf.Filename)
+	if err != nil {
+		buf.modTime = time.Time{}
+		buf.fileSize = 0
+		return
+	}
+	buf.modTime = info.ModTime()
+	buf.fileSize = info.Size()
+}
+
+// Whether the buffer's file has been changed by something else since we last
+// loaded or saved it. A file that has been deleted doesn't count, since saving
+// it again won't lose anything.
+func (buf *EditorBuffer) changedOnDisk() bool {
+	if buf.Filename == "" {
+		return false
+	}
+	info, err := os.Stat(buf.Filename)
+	if err != nil {
+		return false
+	}
+	return !info.ModTime().Equal(buf.modTime) || info.Size() != buf.fileSize
+}
+
+// Reload the buffer from its file, throwing away any changes. Point and the
+// scroll position are kept as far as the new contents allow.
+func (buf *EditorBuffer) revert() error {
+	if buf.Filename == "" {
+		return errors.New("Buffer does not seem to be associated with any file")
+	}
+	err := buf.loadFile(buf.Filename)
+	if err != nil {
+		return err
+	}
+	buf.recordFileStat()
+	buf.Highlight()
+	buf.clearUndo()
+	vcRefresh(buf)
+	buf.Dirty = false
+	buf.regionActive = false
+	if buf.cy > buf.NumRows() {
+		buf.cy = buf.NumRows()
+	}
+	if buf.cy < buf.NumRows() {
+		if buf.cx > buf.Row(buf.cy).Size {
+			buf.cx = buf.Row(buf.cy).Size
+		}
+	} else {
+		buf.cx = 0
+	}
+	if buf.rowoff > buf.cy {
+		buf.rowoff = buf.cy
+	}
+	return nil
+}
+
+func revertBuffer(env *glisp.Zlisp) {
+	buf := Global.CurrentB
+	if buf.Filename == "" {
+		Global.Input = "Buffer does not seem to be associated with any file"
+		return
+	}
+	prompt := "Revert buffer from file " + buf.Filename + "?"
+	if buf.Dirty {
+		prompt = "Discard edits and reread from " + buf.Filename + "?"
+	}
+	ok, cancel := editorYesNoPrompt(prompt, false)
+	if !ok || cancel != nil {
+		Global.Input = "Cancelled."
+		return
+	}
+	err := buf.revert()
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+		return
+	}
+	Global.Input = "Reverted " + buf.getRenderName()
+}
+
+// Revert every unmodified buffer in auto-revert-mode whose file has changed.
+// Returns whether any buffer was reverted.
+func autoRevertBuffers() bool {
+	reverted := false
+	for _, buf := range Global.Buffers {
+		if buf.Dirty || !buf.hasMode("auto-revert-mode") || !buf.changedOnDisk() {
+			continue
+		}
+		err := buf.revert()
+		if err != nil {
+			// Don't try again until it changes again
+			buf.recordFileStat()
+			Global.Input = "Error reverting " + buf.getRenderName() + ": " + err.Error()
+		} else {
+			Global.Input = "Reverting buffer " + buf.getRenderName()
+		}
+		AddErrorMessage(Global.Input)
+		reverted = true
+	}
+	return reverted
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Change a file behind the editor's back, making sure the mtime moves on even
+// on filesystems with coarse timestamps.
+func changeBehindOurBack(fn, contents string) {
+	ioutil.WriteFile(fn, []byte(contents), 0644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(fn, future, future)
+}
+
+func TestChangedOnDisk(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	InitEditor()
+	EditorOpen(fn, nil)
+	if Global.CurrentB.changedOnDisk() {
+		t.Error("File reported as changed straight after opening it")
+	}
+	editorInsertStr("new ")
+	editorBufSave(Global.CurrentB, nil)
+	if Global.CurrentB.changedOnDisk() {
+		t.Error("File reported as changed straight after saving it")
+	}
+	changeBehindOurBack(fn, "changed\n")
+	if !Global.CurrentB.changedOnDisk() {
+		t.Error("File changed on disk but not reported")
+	}
+}
+
+func TestRevert(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("line 1\nline 2\nline 3\n"), 0644)
+	InitEditor()
+	EditorOpen(fn, nil)
+	buf := Global.CurrentB
+	buf.cy = 2
+	buf.cx = 5
+	buf.rowoff = 1
+	editorInsertStr("edited ")
+	changeBehindOurBack(fn, "line 1\nline 2\nline\n")
+	err := buf.revert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.FailIfBufferNe([]string{"line 1", "line 2", "line"}, t)
+	if buf.Dirty || buf.changedOnDisk() {
+		t.Error("Reverted buffer should be clean and up to date")
+	}
+	if buf.cy != 2 || buf.cx != 4 || buf.rowoff != 1 {
+		t.Errorf("Expected point at 2:4 scrolled to 1, got %d:%d scrolled to %d",
+			buf.cy, buf.cx, buf.rowoff)
+	}
+}
+
+func TestAutoRevert(t *testing.T) {
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean.txt")
+	dirty := filepath.Join(dir, "dirty.txt")
+	ioutil.WriteFile(clean, []byte("clean\n"), 0644)
+	ioutil.WriteFile(dirty, []byte("dirty\n"), 0644)
+	InitEditor()
+	Global.DefaultModes["auto-revert-mode"] = true
+	EditorOpen(clean, nil)
+	cleanbuf := Global.CurrentB
+	openFile(dirty, nil)
+	dirtybuf := Global.CurrentB
+	editorInsertStr("very ")
+	changeBehindOurBack(clean, "changed\n")
+	changeBehindOurBack(dirty, "changed\n")
+	if !autoRevertBuffers() {
+		t.Error("Expected a buffer to be reverted")
+	}
+	cleanbuf.FailIfBufferNe([]string{"changed"}, t)
+	// Modified buffers are never auto-reverted
+	dirtybuf.FailIfBufferNe([]string{"very dirty"}, t)
+
+	// Nor is anything while a command is running, only once it's over
+	Global.AutoRevertInterval = 1
+	changeBehindOurBack(clean, "again\n")
+	for _, job := range periodicJobs {
+		job.last = time.Time{}
+	}
+	runAsCommand("test-prompt", func() { runPeriodicJobs() })
+	cleanbuf.FailIfBufferNe([]string{"changed"}, t)
+	runPeriodicJobs()
+	cleanbuf.FailIfBufferNe([]string{"again"}, t)
+}
@@ -0,0 +1,362 @@
+package main
+
+// A buffer's rows are kept in a rope: a B+ tree whose leaves hold runs of rows
+// and whose interior nodes know how many rows lie beneath them. Finding,
+// inserting and deleting a row all take O(log n) time, and rows can find their
+// own line number by walking up the tree, so nothing ever needs renumbering.
+
+const (
+	ropeNodeMax = 64
+	ropeNodeMin = ropeNodeMax / 4
+)
+
+type ropeNode struct {
+	parent   *ropeNode
+	count    int          // Number of rows in this subtree
+	children []*ropeNode  // Interior nodes only
+	rows     []*EditorRow // Leaves only
+	lazy     *lazyChunk   // Leaves of large files that haven't been read yet
+}
+
+type rowRope struct {
+	root *ropeNode
+	// The leaf most recently looked up, and the line its first row is on, so
+	// that walking through the rows in order doesn't keep searching the tree.
+	cache      *ropeNode
+	cacheStart int
+}
+
+func newRowRope(rows []*EditorRow) *rowRope {
+	r := &rowRope{}
+	r.build(rows)
+	return r
+}
+
+func (n *ropeNode) isLeaf() bool {
+	return n.children == nil
+}
+
+// Replace the contents of the rope with rows, building a balanced tree
+// bottom-up in O(n) time.
+func (r *rowRope) build(rows []*EditorRow) {
+	leaves := []*ropeNode{}
+	for _, span := range ropeChunks(len(rows)) {
+		leaf := &ropeNode{rows: append([]*EditorRow{}, rows[span[0]:span[1]]...)}
+		leaf.count = len(leaf.rows)
+		for _, row := range leaf.rows {
+			row.leaf = leaf
+		}
+		leaves = append(leaves, leaf)
+	}
+	r.buildFromLeaves(leaves)
+}
+
+// Build the interior of the tree on top of a row of leaves.
+func (r *rowRope) buildFromLeaves(level []*ropeNode) {
+	r.cache = nil
+	if len(level) == 0 {
+		r.root = &ropeNode{rows: []*EditorRow{}}
+		return
+	}
+	for len(level) > 1 {
+		next := []*ropeNode{}
+		for _, span := range ropeChunks(len(level)) {
+			node := &ropeNode{children: append([]*ropeNode{}, level[span[0]:span[1]]...)}
+			for _, child := range node.children {
+				child.parent = node
+				node.count += child.count
+			}
+			next = append(next, node)
+		}
+		level = next
+	}
+	r.root = level[0]
+	r.root.parent = nil
+}
+
+// Divide n entries into evenly sized, half full nodes, returning the start
+// and end of each.
+func ropeChunks(n int) [][2]int {
+	nchunks := (n + ropeNodeMax/2 - 1) / (ropeNodeMax / 2)
+	ret := make([][2]int, nchunks)
+	start := 0
+	for i := range ret {
+		end := start + (n-start)/(nchunks-i)
+		ret[i] = [2]int{start, end}
+		start = end
+	}
+	return ret
+}
+
+func (r *rowRope) Len() int {
+	return r.root.count
+}
+
+// Find the leaf containing line n (or the last leaf, if n is the line just
+// past the end), and the line that leaf starts on.
+func (r *rowRope) findLeaf(n int) (*ropeNode, int) {
+	if r.cache != nil && r.cacheStart <= n && n < r.cacheStart+len(r.cache.rows) {
+		return r.cache, r.cacheStart
+	}
+	node := r.root
+	start := 0
+	for !node.isLeaf() {
+		i := 0
+		for ; i < len(node.children)-1; i++ {
+			if n < start+node.children[i].count {
+				break
+			}
+			start += node.children[i].count
+		}
+		node = node.children[i]
+	}
+	if node.lazy != nil {
+		node.materialize()
+	}
+	r.cache = node
+	r.cacheStart = start
+	return node, start
+}
+
+// Get the row on line n.
+func (r *rowRope) Get(n int) *EditorRow {
+	leaf, start := r.findLeaf(n)
+	return leaf.rows[n-start]
+}
+
+// Call f on each row from line from up to (but not including) line to, in
+// order. Stops early if f returns false.
+func (r *rowRope) Each(from, to int, f func(int, *EditorRow) bool) {
+	for n := from; n < to; {
+		leaf, start := r.findLeaf(n)
+		for _, row := range leaf.rows[n-start:] {
+			if n >= to || !f(n, row) {
+				return
+			}
+			n++
+		}
+	}
+}
+
+// Insert rows so that the first of them ends up on line at.
+func (r *rowRope) Insert(at int, rows ...*EditorRow) {
+	for i, row := range rows {
+		r.insertOne(at+i, row)
+	}
+}
+
+func (r *rowRope) insertOne(at int, row *EditorRow) {
+	r.cache = nil
+	leaf, start := r.findLeaf(at)
+	i := at - start
+	leaf.rows = append(leaf.rows, nil)
+	copy(leaf.rows[i+1:], leaf.rows[i:])
+	leaf.rows[i] = row
+	row.leaf = leaf
+	for node := leaf; node != nil; node = node.parent {
+		node.count++
+	}
+	if len(leaf.rows) > ropeNodeMax {
+		r.split(leaf)
+	}
+}
+
+// Delete the rows from line from up to (but not including) line to.
+func (r *rowRope) Delete(from, to int) {
+	for n := from; n < to; n++ {
+		r.deleteOne(from)
+	}
+}
+
+func (r *rowRope) deleteOne(at int) {
+	r.cache = nil
+	leaf, start := r.findLeaf(at)
+	i := at - start
+	leaf.rows[i].leaf = nil
+	copy(leaf.rows[i:], leaf.rows[i+1:])
+	leaf.rows[len(leaf.rows)-1] = nil
+	leaf.rows = leaf.rows[:len(leaf.rows)-1]
+	for node := leaf; node != nil; node = node.parent {
+		node.count--
+	}
+	r.rebalance(leaf)
+}
+
+// The number of entries (rows or children) in a node.
+func (n *ropeNode) width() int {
+	if n.lazy != nil {
+		return n.count
+	} else if n.isLeaf() {
+		return len(n.rows)
+	}
+	return len(n.children)
+}
+
+func (n *ropeNode) indexInParent() int {
+	for i, child := range n.parent.children {
+		if child == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// Split an overfull node in two, splitting its parent in turn if need be.
+func (r *rowRope) split(n *ropeNode) {
+	half := n.width() / 2
+	sibling := &ropeNode{parent: n.parent}
+	if n.isLeaf() {
+		sibling.rows = append([]*EditorRow{}, n.rows[half:]...)
+		n.rows = n.rows[:half:half]
+		for _, row := range sibling.rows {
+			row.leaf = sibling
+		}
+		sibling.count = len(sibling.rows)
+	} else {
+		sibling.children = append([]*ropeNode{}, n.children[half:]...)
+		n.children = n.children[:half:half]
+		for _, child := range sibling.children {
+			child.parent = sibling
+			sibling.count += child.count
+		}
+	}
+	n.count -= sibling.count
+
+	if n.parent == nil {
+		r.root = &ropeNode{children: []*ropeNode{n, sibling}, count: n.count + sibling.count}
+		n.parent = r.root
+		sibling.parent = r.root
+		return
+	}
+	parent := n.parent
+	i := n.indexInParent()
+	parent.children = append(parent.children, nil)
+	copy(parent.children[i+2:], parent.children[i+1:])
+	parent.children[i+1] = sibling
+	if len(parent.children) > ropeNodeMax {
+		r.split(parent)
+	}
+}
+
+// Merge an underfull node into one of its siblings, re-splitting if the result
+// is too big, and fixing up its parent in turn.
+func (r *rowRope) rebalance(n *ropeNode) {
+	if n.parent == nil {
+		// Shrink the tree when the root is left with only one child
+		for !r.root.isLeaf() && len(r.root.children) == 1 {
+			r.root = r.root.children[0]
+			r.root.parent = nil
+		}
+		return
+	}
+	if n.width() >= ropeNodeMin {
+		return
+	}
+	parent := n.parent
+	i := n.indexInParent()
+	var left, right *ropeNode
+	if i > 0 {
+		left, right = parent.children[i-1], n
+		i--
+	} else if len(parent.children) > 1 {
+		left, right = n, parent.children[i+1]
+	} else {
+		r.rebalance(parent)
+		return
+	}
+
+	// Move everything from right into left, and drop right
+	if left.isLeaf() {
+		for _, leaf := range []*ropeNode{left, right} {
+			if leaf.lazy != nil {
+				leaf.materialize()
+			}
+		}
+		for _, row := range right.rows {
+			row.leaf = left
+		}
+		left.rows = append(left.rows, right.rows...)
+	} else {
+		for _, child := range right.children {
+			child.parent = left
+		}
+		left.children = append(left.children, right.children...)
+	}
+	left.count += right.count
+	copy(parent.children[i+1:], parent.children[i+2:])
+	parent.children[len(parent.children)-1] = nil
+	parent.children = parent.children[:len(parent.children)-1]
+
+	if left.width() > ropeNodeMax {
+		r.split(left)
+	} else {
+		r.rebalance(parent)
+	}
+}
+
+// Work out which line a row is on by walking up the tree. Rows that aren't in
+// a buffer are on line -1.
+func (row *EditorRow) Line() int {
+	leaf := row.leaf
+	if leaf == nil {
+		return -1
+	}
+	line := 0
+	for i, r := range leaf.rows {
+		if r == row {
+			line = i
+			break
+		}
+	}
+	for node := leaf; node.parent != nil; node = node.parent {
+		for _, sibling := range node.parent.children {
+			if sibling == node {
+				break
+			}
+			line += sibling.count
+		}
+	}
+	return line
+}
+
+// The rest of the editor gets at a buffer's rows through these.
+
+func (buf *EditorBuffer) NumRows() int {
+	if buf.rows == nil {
+		return 0
+	}
+	return buf.rows.Len()
+}
+
+// Get the row on line n.
+func (buf *EditorBuffer) Row(n int) *EditorRow {
+	return buf.rows.Get(n)
+}
+
+// Insert rows so that the first of them ends up on line at.
+func (buf *EditorBuffer) InsertRows(at int, rows ...*EditorRow) {
+	if buf.rows == nil {
+		buf.rows = newRowRope(nil)
+	}
+	buf.rows.Insert(at, rows...)
+}
+
+// Delete the rows from line from up to (but not including) line to.
+func (buf *EditorBuffer) DeleteRows(from, to int) {
+	if buf.rows != nil {
+		buf.rows.Delete(from, to)
+	}
+}
+
+// Replace all of the buffer's rows.
+func (buf *EditorBuffer) SetRows(rows []*EditorRow) {
+	buf.rows = newRowRope(rows)
+}
+
+// Call f on each row from line from up to (but not including) line to, in
+// order. Stops early if f returns false.
+func (buf *EditorBuffer) EachRow(from, to int, f func(int, *EditorRow) bool) {
+	if buf.rows != nil {
+		buf.rows.Each(from, to, f)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func makeTestRows(n int, prefix string) []*EditorRow {
+	rows := make([]*EditorRow, n)
+	for i := range rows {
+		data := prefix + strconv.Itoa(i)
+		rows[i] = &EditorRow{Size: len(data), Data: data}
+	}
+	return rows
+}
+
+// Check that every node's count, parent and width are right, and every row
+// knows which leaf it's in.
+func checkRopeNode(n *ropeNode, isroot bool, t *testing.T) int {
+	if !isroot && (n.width() < ropeNodeMin || ropeNodeMax < n.width()) {
+		t.Fatal("Node has", n.width(), "entries")
+	}
+	count := 0
+	if n.isLeaf() {
+		for _, row := range n.rows {
+			if row.leaf != n {
+				t.Fatal("Row", row.Data, "has the wrong leaf")
+			}
+		}
+		count = len(n.rows)
+	} else {
+		for _, child := range n.children {
+			if child.parent != n {
+				t.Fatal("Node has the wrong parent")
+			}
+			count += checkRopeNode(child, false, t)
+		}
+	}
+	if count != n.count {
+		t.Fatal("Node has count", n.count, "but really has", count, "rows")
+	}
+	return count
+}
+
+func checkRope(r *rowRope, expected []*EditorRow, t *testing.T) {
+	checkRopeNode(r.root, true, t)
+	if r.Len() != len(expected) {
+		t.Fatal("Expected", len(expected), "rows but got", r.Len())
+	}
+	for i, row := range expected {
+		if r.Get(i) != row {
+			t.Fatal("Wrong row at line", i)
+		}
+		if row.Line() != i {
+			t.Fatal("Row at line", i, "thinks it's on line", row.Line())
+		}
+	}
+	r.Each(0, r.Len(), func(i int, row *EditorRow) bool {
+		if row != expected[i] {
+			t.Fatal("Each gave the wrong row at line", i)
+		}
+		return true
+	})
+}
+
+func TestRopeRandomEdits(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	model := makeTestRows(1000, "")
+	r := newRowRope(model)
+	checkRope(r, model, t)
+	for i := 0; i < 2000; i++ {
+		if rng.Intn(2) == 0 || len(model) == 0 {
+			at := rng.Intn(len(model) + 1)
+			rows := makeTestRows(1+rng.Intn(100), fmt.Sprint(i, "-"))
+			r.Insert(at, rows...)
+			model = append(model[:at], append(rows, model[at:]...)...)
+		} else {
+			from := rng.Intn(len(model))
+			to := from + rng.Intn(len(model)-from+1)
+			r.Delete(from, to)
+			model = append(model[:from], model[to:]...)
+		}
+		if i%100 == 0 {
+			checkRope(r, model, t)
+		}
+	}
+	checkRope(r, model, t)
+	r.Delete(0, r.Len())
+	checkRope(r, []*EditorRow{}, t)
+}
+
+func TestRopeEachStopsEarly(t *testing.T) {
+	rows := makeTestRows(500, "")
+	r := newRowRope(rows)
+	seen := 0
+	r.Each(100, 400, func(i int, row *EditorRow) bool {
+		seen++
+		return i < 199
+	})
+	if seen != 100 {
+		t.Error("Expected to see 100 rows but saw", seen)
+	}
+}
+
+func benchmarkBuffer(lines int) {
+	InitEditor()
+	Global.CurrentB.SetRows(makeTestRows(lines, "line "))
+}
+
+// Inserting and deleting a row in the middle of the buffer should take about
+// the same time whatever the size of the buffer.
+func BenchmarkInsertDeleteRow(b *testing.B) {
+	for _, lines := range []int{1000, 100000, 2000000} {
+		b.Run(strconv.Itoa(lines), func(b *testing.B) {
+			benchmarkBuffer(lines)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				editorInsertRow(lines/2, "new line")
+				editorDelRow(lines / 2)
+			}
+		})
+	}
+}
+
+func BenchmarkRowInsertDelChar(b *testing.B) {
+	for _, lines := range []int{1000, 100000, 2000000} {
+		b.Run(strconv.Itoa(lines), func(b *testing.B) {
+			benchmarkBuffer(lines)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				editorRowInsertStr(Global.CurrentB.Row(lines/2), Global.CurrentB, 0, "x")
+				editorRowDelChar(Global.CurrentB.Row(lines/2), Global.CurrentB, 0, 1)
+			}
+		})
+	}
+}
+
+func BenchmarkInsertNewline(b *testing.B) {
+	for _, lines := range []int{1000, 100000, 2000000} {
+		b.Run(strconv.Itoa(lines), func(b *testing.B) {
+			benchmarkBuffer(lines)
+			Global.CurrentB.cy = lines / 2
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				Global.CurrentB.cx = 0
+				// Don't let one huge undo record swamp the measurement
+				Global.CurrentB.Undo = nil
+				editorInsertNewline(false)
+			}
+		})
+	}
+}
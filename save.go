@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type BackupStyle uint8
+
+const (
+	BackupSimple BackupStyle = iota
+	BackupNumbered
+)
+
+var backupStyleNames = []string{"simple", "numbered"}
+
+func (s BackupStyle) String() string {
+	return backupStyleNames[s]
+}
+
+func parseBackupStyle(name string) (BackupStyle, error) {
+	for i, stylename := range backupStyleNames {
+		if name == stylename {
+			return BackupStyle(i), nil
+		}
+	}
+	return BackupSimple, fmt.Errorf("Unknown backup style %s (try %s)", name,
+		strings.Join(backupStyleNames, " or "))
+}
+
+// The mode bits that saving keeps: the permissions, and the setuid, setgid
+// and sticky bits.
+func keptMode(info os.FileInfo) os.FileMode {
+	return info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// Follow a chain of symlinks to the file that should actually be written. A
+// dangling link resolves to the path it points at, so saving creates it.
+func resolveSymlinks(fn string) (string, error) {
+	for i := 0; i < 255; i++ {
+		info, err := os.Lstat(fn)
+		if os.IsNotExist(err) {
+			return fn, nil
+		} else if err != nil {
+			return fn, err
+		} else if info.Mode()&os.ModeSymlink == 0 {
+			return fn, nil
+		}
+		target, err := os.Readlink(fn)
+		if err != nil {
+			return fn, err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(fn), target)
+		}
+		fn = target
+	}
+	return fn, fmt.Errorf("Too many levels of symbolic links: %s", fn)
+}
+
+// Write a file without ever leaving a half-written one behind. The data goes
+// into a temporary file in the same directory, which is synced to disk and
+// then renamed over the top of the original. Symlinks are followed, and the
+// original's permissions and (where possible) owner are kept. A file with
+// other hard links, or in a directory we can't make the temporary file in, is
+// written in place instead.
+func writeFileAtomic(fn string, data []byte) error {
+	target, err := resolveSymlinks(fn)
+	if err != nil {
+		return err
+	}
+	dir, base := filepath.Split(target)
+	perm := os.FileMode(0666)
+	info, staterr := os.Stat(target)
+	if staterr == nil {
+		perm = keptMode(info)
+		if 1 < fileLinks(info) {
+			// Renaming would leave the other links with the old contents
+			return writeFileInPlace(target, data, perm)
+		}
+	}
+
+	var f *os.File
+	var tmpname string
+	for i := 0; i < 100; i++ {
+		tmpname = filepath.Join(dir, fmt.Sprintf(".%s.%d~", base, rand.Int31()))
+		f, err = os.OpenFile(tmpname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return writeFileInPlace(target, data, perm)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && staterr == nil {
+		// Only root can give files away, so this is best-effort. It goes
+		// first since changing the owner clears the setuid and setgid bits.
+		copyFileOwner(tmpname, info)
+		// The umask may have eaten some of the bits
+		err = os.Chmod(tmpname, keptMode(info))
+	}
+	if err == nil {
+		err = os.Rename(tmpname, target)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// Overwrite fn where it is, as editors did before they saved atomically.
+func writeFileInPlace(fn string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func numberedBackupName(fn string, n int) string {
+	return fmt.Sprintf("%s.~%d~", fn, n)
+}
+
+// Find the version numbers of the existing numbered backups of fn, in order.
+func existingBackupNumbers(fn string) []int {
+	matches, _ := filepath.Glob(fn + ".~*~")
+	ret := []int{}
+	for _, match := range matches {
+		num := strings.TrimSuffix(strings.TrimPrefix(match, fn+".~"), "~")
+		n, err := strconv.Atoi(num)
+		if err == nil && 0 < n {
+			ret = append(ret, n)
+		}
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// Copy the file on disk to its backup before it's overwritten for the first
+// time. Returns the name of the backup, or "" if there was nothing to back up.
+func backupFile(fn string) (string, error) {
+	target, err := resolveSymlinks(fn)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		return "", err
+	}
+	var backup string
+	var prune []int
+	if Global.BackupStyle == BackupNumbered {
+		nums := existingBackupNumbers(target)
+		next := 1
+		if len(nums) > 0 {
+			next = nums[len(nums)-1] + 1
+		}
+		backup = numberedBackupName(target, next)
+		if 0 < Global.KeptBackups && Global.KeptBackups <= len(nums) {
+			prune = nums[:len(nums)+1-Global.KeptBackups]
+		}
+	} else {
+		backup = target + "~"
+	}
+	err = writeFileAtomic(backup, data)
+	if err != nil {
+		return "", err
+	}
+	os.Chmod(backup, keptMode(info))
+	// Only now that there's a new one can the oldest go
+	for _, n := range prune {
+		os.Remove(numberedBackupName(target, n))
+	}
+	return backup, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func saveTestFile(fn string) {
+	InitEditor()
+	EditorOpen(fn, nil)
+	editorInsertStr("new ")
+	editorBufSave(Global.CurrentB, nil)
+}
+
+func failIfFileNe(fn, expected string, t *testing.T) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Error(err)
+	} else if string(data) != expected {
+		t.Errorf("Expected %s to contain %q but was %q", fn, expected, data)
+	}
+}
+
+func TestSaveKeepsPermissions(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0600)
+	os.Chmod(fn, 0751)
+	saveTestFile(fn)
+	failIfFileNe(fn, "new old\n", t)
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0751 {
+		t.Errorf("Expected mode 0751 but was %o", info.Mode().Perm())
+	}
+}
+
+func TestSaveKeepsSetgid(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	if err := os.Chmod(fn, 0755|os.ModeSetgid); err != nil {
+		t.Skip("Can't set the setgid bit here:", err)
+	}
+	if info, _ := os.Stat(fn); info.Mode()&os.ModeSetgid == 0 {
+		t.Skip("The setgid bit doesn't stick here")
+	}
+	saveTestFile(fn)
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSetgid == 0 || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected mode 2755 but was %v", info.Mode())
+	}
+}
+
+func TestSaveLeavesNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	saveTestFile(fn)
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Error("Expected only the saved file but found", len(files), "files")
+	}
+}
+
+func TestSaveFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.txt")
+	link := filepath.Join(dir, "link.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	if err := os.Symlink("test.txt", link); err != nil {
+		t.Skip("Can't make symlinks here:", err)
+	}
+	saveTestFile(link)
+	failIfFileNe(fn, "new old\n", t)
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Symlink was replaced by a regular file")
+	}
+}
+
+func TestSaveKeepsHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.txt")
+	link := filepath.Join(dir, "link.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	if err := os.Link(fn, link); err != nil {
+		t.Skip("Can't make hard links here:", err)
+	}
+	saveTestFile(fn)
+	failIfFileNe(link, "new old\n", t)
+}
+
+func TestSaveInUnwritableDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write anywhere")
+	}
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	os.Chmod(dir, 0555)
+	defer os.Chmod(dir, 0755)
+	saveTestFile(fn)
+	failIfFileNe(fn, "new old\n", t)
+}
+
+func TestSimpleBackup(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("old\n"), 0644)
+	InitEditor()
+	Global.DefaultModes["backup-mode"] = true
+	Global.MinorModes["backup-mode"] = true
+	EditorOpen(fn, nil)
+	editorInsertStr("new ")
+	editorBufSave(Global.CurrentB, nil)
+	editorInsertStr("newer ")
+	editorBufSave(Global.CurrentB, nil)
+	// Only the first save in a session makes a backup
+	failIfFileNe(fn+"~", "old\n", t)
+	failIfFileNe(fn, "new newer old\n", t)
+}
+
+func TestNumberedBackups(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.txt")
+	ioutil.WriteFile(fn, []byte("1\n"), 0644)
+	for i := 2; i <= 4; i++ {
+		InitEditor()
+		Global.DefaultModes["backup-mode"] = true
+		Global.MinorModes["backup-mode"] = true
+		Global.BackupStyle = BackupNumbered
+		Global.KeptBackups = 2
+		EditorOpen(fn, nil)
+		editorDelForwardChar()
+		editorInsertStr(string(rune('0' + i)))
+		editorBufSave(Global.CurrentB, nil)
+	}
+	failIfFileNe(fn, "4\n", t)
+	failIfFileNe(numberedBackupName(fn, 3), "3\n", t)
+	failIfFileNe(numberedBackupName(fn, 2), "2\n", t)
+	if _, err := os.Stat(numberedBackupName(fn, 1)); !os.IsNotExist(err) {
+		t.Error("Expected oldest backup to be deleted")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"os/exec"
 	"strings"
@@ -19,6 +21,21 @@ func shellCmd(com string, args []string) (string, error) {
 	return string(out), err
 }
 
+// Run com in dir with input on its stdin, and return what it writes to
+// stdout. If it fails, the error is what it wrote to stderr, if anything.
+func shellCmdInDir(dir, input, com string, args ...string) (string, error) {
+	cmd := exec.Command(com, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		err = errors.New(msg)
+	}
+	return stdout.String(), err
+}
+
 func shellCmdAction(com string, args []string) {
 	result, err := shellCmd(com, args)
 	if err == nil {
@@ -105,37 +122,36 @@ func doShellCmdRegion() {
 }
 
 func replaceBufferWithShellCommand(buf *EditorBuffer, com string, args []string, env *glisp.Zlisp) {
-	if buf.NumRows == 0 {
+	if buf.NumRows() == 0 {
 		return
 	}
-	output, err := shellCmdWithInput(getRegionText(buf, 0, buf.Rows[buf.NumRows-1].Size, 0, buf.NumRows-1), com, args)
+	barfIfReadOnly(buf)
+	output, err := shellCmdWithInput(getRegionText(buf, 0, buf.Row(buf.NumRows()-1).Size, 0, buf.NumRows()-1), com, args)
 	if err != nil {
 		showMessages(err.Error(), output)
 		return
 	}
 	lines := strings.Split(output, "\n")
 	ll := len(lines)
-	buf.Rows = make([]*EditorRow, ll)
-	buf.NumRows = ll
+	rows := make([]*EditorRow, ll)
 	for i, line := range lines {
 		newrow := &EditorRow{}
 		newrow.Data = line
-		newrow.idx = i
 		newrow.Size = len(line)
 		rowUpdateRender(newrow)
-		buf.Rows[i] = newrow
+		rows[i] = newrow
 	}
+	buf.SetRows(rows)
 	if buf.Highlighter != nil {
 		buf.Highlight()
 	}
-	buf.Undo = nil
-	buf.Redo = nil
-	if buf.cy >= buf.NumRows {
-		buf.cy = buf.NumRows - 1
+	buf.clearUndo()
+	if buf.cy >= buf.NumRows() {
+		buf.cy = buf.NumRows() - 1
 	}
-	if buf.cx >= buf.Rows[buf.cy].Size {
-		buf.cx = buf.Rows[buf.cy].Size
+	if buf.cx >= buf.Row(buf.cy).Size {
+		buf.cx = buf.Row(buf.cy).Size
 	}
-	editorRowCxToRx(buf.Rows[buf.cy])
+	editorRowCxToRx(buf.Row(buf.cy))
 	editorBufSave(buf, env)
 }
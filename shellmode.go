@@ -0,0 +1,457 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// A shell running in a buffer. What it prints goes in at the process mark;
+// what's typed after the mark is the input, sent when RET is pressed.
+
+type shellProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	buf     *EditorBuffer
+	dir     string // Where the shell is, as far as we can tell from cd commands
+	markx   int    // The process mark, where output goes
+	marky   int
+	history []string
+	histIdx int // How far back M-p has gone, or -1
+	done    bool
+}
+
+const shellHistoryMax = 512
+
+// Terminal escape sequences, which a shell on a pipe shouldn't print but some
+// programs do anyway.
+var shellEscapeRe = regexp.MustCompile("\x1b\\[[0-9;?]*[A-Za-z]|\x1b\\][^\x07]*\x07")
+
+// What a prompt looks like, so it can be left out when an old line is reused.
+var shellPromptRe = regexp.MustCompile(`^[^#$%>\n]*[#$%>] *`)
+
+var shellCdRe = regexp.MustCompile(`^\s*cd(?:\s+(\S+))?\s*$`)
+
+// The shell the user likes.
+func shellProgram() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return sh
+	}
+	return "/bin/sh"
+}
+
+// Start program as an interactive shell in dir, in buf.
+func startShell(buf *EditorBuffer, program, dir string) (*shellProcess, error) {
+	cmd := exec.Command(program, "-i")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "TERM=dumb", "PAGER=cat")
+	setProcessGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	err = cmd.Start()
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+	s := &shellProcess{cmd: cmd, stdin: stdin, buf: buf, dir: dir, histIdx: -1}
+	buf.shell = s
+	buf.MajorMode = "shell"
+	buf.Highlighter = nil
+	buf.setMode("read-only-mode", false)
+	s.marky = buf.NumRows() - 1
+	s.markx = buf.Row(s.marky).Size
+	waited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waited <- err
+	}()
+	go func() {
+		chunk := make([]byte, 4096)
+		pending := []byte{}
+		for {
+			n, err := pr.Read(chunk)
+			pending = append(pending, chunk[:n]...)
+			// Keep back a character that's been split between two reads
+			end := len(pending)
+			if last := lastRuneStart(pending); err == nil && !utf8.FullRune(pending[last:]) {
+				end = last
+			}
+			if 0 < end {
+				text := string(pending[:end])
+				pending = append([]byte{}, pending[end:]...)
+				runOnMain(func() { s.output(text) })
+			}
+			if err != nil {
+				break
+			}
+		}
+		err := <-waited
+		runOnMain(func() { s.finish(err) })
+	}()
+	return s, nil
+}
+
+// Where the last character in data starts.
+func lastRuneStart(data []byte) int {
+	i := len(data) - 1
+	for 0 < i && len(data)-utf8.UTFMax < i && !utf8.RuneStart(data[i]) {
+		i--
+	}
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+// Make sure the process mark is somewhere in the buffer.
+func (s *shellProcess) clampMark() {
+	buf := s.buf
+	if buf.NumRows() == 0 {
+		buf.SetRows([]*EditorRow{&EditorRow{}})
+	}
+	if buf.NumRows() <= s.marky {
+		s.marky = buf.NumRows() - 1
+		s.markx = buf.Row(s.marky).Size
+	}
+	if buf.Row(s.marky).Size < s.markx {
+		s.markx = buf.Row(s.marky).Size
+	}
+}
+
+// Put text in the buffer at x, y, moving the cursor along if it's after it.
+// Returns where the text ends.
+func (s *shellProcess) insertAt(x, y int, text string) (int, int) {
+	buf := s.buf
+	lines := strings.Split(text, "\n")
+	row := buf.Row(y)
+	tail := row.Data[x:]
+	row.Data = row.Data[:x] + lines[0]
+	endx, endy := len(row.Data), y+len(lines)-1
+	if 1 < len(lines) {
+		rows := make([]*EditorRow, len(lines)-1)
+		for i, line := range lines[1:] {
+			rows[i] = &EditorRow{Data: line}
+		}
+		endx = len(rows[len(rows)-1].Data)
+		rows[len(rows)-1].Data += tail
+		for _, r := range rows {
+			r.Size = len(r.Data)
+			rowUpdateRender(r)
+		}
+		buf.InsertRows(y+1, rows...)
+	} else {
+		row.Data += tail
+	}
+	row.Size = len(row.Data)
+	rowUpdateRender(row)
+	if buf.cy == y && x <= buf.cx {
+		buf.cx += endx - x
+		buf.cy = endy
+	} else if y < buf.cy {
+		buf.cy += endy - y
+	}
+	buf.prefcx = buf.cx
+	// Edits under the undo records would leave them pointing at the wrong
+	// places, so start afresh.
+	buf.clearUndo()
+	buf.Dirty = false
+	return endx, endy
+}
+
+// Add what the shell printed to the buffer.
+func (s *shellProcess) output(text string) {
+	text = shellEscapeRe.ReplaceAllString(text, "")
+	text = strings.Replace(text, "\r", "", -1)
+	if text == "" {
+		return
+	}
+	s.clampMark()
+	s.markx, s.marky = s.insertAt(s.markx, s.marky, text)
+}
+
+// Say that the shell has gone.
+func (s *shellProcess) finish(err error) {
+	s.done = true
+	msg := "finished"
+	if err != nil {
+		msg = err.Error()
+	}
+	s.output("\nProcess shell " + msg + "\n")
+	if Global.CurrentB == s.buf {
+		Global.Input = "Process shell " + msg
+	}
+}
+
+// The text after the process mark.
+func (s *shellProcess) input() string {
+	s.clampMark()
+	buf := s.buf
+	last := buf.NumRows() - 1
+	return getRegionText(buf, s.markx, buf.Row(last).Size, s.marky, last)
+}
+
+// Replace the text after the process mark with text, leaving the cursor at the
+// end of it.
+func (s *shellProcess) setInput(text string) {
+	s.clampMark()
+	buf := s.buf
+	if s.marky+1 < buf.NumRows() {
+		buf.DeleteRows(s.marky+1, buf.NumRows())
+	}
+	row := buf.Row(s.marky)
+	row.Data = row.Data[:s.markx]
+	buf.cx, buf.cy = s.markx, s.marky
+	s.insertAt(s.markx, s.marky, text)
+	buf.cy = buf.NumRows() - 1
+	buf.cx = buf.Row(buf.cy).Size
+	buf.prefcx = buf.cx
+}
+
+// Keep track of cd commands, so that completion knows where the shell is.
+func (s *shellProcess) trackDirectory(command string) {
+	m := shellCdRe.FindStringSubmatch(command)
+	if m == nil {
+		return
+	}
+	dir := m[1]
+	if dir == "" || dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := homedir.Dir()
+		if err != nil {
+			return
+		}
+		dir = filepath.Join(home, strings.TrimPrefix(dir, "~"))
+	} else if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.dir, dir)
+	}
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		s.dir = dir
+	}
+}
+
+// Send the input to the shell. On an earlier line, copy that line to the
+// input instead, without the prompt.
+func (s *shellProcess) sendInput() {
+	buf := s.buf
+	s.clampMark()
+	if buf.cy < s.marky || (buf.cy == s.marky && buf.cx < s.markx) {
+		old := buf.Row(buf.cy).Data
+		if buf.cy == s.marky {
+			old = old[:s.markx]
+		}
+		s.setInput(shellPromptRe.ReplaceAllString(old, ""))
+		return
+	}
+	if s.done {
+		Global.Input = "Buffer has no process"
+		return
+	}
+	text := s.input()
+	buf.cy = buf.NumRows() - 1
+	buf.cx = buf.Row(buf.cy).Size
+	buf.cx, buf.cy = s.insertAt(buf.cx, buf.cy, "\n")
+	s.markx, s.marky = buf.cx, buf.cy
+	if strings.TrimSpace(text) != "" &&
+		(len(s.history) == 0 || s.history[len(s.history)-1] != text) {
+		s.history = append(s.history, text)
+		if shellHistoryMax < len(s.history) {
+			s.history = s.history[1:]
+		}
+	}
+	s.histIdx = -1
+	s.trackDirectory(text)
+	_, err := io.WriteString(s.stdin, text+"\n")
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+	}
+}
+
+// Replace the input with an older (or, if delta is negative, newer) one.
+func (s *shellProcess) historyMove(delta int) {
+	idx := s.histIdx + delta
+	if idx < -1 || len(s.history) <= idx {
+		if 0 < delta {
+			Global.Input = "Beginning of history; no preceding item"
+		} else {
+			Global.Input = "End of history; no next item"
+		}
+		return
+	}
+	s.histIdx = idx
+	if idx == -1 {
+		s.setInput("")
+	} else {
+		s.setInput(s.history[len(s.history)-1-idx])
+	}
+}
+
+// Files whose names start with prefix, a path as typed in the shell.
+func (s *shellProcess) fileCompletions(prefix string) []string {
+	path, err := homedir.Expand(prefix)
+	if err != nil {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.dir, path)
+	}
+	dir, base := filepath.Dir(path), filepath.Base(path)
+	if strings.HasSuffix(prefix, "/") || prefix == "" {
+		dir, base = path, ""
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	ret := []string{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, base) || (base == "" && strings.HasPrefix(name, ".")) {
+			continue
+		}
+		if file.IsDir() {
+			name += "/"
+		}
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// The longest string every one of strs starts with.
+func commonPrefix(strs []string) string {
+	if len(strs) == 0 {
+		return ""
+	}
+	prefix := strs[0]
+	for _, s := range strs[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// Complete the file name before the cursor.
+func (s *shellProcess) complete() {
+	buf := s.buf
+	s.clampMark()
+	if buf.cy < s.marky || (buf.cy == s.marky && buf.cx < s.markx) {
+		Global.Input = "Not in the input"
+		return
+	}
+	from := 0
+	if buf.cy == s.marky {
+		from = s.markx
+	}
+	data := buf.Row(buf.cy).Data
+	wordStart := strings.LastIndexAny(data[from:buf.cx], " \t\"'=<>|;&") + 1 + from
+	word := data[wordStart:buf.cx]
+	candidates := s.fileCompletions(word)
+	if len(candidates) == 0 {
+		Global.Input = "No completions"
+		return
+	}
+	typed := word[strings.LastIndexByte(word, '/')+1:]
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(completion, "/") {
+		completion += " "
+	}
+	if len(typed) < len(completion) {
+		editorInsertStr(completion[len(typed):])
+		buf.Dirty = false
+	}
+	if 1 < len(candidates) {
+		Global.Input = strings.Join(candidates, " ")
+	}
+}
+
+// Stop whatever the shell's running.
+func (s *shellProcess) interrupt() {
+	if s.done {
+		Global.Input = "Buffer has no process"
+		return
+	}
+	err := interruptProcessGroup(s.cmd)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+	}
+}
+
+// Close the shell's input, as C-d would at a terminal.
+func (s *shellProcess) sendEOF() {
+	if s.done {
+		Global.Input = "Buffer has no process"
+		return
+	}
+	s.stdin.Close()
+}
+
+// Go to the start of the line, or to the start of the input if we're in it.
+func (s *shellProcess) bol() {
+	buf := s.buf
+	s.clampMark()
+	if buf.cy == s.marky && s.markx <= buf.cx {
+		buf.cx = s.markx
+	} else {
+		buf.cx = 0
+	}
+	buf.prefcx = buf.cx
+}
+
+// Kill the shell, e.g. because its buffer is being killed.
+func (s *shellProcess) kill() {
+	if !s.done {
+		s.done = true
+		killProcessGroup(s.cmd)
+	}
+}
+
+// The shell of the current buffer, if it has one.
+func currentShell() *shellProcess {
+	s := Global.CurrentB.shell
+	if s == nil {
+		Global.Input = "Not in a shell buffer"
+	}
+	return s
+}
+
+// Switch to the *shell* buffer, starting a shell in it if there isn't one
+// running.
+func shellCommand() {
+	dir := defaultDirectory()
+	buf := namedBuffer("*shell*")
+	getFocusWindow().buf = buf
+	Global.CurrentB = buf
+	if buf.shell != nil && !buf.shell.done {
+		return
+	}
+	if buf.NumRows() == 0 {
+		buf.SetRows([]*EditorRow{&EditorRow{}})
+	}
+	var history []string
+	if buf.shell != nil {
+		history = buf.shell.history
+	}
+	s, err := startShell(buf, shellProgram(), dir)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+		return
+	}
+	s.history = history
+	buf.cy = buf.NumRows() - 1
+	buf.cx = buf.Row(buf.cy).Size
+}
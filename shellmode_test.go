@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellOutputAndInput(t *testing.T) {
+	InitEditor()
+	buf := setLines("")
+	s := &shellProcess{buf: buf, histIdx: -1, done: true}
+	buf.shell = s
+	s.output("welcome\r\n\x1b[1m$\x1b[0m ")
+	buf.MoveCursorToEndOfBuffer()
+	editorInsertStr("ls")
+	s.output("mail\n$ ")
+	buf.FailIfBufferNe([]string{"welcome", "$ mail", "$ ls"}, t)
+	if buf.cy != 2 || buf.cx != 4 {
+		t.Errorf("Expected the cursor to stay after the input, at 3:4, got %d:%d", buf.cy+1, buf.cx)
+	}
+	if s.input() != "ls" {
+		t.Errorf("Expected the input to be %q, got %q", "ls", s.input())
+	}
+
+	s.history = []string{"one", "two"}
+	s.historyMove(1)
+	s.historyMove(1)
+	if s.input() != "one" {
+		t.Errorf("Expected M-p twice to give the first input, got %q", s.input())
+	}
+	s.historyMove(-1)
+	s.historyMove(-1)
+	if s.input() != "" || s.histIdx != -1 {
+		t.Errorf("Expected M-n to come back to an empty input, got %q", s.input())
+	}
+
+	buf.cy, buf.cx = 2, 0
+	s.sendInput()
+	if s.input() != "" || buf.cy != 2 {
+		t.Errorf("Expected RET before the input to copy the line without the prompt")
+	}
+	buf.cy, buf.cx = 1, 0
+	s.sendInput()
+	if s.input() != "mail" || buf.cx != 6 {
+		t.Errorf("Expected RET on an old line to copy it to the input, got %q", s.input())
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	InitEditor()
+	dir, err := ioutil.TempDir("", "gomacs-shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"notes.txt": "", "nothing/a": "", "other": ""})
+	buf := setLines("$ ")
+	s := &shellProcess{buf: buf, dir: dir, markx: 2, histIdx: -1}
+	buf.shell = s
+	buf.cx = 2
+	editorInsertStr("cat no")
+	s.complete()
+	if s.input() != "cat not" || Global.Input != "notes.txt nothing/" {
+		t.Errorf("Expected the common part and a list of candidates, got %q and %q", s.input(), Global.Input)
+	}
+	editorInsertStr("h")
+	s.complete()
+	if s.input() != "cat nothing/" {
+		t.Errorf("Expected a directory to be completed, got %q", s.input())
+	}
+	s.complete()
+	if s.input() != "cat nothing/a " {
+		t.Errorf("Expected a file in it to be completed, got %q", s.input())
+	}
+}
+
+func TestShellProcess(t *testing.T) {
+	InitEditor()
+	dir, err := ioutil.TempDir("", "gomacs-shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	buf := setLines("")
+	s, err := startShell(buf, "sh", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.kill()
+	buf.MoveCursorToEndOfBuffer()
+	editorInsertStr("echo hel''lo")
+	s.sendInput()
+	editorInsertStr("cd /")
+	s.sendInput()
+	if len(s.history) != 2 || s.dir != "/" {
+		t.Errorf("Expected the input to go in the history and the cd to be noticed")
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for found := false; !found; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the shell to say hello")
+		}
+		if !runQueuedJobs() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+			found = found || strings.HasSuffix(row.Data, "hello")
+			return true
+		})
+	}
+	s.sendEOF()
+	for !s.done {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the shell to finish at the end of its input")
+		}
+		if !runQueuedJobs() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -13,23 +13,23 @@ var defs []*highlight.Def
 
 // These functions implement highlight's LineStates interface for EditorBuffer
 func (buf *EditorBuffer) Line(n int) string {
-	return buf.Rows[n].Render
+	return buf.Row(n).Render
 }
 
 func (buf *EditorBuffer) LinesNum() int {
-	return buf.NumRows
+	return buf.NumRows()
 }
 
 func (buf *EditorBuffer) State(n int) highlight.State {
-	return buf.Rows[n].HlState
+	return buf.Row(n).HlState
 }
 
 func (buf *EditorBuffer) SetState(n int, s highlight.State) {
-	buf.Rows[n].HlState = s
+	buf.Row(n).HlState = s
 }
 
 func (buf *EditorBuffer) SetMatch(n int, m highlight.LineMatch) {
-	buf.Rows[n].HlMatches = m
+	buf.Row(n).HlMatches = m
 }
 
 // End interface functions
@@ -37,9 +37,13 @@ func (buf *EditorBuffer) SetMatch(n int, m highlight.LineMatch) {
 func (buf *EditorBuffer) Highlight() {
 	if buf.Highlighter == nil {
 		return
+	} else if buf.LargeFile {
+		// Only highlight what's on screen; see highlightRange
+		buf.rows.root.forgetHighlighting()
+		return
 	}
 	buf.Highlighter.HighlightStates(buf)
-	buf.Highlighter.HighlightMatches(buf, 0, buf.NumRows)
+	buf.Highlighter.HighlightMatches(buf, 0, buf.NumRows())
 }
 
 func getColorForGroup(group highlight.Group) termbox.Attribute {
@@ -71,11 +75,12 @@ func getColorForGroup(group highlight.Group) termbox.Attribute {
 }
 
 func (row *EditorRow) PrintWCursor(x, y, offset, runeoff, sx int, ts string, buf *EditorBuffer) {
-	if buf.regionActive && buf.region.startl <= row.idx && row.idx < buf.region.endl {
+	line := row.Line()
+	if buf.regionActive && buf.region.startl <= line && line < buf.region.endl {
 		for i := x; i <= sx; i++ {
 			termbox.SetCell(i, y, ' ', termbox.AttrReverse, termbox.ColorDefault)
 		}
-		if buf.region.startl < row.idx {
+		if buf.region.startl < line {
 			termutil.PrintstringColored(termbox.AttrReverse, ts, x, y)
 			return
 		}
@@ -104,8 +109,8 @@ func (row *EditorRow) PrintWCursor(x, y, offset, runeoff, sx int, ts string, buf
 		}
 		// See comment in original function
 		if buf.regionActive &&
-			((row.idx == buf.region.startl && buf.region.startl == buf.region.endl && offset+os < buf.region.endc && offset+os >= buf.region.startc) ||
-				(buf.region.startl != buf.region.endl && ((row.idx == buf.region.startl && offset+os >= buf.region.startc) || (row.idx == buf.region.endl && offset+os < buf.region.endc)))) {
+			((line == buf.region.startl && buf.region.startl == buf.region.endl && offset+os < buf.region.endc && offset+os >= buf.region.startc) ||
+				(buf.region.startl != buf.region.endl && ((line == buf.region.startl && offset+os >= buf.region.startc) || (line == buf.region.endl && offset+os < buf.region.endc)))) {
 			termutil.PrintRune(x+os, y, ru, termbox.AttrReverse)
 		} else {
 			termutil.PrintRune(x+os, y, ru, color)
@@ -119,11 +124,12 @@ func (row *EditorRow) PrintWCursor(x, y, offset, runeoff, sx int, ts string, buf
 }
 
 func (row *EditorRow) Print(x, y, offset, runeoff, sx int, ts string, buf *EditorBuffer) {
-	if buf.regionActive && buf.region.startl <= row.idx && row.idx < buf.region.endl {
+	line := row.Line()
+	if buf.regionActive && buf.region.startl <= line && line < buf.region.endl {
 		for i := x; i <= sx; i++ {
 			termbox.SetCell(i, y, ' ', termbox.AttrReverse, termbox.ColorDefault)
 		}
-		if buf.region.startl < row.idx {
+		if buf.region.startl < line {
 			termutil.PrintstringColored(termbox.AttrReverse, ts, x, y)
 			return
 		}
@@ -153,8 +159,8 @@ func (row *EditorRow) Print(x, y, offset, runeoff, sx int, ts string, buf *Edito
 		// 2nd line is "If the start & end are the same, and we're in between the first and last character"
 		// 3rd line is "If the start & end are not the same and we're within the region"
 		if buf.regionActive &&
-			((row.idx == buf.region.startl && buf.region.startl == buf.region.endl && offset+os < buf.region.endc && offset+os >= buf.region.startc) ||
-				(buf.region.startl != buf.region.endl && ((row.idx == buf.region.startl && offset+os >= buf.region.startc) || (row.idx == buf.region.endl && offset+os < buf.region.endc)))) {
+			((line == buf.region.startl && buf.region.startl == buf.region.endl && offset+os < buf.region.endc && offset+os >= buf.region.startc) ||
+				(buf.region.startl != buf.region.endl && ((line == buf.region.startl && offset+os >= buf.region.startc) || (line == buf.region.endl && offset+os < buf.region.endc)))) {
 			termutil.PrintRune(x+os, y, ru, termbox.AttrReverse)
 		} else {
 			termutil.PrintRune(x+os, y, ru, color)
@@ -180,8 +186,8 @@ func LoadSyntaxDefs() {
 
 func editorSelectSyntaxHighlight(buf *EditorBuffer, env *glisp.Zlisp) {
 	var first []byte
-	if buf.NumRows > 0 {
-		first = []byte(buf.Rows[0].Data)
+	if buf.NumRows() > 0 {
+		first = []byte(buf.Row(0).Data)
 	}
 	buf.Highlighter = highlight.NewHighlighter(highlight.DetectFiletype(defs, buf.Filename, first))
 	if buf.Highlighter != nil {
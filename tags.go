@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+)
+
+// Tags tables, as made by etags (TAGS) and ctags (tags), for going to
+// definitions in projects without a language server. The table used is the
+// one visit-tags-table chose, or else the nearest one above the current
+// buffer's directory.
+
+type tag struct {
+	name    string
+	file    string // Absolute
+	line    int    // From 1, or 0 if the table doesn't say
+	pattern string // What the line starts with, or "" if the table doesn't say
+}
+
+type tagsTable struct {
+	file    string
+	modTime time.Time
+	tags    []*tag
+}
+
+var tagsTables = map[string]*tagsTable{} // By file name
+var visitedTagsFile string
+
+// Parse an etags TAGS file. Each file's section starts with a form feed, then
+// "file,size"; each tag is "text\x7fname\x01line,offset", where the name may
+// be left out if it's at the end of the text.
+func parseEtags(data []byte, dir string) []*tag {
+	ret := []*tag{}
+	file := ""
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		if line == "\f" {
+			if i+1 < len(lines) {
+				i++
+				header := lines[i]
+				if j := strings.LastIndexByte(header, ','); 0 <= j {
+					header = header[:j]
+				}
+				file = header
+				if !filepath.IsAbs(file) {
+					file = filepath.Join(dir, file)
+				}
+			}
+			continue
+		}
+		del := strings.IndexByte(line, '\x7f')
+		if del < 0 || file == "" {
+			continue
+		}
+		text, rest := line[:del], line[del+1:]
+		name := ""
+		if j := strings.IndexByte(rest, '\x01'); 0 <= j {
+			name, rest = rest[:j], rest[j+1:]
+		} else {
+			name = implicitTagName(text)
+		}
+		if name == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(strings.SplitN(rest, ",", 2)[0])
+		ret = append(ret, &tag{name, file, n, text})
+	}
+	return ret
+}
+
+// The name at the end of a tag's text, e.g. "foo" in "int foo(".
+func implicitTagName(text string) string {
+	isName := func(ru rune) bool {
+		return unicode.IsLetter(ru) || unicode.IsDigit(ru) || ru == '_' || ru == '$'
+	}
+	end := strings.LastIndexFunc(text, isName) + 1
+	start := strings.LastIndexFunc(text[:end], func(ru rune) bool { return !isName(ru) }) + 1
+	return text[start:end]
+}
+
+// Parse a ctags tags file, whose lines are "name\tfile\taddress;\"\tfields".
+// The address is a line number or a /^pattern$/ search.
+func parseCtags(data []byte, dir string) []*tag {
+	ret := []*tag{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "!_TAG_") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		t := &tag{name: fields[0], file: fields[1]}
+		if !filepath.IsAbs(t.file) {
+			t.file = filepath.Join(dir, t.file)
+		}
+		address := fields[2]
+		extra := ""
+		if j := strings.LastIndex(address, ";\""); 0 <= j {
+			address, extra = address[:j], address[j+2:]
+		}
+		if n, err := strconv.Atoi(address); err == nil {
+			t.line = n
+		} else if 2 <= len(address) && (address[0] == '/' || address[0] == '?') {
+			t.pattern = unescapeTagPattern(address)
+		}
+		for _, field := range strings.Split(extra, "\t") {
+			if strings.HasPrefix(field, "line:") {
+				t.line, _ = strconv.Atoi(field[5:])
+			}
+		}
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+// The text a search address like /^int foo(void)$/ looks for at the start of
+// a line.
+func unescapeTagPattern(address string) string {
+	delim := address[0]
+	body := strings.TrimSuffix(address[1:], string(delim))
+	body = strings.TrimPrefix(body, "^")
+	if strings.HasSuffix(body, "$") && !strings.HasSuffix(body, "\\$") {
+		body = body[:len(body)-1]
+	}
+	var sb strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\\' && i+1 < len(body) {
+			i++
+		}
+		sb.WriteByte(body[i])
+	}
+	return sb.String()
+}
+
+// Read a tags table, or get it from the cache if it hasn't changed.
+func loadTagsTable(fn string) (*tagsTable, error) {
+	info, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+	if t := tagsTables[fn]; t != nil && t.modTime.Equal(info.ModTime()) {
+		return t, nil
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	t := &tagsTable{file: fn, modTime: info.ModTime()}
+	if bytes.HasPrefix(data, []byte("\f\n")) || bytes.HasPrefix(data, []byte("\f\r\n")) {
+		t.tags = parseEtags(data, filepath.Dir(fn))
+	} else {
+		t.tags = parseCtags(data, filepath.Dir(fn))
+	}
+	tagsTables[fn] = t
+	return t, nil
+}
+
+// The nearest TAGS or tags file in dir or above it, or "".
+func findTagsFile(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, name := range []string{"TAGS", "tags"} {
+			fn := filepath.Join(d, name)
+			if info, err := os.Stat(fn); err == nil && info.Mode().IsRegular() {
+				return fn
+			}
+		}
+		if d == filepath.Dir(d) {
+			return ""
+		}
+	}
+}
+
+// The tags table for the current buffer.
+func currentTagsTable() (*tagsTable, error) {
+	fn := visitedTagsFile
+	if fn == "" {
+		fn = findTagsFile(defaultDirectory())
+	}
+	if fn == "" {
+		return nil, fmt.Errorf("No TAGS or tags file in %s or above it; try M-x visit-tags-table", defaultDirectory())
+	}
+	return loadTagsTable(fn)
+}
+
+func (t *tagsTable) named(name string) []*tag {
+	ret := []*tag{}
+	for _, tg := range t.tags {
+		if tg.name == name {
+			ret = append(ret, tg)
+		}
+	}
+	return ret
+}
+
+// The names of the tags that start with prefix, sorted, for tab completion.
+func (t *tagsTable) completions(prefix string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	for _, tg := range t.tags {
+		if strings.HasPrefix(tg.name, prefix) && !seen[tg.name] {
+			seen[tg.name] = true
+			ret = append(ret, tg.name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// The row the tag is on: the nearest to its line that starts with its
+// pattern, since the file may have changed since the table was made.
+func (tg *tag) row(buf *EditorBuffer) int {
+	want := tg.line - 1
+	if buf.NumRows() <= want {
+		want = buf.NumRows() - 1
+	}
+	if want < 0 {
+		want = 0
+	}
+	if tg.pattern == "" {
+		return want
+	}
+	for d := 0; d <= want || want+d < buf.NumRows(); d++ {
+		for _, r := range []int{want - d, want + d} {
+			if 0 <= r && r < buf.NumRows() && strings.HasPrefix(buf.Row(r).Data, tg.pattern) {
+				return r
+			}
+		}
+	}
+	return want
+}
+
+// Visit the tag's file, with the cursor on the tag's name.
+func (tg *tag) visit(env *glisp.Zlisp) {
+	buf := visitFile(tg.file, env)
+	buf.cy = tg.row(buf)
+	buf.cx = 0
+	if buf.cy < buf.NumRows() {
+		if i := strings.Index(buf.Row(buf.cy).Data, tg.name); 0 <= i {
+			buf.cx = i
+		}
+	}
+	buf.prefcx = buf.cx
+	editorCentreView()
+}
+
+// How a tag is shown in the chooser: where it is, and the text it's on.
+func (tg *tag) String() string {
+	fn := tg.file
+	if rel, err := filepath.Rel(defaultDirectory(), fn); err == nil && !strings.HasPrefix(rel, "..") {
+		fn = rel
+	}
+	if tg.line == 0 {
+		return fmt.Sprintf("%s: %s", fn, strings.TrimSpace(tg.pattern))
+	}
+	return fmt.Sprintf("%s:%d: %s", fn, tg.line, strings.TrimSpace(tg.pattern))
+}
+
+// Go to the tag called name, asking which if there are several.
+func findTagNamed(name string, env *glisp.Zlisp) {
+	t, err := currentTagsTable()
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	tags := t.named(name)
+	choice := 0
+	switch len(tags) {
+	case 0:
+		Global.Input = "No tag " + name + " in " + t.file
+		return
+	case 1:
+	default:
+		choices := make([]string, len(tags))
+		for i, tg := range tags {
+			choices[i] = tg.String()
+		}
+		choice = editorChoiceIndex("Several tags are called "+name, choices, -1)
+		if choice < 0 || len(tags) <= choice {
+			Global.Input = "Cancelled"
+			return
+		}
+	}
+	if _, err := os.Stat(tags[choice].file); err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	xrefPushMarker()
+	tags[choice].visit(env)
+}
+
+// Ask for a tag, with tab completion and the word at the cursor as the
+// default, and go to it.
+func findTag(env *glisp.Zlisp) {
+	t, err := currentTagsTable()
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	def := symbolAtPoint()
+	prompt := "Find tag"
+	if def != "" {
+		prompt += " (default " + def + ")"
+	}
+	name := tabCompletedEditorPrompt(prompt, t.completions)
+	if name == "" {
+		name = def
+	}
+	if name == "" {
+		Global.Input = "Cancelled"
+		return
+	}
+	findTagNamed(name, env)
+}
+
+// Use a particular tags table from now on.
+func visitTagsTable() {
+	fn := tabCompletedEditorPrompt("Visit tags table", tabCompleteFilename)
+	if fn == "" {
+		Global.Input = "Cancelled"
+		return
+	}
+	fn, err := AbsPath(fn)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	if info, err := os.Stat(fn); err == nil && info.IsDir() {
+		fn = findTagsFile(fn)
+	}
+	t, err := loadTagsTable(fn)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	visitedTagsFile = fn
+	Global.Input = fmt.Sprintf("%d tags in %s", len(t.tags), fn)
+}
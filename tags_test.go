@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const tagsTestSource = "package main\n\n// Helper helps\nfunc Helper() {}\n\nfunc main() {\n\tHelper()\n}\n"
+
+func TestParseTags(t *testing.T) {
+	etags := "\f\nmain.go,60\nfunc Helper() {\x7fHelper\x014,31\nfunc main(\x7f6,50\n\f\n/abs/other.c,10\nint count;\x7f3,5\n"
+	tags := parseEtags([]byte(etags), "/src")
+	expect := []*tag{
+		{"Helper", "/src/main.go", 4, "func Helper() {"},
+		{"main", "/src/main.go", 6, "func main("},
+		{"count", "/abs/other.c", 3, "int count;"},
+	}
+	if !reflect.DeepEqual(tags, expect) {
+		t.Errorf("Expected %v, got %v", expect, tags)
+	}
+
+	ctags := "!_TAG_FILE_FORMAT\t2\t/extended format/\n" +
+		"Helper\tmain.go\t/^func Helper() {}$/;\"\tf\tline:4\n" +
+		"main\tmain.go\t6;\"\tf\n" +
+		"path\tsub/a.js\t/^var path = \\/a\\/b\\/;$/;\"\tv\n"
+	tags = parseCtags([]byte(ctags), "/src")
+	expect = []*tag{
+		{"Helper", "/src/main.go", 4, "func Helper() {}"},
+		{"main", "/src/main.go", 6, ""},
+		{"path", "/src/sub/a.js", 0, "var path = /a/b/;"},
+	}
+	if !reflect.DeepEqual(tags, expect) {
+		t.Errorf("Expected %v, got %v", expect, tags)
+	}
+}
+
+func TestFindTag(t *testing.T) {
+	InitEditor()
+	xrefStack = nil
+	dir, err := ioutil.TempDir("", "gomacs-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// The table is out of date: Helper has moved down two lines since
+	writeTree(t, dir, map[string]string{
+		"main.go": "// Copyright\n\n" + tagsTestSource,
+		"tags": "Helper\tmain.go\t/^func Helper() {}$/;\"\tf\tline:4\n" +
+			"Helpless\tmain.go\t1;\"\tf\nmain\tmain.go\t/^func main() {$/;\"\tf\n",
+	})
+	openFile(filepath.Join(dir, "main.go"), nil)
+	buf := Global.CurrentB
+	table, err := currentTagsTable()
+	if err != nil || table.file != filepath.Join(dir, "tags") {
+		t.Fatalf("Expected to find the tags file, got %v", err)
+	}
+	if got := table.completions("Help"); !reflect.DeepEqual(got, []string{"Helper", "Helpless"}) {
+		t.Errorf("Expected both Help tags to complete, got %q", got)
+	}
+
+	buf.cy, buf.cx = 8, 3
+	findTagNamed("Helper", nil)
+	if Global.CurrentB != buf || buf.cy != 5 || buf.cx != 5 {
+		t.Errorf("Expected to be at Helper's definition, got %d:%d", buf.cy, buf.cx)
+	}
+	findTagNamed("main", nil)
+	if buf.cy != 7 {
+		t.Errorf("Expected to be at main, got %d", buf.cy)
+	}
+	xrefGoBack()
+	if buf.cy != 5 || buf.cx != 5 {
+		t.Errorf("Expected M-, to go back to Helper, got %d:%d", buf.cy, buf.cx)
+	}
+	xrefGoBack()
+	if buf.cy != 8 || buf.cx != 3 {
+		t.Errorf("Expected M-, to go back to the start, got %d:%d", buf.cy, buf.cx)
+	}
+	xrefGoBack()
+	if Global.Input != "Nowhere to go back to" {
+		t.Errorf("Expected the stack to be empty, got %q", Global.Input)
+	}
+	findTagNamed("Nothing", nil)
+	if Global.Input != "No tag Nothing in "+table.file {
+		t.Errorf("Expected no tag, got %q", Global.Input)
+	}
+}
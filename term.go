@@ -0,0 +1,298 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf8"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+	"github.com/kballard/go-shellquote"
+)
+
+// A program running under a pty, its screen shown in a buffer. In char mode
+// keys go straight to the program, except C-c, which is followed by C-j for
+// line mode, C-c to send a C-c, or a key to use as if after C-x. In line mode
+// the buffer can be moved around and copied from as usual.
+
+type terminal struct {
+	screen   *vtScreen
+	pty      *os.File
+	cmd      *exec.Cmd
+	buf      *EditorBuffer
+	name     string
+	charMode bool
+	done     bool
+}
+
+// Run program with args in dir under a new pty, showing its screen in buf.
+func startTerm(buf *EditorBuffer, program string, args []string, dir string, rows, cols int) (*terminal, error) {
+	cmd := exec.Command(program, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "TERM=xterm")
+	pty, err := startInPty(cmd, rows, cols)
+	if err != nil {
+		return nil, err
+	}
+	t := &terminal{newVtScreen(rows, cols), pty, cmd, buf, program, true, false}
+	t.screen.reply = func(msg string) { t.send(msg) }
+	buf.term = t
+	buf.MajorMode = "term"
+	buf.Highlighter = nil
+	buf.setMode("read-only-mode", true)
+	t.sync()
+	go func() {
+		chunk := make([]byte, 4096)
+		pending := []byte{}
+		for {
+			n, err := pty.Read(chunk)
+			pending = append(pending, chunk[:n]...)
+			// Keep back a character that's been split between two reads
+			end := len(pending)
+			if last := lastRuneStart(pending); err == nil && !utf8.FullRune(pending[last:]) {
+				end = last
+			}
+			if 0 < end {
+				text := string(pending[:end])
+				pending = append([]byte{}, pending[end:]...)
+				runOnMain(func() {
+					t.screen.feed(text)
+					t.sync()
+				})
+			}
+			if err != nil {
+				break
+			}
+		}
+		err := cmd.Wait()
+		runOnMain(func() { t.finish(err) })
+	}()
+	return t, nil
+}
+
+// Make the buffer show the screen, after the lines that have scrolled off it.
+func (t *terminal) sync() {
+	s, buf := t.screen, t.buf
+	rows := make([]*EditorRow, 0, len(s.scrollback)+s.rows)
+	for _, line := range s.scrollback {
+		rows = append(rows, &EditorRow{Size: len(line), Data: line})
+	}
+	cx := 0
+	for i, line := range s.lines {
+		data := vtLineString(line)
+		if i == s.y {
+			cx = vtByteIndex(line, s.x)
+			if len(data) < cx {
+				data += strings.Repeat(" ", cx-len(data))
+			}
+		}
+		rows = append(rows, &EditorRow{Size: len(data), Data: data})
+	}
+	for _, row := range rows {
+		rowUpdateRender(row)
+	}
+	buf.SetRows(rows)
+	buf.clearUndo()
+	buf.Dirty = false
+	if t.charMode {
+		buf.cy, buf.cx, buf.prefcx = len(s.scrollback)+s.y, cx, cx
+		buf.rowoff = len(s.scrollback)
+	} else if buf.NumRows() <= buf.cy {
+		buf.cy = buf.NumRows() - 1
+		buf.cx = 0
+	} else if buf.Row(buf.cy).Size < buf.cx {
+		buf.cx = buf.Row(buf.cy).Size
+	}
+}
+
+// Say that the program has gone, and leave the buffer in line mode.
+func (t *terminal) finish(err error) {
+	t.done = true
+	t.charMode = false
+	t.pty.Close()
+	msg := "finished"
+	if err != nil {
+		msg = err.Error()
+	}
+	t.screen.altScreen(false)
+	t.screen.feed("\r\n\r\nProcess " + t.name + " " + msg + "\r\n")
+	t.sync()
+	if Global.CurrentB == t.buf {
+		Global.Input = "Process " + t.name + " " + msg
+	}
+}
+
+func (t *terminal) send(s string) {
+	if t.done {
+		Global.Input = "Buffer has no process"
+		return
+	}
+	_, err := t.pty.WriteString(s)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+	}
+}
+
+// Make the screen rows by cols, as when the window showing it changes size.
+func (t *terminal) resize(rows, cols int) {
+	s := t.screen
+	if t.done || rows < 1 || cols < 1 || (rows == s.rows && cols == s.cols) {
+		return
+	}
+	s.resize(rows, cols)
+	setPtySize(t.pty, rows, cols)
+	t.sync()
+}
+
+func (t *terminal) setCharMode(char bool) {
+	if char && t.done {
+		Global.Input = "Buffer has no process"
+		return
+	}
+	t.charMode = char
+	if char {
+		t.sync()
+		Global.Input = "Char mode; C-c C-j for line mode"
+	} else {
+		Global.Input = "Line mode; C-c C-k for char mode"
+	}
+}
+
+var termKeys = map[string]string{
+	"RET": "\r", "TAB": "\t", "DEL": "\x7f", "ESC": "\x1b", "SPC": " ",
+	"C-@": "\x00", "C-_": "\x1f", "insert": "\x1b[2~", "deletechar": "\x1b[3~",
+	"prior": "\x1b[5~", "next": "\x1b[6~",
+	"f1": "\x1bOP", "f2": "\x1bOQ", "f3": "\x1bOR", "f4": "\x1bOS",
+	"f5": "\x1b[15~", "f6": "\x1b[17~", "f7": "\x1b[18~", "f8": "\x1b[19~",
+	"f9": "\x1b[20~", "f10": "\x1b[21~", "f11": "\x1b[23~", "f12": "\x1b[24~",
+}
+
+// The cursor keys, which send different things in application mode.
+var termCursorKeys = map[string]string{
+	"UP": "A", "DOWN": "B", "RIGHT": "C", "LEFT": "D", "Home": "H", "End": "F",
+}
+
+// What a terminal sends when key is pressed.
+func termKeySequence(key string, appCursor bool) (string, bool) {
+	if strings.HasPrefix(key, "C-M-") {
+		seq, ok := termKeySequence("C-"+key[4:], appCursor)
+		return "\x1b" + seq, ok
+	}
+	if strings.HasPrefix(key, "M-") && 2 < len(key) {
+		seq, ok := termKeySequence(key[2:], appCursor)
+		return "\x1b" + seq, ok
+	}
+	if seq, ok := termKeys[key]; ok {
+		return seq, true
+	}
+	if final, ok := termCursorKeys[key]; ok {
+		if appCursor {
+			return "\x1bO" + final, true
+		}
+		return "\x1b[" + final, true
+	}
+	if len(key) == 3 && strings.HasPrefix(key, "C-") && 'a' <= key[2] && key[2] <= 'z' {
+		return string(rune(key[2] - 'a' + 1)), true
+	}
+	if utf8.RuneCountInString(key) == 1 {
+		return key, true
+	}
+	return "", false
+}
+
+// Deal with a key pressed in char mode. Returns false for keys the editor
+// should have, like M-x and the mouse.
+func (t *terminal) handleKey(key string, env *glisp.Zlisp) bool {
+	if key == "M-x" || strings.HasPrefix(key, "<") {
+		return false
+	}
+	if key == "C-c" {
+		Global.Input = "C-c "
+		editorRefreshScreen()
+		next := editorGetKey()
+		switch next {
+		case "C-j":
+			t.setCharMode(false)
+		case "C-k":
+		case "C-c":
+			Global.Input = ""
+			t.send("\x03")
+		default:
+			com, err := GetCommand(next, Emacs.Children["C-x"], nil)
+			if err != nil {
+				Global.Input = err.Error()
+			} else if com != nil {
+				Global.Input = ""
+				com.Run(env)
+			}
+		}
+		return true
+	}
+	seq, ok := termKeySequence(key, t.screen.appCursor)
+	if !ok {
+		Global.Input = "Can't send " + key + " to the terminal"
+		return true
+	}
+	t.send(seq)
+	return true
+}
+
+// The terminal of the current buffer, if it has one.
+func currentTerm() *terminal {
+	t := Global.CurrentB.term
+	if t == nil {
+		Global.Input = "Not in a terminal buffer"
+	}
+	return t
+}
+
+// Whether buf has a shell or terminal that's still running.
+func bufferHasProcess(buf *EditorBuffer) bool {
+	return (buf.shell != nil && !buf.shell.done) || (buf.term != nil && !buf.term.done)
+}
+
+// Kill buf's shell or terminal, if it has one.
+func killBufferProcess(buf *EditorBuffer) {
+	if buf.shell != nil {
+		buf.shell.kill()
+	}
+	if buf.term != nil && !buf.term.done {
+		buf.term.done = true
+		killProcessGroup(buf.term.cmd)
+	}
+}
+
+// Ask for a program and run it in the *terminal* buffer, or switch to it if
+// there's one running there already.
+func termCommand() {
+	dir := defaultDirectory()
+	buf := namedBuffer("*terminal*")
+	if buf.term == nil || buf.term.done {
+		program := editorPrompt("Run program (default "+shellProgram()+")", nil)
+		words, err := shellquote.Split(program)
+		if err != nil {
+			Global.Input = err.Error()
+			return
+		}
+		if len(words) == 0 {
+			words = []string{shellProgram()}
+		}
+		rows := Global.CurrentBHeight
+		if rows < 1 {
+			rows = 24
+		}
+		cols := 80
+		if sx, _ := GetScreenSize(); 0 < sx {
+			cols = sx
+		}
+		_, err = startTerm(buf, words[0], words[1:], dir, rows, cols)
+		if err != nil {
+			Global.Input = err.Error()
+			AddErrorMessage(err.Error())
+			return
+		}
+	}
+	getFocusWindow().buf = buf
+	Global.CurrentB = buf
+}
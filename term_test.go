@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run what the terminal sends to the main loop until a row of its buffer
+// starts with prefix.
+func waitForRow(t *testing.T, buf *EditorBuffer, prefix string) int {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		found := -1
+		buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+			if strings.HasPrefix(row.Data, prefix) {
+				found = i
+				return false
+			}
+			return true
+		})
+		if 0 <= found {
+			return found
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a row starting %q", prefix)
+		}
+		if !runQueuedJobs() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestTerm(t *testing.T) {
+	InitEditor()
+	buf := setLines("")
+	term, err := startTerm(buf, "sh",
+		[]string{"-c", `printf 'size %s\n' "$(stty size)"; read x; echo got $x`},
+		os.TempDir(), 5, 20)
+	if err != nil {
+		t.Skip("No ptys here: " + err.Error())
+	}
+	defer killBufferProcess(buf)
+	waitForRow(t, buf, "size 5 20")
+	if !bufferHasProcess(buf) || buf.MajorMode != "term" || !term.charMode {
+		t.Error("Expected a running terminal in char mode")
+	}
+	for _, key := range []string{"h", "i", "RET"} {
+		if !term.handleKey(key, nil) {
+			t.Errorf("Expected %s to go to the terminal", key)
+		}
+	}
+	row := waitForRow(t, buf, "got hi")
+	waitForRow(t, buf, "Process sh finished")
+	if bufferHasProcess(buf) || term.charMode {
+		t.Error("Expected the terminal to be done, and in line mode")
+	}
+	if buf.Row(row-1).Data != "hi" {
+		t.Errorf("Expected the terminal to echo the input, got %q", buf.Row(row-1).Data)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// A job that runs on the main goroutine every so often, while the editor is
+// waiting for a key. Running it there means it can touch buffers safely.
+type periodicJob struct {
+	interval func() time.Duration // zero or less disables the job
+	run      func() bool          // returns whether the screen needs redrawing
+	last     time.Time
+	// Whether the job replaces buffers' text, which mustn't happen under a
+	// command that's waiting for a key (in a prompt, say) and still has
+	// places in them in hand. It waits until the command is over.
+	edits bool
+}
+
+var periodicJobs = []*periodicJob{
+	{interval: func() time.Duration { return time.Duration(Global.AutoSaveTimeout) * time.Second },
+		run: doTimedAutoSave},
+	{interval: func() time.Duration { return time.Duration(Global.AutoRevertInterval) * time.Second },
+		run: autoRevertBuffers, edits: true},
+	{interval: func() time.Duration { return time.Second }, run: lspSyncBuffers},
+	{interval: func() time.Duration { return time.Second }, run: vcUpdateGutters},
+}
+
+// Wake up editorGetKey once a second so that it can run the periodic jobs.
+func startTimers() {
+	now := time.Now()
+	for _, job := range periodicJobs {
+		job.last = now
+	}
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		for range ticker.C {
+			termbox.Interrupt()
+		}
+	}()
+}
+
+// Run the jobs that are due. Returns whether the screen needs redrawing.
+func runPeriodicJobs() bool {
+	now := time.Now()
+	redraw := false
+	for _, job := range periodicJobs {
+		interval := job.interval()
+		if job.edits && 0 < commandDepth {
+			continue
+		}
+		if 0 < interval && interval <= now.Sub(job.last) {
+			job.last = now
+			redraw = job.run() || redraw
+		}
+	}
+	return redraw
+}
+
+// Work that other goroutines have handed to the main one, because it touches
+// buffers.
+var mainQueue struct {
+	sync.Mutex
+	jobs []func()
+}
+
+// Wakes up editorGetKey; a variable so that tests can do without a terminal.
+var wakeMain = termbox.Interrupt
+
+// Have the main goroutine run f soon, while it's waiting for a key.
+func runOnMain(f func()) {
+	mainQueue.Lock()
+	wake := len(mainQueue.jobs) == 0
+	mainQueue.jobs = append(mainQueue.jobs, f)
+	mainQueue.Unlock()
+	if wake {
+		wakeMain()
+	}
+}
+
+// Run the work handed to the main goroutine. Returns whether there was any,
+// in which case the screen needs redrawing.
+func runQueuedJobs() bool {
+	mainQueue.Lock()
+	jobs := mainQueue.jobs
+	mainQueue.jobs = nil
+	mainQueue.Unlock()
+	for _, job := range jobs {
+		job()
+	}
+	return len(jobs) > 0
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	glisp "github.com/glycerine/zygomys/zygo"
 )
@@ -15,21 +17,89 @@ type EditorUndo struct {
 	endc   int
 	str    string
 	prev   *EditorUndo
+	// Made by the same command as prev, so undone and redone along with it
 	paired bool
+	cmd    int
+	typed  bool // Made by a command that types or deletes a character
+	// The record that undo-in-region undid to make this one
+	reverts *EditorUndo
+	// Undo never throws anything away: each undo is a node in a tree, and
+	// making a change after undoing starts a new branch.
+	next   []*EditorUndo
+	branch int // The branch of next that redo takes
+	time   time.Time
+}
+
+// The node that undo records made now will hang off. nil means the buffer as
+// it was before any of them, which is represented by undoStart.
+func (buf *EditorBuffer) undoNode(u *EditorUndo) *EditorUndo {
+	if u == nil {
+		return &buf.undoStart
+	}
+	return u
+}
+
+// Forget all undo information, e.g. because the buffer has been reloaded.
+func (buf *EditorBuffer) clearUndo() {
+	buf.Undo = nil
+	buf.SaveUndo = nil
+	buf.undoStart = EditorUndo{}
+}
+
+// Which of its parent's branches u is.
+func (buf *EditorBuffer) undoIndex(u *EditorUndo) int {
+	for i, sib := range buf.undoNode(u.prev).next {
+		if sib == u {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add a new undo record after the current one, starting a new branch if
+// something has been undone. Records made by the same command are paired up,
+// so that the command is undone as a whole.
+func editorPushUndo(u *EditorUndo) {
+	buf := Global.CurrentB
+	parent := buf.undoNode(buf.Undo)
+	u.prev = buf.Undo
+	u.time = time.Now()
+	u.cmd = commandSeq
+	u.typed = 0 < commandDepth && commandTypes
+	if 0 < commandDepth && buf.Undo != nil && buf.Undo.cmd == commandSeq {
+		u.paired = true
+	}
+	parent.next = append(parent.next, u)
+	parent.branch = len(parent.next) - 1
+	buf.Undo = u
 }
 
 // Discards the last undo. Useful for the region functions, as they're made of
 // regular insertion functions (which take care of their own undo)
 func editorPopUndo() {
-	old := Global.CurrentB.Undo
+	buf := Global.CurrentB
+	old := buf.Undo
 	if old == nil {
 		return
 	}
-	Global.CurrentB.Undo = old.prev
+	parent := buf.undoNode(old.prev)
+	if i := buf.undoIndex(old); i >= 0 {
+		parent.next = append(parent.next[:i], parent.next[i+1:]...)
+	}
+	if parent.branch >= len(parent.next) {
+		parent.branch = len(parent.next) - 1
+		if parent.branch < 0 {
+			parent.branch = 0
+		}
+	}
+	buf.Undo = old.prev
 }
 
+// Every change is recorded before it's made, so the recorders are where
+// read-only buffers are refused; otherwise undo would take back a change that
+// never happened.
 func editorAddRegionUndo(ins bool, startc, endc, startl, endl int, str string) {
-	old := Global.CurrentB.Undo
+	barfIfReadOnly(Global.CurrentB)
 	ret := new(EditorUndo)
 	ret.endl = endl
 	ret.startl = startl
@@ -38,25 +108,43 @@ func editorAddRegionUndo(ins bool, startc, endc, startl, endl int, str string) {
 	ret.str = str
 	ret.ins = ins
 	ret.region = true
+	editorPushUndo(ret)
+}
 
-	if old == nil {
-		ret.prev = nil
-	} else {
-		ret.prev = old
+// How many characters of typing (or deleting) are undone at once.
+const undoRunMax = 20
+
+// Whether str can be added on to the record old. A command's changes go
+// together, and so do those of a run of commands that each type or delete a
+// character, up to undoRunMax characters, as in Emacs. Anything else is undone
+// separately.
+func canMergeUndo(old *EditorUndo, str string) bool {
+	if commandDepth == 0 || old.cmd == commandSeq {
+		return true
+	}
+	return old.typed && commandTypes &&
+		utf8.RuneCountInString(old.str)+utf8.RuneCountInString(str) <= undoRunMax
+}
+
+// Add a change to old, which now belongs to the running command.
+func mergedUndo(old *EditorUndo) {
+	old.time = time.Now()
+	if 0 < commandDepth {
+		old.cmd = commandSeq
 	}
-	Global.CurrentB.Undo = ret
-	Global.CurrentB.Redo = nil
 }
 
 func editorAddInsertUndo(startc, startl int, str string) {
+	barfIfReadOnly(Global.CurrentB)
 	old := Global.CurrentB.Undo
 	newlines := strings.Count(str, "\n")
 	lastnl := 0
 	if 0 < newlines {
 		lastnl = strings.LastIndex(str, "\n") + 1
 	}
-	if old != nil && old != Global.CurrentB.SaveUndo &&
-		old.ins && old.endc == startc && old.endl == startl {
+	if old != nil && old != Global.CurrentB.SaveUndo && len(old.next) == 0 &&
+		old.ins && old.endc == startc && old.endl == startl && canMergeUndo(old, str) {
+		mergedUndo(old)
 		old.str += str
 		old.endl += newlines
 		if newlines <= 0 {
@@ -79,32 +167,31 @@ func editorAddInsertUndo(startc, startl int, str string) {
 			ret.endl = startl + newlines
 			ret.endc = len(str[lastnl:])
 		}
-		ret.prev = old
-		Global.CurrentB.Undo = ret
+		editorPushUndo(ret)
 	}
-	Global.CurrentB.Redo = nil
 }
 
 func editorAddDeleteUndo(startc, endc, startl, endl int, str string) {
+	barfIfReadOnly(Global.CurrentB)
 	old := Global.CurrentB.Undo
 	ins := false
-	app := false
+	app, forward := false, false
 	if old != nil {
-		app = old.startl == startl && old.endl == endl && old.ins == ins && old != Global.CurrentB.SaveUndo
+		app = old.startl == startl && old.endl == endl && old.ins == ins &&
+			old != Global.CurrentB.SaveUndo && len(old.next) == 0 && canMergeUndo(old, str)
 		if app {
-			if ins {
-				app = old.endc == startc
-			} else {
-				app = old.startc == endc
-			}
+			// Deleting backwards ends where the last deletion started;
+			// deleting forwards starts in the same place
+			forward = startl == endl && old.startc == startc
+			app = old.startc == endc || forward
 		}
 	}
 	if app {
-		Global.CurrentB.Redo = nil
+		mergedUndo(old)
 		//append to group things together, ala gnu
-		if ins {
+		if forward {
 			old.str += str
-			old.endc = endc
+			old.endc += endc - startc
 		} else {
 			old.str = str + old.str
 			old.startc = startc
@@ -118,14 +205,7 @@ func editorAddDeleteUndo(startc, endc, startl, endl int, str string) {
 		ret.str = str
 		ret.ins = ins
 		ret.region = false
-
-		if old == nil {
-			ret.prev = nil
-		} else {
-			ret.prev = old
-		}
-		Global.CurrentB.Undo = ret
-		Global.CurrentB.Redo = nil
+		editorPushUndo(ret)
 	}
 }
 
@@ -145,7 +225,7 @@ func editorDoUndo(tree *EditorUndo) bool {
 		// Insertion
 		if tree.startl == tree.endl {
 			// Basic string insertion
-			editorRowDelChar(Global.CurrentB.Rows[tree.startl], Global.CurrentB,
+			editorRowDelChar(Global.CurrentB.Row(tree.startl), Global.CurrentB,
 				tree.startc, len(tree.str))
 			Global.CurrentB.cx = tree.startc
 			Global.CurrentB.cy = tree.startl
@@ -154,7 +234,7 @@ func editorDoUndo(tree *EditorUndo) bool {
 			// inserting a line
 			Global.CurrentB.cx = tree.startc
 			Global.CurrentB.cy = tree.startl
-			editorRowAppendStr(Global.CurrentB.Rows[tree.startl], Global.CurrentB, tree.str)
+			editorRowAppendStr(Global.CurrentB.Row(tree.startl), Global.CurrentB, tree.str)
 			editorDelRow(tree.endl)
 			return true
 		}
@@ -162,20 +242,20 @@ func editorDoUndo(tree *EditorUndo) bool {
 		// Deletion
 		if tree.startl == tree.endl {
 			// Character or word deletion
-			editorRowInsertStr(Global.CurrentB.Rows[tree.startl], Global.CurrentB,
+			editorRowInsertStr(Global.CurrentB.Row(tree.startl), Global.CurrentB,
 				tree.startc, tree.str)
 			Global.CurrentB.cx = tree.endc
 			Global.CurrentB.cy = tree.startl
 			return true
 		} else {
 			// deleting a line
-			editorInsertRow(tree.startl, Global.CurrentB.Rows[tree.startl].Data[:tree.endc])
-			row := Global.CurrentB.Rows[tree.endl]
+			editorInsertRow(tree.startl, Global.CurrentB.Row(tree.startl).Data[:tree.endc])
+			row := Global.CurrentB.Row(tree.endl)
 			row.Data = tree.str
 			row.Size = len(row.Data)
-			Global.CurrentB.Rows[tree.startl].Size = len(Global.CurrentB.Rows[tree.startl].Data)
+			Global.CurrentB.Row(tree.startl).Size = len(Global.CurrentB.Row(tree.startl).Data)
 			editorUpdateRow(row, Global.CurrentB)
-			editorUpdateRow(Global.CurrentB.Rows[tree.startl], Global.CurrentB)
+			editorUpdateRow(Global.CurrentB.Row(tree.startl), Global.CurrentB)
 			return true
 		}
 	}
@@ -196,7 +276,7 @@ func editorDoRedo(tree *EditorUndo) {
 		} else if tree.startl == -1 {
 			editorAppendRow(tree.str)
 			Global.CurrentB.cx = tree.endc
-			Global.CurrentB.cy = Global.CurrentB.NumRows - 1
+			Global.CurrentB.cy = Global.CurrentB.NumRows() - 1
 		} else {
 			Global.CurrentB.cx = tree.startc
 			Global.CurrentB.cy = tree.startl
@@ -215,38 +295,59 @@ func editorDoRedo(tree *EditorUndo) {
 	}
 }
 
+// Undo the current record, leaving its branch as the one redo takes.
+func undoOne() bool {
+	buf := Global.CurrentB
+	u := buf.Undo
+	if !editorDoUndo(u) {
+		return false
+	}
+	buf.undoNode(u.prev).branch = buf.undoIndex(u)
+	buf.Undo = u.prev
+	if buf.Undo == buf.SaveUndo {
+		buf.Dirty = false
+	}
+	return true
+}
+
+// Redo r, which must be one of the branches after the current record.
+func redoOne(r *EditorUndo) {
+	buf := Global.CurrentB
+	i := buf.undoIndex(r)
+	editorDoRedo(r)
+	// Redoing can make (and pop) undo records of its own; put things back
+	buf.undoNode(r.prev).branch = i
+	buf.Undo = r
+	if r == buf.SaveUndo {
+		buf.Dirty = false
+	}
+}
+
+// The record redo would do next, or nil.
+func (buf *EditorBuffer) nextRedo() *EditorUndo {
+	node := buf.undoNode(buf.Undo)
+	if len(node.next) == 0 {
+		return nil
+	}
+	return node.next[node.branch]
+}
+
 func editorUndoAction() {
-	r := Global.CurrentB.Redo
-	succ := editorDoUndo(Global.CurrentB.Undo)
 	paired := Global.CurrentB.Undo != nil && Global.CurrentB.Undo.paired
-	if succ {
-		Global.CurrentB.Redo = Global.CurrentB.Undo
-		Global.CurrentB.Undo = Global.CurrentB.Undo.prev
-		Global.CurrentB.Redo.prev = r
-		if paired {
-			editorUndoAction()
-		}
-	} else {
+	if !undoOne() {
 		Global.Input = "No further undo information."
-	}
-	if Global.CurrentB.Undo == Global.CurrentB.SaveUndo {
-		Global.CurrentB.Dirty = false
+	} else if paired {
+		editorUndoAction()
 	}
 }
 
 func doOneRedo(env *glisp.Zlisp) {
-	if Global.CurrentB.Redo == nil {
+	r := Global.CurrentB.nextRedo()
+	if r == nil {
 		Global.Input = "No further redo information."
 	} else {
-		r := Global.CurrentB.Redo
-		editorDoRedo(Global.CurrentB.Redo)
-		Global.CurrentB.Redo = r.prev
-		r.prev = Global.CurrentB.Undo
-		Global.CurrentB.Undo = r
-		if r == Global.CurrentB.SaveUndo {
-			Global.CurrentB.Dirty = false
-		}
-		if Global.CurrentB.Redo != nil && Global.CurrentB.Redo.paired {
+		redoOne(r)
+		if next := Global.CurrentB.nextRedo(); next != nil && next.paired {
 			doOneRedo(env)
 		}
 	}
@@ -255,3 +356,71 @@ func doOneRedo(env *glisp.Zlisp) {
 func editorRedoAction(env *glisp.Zlisp) {
 	micromode("C-_", "Press C-_ or C-/ to redo again", env, doOneRedo)
 }
+
+// u as an undo record for a region: the text it inserted (or deleted) and
+// where that text is (or was) in the buffer.
+func (u *EditorUndo) asRegion() *EditorUndo {
+	ret := &EditorUndo{ins: u.ins, region: true, startl: u.startl,
+		endl: u.endl, startc: u.startc, endc: u.endc, str: u.str}
+	if !u.region && !u.ins && u.startl != u.endl {
+		// Joining a line onto the one before deleted a newline at the end
+		// of the line before
+		ret.startc, ret.endc, ret.str = u.endc, 0, "\n"
+	}
+	return ret
+}
+
+func posBefore(l1, c1, l2, c2 int) bool {
+	return l1 < l2 || (l1 == l2 && c1 < c2)
+}
+
+// Find the last change that lies inside the region, going back no further than
+// the first change that moved the region's text around.
+func findUndoInRegion(buf *EditorBuffer, startc, endc, startl, endl int, consecutive bool) *EditorUndo {
+	reverted := map[*EditorUndo]bool{}
+	for u := buf.Undo; u != nil; u = u.prev {
+		r := u.asRegion()
+		// Where the change is now; deletions take up no space
+		ul, uc, vl, vc := r.startl, r.startc, r.endl, r.endc
+		if !r.ins {
+			vl, vc = ul, uc
+		}
+		if !posBefore(ul, uc, startl, startc) && !posBefore(endl, endc, vl, vc) {
+			if consecutive && u.reverts != nil {
+				reverted[u.reverts] = true
+			} else if !reverted[u] {
+				return u
+			}
+		} else if !posBefore(ul, uc, endl, endc) {
+			// After the region, so it didn't move anything in it
+		} else if vl < startl && !strings.Contains(r.str, "\n") {
+			// On a line above the region, and didn't add or remove lines
+		} else {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Undo the last change inside the region, leaving everything else alone. The
+// undo is a change like any other, so it can itself be undone. Repeating the
+// command undoes changes further back.
+func undoInRegion(env *glisp.Zlisp) {
+	consecutive := Global.LastCommand != nil && Global.LastCommand.Name == "undo-in-region"
+	regionCmd(func(buf *EditorBuffer, startc, endc, startl, endl int) string {
+		target := findUndoInRegion(buf, startc, endc, startl, endl, consecutive)
+		if target == nil {
+			Global.Input = "No further undo information in region."
+			return ""
+		}
+		inverse := target.asRegion()
+		inverse.ins = !inverse.ins
+		editorDoRedo(inverse)
+		editorPushUndo(inverse)
+		inverse.reverts = target
+		buf.Dirty = true
+		buf.regionActive = false
+		Global.Input = "Undo in region"
+		return ""
+	})
+}
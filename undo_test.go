@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"strconv"
 	"testing"
+
+	glisp "github.com/glycerine/zygomys/zygo"
 )
 
 func (b *EditorBuffer) FailIfBufferNe(lines []string, t *testing.T) {
@@ -14,7 +16,8 @@ func (b *EditorBuffer) FailIfBufferNe(lines []string, t *testing.T) {
 
 func (b *EditorBuffer) PrintBuf() string {
 	buf := bytes.Buffer{}
-	for i, row := range b.Rows {
+	for i := 0; i < b.NumRows(); i++ {
+		row := b.Row(i)
 		buf.WriteString(strconv.Itoa(i))
 		buf.WriteString(": ")
 		buf.WriteString(row.Data)
@@ -25,14 +28,14 @@ func (b *EditorBuffer) PrintBuf() string {
 
 func (b *EditorBuffer) TestIs(lines []string) bool {
 	// Special case - one empty line is functionally equivalent to an empty buffer
-	if len(lines) == 0 && (b.LinesNum() == 1 && b.Rows[0].Data == "") {
+	if len(lines) == 0 && (b.LinesNum() == 1 && b.Row(0).Data == "") {
 		return true
 	}
-	if len(lines) != len(b.Rows) {
+	if len(lines) != b.NumRows() {
 		return false
 	}
 	for i, line := range lines {
-		if b.Rows[i].Data != line {
+		if b.Row(i).Data != line {
 			return false
 		}
 	}
@@ -141,3 +144,123 @@ func TestMultilineDeleteRedo(t *testing.T) {
 	doOneRedo(nil)
 	Global.CurrentB.FailIfBufferNe([]string{}, t)
 }
+
+func TestCommandUndoesAsOne(t *testing.T) {
+	InitEditor()
+	editorInsertStr("middle")
+	cmd := &CommandFunc{"test-surround", func(*glisp.Zlisp) {
+		buf := Global.CurrentB
+		buf.cx = 0
+		editorInsertStr("(")
+		buf.cx = buf.Row(0).Size
+		editorInsertStr(")")
+	}, false}
+	cmd.Run(nil)
+	Global.CurrentB.FailIfBufferNe([]string{"(middle)"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"middle"}, t)
+	doOneRedo(nil)
+	Global.CurrentB.FailIfBufferNe([]string{"(middle)"}, t)
+}
+
+// Type s a key at a time, as self-inserting commands.
+func typeKeys(s string) {
+	for _, r := range s {
+		runAsCommand(string(r), func() { editorInsertStr(string(r)) })
+	}
+}
+
+func TestCommandsDontMergeUndo(t *testing.T) {
+	InitEditor()
+	runAsCommand("test-insert", func() { editorInsertStr("foo") })
+	typeKeys("hello")
+	Global.CurrentB.FailIfBufferNe([]string{"foohello"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"foo"}, t)
+	runAsCommand("test-insert", func() {
+		editorInsertStr("X")
+		editorInsertStr("Y")
+	})
+	typeKeys("Z")
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"fooXY"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"foo"}, t)
+
+	typeKeys("abcdefghijklmnopqrstuvwxy")
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"fooabcdefghijklmnopqrst"}, t)
+	Global.CurrentB.clearUndo()
+	for i := 0; i < 3; i++ {
+		runAsCommand("delete-backward-char", editorDelChar)
+	}
+	Global.CurrentB.FailIfBufferNe([]string{"fooabcdefghijklmnopq"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"fooabcdefghijklmnopqrst"}, t)
+	Global.CurrentB.cx = 3
+	for i := 0; i < 3; i++ {
+		runAsCommand("delete-char", editorDelForwardChar)
+	}
+	Global.CurrentB.FailIfBufferNe([]string{"foodefghijklmnopqrst"}, t)
+	runAsCommand("test-delete", editorDelForwardChar)
+	Global.CurrentB.FailIfBufferNe([]string{"fooefghijklmnopqrst"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"foodefghijklmnopqrst"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"fooabcdefghijklmnopqrst"}, t)
+}
+
+// Put the region around line n.
+func selectLine(n int) {
+	buf := Global.CurrentB
+	buf.MarkX, buf.MarkY = 0, n
+	buf.cx, buf.cy = buf.Row(n).Size, n
+}
+
+// Insert s at the end of line n.
+func appendToLine(n int, s string) {
+	buf := Global.CurrentB
+	buf.cx, buf.cy = buf.Row(n).Size, n
+	editorInsertStr(s)
+}
+
+func TestUndoInRegion(t *testing.T) {
+	InitEditor()
+	editorInsertStr("aaa")
+	editorInsertNewline(false)
+	editorInsertStr("bbb")
+	editorInsertNewline(false)
+	editorInsertStr("ccc")
+	Global.CurrentB.clearUndo()
+	appendToLine(2, "Y")
+	appendToLine(0, "X")
+	selectLine(2)
+	undoInRegion(nil)
+	Global.CurrentB.FailIfBufferNe([]string{"aaaX", "bbb", "ccc"}, t)
+	editorUndoAction()
+	Global.CurrentB.FailIfBufferNe([]string{"aaaX", "bbb", "cccY"}, t)
+	selectLine(1)
+	undoInRegion(nil)
+	if Global.Input != "No further undo information in region." {
+		t.Error("Expected no undo information for an unchanged region, but got", Global.Input)
+	}
+}
+
+func TestUndoInRegionRepeated(t *testing.T) {
+	InitEditor()
+	editorInsertStr("aaa")
+	editorInsertNewline(false)
+	editorInsertStr("bbb")
+	Global.CurrentB.clearUndo()
+	appendToLine(1, "Y")
+	buf := Global.CurrentB
+	buf.cx = 0
+	editorInsertStr("Z")
+	selectLine(1)
+	undoInRegion(nil)
+	buf.FailIfBufferNe([]string{"aaa", "bbbY"}, t)
+	Global.LastCommand = &CommandFunc{"undo-in-region", undoInRegion, false}
+	selectLine(1)
+	undoInRegion(nil)
+	buf.FailIfBufferNe([]string{"aaa", "bbb"}, t)
+}
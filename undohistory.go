@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/uinta-labs/configdir"
+)
+
+// Undo trees are kept between sessions in Global.UndoDir, one file per file
+// edited, named after a hash of its path. An undo tree is only any use if the
+// file is exactly as it was when the tree was written, so the file's contents
+// are hashed too, and the tree is thrown away if they don't match.
+
+// An undo record, as written to disk. The records of a tree are written
+// parents first, so that each can refer to its parent by its index.
+type undoHistoryRecord struct {
+	Ins    bool
+	Region bool
+	Paired bool
+	StartL int
+	EndL   int
+	StartC int
+	EndC   int
+	Str    string
+	Time   time.Time
+	Parent int // -1 for the unedited file
+	Branch int
+}
+
+type undoHistory struct {
+	Path   string
+	Sum    string // Hash of the file's contents that the tree leads to
+	Branch int    // The branch redo takes from the unedited file
+	// The record that leaves the buffer as it is on disk, or -1
+	Current int
+	Records []undoHistoryRecord
+}
+
+// Where undo histories go unless the user says otherwise.
+func defaultUndoDir() string {
+	folder := configdir.New("japanoise", "gomacs").QueryUserFolder()
+	if folder == nil {
+		return ""
+	}
+	return filepath.Join(folder.Path, "undo")
+}
+
+func hashContents(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// The file fn's undo history is kept in.
+func undoHistoryName(fn string) string {
+	sum := sha256.Sum256([]byte(fn))
+	return filepath.Join(Global.UndoDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// Whether the buffer's undo history should be kept between sessions.
+func (buf *EditorBuffer) keepsUndoHistory() bool {
+	return Global.UndoDir != "" && buf.Filename != "" && buf.fileSum != "" &&
+		buf.hasMode("persistent-undo-mode")
+}
+
+// Write the buffer's undo tree out, as it stands relative to its file on disk.
+func (buf *EditorBuffer) saveUndoHistory() error {
+	if !buf.keepsUndoHistory() || buf.changedOnDisk() {
+		return nil
+	}
+	if buf.undoStart.next == nil {
+		// Nothing to save, and don't clobber what's there with nothing
+		return nil
+	}
+	hist := undoHistory{buf.Filename, buf.fileSum, buf.undoStart.branch, -1, nil}
+	index := map[*EditorUndo]int{}
+	queue := append([]*EditorUndo{}, buf.undoStart.next...)
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		parent := -1
+		if u.prev != nil {
+			parent = index[u.prev]
+		}
+		index[u] = len(hist.Records)
+		if u == buf.SaveUndo {
+			hist.Current = index[u]
+		}
+		hist.Records = append(hist.Records, undoHistoryRecord{u.ins, u.region,
+			u.paired, u.startl, u.endl, u.startc, u.endc, u.str,
+			u.time, parent, u.branch})
+		queue = append(queue, u.next...)
+	}
+	data, err := json.Marshal(&hist)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(Global.UndoDir, 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(undoHistoryName(buf.Filename), data, 0600)
+}
+
+// Read the buffer's undo tree back in, if there is one for its file as it is
+// now. The buffer is left in the state it was saved in, with everything done
+// since then available to redo.
+func (buf *EditorBuffer) loadUndoHistory() error {
+	if !buf.keepsUndoHistory() {
+		return nil
+	}
+	data, err := ioutil.ReadFile(undoHistoryName(buf.Filename))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	hist := undoHistory{}
+	err = json.Unmarshal(data, &hist)
+	if err != nil {
+		return err
+	}
+	if hist.Path != buf.Filename || hist.Sum != buf.fileSum {
+		return nil
+	}
+	buf.clearUndo()
+	records := make([]*EditorUndo, len(hist.Records))
+	for i, r := range hist.Records {
+		u := &EditorUndo{ins: r.Ins, region: r.Region, paired: r.Paired,
+			startl: r.StartL, endl: r.EndL, startc: r.StartC, endc: r.EndC,
+			str: r.Str, time: r.Time, branch: r.Branch}
+		if 0 <= r.Parent && r.Parent < i {
+			u.prev = records[r.Parent]
+		}
+		parent := buf.undoNode(u.prev)
+		parent.next = append(parent.next, u)
+		records[i] = u
+	}
+	buf.undoStart.branch = hist.Branch
+	for _, u := range append(records, &buf.undoStart) {
+		if u.branch < 0 || len(u.next) <= u.branch {
+			u.branch = 0
+		}
+	}
+	if 0 <= hist.Current && hist.Current < len(records) {
+		buf.Undo = records[hist.Current]
+	}
+	buf.SaveUndo = buf.Undo
+	return nil
+}
+
+// Save every buffer's undo history, e.g. because we're quitting.
+func saveUndoHistories() {
+	for _, buf := range Global.Buffers {
+		err := buf.saveUndoHistory()
+		if err != nil {
+			AddErrorMessage("Couldn't save undo history: " + err.Error())
+		}
+	}
+}
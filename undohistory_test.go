@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Edit fn, save it, make a change that isn't saved, and quit.
+func makeUndoHistory(fn, undodir string) {
+	InitEditor()
+	Global.MinorModes["persistent-undo-mode"] = true
+	Global.UndoDir = undodir
+	EditorOpen(fn, nil)
+	editorInsertStr("x")
+	editorBufSave(Global.CurrentB, nil)
+	editorInsertStr("y")
+	saveUndoHistories()
+}
+
+func reopenWithUndoHistory(fn, undodir string) *EditorBuffer {
+	InitEditor()
+	Global.MinorModes["persistent-undo-mode"] = true
+	Global.UndoDir = undodir
+	EditorOpen(fn, nil)
+	return Global.CurrentB
+}
+
+func TestUndoHistoryIsRestored(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.txt")
+	ioutil.WriteFile(fn, []byte("a\n"), 0644)
+	undodir := filepath.Join(dir, "undo")
+	makeUndoHistory(fn, undodir)
+	buf := reopenWithUndoHistory(fn, undodir)
+	buf.FailIfBufferNe([]string{"xa"}, t)
+	if buf.Undo == nil || buf.Undo != buf.SaveUndo {
+		t.Error("Expected to be at the saved state")
+	}
+	doOneRedo(nil)
+	buf.FailIfBufferNe([]string{"xya"}, t)
+	if !buf.Dirty {
+		t.Error("Expected redoing the unsaved change to dirty the buffer")
+	}
+	editorUndoAction()
+	if buf.Dirty {
+		t.Error("Expected undoing back to the saved state to undirty the buffer")
+	}
+	editorUndoAction()
+	buf.FailIfBufferNe([]string{"a"}, t)
+}
+
+func TestUndoHistoryNeedsSameContents(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.txt")
+	ioutil.WriteFile(fn, []byte("a\n"), 0644)
+	undodir := filepath.Join(dir, "undo")
+	makeUndoHistory(fn, undodir)
+	ioutil.WriteFile(fn, []byte("changed\n"), 0644)
+	buf := reopenWithUndoHistory(fn, undodir)
+	if buf.Undo != nil || len(buf.undoStart.next) != 0 {
+		t.Error("Undo history was restored for a file that has changed")
+	}
+}
+
+func TestUndoHistoryKeepsBranches(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "test.txt")
+	ioutil.WriteFile(fn, []byte("a\n"), 0644)
+	undodir := filepath.Join(dir, "undo")
+	InitEditor()
+	Global.MinorModes["persistent-undo-mode"] = true
+	Global.UndoDir = undodir
+	EditorOpen(fn, nil)
+	editorInsertStr("first")
+	editorUndoAction()
+	editorInsertStr("second")
+	editorBufSave(Global.CurrentB, nil)
+	buf := reopenWithUndoHistory(fn, undodir)
+	if len(buf.undoStart.next) != 2 || buf.undoStart.branch != 1 {
+		t.Fatal("Expected 2 branches, the second selected")
+	}
+	undoTreeGoto(buf.undoStart.next[0])
+	buf.FailIfBufferNe([]string{"firsta"}, t)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+)
+
+// A short description of what an undo record does, for showing to the user.
+func (u *EditorUndo) describe() string {
+	op := "-"
+	if u.ins {
+		op = "+"
+	}
+	str := u.str
+	if 20 < len(str) {
+		str = str[:17] + "..."
+	}
+	return op + strconv.Quote(str)
+}
+
+// The records made by one command (see paired) are one state as far as the
+// user is concerned; this is the last of them if u is one of the others.
+func (buf *EditorBuffer) undoState(u *EditorUndo) *EditorUndo {
+	for node := buf.undoNode(u); len(node.next) == 1 && node.next[0].paired; node = u {
+		u = node.next[0]
+	}
+	return u
+}
+
+// Undo and redo until the buffer is as it was after target (or, if target is
+// nil, as it was before any of the changes).
+func undoTreeGoto(target *EditorUndo) {
+	buf := Global.CurrentB
+	target = buf.undoState(target)
+	ancestors := map[*EditorUndo]bool{}
+	for u := target; u != nil; u = u.prev {
+		ancestors[u] = true
+	}
+	for buf.Undo != nil && !ancestors[buf.Undo] {
+		undoOne()
+	}
+	path := []*EditorUndo{}
+	for u := target; u != buf.Undo; u = u.prev {
+		path = append(path, u)
+	}
+	for i := len(path) - 1; 0 <= i; i-- {
+		redoOne(path[i])
+	}
+}
+
+// Call f on every record in the undo tree.
+func (buf *EditorBuffer) eachUndo(f func(*EditorUndo)) {
+	stack := append([]*EditorUndo{}, buf.undoStart.next...)
+	for len(stack) > 0 {
+		u := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		f(u)
+		stack = append(stack, u.next...)
+	}
+}
+
+// The last record made at or before t, or nil if there were none.
+func (buf *EditorBuffer) undoAtTime(t time.Time) *EditorUndo {
+	var ret *EditorUndo
+	buf.eachUndo(func(u *EditorUndo) {
+		if !u.time.After(t) && (ret == nil || ret.time.Before(u.time)) {
+			ret = u
+		}
+	})
+	return ret
+}
+
+// Understand a time given either as how long ago it was ("10m", "1h30m ago")
+// or as a time today ("14:05", "14:05:30").
+func parseUndoTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "ago"))
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(),
+				t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	return now, errors.New("Can't understand time " + s)
+}
+
+func undoTreeJumpToTime(env *glisp.Zlisp) {
+	s := editorPrompt("Go to state at time (e.g. 10m or 14:05)", nil)
+	if s == "" {
+		Global.Input = "Cancelled."
+		return
+	}
+	t, err := parseUndoTime(s, time.Now())
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(Global.Input)
+		return
+	}
+	undoTreeGoto(Global.CurrentB.undoAtTime(t))
+	Global.Input = "Buffer as it was at " + t.Format("15:04:05")
+}
+
+// Choose which branch after the current state redo takes.
+func undoTreeSwitchBranch(env *glisp.Zlisp) {
+	buf := Global.CurrentB
+	node := buf.undoNode(buf.Undo)
+	if len(node.next) < 2 {
+		Global.Input = "No branches to switch to."
+		return
+	}
+	choices := make([]string, len(node.next))
+	for i, u := range node.next {
+		choices[i] = fmt.Sprintf("%d: %s %s", i+1, u.time.Format("15:04:05"), u.describe())
+	}
+	choice := editorChoiceIndex("Redo branch", choices, node.branch)
+	if 0 <= choice && choice < len(node.next) {
+		node.branch = choice
+		Global.Input = fmt.Sprintf("Switched to branch %d", choice+1)
+	}
+}
+
+// Draw the undo tree with the oldest state at the top, one state per line.
+// Returns the lines and which of them is the current state.
+func (buf *EditorBuffer) drawUndoTree() ([]string, int) {
+	lines := []string{}
+	cur := 0
+	var walk func(u *EditorUndo, first, rest string)
+	walk = func(u *EditorUndo, first, rest string) {
+		for {
+			label := "original"
+			if u != nil {
+				label = u.time.Format("15:04:05") + " " + u.describe()
+				n := 0
+				for state := buf.undoState(u); u != state; n++ {
+					u = u.next[0]
+				}
+				if n == 1 {
+					label += " " + u.describe()
+				} else if n > 1 {
+					label += fmt.Sprintf(" and %d more", n)
+				}
+			}
+			marker := "o"
+			if u == buf.Undo {
+				marker = "x"
+				cur = len(lines)
+			}
+			lines = append(lines, first+marker+" "+label)
+			node := buf.undoNode(u)
+			if len(node.next) == 0 {
+				return
+			} else if len(node.next) == 1 {
+				u, first = node.next[0], rest
+				continue
+			}
+			for i, child := range node.next {
+				conn, cont := "├", "│ "
+				if i == len(node.next)-1 {
+					conn, cont = "└", "  "
+				}
+				if i == node.branch {
+					conn += ">"
+				} else {
+					conn += "─"
+				}
+				walk(child, rest+conn, rest+cont)
+			}
+			return
+		}
+	}
+	walk(nil, "", "")
+	return lines, cur
+}
+
+// Move to the state on the branch beside the current one.
+func undoTreeSwitchSibling(delta int) {
+	buf := Global.CurrentB
+	u := buf.Undo
+	for u != nil && u.paired {
+		u = u.prev
+	}
+	if u == nil {
+		return
+	}
+	sibs := buf.undoNode(u.prev).next
+	i := buf.undoIndex(u) + delta
+	if 0 <= i && i < len(sibs) {
+		undoTreeGoto(sibs[i])
+	}
+}
+
+// Fill viz with a drawing of buf's undo tree, scrolled to the current state.
+func showUndoTree(viz, buf *EditorBuffer) {
+	lines, cur := buf.drawUndoTree()
+	rows := make([]*EditorRow, len(lines))
+	for i, line := range lines {
+		rows[i] = &EditorRow{Size: len(line), Data: line}
+		rowUpdateRender(rows[i])
+	}
+	viz.SetRows(rows)
+	viz.cy = cur
+	if viz.cy < viz.rowoff {
+		viz.rowoff = viz.cy
+	} else if viz.rowoff+Global.CurrentBHeight <= viz.cy {
+		viz.rowoff = viz.cy - Global.CurrentBHeight + 1
+	}
+}
+
+// Show the undo tree in a window below the buffer. Moving around the tree
+// changes the buffer as you go, so you can see what each state looks like.
+func undoTreeVisualize(env *glisp.Zlisp) {
+	buf := Global.CurrentB
+	start := buf.Undo
+	viz := &EditorBuffer{Rendername: "*Undo Tree*"}
+	win := getFocusWindow()
+	vSplit()
+	win.childRB.buf = viz
+	defer func() {
+		win.childLT.focused = false
+		win.childRB.focused = true
+		closeThisWindow()
+		Global.CurrentB = buf
+	}()
+	for {
+		showUndoTree(viz, buf)
+		Global.Input = "p/n: undo/redo, b/f: switch branch, RET/q: done, C-g: cancel"
+		editorRefreshScreen()
+		switch editorGetKey() {
+		case "p", "C-p", "UP":
+			if buf.Undo != nil {
+				editorUndoAction()
+			}
+		case "n", "C-n", "DOWN":
+			if buf.nextRedo() != nil {
+				doOneRedo(env)
+			}
+		case "b", "C-b", "LEFT":
+			undoTreeSwitchSibling(-1)
+		case "f", "C-f", "RIGHT":
+			undoTreeSwitchSibling(1)
+		case "C-g":
+			undoTreeGoto(start)
+			Global.Input = "Cancelled."
+			return
+		case "q", "RET":
+			Global.Input = ""
+			return
+		}
+	}
+}
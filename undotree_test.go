@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Make two branches: "first" was typed, undone, and replaced by "second".
+func makeUndoBranches() (*EditorUndo, *EditorUndo) {
+	InitEditor()
+	editorInsertStr("first")
+	first := Global.CurrentB.Undo
+	editorUndoAction()
+	editorInsertStr("second")
+	return first, Global.CurrentB.Undo
+}
+
+func TestUndoKeepsBranches(t *testing.T) {
+	first, second := makeUndoBranches()
+	buf := Global.CurrentB
+	buf.FailIfBufferNe([]string{"second"}, t)
+	if len(buf.undoStart.next) != 2 {
+		t.Fatal("Expected 2 branches but got", len(buf.undoStart.next))
+	}
+	undoTreeGoto(first)
+	buf.FailIfBufferNe([]string{"first"}, t)
+	undoTreeGoto(second)
+	buf.FailIfBufferNe([]string{"second"}, t)
+	undoTreeGoto(nil)
+	buf.FailIfBufferNe([]string{}, t)
+}
+
+func TestRedoFollowsBranch(t *testing.T) {
+	makeUndoBranches()
+	buf := Global.CurrentB
+	editorUndoAction()
+	doOneRedo(nil)
+	buf.FailIfBufferNe([]string{"second"}, t)
+	editorUndoAction()
+	buf.undoStart.branch = 0
+	doOneRedo(nil)
+	buf.FailIfBufferNe([]string{"first"}, t)
+}
+
+func TestUndoTreeGotoKeepsPairsTogether(t *testing.T) {
+	InitEditor()
+	editorInsertStr("hello world")
+	buf := Global.CurrentB
+	setMark(buf)
+	buf.cx = 6
+	transposeRegionCmd(func(s string) string { return "there" })
+	if !buf.Undo.paired {
+		t.Fatal("Expected replacing the region to make paired undo records")
+	}
+	replaced := buf.Undo
+	undoTreeGoto(nil)
+	buf.FailIfBufferNe([]string{}, t)
+	undoTreeGoto(replaced.prev)
+	buf.FailIfBufferNe([]string{"hello there"}, t)
+	undoTreeGoto(replaced.prev.prev)
+	buf.FailIfBufferNe([]string{"hello world"}, t)
+}
+
+func TestUndoAtTime(t *testing.T) {
+	first, second := makeUndoBranches()
+	buf := Global.CurrentB
+	now := time.Now()
+	first.time = now.Add(-10 * time.Minute)
+	second.time = now.Add(-5 * time.Minute)
+	if buf.undoAtTime(now.Add(-7*time.Minute)) != first {
+		t.Error("Expected the first change to be current 7 minutes ago")
+	}
+	if buf.undoAtTime(now) != second {
+		t.Error("Expected the second change to be current now")
+	}
+	if buf.undoAtTime(now.Add(-time.Hour)) != nil {
+		t.Error("Expected no changes an hour ago")
+	}
+}
+
+func TestParseUndoTime(t *testing.T) {
+	now := time.Date(2020, 1, 2, 15, 30, 0, 0, time.Local)
+	for s, expected := range map[string]time.Time{
+		"10m":       now.Add(-10 * time.Minute),
+		"1h30m ago": now.Add(-90 * time.Minute),
+		"14:05":     time.Date(2020, 1, 2, 14, 5, 0, 0, time.Local),
+		"14:05:30":  time.Date(2020, 1, 2, 14, 5, 30, 0, time.Local),
+	} {
+		got, err := parseUndoTime(s, now)
+		if err != nil || !got.Equal(expected) {
+			t.Errorf("Expected %q to be %v but got %v (%v)", s, expected, got, err)
+		}
+	}
+	if _, err := parseUndoTime("yesterday-ish", now); err == nil {
+		t.Error("Expected an error for a time that makes no sense")
+	}
+}
+
+func TestDrawUndoTree(t *testing.T) {
+	first, second := makeUndoBranches()
+	first.time = time.Date(2020, 1, 2, 10, 0, 0, 0, time.Local)
+	second.time = time.Date(2020, 1, 2, 10, 1, 0, 0, time.Local)
+	lines, cur := Global.CurrentB.drawUndoTree()
+	expected := []string{
+		"o original",
+		"├─o 10:00:00 +\"first\"",
+		"└>x 10:01:00 +\"second\"",
+	}
+	if len(lines) != len(expected) {
+		t.Fatal("Expected", expected, "but got", lines)
+	}
+	for i := range lines {
+		if lines[i] != expected[i] {
+			t.Errorf("Expected line %d to be %q but got %q", i, expected[i], lines[i])
+		}
+	}
+	if cur != 2 {
+		t.Error("Expected the current state to be on line 2 but got", cur)
+	}
+}
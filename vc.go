@@ -0,0 +1,652 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+	termbox "github.com/nsf/termbox-go"
+	"github.com/zyedidia/highlight"
+)
+
+// Version control, by running git. Buffers visiting files in a git work tree
+// show the branch and the state of the file in the mode line, and the lines
+// changed since HEAD in the gutter; the vc- commands diff, blame, stage and
+// commit them.
+
+type vcInfo struct {
+	root   string // The top of the work tree
+	branch string // For file buffers; "" for the others
+	state  byte   // As shown in the mode line, before the branch
+	file   string // For blame buffers, the file being blamed
+	// For the diff gutter: the file's lines in HEAD, or nil if it isn't
+	// there, and how the buffer's text differs from them
+	head  []string
+	text  string
+	hunks []diffHunk
+}
+
+const (
+	vcUpToDate  = '-'
+	vcModified  = ':' // Has changes that haven't been staged
+	vcStaged    = '+' // All its changes are staged
+	vcAdded     = '@' // Staged, and not in HEAD
+	vcUntracked = '?'
+	vcConflict  = '!'
+)
+
+// The top of the git work tree dir is in, or "".
+func vcRoot(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if d == filepath.Dir(d) {
+			return ""
+		}
+	}
+}
+
+func git(root, input string, args ...string) (string, error) {
+	return shellCmdInDir(root, input, "git", args...)
+}
+
+// The first line of a git error, which is the one that says what went wrong.
+func gitError(err error) string {
+	return strings.SplitN(err.Error(), "\n", 2)[0]
+}
+
+// fn relative to the top of the work tree, as git wants it.
+func (v *vcInfo) path(fn string) string {
+	rel, err := filepath.Rel(v.root, fn)
+	if err != nil {
+		return fn
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Find out (again) which branch buf's file is on and what state it's in.
+// Buffers not visiting a file in a work tree, and ignored files, get nothing.
+func vcRefresh(buf *EditorBuffer) {
+	buf.vc = nil
+	if buf.Filename == "" || buf.LargeFile {
+		return
+	}
+	root := vcRoot(filepath.Dir(buf.Filename))
+	if root == "" {
+		return
+	}
+	v := &vcInfo{root: root, state: vcUpToDate}
+	out, err := git(root, "", "status", "--porcelain=v1", "--branch", "--ignored", "--", v.path(buf.Filename))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if strings.HasPrefix(line, "## ") {
+			v.branch = parseGitBranch(line[3:])
+			continue
+		}
+		if len(line) < 3 {
+			continue
+		}
+		x, y := line[0], line[1]
+		switch {
+		case x == '!':
+			return
+		case x == '?':
+			v.state = vcUntracked
+		case x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D'):
+			v.state = vcConflict
+		case y != ' ':
+			v.state = vcModified
+		case x == 'A':
+			v.state = vcAdded
+		default:
+			v.state = vcStaged
+		}
+	}
+	if v.branch == "HEAD" {
+		// Not on a branch, so show the commit instead
+		if out, err := git(root, "", "rev-parse", "--short", "HEAD"); err == nil {
+			v.branch = strings.TrimSpace(out)
+		}
+	}
+	if head, err := v.headText(buf.Filename); err == nil {
+		v.head = splitDiffText(decodeHead(buf, head))
+		if v.head == nil {
+			v.head = []string{}
+		}
+		v.updateDiff(buf)
+	}
+	buf.vc = v
+}
+
+// The branch from the "## " line of git status --branch, which may be
+// "main...origin/main [ahead 1]", "No commits yet on main" or
+// "HEAD (no branch)".
+func parseGitBranch(s string) string {
+	for _, prefix := range []string{"No commits yet on ", "Initial commit on "} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	if i := strings.Index(s, "..."); 0 <= i {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, ' '); 0 <= i {
+		s = s[:i]
+	}
+	return s
+}
+
+// Refresh every buffer visiting a file in the work tree at root.
+func vcRefreshAll(root string) {
+	for _, buf := range Global.Buffers {
+		if buf.vc != nil && buf.vc.root == root && buf.vc.branch != "" {
+			vcRefresh(buf)
+		}
+	}
+}
+
+// The mode line's part, like " [Git:main]" for a modified file on main.
+func (v *vcInfo) status() string {
+	if v.branch == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [Git%c%s]", v.state, v.branch)
+}
+
+// The current buffer's vc info, or a message saying why there isn't any.
+func currentVc() *vcInfo {
+	buf := Global.CurrentB
+	if buf.vc == nil && buf.Filename != "" {
+		vcRefresh(buf)
+	}
+	if buf.vc == nil || buf.vc.branch == "" {
+		Global.Input = "Not visiting a file in a git work tree"
+		return nil
+	}
+	return buf.vc
+}
+
+// Show lines in buf, in a read-only mode, in the other window.
+func showVcBuffer(buf *EditorBuffer, mode string, lines []string, v *vcInfo) {
+	fillReadOnlyBuffer(buf, mode, lines)
+	buf.vc = v
+	if mode == "diff" {
+		for _, d := range defs {
+			if d.FileType == "patch" {
+				buf.Highlighter = highlight.NewHighlighter(d)
+				buf.Highlight()
+			}
+		}
+	}
+	showBufferOtherWindow(buf)
+}
+
+// The text of fn as of HEAD, as it is in the repository.
+func (v *vcInfo) headText(fn string) (string, error) {
+	return git(v.root, "", "show", "HEAD:"+v.path(fn))
+}
+
+// Decode the text of buf's file from HEAD the way buf's file was read, with
+// "\n" between lines as in lspText, so that the two can be compared.
+func decodeHead(buf *EditorBuffer, raw string) string {
+	data := []byte(raw)
+	coding := buf.Coding
+	if c := detectCoding(data); coding != c && (coding == CodingUTF8BOM ||
+		coding == CodingUTF16LE || coding == CodingUTF16BE) {
+		// HEAD has no byte order mark to strip
+		coding = c
+	}
+	text := decodeText(data, coding)
+	if sep := buf.Eol.Separator(); sep != "\n" {
+		text = strings.Replace(text, sep, "\n", -1)
+	}
+	return text
+}
+
+func splitDiffText(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Show how the buffer, saved or not, differs from the file in HEAD.
+func vcDiff() {
+	v := currentVc()
+	if v == nil {
+		return
+	}
+	buf := Global.CurrentB
+	path := v.path(buf.Filename)
+	head, err := v.headText(buf.Filename)
+	head = decodeHead(buf, head)
+	hunks := unifiedDiff(splitDiffText(head), splitDiffText(lspText(buf)), 3)
+	if len(hunks) == 0 {
+		Global.Input = "No changes to " + path + " since HEAD"
+		return
+	}
+	from := "a/" + path
+	if err != nil {
+		from = "/dev/null"
+	}
+	lines := append([]string{"diff --git a/" + path + " b/" + path, "--- " + from, "+++ b/" + path}, hunks...)
+	showVcBuffer(namedBuffer("*vc-diff*"), "diff", lines, &vcInfo{root: v.root})
+	Global.Input = "Changes to " + path + " since HEAD"
+}
+
+// Visit the line of the file that the diff line at the cursor is about.
+func diffGotoSource(env *glisp.Zlisp) {
+	buf := Global.CurrentB
+	if buf.vc == nil || buf.NumRows() == 0 {
+		Global.Input = "Not in a diff"
+		return
+	}
+	line, fn := -1, ""
+	for row := buf.cy; 0 <= row; row-- {
+		data := buf.Row(row).Data
+		if line < 0 && strings.HasPrefix(data, "@@ ") {
+			// "@@ -old,n +new,n @@"
+			fields := strings.Fields(data)
+			if len(fields) < 3 {
+				break
+			}
+			line, _ = strconv.Atoi(strings.SplitN(fields[2][1:], ",", 2)[0])
+			for r := row + 1; r < buf.cy; r++ {
+				if !strings.HasPrefix(buf.Row(r).Data, "-") {
+					line++
+				}
+			}
+		} else if 0 <= line && strings.HasPrefix(data, "+++ ") {
+			fn = strings.TrimPrefix(strings.TrimPrefix(data, "+++ "), "b/")
+			if fn == "/dev/null" && 0 < row {
+				fn = strings.TrimPrefix(strings.TrimPrefix(buf.Row(row-1).Data, "--- "), "a/")
+			}
+			break
+		}
+	}
+	if line < 0 || fn == "" {
+		Global.Input = "No hunk here"
+		return
+	}
+	fn = filepath.Join(buf.vc.root, filepath.FromSlash(fn))
+	if _, err := os.Stat(fn); err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	callFunOtherWindow(func() { vcVisitLine(fn, line, env) })
+}
+
+// Visit fn with the cursor at the start of line, counting from 1.
+func vcVisitLine(fn string, line int, env *glisp.Zlisp) {
+	buf := visitFile(fn, env)
+	buf.cy = line - 1
+	if buf.NumRows() <= buf.cy {
+		buf.cy = buf.NumRows() - 1
+	}
+	if buf.cy < 0 {
+		buf.cy = 0
+	}
+	buf.cx, buf.prefcx = 0, 0
+	editorCentreView()
+}
+
+// Move to the next or previous hunk of a diff.
+func diffHunkMove(delta int) {
+	buf := Global.CurrentB
+	for row := buf.cy + delta; 0 <= row && row < buf.NumRows(); row += delta {
+		if strings.HasPrefix(buf.Row(row).Data, "@@ ") {
+			buf.cy, buf.cx, buf.prefcx = row, 0, 0
+			return
+		}
+	}
+	Global.Input = "No more hunks"
+}
+
+type blameCommit struct {
+	author string
+	time   time.Time
+}
+
+// Parse git blame --porcelain into a line for each line of the file, like git
+// blame's own: "sha (author date line) text". The text is taken from rows
+// where there is one, since git's copy is in the file's encoding.
+func parseGitBlame(out string, rows []string) []string {
+	commits := map[string]*blameCommit{}
+	ret := []string{}
+	lines := strings.Split(out, "\n")
+	for i := 0; i < len(lines); i++ {
+		header := strings.Fields(lines[i])
+		if len(header) < 3 {
+			continue
+		}
+		sha := header[0]
+		n, _ := strconv.Atoi(header[2])
+		c := commits[sha]
+		if c == nil {
+			c = &blameCommit{}
+			commits[sha] = c
+		}
+		for i++; i < len(lines) && !strings.HasPrefix(lines[i], "\t"); i++ {
+			kv := strings.SplitN(lines[i], " ", 2)
+			if len(kv) < 2 {
+				continue
+			}
+			switch kv[0] {
+			case "author":
+				c.author = kv[1]
+			case "author-time":
+				secs, _ := strconv.ParseInt(kv[1], 10, 64)
+				c.time = time.Unix(secs, 0)
+			}
+		}
+		if len(lines) <= i {
+			break
+		}
+		text := lines[i][1:]
+		if 0 < n && n <= len(rows) {
+			text = rows[n-1]
+		}
+		ret = append(ret, fmt.Sprintf("%.8s (%-17.17s %s %4d) %s",
+			sha, c.author, c.time.Format("2006-01-02"), n, text))
+	}
+	return ret
+}
+
+// Show who last changed each line of the buffer, and in which commit. Lines
+// changed since then, saved or not, are "Not Committed Yet".
+func vcAnnotate() {
+	v := currentVc()
+	if v == nil {
+		return
+	}
+	buf := Global.CurrentB
+	// git compares the contents with what's committed byte for byte
+	data, err := buf.encodeContents()
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	out, err := git(v.root, string(data), "blame", "--porcelain", "--contents", "-", "--", v.path(buf.Filename))
+	if err != nil {
+		Global.Input = gitError(err)
+		return
+	}
+	cy := buf.cy
+	blame := namedBuffer("*vc-annotate*")
+	rows := make([]string, buf.NumRows())
+	buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+		rows[i] = row.Data
+		return true
+	})
+	showVcBuffer(blame, "vc-annotate", parseGitBlame(out, rows), &vcInfo{root: v.root, file: buf.Filename})
+	if cy < blame.NumRows() {
+		blame.cy = cy
+		editorCentreView()
+	}
+	Global.Input = "Annotated " + v.path(buf.Filename)
+}
+
+// The commit the blame line at the cursor is from.
+func blameCommitAtPoint() (*vcInfo, string) {
+	buf := Global.CurrentB
+	if buf.vc == nil || buf.vc.file == "" || buf.NumRows() == 0 {
+		Global.Input = "Not in a blame buffer"
+		return nil, ""
+	}
+	return buf.vc, strings.SplitN(buf.Row(buf.cy).Data, " ", 2)[0]
+}
+
+// Visit the line of the file that the blame line at the cursor is about.
+func vcAnnotateGotoLine(env *glisp.Zlisp) {
+	v, _ := blameCommitAtPoint()
+	if v == nil {
+		return
+	}
+	line := Global.CurrentB.cy + 1
+	callFunOtherWindow(func() { vcVisitLine(v.file, line, env) })
+}
+
+// Show the commit the blame line at the cursor is from.
+func vcAnnotateShowCommit() {
+	v, sha := blameCommitAtPoint()
+	if v == nil {
+		return
+	}
+	if strings.Trim(sha, "0") == "" {
+		Global.Input = "Not committed yet"
+		return
+	}
+	out, err := git(v.root, "", "show", "--no-color", sha)
+	if err != nil {
+		Global.Input = gitError(err)
+		return
+	}
+	showVcBuffer(namedBuffer("*vc-diff*"), "diff", strings.Split(strings.TrimSuffix(out, "\n"), "\n"), &vcInfo{root: v.root})
+}
+
+// Stage the buffer's file, offering to save it first.
+func vcStage(env *glisp.Zlisp) {
+	v := currentVc()
+	if v == nil {
+		return
+	}
+	buf := Global.CurrentB
+	if buf.Dirty {
+		ok, _ := editorYesNoPrompt("Save "+buf.getRenderName()+" first?", false)
+		if ok {
+			editorBufSave(buf, env)
+		}
+	}
+	path := v.path(buf.Filename)
+	if _, err := git(v.root, "", "add", "--", path); err != nil {
+		Global.Input = gitError(err)
+		return
+	}
+	vcRefresh(buf)
+	Global.Input = "Staged " + path
+}
+
+// Open the *vc-log* buffer to write the message for committing what's been
+// staged.
+func vcCommit() {
+	root := vcRoot(defaultDirectory())
+	if Global.CurrentB.vc != nil {
+		root = Global.CurrentB.vc.root
+	}
+	if root == "" {
+		Global.Input = "Not in a git work tree"
+		return
+	}
+	if _, err := git(root, "", "diff", "--cached", "--quiet"); err == nil {
+		Global.Input = "Nothing staged to commit; stage files with C-x v i"
+		return
+	}
+	buf := namedBuffer("*vc-log*")
+	if buf.vc == nil || buf.vc.root != root {
+		buf.SetRows([]*EditorRow{&EditorRow{}})
+		buf.clearUndo()
+		buf.Dirty = false
+		buf.cx, buf.cy, buf.prefcx, buf.rowoff = 0, 0, 0, 0
+	}
+	buf.MajorMode = "vc-log"
+	buf.vc = &vcInfo{root: root}
+	showBufferOtherWindow(buf)
+	Global.Input = "Type the commit message, then C-c C-c to commit or C-c C-k to cancel"
+}
+
+// Commit with the message in the *vc-log* buffer.
+func vcLogFinish() {
+	buf := Global.CurrentB
+	if buf.vc == nil || buf.MajorMode != "vc-log" {
+		Global.Input = "Not in a commit message buffer"
+		return
+	}
+	msg := strings.TrimSpace(lspText(buf))
+	if msg == "" {
+		Global.Input = "Empty commit message; not committing"
+		return
+	}
+	root := buf.vc.root
+	if _, err := git(root, msg+"\n", "commit", "-q", "-F", "-"); err != nil {
+		Global.Input = gitError(err)
+		AddErrorMessage(err.Error())
+		return
+	}
+	sha, _ := git(root, "", "rev-parse", "--short", "HEAD")
+	closeVcLog(buf)
+	vcRefreshAll(root)
+	Global.Input = "Committed " + strings.TrimSpace(sha) + ": " + strings.SplitN(msg, "\n", 2)[0]
+	AddErrorMessage(Global.Input)
+}
+
+// Throw the commit message away.
+func vcLogCancel() {
+	buf := Global.CurrentB
+	if buf.MajorMode != "vc-log" {
+		Global.Input = "Not in a commit message buffer"
+		return
+	}
+	closeVcLog(buf)
+	Global.Input = "Commit cancelled"
+}
+
+// Kill the *vc-log* buffer and its window, going back to where C-x v v was
+// typed.
+func closeVcLog(buf *EditorBuffer) {
+	buf.Dirty = false
+	i := getIndexOfCurrentBuffer()
+	if getFocusWindow() != Global.WindowTree {
+		closeThisWindow()
+	}
+	killGivenBuffer(i)
+	Global.CurrentB = getFocusWindow().buf
+}
+
+// Work out how buf differs from HEAD again, if its text has changed since
+// last time. Returns whether it had.
+func (v *vcInfo) updateDiff(buf *EditorBuffer) bool {
+	if v.head == nil {
+		return false
+	}
+	text := lspText(buf)
+	if text == v.text && v.hunks != nil {
+		return false
+	}
+	v.text = text
+	v.hunks = diffLines(v.head, splitDiffText(text))
+	return true
+}
+
+// Keep the diff gutters up to date with the changes made to their buffers.
+func vcUpdateGutters() bool {
+	redraw := false
+	for _, buf := range Global.Buffers {
+		if buf.hasDiffGutter() {
+			redraw = buf.vc.updateDiff(buf) || redraw
+		}
+	}
+	return redraw
+}
+
+func (buf *EditorBuffer) hasDiffGutter() bool {
+	return buf.vc != nil && buf.vc.head != nil && buf.hasMode("diff-gutter-mode")
+}
+
+// The row of the buffer a hunk's mark starts on. Deleted lines are marked on
+// the row after them, or the last row if they were at the end.
+func (buf *EditorBuffer) hunkRow(h diffHunk) int {
+	if h.newStart == h.newEnd && buf.NumRows() <= h.newStart {
+		return buf.NumRows() - 1
+	}
+	return h.newStart
+}
+
+// The hunk that row is in, or nil.
+func (buf *EditorBuffer) hunkAt(row int) *diffHunk {
+	for i, h := range buf.vc.hunks {
+		if row == buf.hunkRow(h) || (h.newStart <= row && row < h.newEnd) {
+			return &buf.vc.hunks[i]
+		}
+	}
+	return nil
+}
+
+// The diff gutter's mark for a row: whether it was added or changed since
+// HEAD, or has lines that were deleted just above it.
+func (buf *EditorBuffer) diffGutterMark(row int) (rune, termbox.Attribute) {
+	if !buf.hasDiffGutter() {
+		return ' ', termbox.ColorDefault
+	}
+	h := buf.hunkAt(row)
+	switch {
+	case h == nil:
+		return ' ', termbox.ColorDefault
+	case h.newStart == h.newEnd:
+		return '-', termbox.ColorRed
+	case h.oldStart == h.oldEnd:
+		return '+', termbox.ColorGreen
+	default:
+		return '~', termbox.ColorBlue
+	}
+}
+
+// Move to the start of the next or previous hunk of changes since HEAD.
+func vcHunkMove(delta int) {
+	buf := Global.CurrentB
+	if !buf.hasDiffGutter() {
+		Global.Input = "No diff gutter; is the file tracked, and diff-gutter-mode on?"
+		return
+	}
+	buf.vc.updateDiff(buf)
+	hunks := buf.vc.hunks
+	for i := range hunks {
+		if delta < 0 {
+			i = len(hunks) - 1 - i
+		}
+		row := buf.hunkRow(hunks[i])
+		if (0 < delta && buf.cy < row) || (delta < 0 && row < buf.cy) {
+			buf.cy, buf.cx, buf.prefcx = row, 0, 0
+			return
+		}
+	}
+	Global.Input = "No more hunks"
+}
+
+// Put the lines of the hunk at the cursor back as they are in HEAD.
+func vcRevertHunk() {
+	buf := Global.CurrentB
+	if !buf.hasDiffGutter() {
+		Global.Input = "No diff gutter; is the file tracked, and diff-gutter-mode on?"
+		return
+	}
+	v := buf.vc
+	v.updateDiff(buf)
+	h := buf.hunkAt(buf.cy)
+	if h == nil {
+		Global.Input = "No changes here"
+		return
+	}
+	old := splitDiffText(v.text)
+	lines := append(append(append([]string{}, old[:h.newStart]...),
+		v.head[h.oldStart:h.oldEnd]...), old[h.newEnd:]...)
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	back := diffHunk{h.newStart, h.newEnd, h.newStart, h.newStart + h.oldEnd - h.oldStart}
+	if len(old) == 0 {
+		// The buffer is empty but for a row, which the lines replace
+		if buf.NumRows() == 0 {
+			buf.SetRows([]*EditorRow{&EditorRow{}})
+		}
+		old = []string{""}
+		back = diffHunk{0, 1, 0, len(lines)}
+	}
+	applyHunks(buf, old, lines, []diffHunk{back})
+	v.updateDiff(buf)
+	Global.Input = "Reverted hunk"
+}
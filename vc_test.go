@@ -0,0 +1,265 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gitIn(t *testing.T, dir string, args ...string) string {
+	out, err := git(dir, "", args...)
+	if err != nil {
+		t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(out)
+}
+
+// A new work tree on main, with files committed in it.
+func tempRepo(t *testing.T, files map[string]string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	dir, err := ioutil.TempDir("", "gomacs-vc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ = filepath.EvalSymlinks(dir)
+	gitIn(t, dir, "init", "-q")
+	gitIn(t, dir, "symbolic-ref", "HEAD", "refs/heads/main")
+	gitIn(t, dir, "config", "user.name", "Tester")
+	gitIn(t, dir, "config", "user.email", "tester@example.com")
+	gitIn(t, dir, "config", "commit.gpgsign", "false")
+	writeTree(t, dir, files)
+	gitIn(t, dir, "add", ".")
+	gitIn(t, dir, "commit", "-q", "-m", "First")
+	return dir
+}
+
+func TestParseGitBranch(t *testing.T) {
+	for s, expect := range map[string]string{
+		"main":                                "main",
+		"main...origin/main [ahead 1]":        "main",
+		"No commits yet on topic":             "topic",
+		"HEAD (no branch)":                    "HEAD",
+		"feature/x...origin/feature/x [gone]": "feature/x",
+	} {
+		if got := parseGitBranch(s); got != expect {
+			t.Errorf("Expected %q to be on %q, got %q", s, expect, got)
+		}
+	}
+}
+
+func TestVcDiffAndBlame(t *testing.T) {
+	dir := tempRepo(t, map[string]string{"a.txt": "one\ntwo\nthree\n"})
+	defer os.RemoveAll(dir)
+	InitEditor()
+	openFile(filepath.Join(dir, "a.txt"), nil)
+	buf := Global.CurrentB
+	if !strings.Contains(editorUpdateStatus(buf), " [Git-main]") {
+		t.Errorf("Expected the branch in the mode line, got %q", editorUpdateStatus(buf))
+	}
+
+	replaceText(buf, 0, 3, 1, 1, "TWO")
+	vcDiff()
+	Global.CurrentB.FailIfBufferNe([]string{
+		"diff --git a/a.txt b/a.txt",
+		"--- a/a.txt",
+		"+++ b/a.txt",
+		"@@ -1,3 +1,3 @@",
+		" one",
+		"-two",
+		"+TWO",
+		" three",
+	}, t)
+	Global.CurrentB.cy = 7
+	diffGotoSource(nil)
+	if Global.CurrentB != buf || buf.cy != 2 {
+		t.Errorf("Expected RET to visit line 3, got %d", buf.cy)
+	}
+
+	editorBufSave(buf, nil)
+	if buf.vc.status() != " [Git:main]" {
+		t.Errorf("Expected the file to be modified, got %q", buf.vc.status())
+	}
+	buf.cy = 1
+	vcAnnotate()
+	blame := Global.CurrentB
+	if blame.NumRows() != 3 || blame.cy != 1 {
+		t.Fatalf("Expected a line of blame for each line, got %q", lspText(blame))
+	}
+	first, second := blame.Row(0).Data, blame.Row(1).Data
+	if !strings.Contains(first, " (Tester ") || !strings.HasSuffix(first, "    1) one") {
+		t.Errorf("Expected line 1 to be by Tester, got %q", first)
+	}
+	if !strings.HasPrefix(second, "00000000 (Not Committed Yet") || !strings.HasSuffix(second, "2) TWO") {
+		t.Errorf("Expected line 2 not to be committed, got %q", second)
+	}
+	blame.cy = 0
+	vcAnnotateShowCommit()
+	if diff := Global.CurrentB; diff.MajorMode != "diff" || !strings.Contains(lspText(diff), "\n    First\n") {
+		t.Errorf("Expected the first commit to be shown, got %q", lspText(diff))
+	}
+}
+
+func TestVcDosLineEndings(t *testing.T) {
+	dir := tempRepo(t, map[string]string{"a.txt": "one\r\ntwo\r\n", "b.txt": "caf\xe9\n"})
+	defer os.RemoveAll(dir)
+	InitEditor()
+	openFile(filepath.Join(dir, "a.txt"), nil)
+	buf := Global.CurrentB
+	vcDiff()
+	if Global.CurrentB != buf || Global.Input != "No changes to a.txt since HEAD" {
+		t.Errorf("Expected no changes, got %q", Global.Input)
+	}
+	vcAnnotate()
+	blame := Global.CurrentB
+	if blame.NumRows() != 2 || !strings.HasSuffix(blame.Row(1).Data, "    2) two") ||
+		strings.HasPrefix(blame.Row(1).Data, "00000000") {
+		t.Errorf("Expected every line to be committed, got %q", lspText(blame))
+	}
+
+	openFile(filepath.Join(dir, "b.txt"), nil)
+	buf = Global.CurrentB
+	if buf.Coding != CodingLatin1 {
+		t.Fatalf("Expected b.txt to be Latin-1, got %s", buf.Coding)
+	}
+	vcDiff()
+	if Global.Input != "No changes to b.txt since HEAD" {
+		t.Errorf("Expected no changes, got %q", Global.Input)
+	}
+	vcAnnotate()
+	if line := Global.CurrentB.Row(0).Data; !strings.HasSuffix(line, "1) café") {
+		t.Errorf("Expected the line as it's shown in the buffer, got %q", line)
+	}
+}
+
+func TestVcStageAndCommit(t *testing.T) {
+	dir := tempRepo(t, map[string]string{"a.txt": "one\n"})
+	defer os.RemoveAll(dir)
+	InitEditor()
+	writeTree(t, dir, map[string]string{"b.txt": "new\n"})
+	openFile(filepath.Join(dir, "b.txt"), nil)
+	buf := Global.CurrentB
+	if buf.vc.status() != " [Git?main]" {
+		t.Errorf("Expected the file to be untracked, got %q", buf.vc.status())
+	}
+	vcCommit()
+	if Global.Input != "Nothing staged to commit; stage files with C-x v i" {
+		t.Errorf("Expected nothing to commit, got %q", Global.Input)
+	}
+	vcStage(nil)
+	if buf.vc.status() != " [Git@main]" || Global.Input != "Staged b.txt" {
+		t.Errorf("Expected the file to be added, got %q", buf.vc.status())
+	}
+
+	vcCommit()
+	log := Global.CurrentB
+	if log.MajorMode != "vc-log" {
+		t.Fatalf("Expected to be writing the message, got %q", log.MajorMode)
+	}
+	vcLogFinish()
+	if Global.Input != "Empty commit message; not committing" {
+		t.Errorf("Expected an empty message to be refused, got %q", Global.Input)
+	}
+	editorInsertStr("Add b")
+	vcLogFinish()
+	if !strings.HasPrefix(Global.Input, "Committed ") || !strings.HasSuffix(Global.Input, ": Add b") {
+		t.Errorf("Expected a commit, got %q", Global.Input)
+	}
+	if bufferAlive(log) || Global.CurrentB != buf || buf.vc.status() != " [Git-main]" {
+		t.Error("Expected to be back at the file, now committed")
+	}
+	if subject := gitIn(t, dir, "log", "-1", "--format=%s"); subject != "Add b" {
+		t.Errorf("Expected the commit to be made, got %q", subject)
+	}
+}
+
+func TestVcDiffGutter(t *testing.T) {
+	dir := tempRepo(t, map[string]string{"a.txt": "a\nb\nc\nd\ne\nf\ng\n"})
+	defer os.RemoveAll(dir)
+	InitEditor()
+	writeTree(t, dir, map[string]string{"a.txt": "b\nC\nd\ne\nX\nf\n"})
+	openFile(filepath.Join(dir, "a.txt"), nil)
+	buf := Global.CurrentB
+	if gutterWidth(buf) != 2 {
+		t.Errorf("Expected a gutter for the marks, got %d columns", gutterWidth(buf))
+	}
+	marks := func() string {
+		ret := ""
+		for row := 0; row < buf.NumRows(); row++ {
+			ru, _ := buf.gutterMark(row)
+			ret += string(ru)
+		}
+		return ret
+	}
+	if got := marks(); got != "-~  +-" {
+		t.Errorf("Expected deleted, changed and added lines to be marked, got %q", got)
+	}
+
+	for _, expect := range []int{1, 4, 5, 5} {
+		vcHunkMove(1)
+		if buf.cy != expect {
+			t.Errorf("Expected the next hunk to be at %d, got %d", expect, buf.cy)
+		}
+	}
+	if Global.Input != "No more hunks" {
+		t.Errorf("Expected to run out of hunks, got %q", Global.Input)
+	}
+	vcHunkMove(-1)
+	if buf.cy != 4 {
+		t.Errorf("Expected the previous hunk to be at 4, got %d", buf.cy)
+	}
+
+	runAsCommand("vc-revert-hunk", vcRevertHunk)
+	buf.FailIfBufferNe([]string{"b", "C", "d", "e", "f"}, t)
+	if got := marks(); got != "-~  -" {
+		t.Errorf("Expected the added line's mark to go, got %q", got)
+	}
+	buf.cy, buf.cx = 1, 1
+	runAsCommand("vc-revert-hunk", vcRevertHunk)
+	buf.cy = 0
+	runAsCommand("vc-revert-hunk", vcRevertHunk)
+	buf.FailIfBufferNe([]string{"a", "b", "c", "d", "e", "f"}, t)
+	editorUndoAction()
+	buf.FailIfBufferNe([]string{"b", "c", "d", "e", "f"}, t)
+	buf.cy = 4
+	runAsCommand("vc-revert-hunk", vcRevertHunk)
+	buf.FailIfBufferNe([]string{"b", "c", "d", "e", "f", "g"}, t)
+	if got := marks(); got != "-     " {
+		t.Errorf("Expected only the deleted line to be marked, got %q", got)
+	}
+
+	buf.cy, buf.cx = 3, 1
+	editorInsertStr("!")
+	if !vcUpdateGutters() || marks() != "-  ~  " {
+		t.Errorf("Expected the change to be marked, got %q", marks())
+	}
+	Global.MinorModes["diff-gutter-mode"] = true
+	buf.setMode("diff-gutter-mode", false)
+	if buf.hasGutterMarks() || gutterWidth(buf) != 0 {
+		t.Error("Expected no gutter with diff-gutter-mode off")
+	}
+}
+
+func TestVcDiffGutterDosLineEndings(t *testing.T) {
+	dir := tempRepo(t, map[string]string{"a.txt": "a\r\nb\r\nc\r\n"})
+	defer os.RemoveAll(dir)
+	InitEditor()
+	openFile(filepath.Join(dir, "a.txt"), nil)
+	buf := Global.CurrentB
+	if len(buf.vc.hunks) != 0 {
+		t.Errorf("Expected no changes, got %v", buf.vc.hunks)
+	}
+	buf.cy, buf.cx = 1, 0
+	editorInsertStr("B")
+	vcUpdateGutters()
+	runAsCommand("vc-revert-hunk", vcRevertHunk)
+	buf.FailIfBufferNe([]string{"a", "b", "c"}, t)
+	editorBufSave(buf, nil)
+	if data, _ := ioutil.ReadFile(buf.Filename); string(data) != "a\r\nb\r\nc\r\n" {
+		t.Errorf("Expected the file to be as it was, got %q", data)
+	}
+}
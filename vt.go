@@ -0,0 +1,527 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	runewidth "github.com/mattn/go-runewidth"
+)
+
+// A screen that interprets the VT100 and xterm escape sequences programs
+// print, for term to show in a buffer. Colours and other attributes are
+// ignored.
+
+const (
+	vtGround = iota
+	vtEscape
+	vtCSI
+	vtOSC
+	vtOSCEscape
+	vtCharset
+)
+
+type vtScreen struct {
+	rows, cols int
+	// The cells of the screen; 0 is the right half of a wide character
+	lines [][]rune
+	x, y  int
+	// Whether the last character went in the last column, so the next one
+	// goes on the next line
+	wrapNext       bool
+	top, bottom    int // The scroll region, inclusive
+	savedX, savedY int
+	scrollback     []string
+	// The normal screen, while programs like less use the alternate one
+	mainLines    [][]rune
+	mainX, mainY int
+	appCursor    bool // Whether the arrow keys send ESC O rather than ESC [
+	hideCursor   bool
+	state        int
+	params       string
+	osc          string
+	title        string
+	reply        func(string) // Answers questions, e.g. where the cursor is
+}
+
+const vtMaxScrollback = 2000
+
+func newVtScreen(rows, cols int) *vtScreen {
+	s := &vtScreen{rows: rows, cols: cols, bottom: rows - 1}
+	s.lines = make([][]rune, rows)
+	for i := range s.lines {
+		s.lines[i] = s.blankLine()
+	}
+	return s
+}
+
+func (s *vtScreen) blankLine() []rune {
+	line := make([]rune, s.cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// A line of the screen as text, without trailing blanks.
+func vtLineString(line []rune) string {
+	var sb strings.Builder
+	for _, r := range line {
+		if r != 0 {
+			sb.WriteRune(r)
+		}
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// Where column x of line is, in bytes.
+func vtByteIndex(line []rune, x int) int {
+	n := 0
+	for _, r := range line[:x] {
+		if r != 0 {
+			n += len(string(r))
+		}
+	}
+	return n
+}
+
+func (s *vtScreen) clamp() {
+	if s.x < 0 {
+		s.x = 0
+	} else if s.cols <= s.x {
+		s.x = s.cols - 1
+	}
+	if s.y < 0 {
+		s.y = 0
+	} else if s.rows <= s.y {
+		s.y = s.rows - 1
+	}
+	s.wrapNext = false
+}
+
+// Scroll the scroll region up n lines. If keep, lines leaving the top of the
+// normal screen go in the scrollback.
+func (s *vtScreen) scrollUp(n int, keep bool) {
+	n = s.clampScroll(n)
+	for ; 0 < n; n-- {
+		if keep && s.top == 0 && s.mainLines == nil {
+			s.scrollback = append(s.scrollback, vtLineString(s.lines[0]))
+			if vtMaxScrollback < len(s.scrollback) {
+				s.scrollback = s.scrollback[len(s.scrollback)-vtMaxScrollback:]
+			}
+		}
+		copy(s.lines[s.top:s.bottom], s.lines[s.top+1:s.bottom+1])
+		s.lines[s.bottom] = s.blankLine()
+	}
+}
+
+// Scrolling further than the height of the scroll region just clears it, so a
+// huge count from a program mustn't make us scroll line by line.
+func (s *vtScreen) clampScroll(n int) int {
+	if h := s.bottom - s.top + 1; h < n {
+		return h
+	}
+	return n
+}
+
+// Scroll the scroll region down n lines.
+func (s *vtScreen) scrollDown(n int) {
+	n = s.clampScroll(n)
+	for ; 0 < n; n-- {
+		copy(s.lines[s.top+1:s.bottom+1], s.lines[s.top:s.bottom])
+		s.lines[s.top] = s.blankLine()
+	}
+}
+
+func (s *vtScreen) lineFeed() {
+	if s.y == s.bottom {
+		s.scrollUp(1, true)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+	s.wrapNext = false
+}
+
+func (s *vtScreen) reverseIndex() {
+	if s.y == s.top {
+		s.scrollDown(1)
+	} else if 0 < s.y {
+		s.y--
+	}
+	s.wrapNext = false
+}
+
+func (s *vtScreen) put(r rune) {
+	w := runewidth.RuneWidth(r)
+	if w == 0 {
+		return
+	}
+	if s.wrapNext || (w == 2 && s.x == s.cols-1) {
+		s.x = 0
+		s.lineFeed()
+	}
+	line := s.lines[s.y]
+	line[s.x] = r
+	if w == 2 && s.x+1 < s.cols {
+		line[s.x+1] = 0
+	}
+	s.x += w
+	if s.cols <= s.x {
+		s.x = s.cols - 1
+		s.wrapNext = true
+	}
+}
+
+// Blank columns from up to (but not including) to of line y.
+func (s *vtScreen) erase(y, from, to int) {
+	if to > s.cols {
+		to = s.cols
+	}
+	for x := from; x < to; x++ {
+		s.lines[y][x] = ' '
+	}
+}
+
+// Show the alternate screen, or go back to the normal one.
+func (s *vtScreen) altScreen(on bool) {
+	if on && s.mainLines == nil {
+		s.mainLines, s.mainX, s.mainY = s.lines, s.x, s.y
+		s.lines = make([][]rune, s.rows)
+		for i := range s.lines {
+			s.lines[i] = s.blankLine()
+		}
+	} else if !on && s.mainLines != nil {
+		s.lines, s.x, s.y = s.mainLines, s.mainX, s.mainY
+		s.mainLines = nil
+	}
+	s.clamp()
+}
+
+// The nth parameter of the current control sequence, or def if it's missing
+// or zero.
+func (s *vtScreen) param(params []string, n, def int) int {
+	if len(params) <= n {
+		return def
+	}
+	v, err := strconv.Atoi(params[n])
+	if err != nil || v == 0 {
+		return def
+	}
+	return v
+}
+
+func (s *vtScreen) csi(final rune) {
+	private := strings.HasPrefix(s.params, "?")
+	secondary := strings.HasPrefix(s.params, ">")
+	params := strings.Split(strings.TrimLeft(s.params, "?>="), ";")
+	n := s.param(params, 0, 1)
+	switch final {
+	case 'A':
+		s.y -= n
+		if s.top <= s.y+n && s.y < s.top {
+			s.y = s.top
+		}
+		s.clamp()
+	case 'B', 'e':
+		s.y += n
+		if s.y-n <= s.bottom && s.bottom < s.y {
+			s.y = s.bottom
+		}
+		s.clamp()
+	case 'C', 'a':
+		s.x += n
+		s.clamp()
+	case 'D':
+		s.x -= n
+		s.clamp()
+	case 'E':
+		s.x, s.y = 0, s.y+n
+		s.clamp()
+	case 'F':
+		s.x, s.y = 0, s.y-n
+		s.clamp()
+	case 'G', '`':
+		s.x = n - 1
+		s.clamp()
+	case 'd':
+		s.y = n - 1
+		s.clamp()
+	case 'H', 'f':
+		s.y, s.x = n-1, s.param(params, 1, 1)-1
+		s.clamp()
+	case 'J':
+		switch s.param(params, 0, 0) {
+		case 0:
+			s.erase(s.y, s.x, s.cols)
+			for y := s.y + 1; y < s.rows; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		case 1:
+			s.erase(s.y, 0, s.x+1)
+			for y := 0; y < s.y; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		case 3:
+			s.scrollback = nil
+			fallthrough
+		case 2:
+			for y := 0; y < s.rows; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		}
+	case 'K':
+		switch s.param(params, 0, 0) {
+		case 0:
+			s.erase(s.y, s.x, s.cols)
+		case 1:
+			s.erase(s.y, 0, s.x+1)
+		case 2:
+			s.erase(s.y, 0, s.cols)
+		}
+	case 'X':
+		s.erase(s.y, s.x, s.x+n)
+	case '@':
+		line := s.lines[s.y]
+		if s.cols-s.x < n {
+			n = s.cols - s.x
+		}
+		copy(line[s.x+n:], line[s.x:])
+		s.erase(s.y, s.x, s.x+n)
+	case 'P':
+		line := s.lines[s.y]
+		if s.cols-s.x < n {
+			n = s.cols - s.x
+		}
+		copy(line[s.x:], line[s.x+n:])
+		s.erase(s.y, s.cols-n, s.cols)
+	case 'L', 'M':
+		if s.y < s.top || s.bottom < s.y {
+			break
+		}
+		top := s.top
+		s.top = s.y
+		if final == 'L' {
+			s.scrollDown(n)
+		} else {
+			s.scrollUp(n, false)
+		}
+		s.top = top
+		s.x = 0
+	case 'S':
+		s.scrollUp(n, true)
+	case 'T':
+		if !secondary {
+			s.scrollDown(n)
+		}
+	case 'r':
+		top, bottom := n-1, s.param(params, 1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+		} else {
+			s.top, s.bottom = 0, s.rows-1
+		}
+		s.x, s.y = 0, 0
+		s.wrapNext = false
+	case 's':
+		s.savedX, s.savedY = s.x, s.y
+	case 'u':
+		s.x, s.y = s.savedX, s.savedY
+		s.clamp()
+	case 'h', 'l':
+		if !private {
+			break
+		}
+		set := final == 'h'
+		for _, p := range params {
+			switch p {
+			case "1":
+				s.appCursor = set
+			case "25":
+				s.hideCursor = !set
+			case "1049":
+				if set {
+					s.savedX, s.savedY = s.x, s.y
+					s.altScreen(true)
+				} else {
+					s.altScreen(false)
+					s.x, s.y = s.savedX, s.savedY
+					s.clamp()
+				}
+			case "47", "1047":
+				s.altScreen(set)
+			}
+		}
+	case 'n':
+		switch s.param(params, 0, 0) {
+		case 5:
+			s.answer("\x1b[0n")
+		case 6:
+			s.answer(fmt.Sprintf("\x1b[%d;%dR", s.y+1, s.x+1))
+		}
+	case 'c':
+		if secondary {
+			s.answer("\x1b[>0;0;0c")
+		} else if !private {
+			s.answer("\x1b[?1;2c")
+		}
+	}
+}
+
+func (s *vtScreen) answer(msg string) {
+	if s.reply != nil {
+		s.reply(msg)
+	}
+}
+
+// Clear the screen and forget all the modes programs have set.
+func (s *vtScreen) reset() {
+	scrollback, reply := s.scrollback, s.reply
+	*s = *newVtScreen(s.rows, s.cols)
+	s.scrollback, s.reply = scrollback, reply
+}
+
+func (s *vtScreen) escape(r rune) {
+	s.state = vtGround
+	switch r {
+	case '[':
+		s.state, s.params = vtCSI, ""
+	case ']':
+		s.state, s.osc = vtOSC, ""
+	case '(', ')', '*', '+':
+		s.state = vtCharset
+	case '7':
+		s.savedX, s.savedY = s.x, s.y
+	case '8':
+		s.x, s.y = s.savedX, s.savedY
+		s.clamp()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.x = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.reset()
+	}
+}
+
+// What's in an OSC sequence; only titles are understood.
+func (s *vtScreen) oscDone() {
+	s.state = vtGround
+	if strings.HasPrefix(s.osc, "0;") || strings.HasPrefix(s.osc, "2;") {
+		s.title = s.osc[2:]
+	}
+}
+
+// Interpret what a program printed.
+func (s *vtScreen) feed(text string) {
+	for _, r := range text {
+		switch s.state {
+		case vtEscape:
+			s.escape(r)
+			continue
+		case vtCSI:
+			switch {
+			case r == 0x1b:
+				s.state = vtEscape
+			case 0x30 <= r && r <= 0x3f:
+				s.params += string(r)
+			case 0x40 <= r && r <= 0x7e:
+				s.state = vtGround
+				s.csi(r)
+			case r < 0x20:
+				s.control(r)
+			}
+			continue
+		case vtOSC:
+			switch r {
+			case 0x07:
+				s.oscDone()
+			case 0x1b:
+				s.state = vtOSCEscape
+			default:
+				s.osc += string(r)
+			}
+			continue
+		case vtOSCEscape:
+			s.oscDone()
+			if r != '\\' {
+				s.escape(r)
+			}
+			continue
+		case vtCharset:
+			s.state = vtGround
+			continue
+		}
+		if r < 0x20 || r == 0x7f {
+			s.control(r)
+		} else {
+			s.put(r)
+		}
+	}
+}
+
+func (s *vtScreen) control(r rune) {
+	switch r {
+	case 0x1b:
+		s.state = vtEscape
+	case '\r':
+		s.x = 0
+		s.wrapNext = false
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\b':
+		if 0 < s.x {
+			s.x--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.x = (s.x/8 + 1) * 8
+		s.clamp()
+	}
+}
+
+// Change the size of the screen, keeping the cursor on it and putting lines
+// pushed off the top in the scrollback.
+func (s *vtScreen) resize(rows, cols int) {
+	if rows < 1 || cols < 1 || (rows == s.rows && cols == s.cols) {
+		return
+	}
+	fit := func(lines [][]rune, cy int, keep bool) [][]rune {
+		if rows <= cy {
+			off := cy - rows + 1
+			if keep {
+				for _, line := range lines[:off] {
+					s.scrollback = append(s.scrollback, vtLineString(line))
+				}
+			}
+			lines = lines[off:]
+		}
+		ret := make([][]rune, rows)
+		for i := range ret {
+			line := make([]rune, cols)
+			for x := range line {
+				line[x] = ' '
+			}
+			if i < len(lines) {
+				copy(line, lines[i])
+			}
+			ret[i] = line
+		}
+		return ret
+	}
+	if s.mainLines != nil {
+		s.mainLines = fit(s.mainLines, s.mainY, true)
+		if rows <= s.mainY {
+			s.mainY = rows - 1
+		}
+	}
+	s.lines = fit(s.lines, s.y, s.mainLines == nil)
+	if rows <= s.y {
+		s.y = rows - 1
+	}
+	s.rows, s.cols = rows, cols
+	s.top, s.bottom = 0, rows-1
+	s.clamp()
+}
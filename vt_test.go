@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The screen's lines as text.
+func vtText(s *vtScreen) []string {
+	ret := []string{}
+	for _, line := range s.lines {
+		ret = append(ret, vtLineString(line))
+	}
+	return ret
+}
+
+func expectScreen(t *testing.T, s *vtScreen, lines []string, x, y int) {
+	if got := vtText(s); !reflect.DeepEqual(got, lines) {
+		t.Errorf("Expected screen %q, got %q", lines, got)
+	}
+	if s.x != x || s.y != y {
+		t.Errorf("Expected the cursor at %d,%d, got %d,%d", x, y, s.x, s.y)
+	}
+}
+
+func TestVtCursorAndErase(t *testing.T) {
+	s := newVtScreen(3, 10)
+	s.feed("hello\r\nworld\x1b[1;3HLL\x1b[2;2H\x1b[K")
+	expectScreen(t, s, []string{"heLLo", "w", ""}, 1, 1)
+	s.feed("\x1b[3;1Habcdefghijkl")
+	expectScreen(t, s, []string{"w", "abcdefghij", "kl"}, 2, 2)
+	if len(s.scrollback) != 1 || s.scrollback[0] != "heLLo" {
+		t.Errorf("Expected the top line in the scrollback, got %q", s.scrollback)
+	}
+	s.feed("\x1b[H\x1b[2J")
+	expectScreen(t, s, []string{"", "", ""}, 0, 0)
+}
+
+func TestVtEditing(t *testing.T) {
+	s := newVtScreen(4, 10)
+	s.feed("12345\x1b[1;2H\x1b[2P")
+	expectScreen(t, s, []string{"145", "", "", ""}, 1, 0)
+	s.feed("\x1b[2@x")
+	expectScreen(t, s, []string{"1x 45", "", "", ""}, 2, 0)
+	s.feed("\r\nb\r\nc\r\nd\x1b[2;1H\x1b[L")
+	expectScreen(t, s, []string{"1x 45", "", "b", "c"}, 0, 1)
+	s.feed("\x1b[2M")
+	expectScreen(t, s, []string{"1x 45", "c", "", ""}, 0, 1)
+	if len(s.scrollback) != 0 {
+		t.Errorf("Expected deleted lines not to go in the scrollback, got %q", s.scrollback)
+	}
+	s.feed("\x1b[2;3r\x1b[2;1Hp\nq\nr")
+	expectScreen(t, s, []string{"1x 45", " q", "  r", ""}, 3, 2)
+
+	// Huge counts scroll no further than the region
+	s.feed("\x1b[r\x1b[99999999T\x1b[99999999M")
+	expectScreen(t, s, []string{"", "", "", ""}, 0, 0)
+	s.feed("a\x1b[99999999S")
+	if len(s.scrollback) != 4 {
+		t.Errorf("Expected a screenful in the scrollback, got %d lines", len(s.scrollback))
+	}
+}
+
+func TestVtAltScreenAndReplies(t *testing.T) {
+	s := newVtScreen(2, 10)
+	replies := ""
+	s.reply = func(msg string) { replies += msg }
+	s.feed("main\x1b[?1049h\x1b[Halt\x1b[6n\x1b]0;title\x07")
+	expectScreen(t, s, []string{"alt", ""}, 3, 0)
+	if replies != "\x1b[1;4R" || s.title != "title" {
+		t.Errorf("Expected a cursor position report and a title, got %q and %q", replies, s.title)
+	}
+	s.feed("\x1b[?1049l")
+	expectScreen(t, s, []string{"main", ""}, 4, 0)
+	s.feed("\x1b[?1h")
+	if seq, _ := termKeySequence("UP", s.appCursor); seq != "\x1bOA" {
+		t.Errorf("Expected an application mode cursor key, got %q", seq)
+	}
+}
+
+func TestVtWideAndResize(t *testing.T) {
+	s := newVtScreen(3, 4)
+	s.feed("日本語")
+	expectScreen(t, s, []string{"日本", "語", ""}, 2, 1)
+	if vtByteIndex(s.lines[0], 2) != 3 {
+		t.Errorf("Expected the second column to be 3 bytes in")
+	}
+	s.resize(1, 6)
+	expectScreen(t, s, []string{"語"}, 2, 0)
+	if len(s.scrollback) != 1 || s.scrollback[0] != "日本" {
+		t.Errorf("Expected lines pushed off the top in the scrollback, got %q", s.scrollback)
+	}
+}
+
+func TestTermKeySequence(t *testing.T) {
+	for key, expect := range map[string]string{
+		"a": "a", "RET": "\r", "C-a": "\x01", "M-b": "\x1bb", "C-M-f": "\x1b\x06",
+		"LEFT": "\x1b[D", "DEL": "\x7f", "f5": "\x1b[15~", "é": "é",
+	} {
+		if seq, ok := termKeySequence(key, false); !ok || seq != expect {
+			t.Errorf("Expected %s to send %q, got %q", key, expect, seq)
+		}
+	}
+	if _, ok := termKeySequence("<mouse1 1 1>", false); ok {
+		t.Error("Expected the mouse not to be sent")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Wdired: a dired listing turned into an editable list of file names, one per
+// line. Finishing renames every file whose line has changed.
+
+type wdiredInfo struct {
+	names []string // The names as they were, in the order they're listed
+}
+
+// A rename to do; done says whether it has been.
+type wdiredRename struct {
+	from, to string
+	done     bool
+}
+
+// How files are renamed; tests replace it to make renames fail.
+var wdiredRenameFile = os.Rename
+
+// Make the dired buffer editable, listing the current buffer's directory if
+// it isn't one.
+func wdiredCommand() {
+	buf := Global.CurrentB
+	if buf.dired == nil {
+		buf = diredDirectory(defaultDirectory())
+		if buf == nil {
+			return
+		}
+	}
+	if buf.wdired != nil {
+		Global.Input = "Already editing file names"
+		return
+	}
+	d := buf.dired
+	names := []string{}
+	for _, e := range d.entries {
+		if e.name != "." && e.name != ".." {
+			names = append(names, e.name)
+		}
+	}
+	if len(names) == 0 {
+		Global.Input = "No files to rename"
+		return
+	}
+	cy := buf.cy - diredHeaderRows - (len(d.entries) - len(names))
+	rows := make([]*EditorRow, len(names))
+	for i, name := range names {
+		rows[i] = &EditorRow{Size: len(name), Data: name}
+		rowUpdateRender(rows[i])
+	}
+	buf.SetRows(rows)
+	buf.clearUndo()
+	buf.Dirty = false
+	buf.wdired = &wdiredInfo{names}
+	buf.MajorMode = "wdired"
+	buf.setMode("read-only-mode", false)
+	if cy < 0 {
+		cy = 0
+	} else if len(names) <= cy {
+		cy = len(names) - 1
+	}
+	buf.cy, buf.cx, buf.prefcx, buf.rowoff = cy, 0, 0, 0
+	Global.Input = "Edit the names, then C-c C-c to rename or C-c C-k to give up"
+}
+
+// Work out the renames that turn names into newNames, in an order that never
+// renames over a file before it has been moved out of the way. Fails if two
+// files would get the same name, a name is taken by a file that isn't being
+// renamed, or the renames go round in a cycle.
+func planRenames(dir string, names, newNames []string) ([]*wdiredRename, error) {
+	if len(names) != len(newNames) {
+		return nil, fmt.Errorf("There were %d names and now there are %d; lines can't be added or removed",
+			len(names), len(newNames))
+	}
+	bySource := map[string]*wdiredRename{}
+	sources := map[string]bool{}
+	taken := map[string]string{}
+	renames := []*wdiredRename{}
+	for i, name := range newNames {
+		// Spaces at either end are kept, since they can be part of a name
+		switch {
+		case strings.TrimSpace(name) == "" || name == "." || name == "..":
+			return nil, fmt.Errorf("%q isn't a file name", name)
+		case strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/'):
+			return nil, fmt.Errorf("%s: names can't have slashes in them", name)
+		}
+		if other, ok := taken[name]; ok {
+			return nil, fmt.Errorf("%s and %s would both be called %s", other, names[i], name)
+		}
+		taken[name] = names[i]
+		sources[names[i]] = true
+		if name != names[i] {
+			r := &wdiredRename{from: names[i], to: name}
+			bySource[r.from] = r
+			renames = append(renames, r)
+		}
+	}
+	for _, r := range renames {
+		if _, err := os.Lstat(filepath.Join(dir, r.to)); err == nil && !sources[r.to] {
+			return nil, fmt.Errorf("%s already exists", r.to)
+		}
+	}
+	// Rename whatever's in a file's way first
+	ordered := []*wdiredRename{}
+	state := map[*wdiredRename]int{} // 1 while visiting, 2 when ordered
+	var visit func(r *wdiredRename) error
+	visit = func(r *wdiredRename) error {
+		switch state[r] {
+		case 1:
+			return fmt.Errorf("Renaming %s to %s goes round in a cycle", r.from, r.to)
+		case 2:
+			return nil
+		}
+		state[r] = 1
+		if blocker := bySource[r.to]; blocker != nil {
+			if err := visit(blocker); err != nil {
+				return err
+			}
+		}
+		state[r] = 2
+		ordered = append(ordered, r)
+		return nil
+	}
+	for _, r := range renames {
+		if err := visit(r); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Do the renames in order. If one fails, undo the ones done so far.
+func doRenames(dir string, renames []*wdiredRename) error {
+	for i, r := range renames {
+		err := wdiredRenameFile(filepath.Join(dir, r.from), filepath.Join(dir, r.to))
+		if err == nil {
+			r.done = true
+			continue
+		}
+		undone := 0
+		for j := i - 1; 0 <= j; j-- {
+			back := renames[j]
+			if wdiredRenameFile(filepath.Join(dir, back.to), filepath.Join(dir, back.from)) == nil {
+				back.done = false
+				undone++
+			}
+		}
+		return fmt.Errorf("Renaming %s to %s failed (%s); undid %d of %d renames", r.from, r.to,
+			err.Error(), undone, i)
+	}
+	return nil
+}
+
+// Go back to dired, with the cursor on the file it was on.
+func (w *wdiredInfo) leave(buf *EditorBuffer) {
+	d := buf.dired
+	row := buf.cy
+	buf.wdired = nil
+	buf.cy = row + diredHeaderRows + len(d.entries) - len(w.names)
+	d.revert(buf)
+}
+
+// Rename the files whose names have been changed, and go back to dired.
+func wdiredFinishEdit() {
+	buf := Global.CurrentB
+	w := buf.wdired
+	if w == nil {
+		Global.Input = "Not editing file names"
+		return
+	}
+	d := buf.dired
+	newNames := []string{}
+	buf.EachRow(0, buf.NumRows(), func(i int, row *EditorRow) bool {
+		newNames = append(newNames, row.Data)
+		return true
+	})
+	// The final newline makes an empty last line
+	if len(w.names) < len(newNames) && strings.TrimSpace(newNames[len(newNames)-1]) == "" {
+		newNames = newNames[:len(newNames)-1]
+	}
+	renames, err := planRenames(d.dir, w.names, newNames)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	err = doRenames(d.dir, renames)
+	n := 0
+	for _, r := range renames {
+		if r.done {
+			n++
+			if mark, ok := d.marks[r.from]; ok {
+				delete(d.marks, r.from)
+				d.marks[r.to] = mark
+			}
+		}
+	}
+	w.leave(buf)
+	if err != nil {
+		Global.Input = err.Error()
+		AddErrorMessage(err.Error())
+		return
+	}
+	if n == 1 {
+		Global.Input = "Renamed 1 file"
+	} else {
+		Global.Input = fmt.Sprintf("Renamed %d files", n)
+	}
+}
+
+// Go back to dired without renaming anything.
+func wdiredAbortChanges() {
+	buf := Global.CurrentB
+	if buf.wdired == nil {
+		Global.Input = "Not editing file names"
+		return
+	}
+	buf.wdired.leave(buf)
+	Global.Input = "Changes aborted"
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The names of the files in dir, and what's in them.
+func dirContents(t *testing.T, dir string) map[string]string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := map[string]string{}
+	for _, info := range infos {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		ret[info.Name()] = string(data)
+	}
+	return ret
+}
+
+func TestWdiredRenames(t *testing.T) {
+	InitEditor()
+	Global.MinorModes["read-only-mode"] = true
+	dir, err := ioutil.TempDir("", "gomacs-wdired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c", "keep": "k"})
+	buf := diredDirectory(dir)
+	diredMark('*', 1)
+	wdiredCommand()
+	if buf.MajorMode != "wdired" || buf.hasMode("read-only-mode") {
+		t.Fatal("Expected an editable wdired buffer")
+	}
+	buf.FailIfBufferNe([]string{"a.txt", "b.txt", "c.txt", "keep"}, t)
+	// a -> b, b -> c and c -> d have to be done back to front
+	buf.EachRow(0, 3, func(i int, row *EditorRow) bool {
+		row.Data = string(rune('b'+i)) + ".txt"
+		row.Size = len(row.Data)
+		return true
+	})
+	wdiredFinishEdit()
+	if Global.Input != "Renamed 3 files" {
+		t.Errorf("Expected a summary, got %q", Global.Input)
+	}
+	expect := map[string]string{"b.txt": "a", "c.txt": "b", "d.txt": "c", "keep": "k"}
+	if got := dirContents(t, dir); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Expected %v, got %v", expect, got)
+	}
+	if buf.MajorMode != "dired-mode" || buf.wdired != nil || !buf.hasMode("read-only-mode") {
+		t.Error("Expected to be back in dired")
+	}
+	if buf.dired.marks["b.txt"] != '*' || len(buf.dired.marks) != 1 {
+		t.Errorf("Expected the mark to follow the file, got %v", buf.dired.marks)
+	}
+
+	wdiredCommand()
+	buf.Row(0).Data = "changed"
+	wdiredAbortChanges()
+	if _, err := os.Stat(filepath.Join(dir, "changed")); err == nil || buf.MajorMode != "dired-mode" {
+		t.Error("Expected aborting not to rename anything")
+	}
+}
+
+func TestPlanRenames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomacs-wdired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a": "", "b": "", ".hidden": ""})
+	for _, c := range []struct {
+		newNames []string
+		err      string
+	}{
+		{[]string{"b", "a"}, "cycle"},
+		{[]string{"c", "c"}, "both be called c"},
+		{[]string{".hidden", "b"}, ".hidden already exists"},
+		{[]string{"x/y", "b"}, "slashes"},
+		{[]string{"", "b"}, "isn't a file name"},
+		{[]string{"  ", "b"}, "isn't a file name"},
+		{[]string{"a"}, "can't be added or removed"},
+	} {
+		_, err := planRenames(dir, []string{"a", "b"}, c.newNames)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Expected renaming a, b to %q to fail with %q, got %v", c.newNames, c.err, err)
+		}
+	}
+	renames, err := planRenames(dir, []string{"a", "b"}, []string{"a", "c"})
+	if err != nil || len(renames) != 1 || renames[0].from != "b" || renames[0].to != "c" {
+		t.Errorf("Expected to rename only b, got %v, %v", renames, err)
+	}
+	renames, err = planRenames(dir, []string{" a", "c "}, []string{" a", "d"})
+	if err != nil || len(renames) != 1 || renames[0].from != "c " || renames[0].to != "d" {
+		t.Errorf("Expected to rename only \"c \", got %v, %v", renames, err)
+	}
+}
+
+func TestWdiredRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomacs-wdired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a": "a", "b": "b", "c": "c"})
+	calls := 0
+	wdiredRenameFile = func(from, to string) error {
+		calls++
+		if calls == 3 {
+			return errors.New("disk on fire")
+		}
+		return os.Rename(from, to)
+	}
+	defer func() { wdiredRenameFile = os.Rename }()
+	renames, err := planRenames(dir, []string{"a", "b", "c"}, []string{"x", "y", "z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = doRenames(dir, renames)
+	if err == nil || !strings.Contains(err.Error(), "undid 2 of 2") {
+		t.Errorf("Expected the failure to be reported, got %v", err)
+	}
+	names := []string{}
+	for name := range dirContents(t, dir) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+		t.Errorf("Expected the renames to be rolled back, got %q", names)
+	}
+}
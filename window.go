@@ -174,13 +174,14 @@ func (t *winTree) draw(x, y, wx, wy int) {
 		}
 		return
 	}
-	gutter := 0
-	if t.buf.hasMode("line-number-mode") && t.buf.NumRows > 0 {
-		gutter = GetGutterWidth(t.buf.NumRows)
-	}
+	gutter := gutterWidth(t.buf)
 
+	if t.focused && t.buf.term != nil {
+		t.buf.term.resize(wy, wx-gutter)
+	}
 	editorDrawStatusLine(x, y+wy, wx, t)
 	editorScroll(wx-gutter, wy)
+	t.buf.highlightRange(t.buf.rowoff, t.buf.rowoff+wy)
 
 	if t.buf.regionActive {
 		t.buf.recalcRegion()
@@ -201,6 +202,8 @@ func editorWriteFile(env *glisp.Zlisp) {
 	}
 	Global.CurrentB.Filename = fn
 	Global.CurrentB.UpdateRenderName()
+	Global.CurrentB.backedUp = false
+	Global.CurrentB.recordFileStat()
 	EditorSave(env)
 }
 
@@ -250,21 +253,23 @@ func openFile(fn string, env *glisp.Zlisp) {
 	if ferr != nil {
 		Global.Input = ferr.Error()
 		AddErrorMessage(ferr.Error())
-		Global.CurrentB.Rows = make([]*EditorRow, 1)
-		Global.CurrentB.Rows[0] = &EditorRow{Global.CurrentB.NumRows,
-			0, "", 0, "", nil, nil, 0}
+		Global.CurrentB.SetRows([]*EditorRow{&EditorRow{}})
 	}
 }
 
 func (e *EditorBuffer) getFilename() string {
-	if e.Filename == "" {
+	if e.Filename == "" && e.Rendername != "" {
+		return e.Rendername
+	} else if e.Filename == "" {
 		return "*unnamed buffer*"
 	}
 	return e.Filename
 }
 
 func (e *EditorBuffer) getRenderName() string {
-	if e.Filename == "" {
+	if e.Filename == "" && e.Rendername != "" {
+		return e.Rendername
+	} else if e.Filename == "" {
 		return "*unnamed buffer*"
 	}
 	return e.Rendername
@@ -311,8 +316,14 @@ func killGivenBuffer(i int) {
 	}
 	kb := Global.Buffers[i]
 
-	// Prompt the user if buffer modified
-	if kb.Dirty {
+	// Prompt the user if buffer modified, or has a shell running in it
+	if bufferHasProcess(kb) {
+		c, _ := editorYesNoPrompt("Buffer has a running process; kill it?", false)
+		if !c {
+			return
+		}
+		killBufferProcess(kb)
+	} else if kb.Dirty {
 		c, _ := editorYesNoPrompt("Buffer has unsaved changes; kill anyway?", false)
 		if !c {
 			return
@@ -332,6 +343,15 @@ func killGivenBuffer(i int) {
 		Global.CurrentB = rb
 	}
 
+	// The user has chosen to throw away any unsaved changes
+	kb.deleteAutoSave()
+	lspDetach(kb)
+	kb.closeLargeFile()
+	err := kb.saveUndoHistory()
+	if err != nil {
+		AddErrorMessage("Couldn't save undo history: " + err.Error())
+	}
+
 	// Delete the killed buffer.
 	copy(Global.Buffers[i:], Global.Buffers[i+1:])
 	Global.Buffers[len(Global.Buffers)-1] = nil
@@ -351,6 +371,12 @@ func killGivenBuffer(i int) {
 			reg.PosBuffer = nil
 		}
 	}
+	for _, reg := range xrefStack {
+		if reg.PosBuffer == kb {
+			reg.Type = RegisterInvalid
+			reg.PosBuffer = nil
+		}
+	}
 }
 
 func killBuffer() {
@@ -380,6 +406,56 @@ func callFunOtherWindowAndGoBack(f func()) {
 	oldfw.setFocus()
 }
 
+// The buffer called name, made if there isn't one yet.
+func namedBuffer(name string) *EditorBuffer {
+	for _, buf := range Global.Buffers {
+		if buf.Filename == "" && buf.Rendername == name {
+			return buf
+		}
+	}
+	buf := &EditorBuffer{Rendername: name}
+	Global.Buffers = append(Global.Buffers, buf)
+	return buf
+}
+
+func bufferAlive(buf *EditorBuffer) bool {
+	for _, b := range Global.Buffers {
+		if b == buf {
+			return true
+		}
+	}
+	return false
+}
+
+// Show buf in another window, and select it.
+func showBufferOtherWindow(buf *EditorBuffer) {
+	if getFocusWindow().buf != buf {
+		callFunOtherWindow(func() {})
+		getFocusWindow().buf = buf
+	}
+	Global.CurrentB = buf
+}
+
+// Put away the window, as when leaving a list like *Occur* or *grep*. If it's
+// the only window, show what the list came from (or, for dired, the buffer
+// before it) instead.
+func quitWindow() {
+	buf := Global.CurrentB
+	if getFocusWindow() != Global.WindowTree {
+		closeThisWindow()
+		Global.CurrentB = getFocusWindow().buf
+	} else if buf.occur != nil && bufferAlive(buf.occur.source) {
+		getFocusWindow().buf = buf.occur.source
+		Global.CurrentB = buf.occur.source
+	} else if i := getIndexOfCurrentBuffer(); buf.dired != nil && 0 < i {
+		// Go back to what was open before, as after visiting a file
+		getFocusWindow().buf = Global.Buffers[i-1]
+		Global.CurrentB = Global.Buffers[i-1]
+	} else {
+		Global.Input = "Only window"
+	}
+}
+
 func getIndexOfCurrentBuffer() int {
 	win := getFocusWindow()
 	for i, buf := range Global.Buffers {
@@ -11,17 +11,17 @@ import (
 
 func indexEndOfBackwardWord() (int, int) {
 	cx, icy := Global.CurrentB.cx, Global.CurrentB.cy
-	if icy >= Global.CurrentB.NumRows {
+	if icy >= Global.CurrentB.NumRows() {
 		return cx, icy
 	}
 	pre := true
 	for cy := icy; cy >= 0; cy-- {
 		if cy != icy {
-			cx = Global.CurrentB.Rows[cy].Size
+			cx = Global.CurrentB.Row(cy).Size
 		}
 		for cx > 0 {
 			r, rs :=
-				utf8.DecodeLastRuneInString(Global.CurrentB.Rows[cy].Data[:cx])
+				utf8.DecodeLastRuneInString(Global.CurrentB.Row(cy).Data[:cx])
 			if !termutil.WordCharacter(r) && !pre {
 				return cx, cy
 			} else if termutil.WordCharacter(r) {
@@ -38,14 +38,14 @@ func indexEndOfBackwardWord() (int, int) {
 
 func indexEndOfForwardWord() (int, int) {
 	cx, icy := Global.CurrentB.cx, Global.CurrentB.cy
-	if icy >= Global.CurrentB.NumRows {
+	if icy >= Global.CurrentB.NumRows() {
 		return cx, icy
 	}
 	pre := true
-	for cy := icy; cy < Global.CurrentB.NumRows; cy++ {
-		l := Global.CurrentB.Rows[cy].Size
+	for cy := icy; cy < Global.CurrentB.NumRows(); cy++ {
+		l := Global.CurrentB.Row(cy).Size
 		for cx < l {
-			r, rs := utf8.DecodeRuneInString(Global.CurrentB.Rows[cy].Data[cx:])
+			r, rs := utf8.DecodeRuneInString(Global.CurrentB.Row(cy).Data[cx:])
 			if !termutil.WordCharacter(r) && !pre {
 				return cx, cy
 			} else if termutil.WordCharacter(r) {
@@ -65,14 +65,14 @@ func delBackWord() {
 	times := getRepeatTimes()
 	for i := 0; i < times; i++ {
 		icx, icy := Global.CurrentB.cx, Global.CurrentB.cy
-		if icy >= Global.CurrentB.NumRows {
+		if icy >= Global.CurrentB.NumRows() {
 			return
 		}
 		ncx, ncy := indexEndOfBackwardWord()
 		if ncx < icx || ncy != icy {
 			ret := bufKillRegion(Global.CurrentB, ncx, icx, ncy, icy)
 			editorAddRegionUndo(false, ncx, icx, ncy, icy, ret)
-			Global.Clipboard = ret
+			addKill(ret, true)
 			Global.CurrentB.cx = ncx
 		}
 	}
@@ -93,14 +93,14 @@ func delForwardWord() {
 	times := getRepeatTimes()
 	for i := 0; i < times; i++ {
 		icx, icy := Global.CurrentB.cx, Global.CurrentB.cy
-		if icy >= Global.CurrentB.NumRows {
+		if icy >= Global.CurrentB.NumRows() {
 			return
 		}
 		ncx, ncy := indexEndOfForwardWord()
 		if ncx > icx || ncy != icy {
 			ret := bufKillRegion(Global.CurrentB, icx, ncx, icy, ncy)
 			editorAddRegionUndo(false, icx, ncx, icy, ncy, ret)
-			Global.Clipboard = ret
+			addKill(ret, false)
 		}
 	}
 }
@@ -109,7 +109,7 @@ func moveForwardWord() {
 	times := getRepeatTimes()
 	for i := 0; i < times; i++ {
 		icy := Global.CurrentB.cy
-		if icy >= Global.CurrentB.NumRows {
+		if icy >= Global.CurrentB.NumRows() {
 			return
 		}
 		Global.CurrentB.cx, Global.CurrentB.cy = indexEndOfForwardWord()
@@ -182,7 +182,8 @@ func autoComplete(env *glisp.Zlisp) {
 
 	matches := []string{}
 	for _, buf := range Global.Buffers {
-		for _, row := range buf.Rows {
+		for i := 0; i < buf.NumRows(); i++ {
+			row := buf.Row(i)
 			somematches := re.FindAllStringSubmatch(row.Data, -1)
 			if len(somematches) > 0 {
 			MATCH:
@@ -218,7 +219,7 @@ func autoComplete(env *glisp.Zlisp) {
 				bufKillRegion(Global.CurrentB, ocx,
 					Global.CurrentB.cx, ocy, ocy)
 			}
-			editorRowInsertStr(Global.CurrentB.Rows[ocy],
+			editorRowInsertStr(Global.CurrentB.Row(ocy),
 				Global.CurrentB, ocx, matches[index])
 			Global.CurrentB.cx = ocx + len(matches[index])
 
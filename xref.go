@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	glisp "github.com/glycerine/zygomys/zygo"
+)
+
+// Xref: finding where things are defined and used, and going back to where
+// you were afterwards. The places come from the buffer's language server if it
+// has one, and from a tags table if not.
+
+// The places M-. jumped from, as position registers; killing a buffer
+// invalidates the ones in it.
+var xrefStack []*Register
+
+func xrefPushMarker() {
+	reg := &Register{}
+	reg.setPosition()
+	xrefStack = append(xrefStack, reg)
+}
+
+// Go back to where the last M-. was done (pop-tag-mark).
+func xrefGoBack() {
+	for 0 < len(xrefStack) {
+		reg := xrefStack[len(xrefStack)-1]
+		xrefStack = xrefStack[:len(xrefStack)-1]
+		if reg.Type != RegisterPos {
+			continue
+		}
+		reg.jump()
+		Global.CurrentB.prefcx = Global.CurrentB.cx
+		editorCentreView()
+		return
+	}
+	Global.Input = "Nowhere to go back to"
+}
+
+// Visit the place a location refers to.
+func xrefVisit(loc lspLocation, env *glisp.Zlisp) {
+	buf := visitFile(uriFile(loc.URI), env)
+	buf.cx, buf.cy = buf.fromLspPosition(loc.Range.Start)
+	buf.prefcx = buf.cx
+	editorCentreView()
+}
+
+// Go to the one location there is, or list them in the *xref* buffer.
+func xrefShow(locs []lspLocation, what string, env *glisp.Zlisp) {
+	switch len(locs) {
+	case 0:
+		Global.Input = "No " + what + " found"
+	case 1:
+		xrefPushMarker()
+		xrefVisit(locs[0], env)
+	default:
+		dir := Global.CurrentB.lsp.client.root
+		xrefPushMarker()
+		buf := namedBuffer("*xref*")
+		fillErrorList(buf, &errorList{dir, nil, -1}, "xref", locationLines(locs, dir))
+		showBufferOtherWindow(buf)
+		Global.Input = fmt.Sprintf("%d %s", len(locs), what)
+	}
+}
+
+func xrefFindDefinitions(env *glisp.Zlisp) {
+	if Global.CurrentB.lsp == nil {
+		findTag(env)
+		return
+	}
+	locs, err := lspLocations("textDocument/definition", nil)
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	xrefShow(locs, "definitions", env)
+}
+
+func xrefFindReferences(env *glisp.Zlisp) {
+	locs, err := lspLocations("textDocument/references", map[string]interface{}{
+		"context": map[string]bool{"includeDeclaration": true},
+	})
+	if err != nil {
+		Global.Input = err.Error()
+		return
+	}
+	xrefShow(locs, "references", env)
+}